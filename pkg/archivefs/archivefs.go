@@ -0,0 +1,398 @@
+// Package archivefs exposes the members of a tar or zip archive as a read-only fs.FS, so that archives like build
+// artifacts can be served and masked without unpacking them.
+//
+// Member paths are cleaned and made relative to the root of the archive, so members can't escape it, and directories
+// missing from the archive are implied by the paths of the members below them. When an archive has several members
+// with the same path, the last one wins, like it does when extracting the archive. Symlinks are followed within the
+// archive, and those leading out of it don't exist.
+package archivefs
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"maps"
+	"path"
+	"slices"
+	"strings"
+	"time"
+)
+
+// maxSymlinkHops is the most symlinks followed when resolving a path, like the kernel's limit.
+const maxSymlinkHops = 40
+
+// extensions are the file extensions of the supported archive formats.
+var extensions = []string{".zip", ".tar", ".tar.gz", ".tgz", ".tar.bz2", ".tbz2"}
+
+// IsArchive returns true if a path has the extension of a supported archive format.
+func IsArchive(name string) bool {
+	name = strings.ToLower(name)
+	for _, ext := range extensions {
+		if strings.HasSuffix(name, ext) {
+			return true
+		}
+	}
+	return false
+}
+
+// FS is the filesystem of an archive's members, which keeps the archive open until it's closed.
+type FS interface {
+	fs.FS
+	io.Closer
+}
+
+// Open opens the archive at the path on the host as a filesystem, choosing its format by its extension. The archive
+// stays open until the filesystem is closed.
+//
+// Compressed contents are decompressed to temporary files, so that they can be read from anywhere without
+// decompressing them again, and maxSize bounds how large they get, so that an archive can't fill the disk with
+// contents that compress well. A maxSize of 0 doesn't bound them.
+func Open(name string, maxSize int64) (FS, error) {
+	lower := strings.ToLower(name)
+	switch {
+	case strings.HasSuffix(lower, ".zip"):
+		return openZip(name, maxSize)
+	case strings.HasSuffix(lower, ".tar"):
+		return openTar(name, "", maxSize)
+	case strings.HasSuffix(lower, ".tar.gz"), strings.HasSuffix(lower, ".tgz"):
+		return openTar(name, "gzip", maxSize)
+	case strings.HasSuffix(lower, ".tar.bz2"), strings.HasSuffix(lower, ".tbz2"):
+		return openTar(name, "bzip2", maxSize)
+	default:
+		return nil, fmt.Errorf("unsupported archive %q, must be one of %s", name, strings.Join(extensions, ", "))
+	}
+}
+
+// errTooLarge is returned when decompressed contents would exceed the maximum size.
+var errTooLarge = errors.New("decompressed contents exceed the maximum size")
+
+// limitedCopy copies r to w, failing with errTooLarge rather than copying more than max bytes, unless max is 0.
+func limitedCopy(w io.Writer, r io.Reader, max int64) (int64, error) {
+	if max <= 0 {
+		return io.Copy(w, r)
+	}
+
+	n, err := io.Copy(w, io.LimitReader(r, max+1))
+	if err == nil && n > max {
+		err = errTooLarge
+	}
+	return n, err
+}
+
+// node is a member of an archive, or a directory implied by the paths of members.
+type node struct {
+	mode     fs.FileMode
+	size     int64
+	modTime  time.Time
+	target   string           // Target of a symlink
+	link     string           // Path of the member a hard link shares its contents with
+	children map[string]*node // Children of a directory, by name
+	open     opener           // Opens the contents of a file, nil for directories and symlinks
+}
+
+// opener returns a reader of the contents of a file starting at an offset.
+type opener func(offset int64) (io.ReadCloser, error)
+
+// archiveFS is the filesystem of an archive's members.
+type archiveFS struct {
+	root    *node
+	closers []io.Closer // The archive and any temporary files, closed along with the filesystem
+}
+
+// Close closes the archive and removes any temporary files. Files already open can no longer be read.
+func (a *archiveFS) Close() error {
+	var errs []error
+	for _, c := range a.closers {
+		errs = append(errs, c.Close())
+	}
+	return errors.Join(errs...)
+}
+
+// newArchiveFS returns an empty filesystem that members are added to.
+func newArchiveFS(modTime time.Time) *archiveFS {
+	return &archiveFS{root: &node{mode: fs.ModeDir | 0o555, modTime: modTime, children: map[string]*node{}}}
+}
+
+// add adds a member at its path, creating the directories it implies, and replacing any member already at the path.
+// Members at the root itself only set the root's mode and modification time.
+func (a *archiveFS) add(member string, n *node) {
+	member = strings.TrimPrefix(path.Clean("/"+strings.ReplaceAll(member, `\`, "/")), "/")
+	if member == "" {
+		if n.mode.IsDir() {
+			a.root.mode, a.root.modTime = n.mode, n.modTime
+		}
+		return
+	}
+
+	dir := a.root
+	parts := strings.Split(member, "/")
+	for _, part := range parts[:len(parts)-1] {
+		child, ok := dir.children[part]
+		if !ok || !child.mode.IsDir() {
+			child = &node{mode: fs.ModeDir | 0o555, modTime: dir.modTime, children: map[string]*node{}}
+			dir.children[part] = child
+		}
+		dir = child
+	}
+
+	name := parts[len(parts)-1]
+	if existing, ok := dir.children[name]; ok && existing.mode.IsDir() && n.mode.IsDir() {
+		// Keep the members already below a directory that's repeated
+		n.children = existing.children
+	}
+	if n.mode.IsDir() && n.children == nil {
+		n.children = map[string]*node{}
+	}
+	dir.children[name] = n
+}
+
+// resolveLinks points hard links at the contents of the members they link to, dropping those whose members don't
+// exist or aren't files.
+func (a *archiveFS) resolveLinks() {
+	var walk func(dir *node)
+	walk = func(dir *node) {
+		for name, child := range dir.children {
+			switch {
+			case child.mode.IsDir():
+				walk(child)
+			case child.link != "":
+				target, err := a.lookup("resolve", strings.TrimPrefix(path.Clean("/"+child.link), "/"), false)
+				if err != nil || !target.mode.IsRegular() || target.open == nil {
+					delete(dir.children, name)
+					continue
+				}
+				child.mode, child.size, child.open, child.link = target.mode, target.size, target.open, ""
+			}
+		}
+	}
+	walk(a.root)
+}
+
+// lookup returns the node of a path, following symlinks along it, and following a symlink at the end of it too if
+// follow is true.
+func (a *archiveFS) lookup(op, name string, follow bool) (*node, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+
+	var (
+		stack = []*node{a.root} // The directories leading to the current one, which is last
+		parts = strings.Split(name, "/")
+		hops  int
+	)
+	for len(parts) > 0 {
+		part := parts[0]
+		parts = parts[1:]
+
+		switch part {
+		case "", ".":
+			continue
+		case "..":
+			if len(stack) == 1 {
+				return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+			}
+			stack = stack[:len(stack)-1]
+			continue
+		}
+
+		dir := stack[len(stack)-1]
+		child, ok := dir.children[part]
+		if !ok || dir.children == nil {
+			return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+		}
+		if child.mode&fs.ModeSymlink == 0 || (len(parts) == 0 && !follow) {
+			if len(parts) > 0 && !child.mode.IsDir() {
+				return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+			}
+			stack = append(stack, child)
+			continue
+		}
+
+		if hops++; hops > maxSymlinkHops {
+			return nil, &fs.PathError{Op: op, Path: name, Err: errors.New("too many levels of symlinks")}
+		}
+		if path.IsAbs(child.target) {
+			return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+		}
+		parts = append(strings.Split(child.target, "/"), parts...)
+	}
+
+	return stack[len(stack)-1], nil
+}
+
+func (a *archiveFS) Open(name string) (fs.File, error) {
+	n, err := a.lookup("open", name, true)
+	if err != nil {
+		return nil, err
+	}
+	if n.mode.IsDir() {
+		return &dir{name: name, node: n}, nil
+	}
+	return &file{name: name, node: n}, nil
+}
+
+func (a *archiveFS) Stat(name string) (fs.FileInfo, error) {
+	n, err := a.lookup("stat", name, true)
+	if err != nil {
+		return nil, err
+	}
+	return fileInfo{n, path.Base(name)}, nil
+}
+
+func (a *archiveFS) Lstat(name string) (fs.FileInfo, error) {
+	n, err := a.lookup("lstat", name, false)
+	if err != nil {
+		return nil, err
+	}
+	return fileInfo{n, path.Base(name)}, nil
+}
+
+func (a *archiveFS) ReadLink(name string) (string, error) {
+	n, err := a.lookup("readlink", name, false)
+	if err != nil {
+		return "", err
+	}
+	if n.mode&fs.ModeSymlink == 0 {
+		return "", &fs.PathError{Op: "readlink", Path: name, Err: fs.ErrInvalid}
+	}
+	return n.target, nil
+}
+
+func (a *archiveFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	n, err := a.lookup("readdir", name, true)
+	if err != nil {
+		return nil, err
+	}
+	if !n.mode.IsDir() {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: errors.New("not a directory")}
+	}
+	return n.entries(), nil
+}
+
+// entries returns the entries of a directory's children, sorted by name.
+func (n *node) entries() []fs.DirEntry {
+	names := slices.Sorted(maps.Keys(n.children))
+	entries := make([]fs.DirEntry, 0, len(names))
+	for _, name := range names {
+		entries = append(entries, fs.FileInfoToDirEntry(fileInfo{n.children[name], name}))
+	}
+	return entries
+}
+
+// fileInfo describes a node by the name it was reached by, which is the symlink's rather than the target's when
+// following a symlink.
+type fileInfo struct {
+	n    *node
+	name string
+}
+
+func (i fileInfo) Name() string       { return i.name }
+func (i fileInfo) Size() int64        { return i.n.size }
+func (i fileInfo) Mode() fs.FileMode  { return i.n.mode }
+func (i fileInfo) ModTime() time.Time { return i.n.modTime }
+func (i fileInfo) IsDir() bool        { return i.n.mode.IsDir() }
+func (i fileInfo) Sys() any           { return nil }
+
+// file reads the contents of a member, reopening them whenever it's read from somewhere other than where the last read
+// left off, so that it can seek even in compressed members.
+type file struct {
+	name   string
+	node   *node
+	offset int64
+	r      io.ReadCloser // Reader of the contents from offset, nil until the next read
+}
+
+func (f *file) Stat() (fs.FileInfo, error) { return fileInfo{f.node, path.Base(f.name)}, nil }
+
+func (f *file) Read(p []byte) (int, error) {
+	if f.offset >= f.node.size {
+		return 0, io.EOF
+	}
+	if f.r == nil {
+		r, err := f.node.open(f.offset)
+		if err != nil {
+			return 0, &fs.PathError{Op: "read", Path: f.name, Err: err}
+		}
+		f.r = r
+	}
+
+	// Never read past the size, even if the member's contents are longer than its header says
+	if remaining := f.node.size - f.offset; int64(len(p)) > remaining {
+		p = p[:remaining]
+	}
+	n, err := f.r.Read(p)
+	f.offset += int64(n)
+	if errors.Is(err, io.EOF) && f.offset < f.node.size {
+		err = io.ErrUnexpectedEOF
+	}
+	return n, err
+}
+
+func (f *file) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += f.offset
+	case io.SeekEnd:
+		offset += f.node.size
+	default:
+		return 0, &fs.PathError{Op: "seek", Path: f.name, Err: fs.ErrInvalid}
+	}
+	if offset < 0 {
+		return 0, &fs.PathError{Op: "seek", Path: f.name, Err: fs.ErrInvalid}
+	}
+
+	if offset != f.offset && f.r != nil {
+		_ = f.r.Close()
+		f.r = nil
+	}
+	f.offset = offset
+
+	return offset, nil
+}
+
+func (f *file) Close() error {
+	if f.r == nil {
+		return nil
+	}
+	err := f.r.Close()
+	f.r = nil
+	return err
+}
+
+// dir is an open directory.
+type dir struct {
+	name    string
+	node    *node
+	entries []fs.DirEntry
+	listed  bool
+}
+
+func (d *dir) Stat() (fs.FileInfo, error) { return fileInfo{d.node, path.Base(d.name)}, nil }
+
+func (d *dir) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.name, Err: errors.New("is a directory")}
+}
+
+func (d *dir) Close() error { return nil }
+
+func (d *dir) ReadDir(n int) ([]fs.DirEntry, error) {
+	if !d.listed {
+		d.entries, d.listed = d.node.entries(), true
+	}
+
+	if n <= 0 {
+		entries := d.entries
+		d.entries = nil
+		return entries, nil
+	}
+	if len(d.entries) == 0 {
+		return nil, io.EOF
+	}
+
+	n = min(n, len(d.entries))
+	entries := d.entries[:n]
+	d.entries = d.entries[n:]
+	return entries, nil
+}
@@ -0,0 +1,190 @@
+package archivefs
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+var members = map[string]string{
+	"a.txt":       "hello",
+	"dir/b.txt":   strings.Repeat("compressible ", 1000),
+	"dir/c/d.txt": "nested",
+}
+
+func writeZip(t *testing.T, name string) {
+	t.Helper()
+
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for member, contents := range members {
+		w, err := zw.CreateHeader(&zip.FileHeader{Name: member, Method: zip.Deflate, Modified: time.Unix(0, 0)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := io.WriteString(w, contents); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(name, buf.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func writeTarGz(t *testing.T, name string) {
+	t.Helper()
+
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(zw)
+	for member, contents := range members {
+		if err := tw.WriteHeader(&tar.Header{Name: member, Mode: 0o644, Size: int64(len(contents)), Typeflag: tar.TypeReg}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := io.WriteString(tw, contents); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.WriteHeader(&tar.Header{Name: "link.txt", Linkname: "a.txt", Typeflag: tar.TypeSymlink}); err != nil {
+		t.Fatal(err)
+	}
+	if err := tw.WriteHeader(&tar.Header{Name: "../escape.txt", Mode: 0o644, Typeflag: tar.TypeReg}); err != nil {
+		t.Fatal(err)
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(name, buf.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestOpen(t *testing.T) {
+	dir := t.TempDir()
+	for _, tt := range []struct {
+		name  string
+		write func(*testing.T, string)
+	}{
+		{name: "archive.zip", write: writeZip},
+		{name: "archive.tar.gz", write: writeTarGz},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			name := filepath.Join(dir, tt.name)
+			tt.write(t, name)
+
+			fsys, err := Open(name, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer fsys.Close()
+
+			for member, contents := range members {
+				data, err := fs.ReadFile(fsys, member)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if string(data) != contents {
+					t.Errorf("ReadFile(%q) = %q, want %q", member, data, contents)
+				}
+			}
+			if err := fstest.TestFS(fsys, "a.txt", "dir/b.txt", "dir/c/d.txt"); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+func TestOpenTarMembers(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "archive.tgz")
+	writeTarGz(t, name)
+
+	fsys, err := Open(name, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fsys.Close()
+
+	// Members can't escape the root, whatever their paths
+	if _, err := fs.Stat(fsys, "escape.txt"); err != nil {
+		t.Errorf("member outside of the root wasn't moved into it: %v", err)
+	}
+
+	data, err := fs.ReadFile(fsys, "link.txt")
+	if err != nil || string(data) != members["a.txt"] {
+		t.Errorf("ReadFile(link.txt) = %q, %v, want the symlink followed", data, err)
+	}
+}
+
+func TestOpenMaxSize(t *testing.T) {
+	dir := t.TempDir()
+
+	tgz := filepath.Join(dir, "archive.tgz")
+	writeTarGz(t, tgz)
+	if _, err := Open(tgz, 1024); !errors.Is(err, errTooLarge) {
+		t.Errorf("Open(%q) = %v, want %v", tgz, err, errTooLarge)
+	}
+
+	zipName := filepath.Join(dir, "archive.zip")
+	writeZip(t, zipName)
+	fsys, err := Open(zipName, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fsys.Close()
+	if _, err := fs.ReadFile(fsys, "a.txt"); err != nil {
+		t.Errorf("member within the maximum size can't be read: %v", err)
+	}
+	if _, err := fs.ReadFile(fsys, "dir/b.txt"); !errors.Is(err, errTooLarge) {
+		t.Errorf("member beyond the maximum size was read: %v", err)
+	}
+}
+
+func TestSeek(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "archive.zip")
+	writeZip(t, name)
+
+	fsys, err := Open(name, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fsys.Close()
+
+	f, err := fsys.Open("dir/b.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	contents := members["dir/b.txt"]
+	for _, offset := range []int64{100, 13, int64(len(contents)) - 5, 0} {
+		if _, err := f.(io.Seeker).Seek(offset, io.SeekStart); err != nil {
+			t.Fatal(err)
+		}
+		buf := make([]byte, 5)
+		if _, err := io.ReadFull(f, buf); err != nil {
+			t.Fatal(err)
+		}
+		if want := contents[offset : offset+5]; string(buf) != want {
+			t.Errorf("read %q at %d, want %q", buf, offset, want)
+		}
+	}
+	if _, err := f.(io.Seeker).Seek(0, 42); err == nil {
+		t.Error("seeking from an unknown origin succeeded")
+	}
+}
@@ -0,0 +1,144 @@
+package archivefs
+
+import (
+	"archive/tar"
+	"compress/bzip2"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+)
+
+// openTar opens a tar archive, compressed with the given compression unless it's empty. Members are read at their
+// offsets in the archive, so compressed archives are first decompressed into a temporary file of at most maxSize
+// bytes, which is removed as soon as it's open on systems that allow it.
+func openTar(name, compression string, maxSize int64) (*archiveFS, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open archive: %w", err)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		_ = f.Close()
+		return nil, fmt.Errorf("failed to stat archive: %w", err)
+	}
+
+	if compression != "" {
+		decompressed, err := decompress(f, compression, maxSize)
+		_ = f.Close()
+		if err != nil {
+			return nil, err
+		}
+		f = decompressed
+	}
+
+	fsys, err := readTar(f, info)
+	if err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+	fsys.closers = append(fsys.closers, f)
+	return fsys, nil
+}
+
+// decompress decompresses an archive into a temporary file of at most maxSize bytes, which is removed as soon as it's
+// open.
+func decompress(r io.Reader, compression string, maxSize int64) (*os.File, error) {
+	var (
+		zr  io.Reader
+		err error
+	)
+	switch compression {
+	case "gzip":
+		zr, err = gzip.NewReader(r)
+	case "bzip2":
+		zr = bzip2.NewReader(r)
+	default:
+		err = fmt.Errorf("unsupported compression %q", compression)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to decompress archive: %w", err)
+	}
+
+	tmp, err := createTemp("maskfs-archive-*.tar")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temporary file for archive: %w", err)
+	}
+
+	if _, err := limitedCopy(tmp, zr, maxSize); err != nil {
+		_ = tmp.Close()
+		return nil, fmt.Errorf("failed to decompress archive: %w", err)
+	}
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		_ = tmp.Close()
+		return nil, fmt.Errorf("failed to rewind decompressed archive: %w", err)
+	}
+	return tmp, nil
+}
+
+// createTemp creates a temporary file and removes it right away. The open file keeps the contents around on systems
+// that allow removing it, the rest leave it to be cleaned up.
+func createTemp(pattern string) (*os.File, error) {
+	tmp, err := os.CreateTemp("", pattern)
+	if err != nil {
+		return nil, err
+	}
+	_ = os.Remove(tmp.Name())
+	return tmp, nil
+}
+
+// readTar indexes the members of an uncompressed tar archive, recording the offsets of their contents.
+func readTar(f *os.File, info fs.FileInfo) (*archiveFS, error) {
+	var (
+		counter = &countingReader{r: f}
+		tr      = tar.NewReader(counter)
+		fsys    = newArchiveFS(info.ModTime())
+	)
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read archive: %w", err)
+		}
+
+		// The reader has consumed exactly the member's headers, so its contents start here
+		offset := counter.n
+		n := &node{mode: hdr.FileInfo().Mode(), size: hdr.Size, modTime: hdr.ModTime}
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			n.size = 0
+		case tar.TypeReg:
+			size := hdr.Size
+			n.open = func(at int64) (io.ReadCloser, error) {
+				return io.NopCloser(io.NewSectionReader(f, offset+at, size-at)), nil
+			}
+		case tar.TypeSymlink:
+			n.size, n.target = 0, hdr.Linkname
+		case tar.TypeLink:
+			n.mode, n.size, n.link = n.mode&^fs.ModeType, 0, hdr.Linkname
+		default:
+			// Device files and fifos have no contents worth serving
+			continue
+		}
+		fsys.add(hdr.Name, n)
+	}
+	fsys.resolveLinks()
+
+	return fsys, nil
+}
+
+// countingReader counts the bytes read through it.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,162 @@
+package archivefs
+
+import (
+	"archive/zip"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"sync"
+)
+
+// openZip opens a zip archive. Members stored without compression are read at their offsets in the archive, and
+// compressed members are decompressed into a temporary file the first time they're read, holding at most maxSize
+// bytes of them, and read from there.
+func openZip(name string, maxSize int64) (*archiveFS, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open archive: %w", err)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		_ = f.Close()
+		return nil, fmt.Errorf("failed to stat archive: %w", err)
+	}
+
+	zr, err := zip.NewReader(f, info.Size())
+	if err != nil {
+		_ = f.Close()
+		return nil, fmt.Errorf("failed to read archive: %w", err)
+	}
+
+	var (
+		fsys = newArchiveFS(info.ModTime())
+		sp   = &spool{max: maxSize}
+	)
+	fsys.closers = append(fsys.closers, f, sp)
+	for _, zf := range zr.File {
+		mode := zf.Mode()
+		n := &node{mode: mode, size: int64(zf.UncompressedSize64), modTime: zf.Modified}
+		switch {
+		case mode.IsDir():
+			n.size = 0
+		case mode&fs.ModeSymlink != 0:
+			// Symlinks store their targets as their contents
+			target, err := readAll(zf)
+			if err != nil {
+				_ = f.Close()
+				return nil, fmt.Errorf("failed to read symlink %q in archive: %w", zf.Name, err)
+			}
+			n.size, n.target = 0, target
+		case mode.IsRegular():
+			n.open = zipOpener(f, sp, zf)
+		default:
+			continue
+		}
+		fsys.add(zf.Name, n)
+	}
+
+	return fsys, nil
+}
+
+// zipOpener returns the opener of a zip member's contents.
+func zipOpener(f *os.File, sp *spool, zf *zip.File) opener {
+	size := int64(zf.UncompressedSize64)
+	if zf.Method == zip.Store {
+		if offset, err := zf.DataOffset(); err == nil {
+			return func(at int64) (io.ReadCloser, error) {
+				return io.NopCloser(io.NewSectionReader(f, offset+at, size-at)), nil
+			}
+		}
+	}
+
+	var (
+		mu      sync.Mutex
+		spooled bool
+		offset  int64
+	)
+	return func(at int64) (io.ReadCloser, error) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		if !spooled {
+			var err error
+			if offset, err = sp.add(zf); err != nil {
+				return nil, err
+			}
+			spooled = true
+		}
+		return io.NopCloser(io.NewSectionReader(sp, offset+at, size-at)), nil
+	}
+}
+
+// spool holds the decompressed contents of compressed members in a temporary file, so that each member is only
+// decompressed once however often, and from wherever, it's read.
+type spool struct {
+	mu   sync.Mutex
+	f    *os.File // Created when the first member is added
+	size int64
+	max  int64 // Maximum size of the contents of every member combined, 0 for no limit
+}
+
+// add decompresses a member to the end of the spool and returns the offset its contents start at.
+func (s *spool) add(zf *zip.File) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.f == nil {
+		f, err := createTemp("maskfs-archive-*.spool")
+		if err != nil {
+			return 0, fmt.Errorf("failed to create temporary file for archive members: %w", err)
+		}
+		s.f = f
+	}
+
+	rc, err := zf.Open()
+	if err != nil {
+		return 0, err
+	}
+	defer rc.Close()
+
+	var limit int64
+	if s.max > 0 {
+		if limit = s.max - s.size; limit <= 0 {
+			return 0, errTooLarge
+		}
+	}
+	n, err := limitedCopy(io.NewOffsetWriter(s.f, s.size), rc, limit)
+	if err != nil {
+		// Whatever was written is overwritten by the next member
+		return 0, err
+	}
+
+	offset := s.size
+	s.size += n
+	return offset, nil
+}
+
+func (s *spool) ReadAt(p []byte, off int64) (int, error) {
+	return s.f.ReadAt(p, off)
+}
+
+func (s *spool) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.f == nil {
+		return nil
+	}
+	return s.f.Close()
+}
+
+// readAll reads the whole contents of a small zip member.
+func readAll(zf *zip.File) (string, error) {
+	rc, err := zf.Open()
+	if err != nil {
+		return "", err
+	}
+	defer rc.Close()
+
+	data, err := io.ReadAll(io.LimitReader(rc, 4096))
+	return string(data), err
+}
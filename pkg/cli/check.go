@@ -0,0 +1,41 @@
+package cli
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+)
+
+type Check struct {
+	MaskOptions
+}
+
+func (c *Check) Customize(cmd *cobra.Command) {
+	cmd.Use = "check [flags] <path>..."
+	cmd.Short = "Check that the mask hides every path, relative to the root, failing if any would be exposed"
+	cmd.Args = cobra.MinimumNArgs(1)
+}
+
+func (c *Check) Run(cmd *cobra.Command, args []string) error {
+	srv, err := c.server(cmd)
+	if err != nil {
+		return err
+	}
+
+	var exposed int
+	for _, arg := range args {
+		explanation, err := explainPath(srv, arg)
+		if err != nil {
+			return fmt.Errorf("failed to check %q: %w", arg, err)
+		}
+		if !explanation.Masked {
+			exposed++
+		}
+		fmt.Fprintf(cmd.OutOrStdout(), "%s: %s\n", arg, describe(explanation))
+	}
+
+	if exposed > 0 {
+		return fmt.Errorf("%d of %d paths would be exposed", exposed, len(args))
+	}
+	return nil
+}
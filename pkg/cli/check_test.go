@@ -0,0 +1,50 @@
+package cli
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// runCommand runs the maskfs command with the given arguments, returning what it printed.
+func runCommand(t *testing.T, args ...string) (string, error) {
+	t.Helper()
+	var out bytes.Buffer
+	cmd := New()
+	cmd.SetArgs(args)
+	cmd.SetOut(&out)
+	cmd.SetErr(&out)
+	err := cmd.Execute()
+	return out.String(), err
+}
+
+func TestCheck(t *testing.T) {
+	root := t.TempDir()
+	if err := os.WriteFile(filepath.Join(root, "a.txt"), []byte("a"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(root, "b.key"), []byte("b"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	mask := "--mask=**\n!*.key\n!secrets/"
+
+	// Paths that don't exist are checked too, as directories when they have a trailing slash
+	out, err := runCommand(t, "check", "--root", root, mask, "b.key", "/c.key", "secrets/")
+	if err != nil {
+		t.Fatalf("check of masked paths failed: %v\n%s", err, out)
+	}
+	if !strings.Contains(out, `b.key: masked, path rule 2 "!*.key" matches`) || !strings.Contains(out, `secrets/: masked, path rule 3 "!secrets/" matches`) {
+		t.Errorf("check of masked paths printed:\n%s", out)
+	}
+
+	// Exposing any path fails the command, and so exits with a non-zero status
+	out, err = runCommand(t, "check", "--root", root, mask, "b.key", "a.txt", "secrets")
+	if err == nil || err.Error() != "2 of 3 paths would be exposed" {
+		t.Errorf("check of exposed paths = %v, want 2 of 3 paths exposed\n%s", err, out)
+	}
+	if !strings.Contains(out, `a.txt: exposed, path rule 1 "**" matches`) {
+		t.Errorf("check of exposed paths printed:\n%s", out)
+	}
+}
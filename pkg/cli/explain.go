@@ -0,0 +1,81 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"strings"
+
+	"github.com/njhale/maskfs/pkg/index"
+	"github.com/njhale/maskfs/pkg/server"
+	"github.com/spf13/cobra"
+)
+
+type Explain struct {
+	MaskOptions
+}
+
+func (e *Explain) Customize(cmd *cobra.Command) {
+	cmd.Use = "explain [flags] <path>..."
+	cmd.Short = "Explain which mask rule decides whether each path, relative to the root, is masked"
+	cmd.Args = cobra.MinimumNArgs(1)
+}
+
+func (e *Explain) Run(cmd *cobra.Command, args []string) error {
+	srv, err := e.server(cmd)
+	if err != nil {
+		return err
+	}
+
+	for _, arg := range args {
+		explanation, err := explainPath(srv, arg)
+		if err != nil {
+			fmt.Fprintf(cmd.OutOrStdout(), "%s: %v\n", arg, err)
+			continue
+		}
+		fmt.Fprintf(cmd.OutOrStdout(), "%s: %s\n", arg, describe(explanation))
+	}
+
+	return nil
+}
+
+// explainPath explains the mask's decision for a path relative to the root given on the command line, which may have
+// a leading slash like a request path. Paths that don't exist are explained as files, or as directories when they
+// have a trailing slash.
+func explainPath(srv *server.Server, arg string) (*server.Explanation, error) {
+	fsPath := path.Clean(strings.TrimPrefix(arg, "/"))
+	explanation, err := srv.Explain(fsPath)
+	if !errors.Is(err, fs.ErrNotExist) {
+		return explanation, err
+	}
+
+	return srv.ExplainEntry(&index.Entry{
+		Name:   path.Base(fsPath),
+		IsDir:  strings.HasSuffix(arg, "/"),
+		FSPath: fsPath,
+	}), nil
+}
+
+// describe returns a sentence describing an explanation.
+func describe(e *server.Explanation) string {
+	decision := "exposed"
+	if e.Masked {
+		decision = "masked"
+	}
+
+	var rule string
+	if e.Index < 0 {
+		rule = "no path rule matches"
+	} else {
+		rule = fmt.Sprintf("path rule %d %q matches", e.Index+1, e.Rule)
+		if e.RuleFile != "" {
+			rule = fmt.Sprintf("path rule %d %q from %s matches", e.Index+1, e.Rule, e.RuleFile)
+		}
+	}
+
+	if e.Masked && !e.PathMasked {
+		return fmt.Sprintf("%s by another mask, like the junk patterns or a size limit, though the path rules expose it, %s", decision, rule)
+	}
+	return fmt.Sprintf("%s, %s", decision, rule)
+}
@@ -0,0 +1,35 @@
+package cli
+
+import (
+	"testing"
+
+	"github.com/njhale/maskfs/pkg/server"
+)
+
+func TestDescribe(t *testing.T) {
+	for _, tt := range []struct {
+		explanation server.Explanation
+		want        string
+	}{
+		{
+			explanation: server.Explanation{Rule: "**", Index: 0},
+			want:        `exposed, path rule 1 "**" matches`,
+		},
+		{
+			explanation: server.Explanation{Rule: "*.txt", Index: 2, RuleFile: "docs/.maskfs"},
+			want:        `exposed, path rule 3 "*.txt" from docs/.maskfs matches`,
+		},
+		{
+			explanation: server.Explanation{Masked: true, PathMasked: true, Index: -1},
+			want:        "masked, no path rule matches",
+		},
+		{
+			explanation: server.Explanation{Masked: true, Rule: "**", Index: 0},
+			want:        `masked by another mask, like the junk patterns or a size limit, though the path rules expose it, path rule 1 "**" matches`,
+		},
+	} {
+		if got := describe(&tt.explanation); got != tt.want {
+			t.Errorf("describe(%+v) = %q, want %q", tt.explanation, got, tt.want)
+		}
+	}
+}
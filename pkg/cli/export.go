@@ -0,0 +1,84 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+type Export struct {
+	MaskOptions
+	Dir    string `usage:"Directory to export, relative to the root" default:"."`
+	Format string `usage:"Format of the export, tar.gz, zip, or dir to copy the files into a directory, guessed from the destination's extension if unset"`
+}
+
+func (e *Export) Customize(cmd *cobra.Command) {
+	cmd.Use = "export [flags] <destination>"
+	cmd.Short = "Export the files the mask exposes to an archive or a directory, preserving modes and modification times"
+	cmd.Args = cobra.ExactArgs(1)
+}
+
+func (e *Export) Run(cmd *cobra.Command, args []string) error {
+	dst := args[0]
+	format, err := exportFormat(e.Format, dst)
+	if err != nil {
+		return err
+	}
+
+	srv, err := e.server(cmd)
+	if err != nil {
+		return err
+	}
+	dir := path.Clean(strings.TrimPrefix(e.Dir, "/"))
+
+	if format == "dir" {
+		if err := srv.ExportDir(dst, dir); err != nil {
+			return fmt.Errorf("failed to export: %w", err)
+		}
+		return nil
+	}
+
+	if dst == "-" {
+		if err := srv.Export(cmd.OutOrStdout(), dir, format); err != nil {
+			return fmt.Errorf("failed to export: %w", err)
+		}
+		return nil
+	}
+
+	f, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to create archive: %w", err)
+	}
+	if err := srv.Export(f, dir, format); err != nil {
+		// Don't leave a truncated archive behind
+		_ = f.Close()
+		_ = os.Remove(dst)
+		return fmt.Errorf("failed to export: %w", err)
+	}
+	return f.Close()
+}
+
+// exportFormat returns the format to export in, guessing it from the destination if unset.
+func exportFormat(format, dst string) (string, error) {
+	switch format {
+	case "tar.gz", "zip", "dir":
+		return format, nil
+	case "":
+	default:
+		return "", fmt.Errorf("unsupported export format %q, must be tar.gz, zip, or dir", format)
+	}
+
+	switch {
+	case strings.HasSuffix(dst, ".tar.gz"), strings.HasSuffix(dst, ".tgz"):
+		return "tar.gz", nil
+	case strings.HasSuffix(dst, ".zip"):
+		return "zip", nil
+	case dst == "-":
+		return "", fmt.Errorf("--format is required when exporting to stdout")
+	default:
+		return "dir", nil
+	}
+}
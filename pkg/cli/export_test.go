@@ -0,0 +1,60 @@
+package cli
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestExportFormat(t *testing.T) {
+	for _, tt := range []struct {
+		format, dst string
+		want        string
+	}{
+		{dst: "out.tar.gz", want: "tar.gz"},
+		{dst: "out.tgz", want: "tar.gz"},
+		{dst: "out.zip", want: "zip"},
+		{dst: "out", want: "dir"},
+		{format: "zip", dst: "-", want: "zip"},
+		{format: "dir", dst: "out.zip", want: "dir"},
+	} {
+		if got, err := exportFormat(tt.format, tt.dst); err != nil || got != tt.want {
+			t.Errorf("exportFormat(%q, %q) = %q, %v, want %q", tt.format, tt.dst, got, err, tt.want)
+		}
+	}
+
+	for _, tt := range []struct{ format, dst string }{{dst: "-"}, {format: "rar", dst: "out.rar"}} {
+		if _, err := exportFormat(tt.format, tt.dst); err == nil {
+			t.Errorf("exportFormat(%q, %q) succeeded", tt.format, tt.dst)
+		}
+	}
+}
+
+func TestExport(t *testing.T) {
+	root := t.TempDir()
+	for name, contents := range map[string]string{"a.txt": "a", "b.key": "b"} {
+		if err := os.WriteFile(filepath.Join(root, name), []byte(contents), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	dst := filepath.Join(t.TempDir(), "out")
+	if out, err := runCommand(t, "export", "--root", root, "--mask=**\n!*.key", dst); err != nil {
+		t.Fatalf("%v\n%s", err, out)
+	}
+	if data, err := os.ReadFile(filepath.Join(dst, "a.txt")); err != nil || string(data) != "a" {
+		t.Errorf("exported a.txt = %q, %v", data, err)
+	}
+	if _, err := os.Stat(filepath.Join(dst, "b.key")); err == nil {
+		t.Error("masked b.key was exported")
+	}
+
+	// Archives aren't left behind when the export fails
+	archive := filepath.Join(t.TempDir(), "out.zip")
+	if out, err := runCommand(t, "export", "--root", root, "--dir", "b.key", archive); err == nil {
+		t.Errorf("export of a masked directory succeeded:\n%s", out)
+	}
+	if _, err := os.Stat(archive); err == nil {
+		t.Error("failed export left an archive behind")
+	}
+}
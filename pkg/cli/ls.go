@@ -0,0 +1,136 @@
+package cli
+
+import (
+	"fmt"
+	"io/fs"
+	"path"
+	"strings"
+
+	"github.com/njhale/maskfs/pkg/index"
+	"github.com/spf13/cobra"
+)
+
+type Ls struct {
+	MaskOptions
+	Recursive bool `usage:"List every entry below the directory rather than only its children" short:"r"`
+}
+
+func (l *Ls) Customize(cmd *cobra.Command) {
+	cmd.Use = "ls [flags] [dir]"
+	cmd.Short = "List the entries of a directory, relative to the root, that the mask exposes"
+	cmd.Args = cobra.MaximumNArgs(1)
+}
+
+func (l *Ls) Run(cmd *cobra.Command, args []string) error {
+	fsys, dir, err := l.dir(cmd, args)
+	if err != nil {
+		return err
+	}
+
+	return index.Walk(fsys, dir, nil, func(entry *index.Entry, _ int) error {
+		fmt.Fprintln(cmd.OutOrStdout(), displayName(entry, entry.RelPath(&index.Entry{FSPath: dir})))
+		if entry.IsDir && !l.Recursive {
+			return fs.SkipDir
+		}
+		return nil
+	})
+}
+
+type Tree struct {
+	MaskOptions
+	MaxDepth int `usage:"Maximum depth of directories to descend into, 0 for no limit" short:"L"`
+}
+
+func (t *Tree) Customize(cmd *cobra.Command) {
+	cmd.Use = "tree [flags] [dir]"
+	cmd.Short = "Print the tree of entries below a directory, relative to the root, that the mask exposes"
+	cmd.Args = cobra.MaximumNArgs(1)
+}
+
+func (t *Tree) Run(cmd *cobra.Command, args []string) error {
+	fsys, dir, err := t.dir(cmd, args)
+	if err != nil {
+		return err
+	}
+
+	out := cmd.OutOrStdout()
+	fmt.Fprintln(out, dir)
+
+	var (
+		dirs, files int
+		print       func(dir, prefix string, depth int) error
+	)
+	print = func(dir, prefix string, depth int) error {
+		entries, err := index.GetEntries(fsys, dir, nil)
+		if err != nil {
+			return err
+		}
+		if err := entries.SortBy(index.SortByName, false); err != nil {
+			return err
+		}
+
+		for i, entry := range entries {
+			branch, indent := "├── ", "│   "
+			if i == len(entries)-1 {
+				branch, indent = "└── ", "    "
+			}
+			fmt.Fprintln(out, prefix+branch+displayName(entry, entry.Name))
+
+			if !entry.IsDir {
+				files++
+				continue
+			}
+			dirs++
+
+			// Like Walk, don't descend into symlinked directories, to avoid loops
+			if entry.IsSymlink || (t.MaxDepth > 0 && depth >= t.MaxDepth) {
+				continue
+			}
+			if err := print(entry.FSPath, prefix+indent, depth+1); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if err := print(dir, "", 1); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(out, "\n%d directories, %d files\n", dirs, files)
+	return nil
+}
+
+// dir returns the masked root along with the directory named by the optional argument, relative to the root.
+func (o MaskOptions) dir(cmd *cobra.Command, args []string) (fs.FS, string, error) {
+	fsys, err := o.fs(cmd)
+	if err != nil {
+		return nil, "", err
+	}
+
+	dir := "."
+	if len(args) > 0 {
+		dir = path.Clean(strings.TrimPrefix(args[0], "/"))
+	}
+	if info, err := fs.Stat(fsys, dir); err != nil {
+		return nil, "", err
+	} else if !info.IsDir() {
+		return nil, "", fmt.Errorf("%q isn't a directory", dir)
+	}
+
+	return fsys, dir, nil
+}
+
+// displayName returns the name to print for an entry, marking directories with a trailing slash and symlinks with
+// their targets.
+func displayName(entry *index.Entry, name string) string {
+	if entry.IsDir {
+		name += "/"
+	}
+	if entry.IsSymlink {
+		if entry.Loop {
+			name += " (loop)"
+		}
+		name += " @"
+	}
+	return name
+}
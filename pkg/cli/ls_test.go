@@ -0,0 +1,70 @@
+package cli
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLsAndTree(t *testing.T) {
+	root := t.TempDir()
+	for _, name := range []string{"a.txt", "b.key", "dir/c.txt", "dir/sub/d.txt", "secrets/e.txt"} {
+		name = filepath.Join(root, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(name), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(name, nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	mask := "--mask=**\n!*.key\n!secrets/"
+
+	for _, tt := range []struct {
+		name string
+		args []string
+		want string
+	}{
+		{
+			name: "ls",
+			args: []string{"ls", "--root", root, mask},
+			want: "a.txt\ndir/\n",
+		},
+		{
+			name: "ls dir",
+			args: []string{"ls", "--root", root, mask, "/dir"},
+			want: "c.txt\nsub/\n",
+		},
+		{
+			name: "ls recursive",
+			args: []string{"ls", "-r", "--root", root, mask},
+			want: "a.txt\ndir/\ndir/c.txt\ndir/sub/\ndir/sub/d.txt\n",
+		},
+		{
+			name: "tree",
+			args: []string{"tree", "--root", root, mask},
+			want: ".\n├── a.txt\n└── dir/\n    ├── c.txt\n    └── sub/\n        └── d.txt\n\n2 directories, 3 files\n",
+		},
+		{
+			name: "tree max depth",
+			args: []string{"tree", "-L", "1", "--root", root, mask},
+			want: ".\n├── a.txt\n└── dir/\n\n1 directories, 1 files\n",
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			out, err := runCommand(t, tt.args...)
+			if err != nil {
+				t.Fatalf("%v\n%s", err, out)
+			}
+			if out != tt.want {
+				t.Errorf("printed:\n%s\nwant:\n%s", out, tt.want)
+			}
+		})
+	}
+
+	// Masked directories don't exist, and files can't be listed
+	for _, dir := range []string{"secrets", "a.txt"} {
+		if out, err := runCommand(t, "ls", "--root", root, mask, dir); err == nil {
+			t.Errorf("ls %s succeeded:\n%s", dir, out)
+		}
+	}
+}
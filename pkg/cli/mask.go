@@ -0,0 +1,95 @@
+package cli
+
+import (
+	"io/fs"
+
+	"github.com/njhale/maskfs/pkg/server"
+	"github.com/spf13/cobra"
+)
+
+// MaskOptions select the masked view of a directory, for the commands that expose it without the file server.
+// They mean the same as the file server's flags of the same names.
+type MaskOptions struct {
+	Root                   string   `usage:"Directory to expose, tar or zip archive to expose the members of, or S3 bucket and key prefix to expose the objects of as s3://bucket/prefix" default:"."`
+	Mask                   string   `usage:"Path mask to apply, rules like mtime:<30d only expose files modified within the last 30 days" default:"**/maskfs/\n**/*.go"`
+	MaskFile               string   `usage:"Path to a file of mask rules, inline --mask rules are applied after them and take precedence"`
+	MaskURL                string   `name:"mask-url" usage:"HTTPS URL of mask rules shared by many servers, or an HTTP URL on a loopback address, the mask file's and inline rules are applied after them and take precedence"`
+	MaskType               string   `usage:"Syntax of mask rules, glob for .gitignore patterns or regex for RE2 regular expressions matched against paths relative to the root" default:"glob"`
+	MaskMode               string   `usage:"How mask rules are used, include to select the files to expose or exclude to select the files to hide like .gitignore" default:"include"`
+	HideJunk               string   `usage:"New-line delimited name patterns of junk files to hide, empty to show them" default:"*~\n.DS_Store\nThumbs.db\n#*#"`
+	MinFileSize            int64    `usage:"Hide files smaller than this size in bytes, 0 for no limit"`
+	MaxFileSize            int64    `usage:"Hide files larger than this size in bytes, 0 for no limit"`
+	ContentTypes           string   `usage:"New-line delimited content type patterns, like text/*, of the only files to serve, sniffed from their first 512 bytes"`
+	HideContentTypes       string   `usage:"New-line delimited content type patterns, like application/octet-stream for binaries, of files to hide, sniffed from their first 512 bytes"`
+	OwnedBy                string   `usage:"Hide entries not owned by this user or group, given as user, user:group, or :group by name or ID"`
+	RequirePerm            string   `usage:"Octal permission bits entries must all have to be served, like 0004 to hide entries that aren't world-readable"`
+	Overlay                []string `split:"false" usage:"Additional root layered over the root, which can be a directory, an archive, or an s3:// root, shadowing the entries at the same paths in the root and earlier layers while merging directories, can be repeated"`
+	MaxArchiveSize         int64    `usage:"Maximum size in bytes that the compressed contents of an archive root or overlay are decompressed to, 0 for no limit" default:"10737418240"`
+	GitRepo                string   `usage:"Path of a bare or working git repository to expose a revision of instead of the root"`
+	GitRef                 string   `usage:"Git revision to expose from the git repository, like a branch, a tag, or a commit" default:"HEAD"`
+	S3Region               string   `name:"s3-region" usage:"Region of the bucket of an s3:// root, empty to use the region of the AWS configuration"`
+	S3Endpoint             string   `name:"s3-endpoint" usage:"URL of an S3 compatible object store to expose an s3:// root from instead of AWS, like http://localhost:9000 for MinIO"`
+	S3PathStyle            bool     `name:"s3-path-style" usage:"Address the bucket of an s3:// root in the URL path rather than the host name, which most S3 compatible object stores need"`
+	S3AccessKeyID          string   `name:"s3-access-key-id" usage:"Access key ID of static credentials for an s3:// root, instead of the default AWS credential chain"`
+	S3SecretAccessKeyFile  string   `name:"s3-secret-access-key-file" usage:"Path of a file containing the secret access key of the static credentials for an s3:// root"`
+	S3StatCache            string   `name:"s3-stat-cache" usage:"How long the stats of objects listed in a directory of an s3:// root are remembered, 0 to ask the bucket for every stat" default:"1m"`
+	S3Timeout              string   `name:"s3-timeout" usage:"How long a request to the bucket of an s3:// root can take, or go without sending any of an object being read, 0 for no limit" default:"30s"`
+	NestedMaskFile         string   `usage:"Name of per-directory files whose rules are layered on the mask for their directory and below, e.g. .maskfs"`
+	FollowExternalSymlinks bool     `usage:"Follow symlinks whose targets are outside of the root instead of treating them as not found"`
+	Symlinks               string   `usage:"How symlinks are handled, serve to follow them, deny to hide them and everything reached through them, or resolve-and-mask to only expose them when their targets are inside the root and unmasked too" default:"serve"`
+}
+
+// fs returns the root with the mask applied, so that masked entries don't exist in it.
+func (o MaskOptions) fs(cmd *cobra.Command) (fs.FS, error) {
+	srv, err := o.server(cmd)
+	if err != nil {
+		return nil, err
+	}
+	return srv.FS(), nil
+}
+
+// server returns a file server of the root with the mask applied, which is never run.
+func (o MaskOptions) server(cmd *cobra.Command) (*server.Server, error) {
+	cfg := server.Config{
+		Root:                   o.Root,
+		Mask:                   o.Mask,
+		MaskFile:               o.MaskFile,
+		MaskURL:                o.MaskURL,
+		MaskType:               o.MaskType,
+		MaskMode:               o.MaskMode,
+		HideJunk:               o.HideJunk,
+		MinFileSize:            o.MinFileSize,
+		MaxFileSize:            o.MaxFileSize,
+		ContentTypes:           o.ContentTypes,
+		HideContentTypes:       o.HideContentTypes,
+		OwnedBy:                o.OwnedBy,
+		RequirePerm:            o.RequirePerm,
+		Overlay:                o.Overlay,
+		MaxArchiveSize:         o.MaxArchiveSize,
+		GitRepo:                o.GitRepo,
+		GitRef:                 o.GitRef,
+		S3Region:               o.S3Region,
+		S3Endpoint:             o.S3Endpoint,
+		S3PathStyle:            o.S3PathStyle,
+		S3AccessKeyID:          o.S3AccessKeyID,
+		S3SecretAccessKeyFile:  o.S3SecretAccessKeyFile,
+		S3StatCache:            o.S3StatCache,
+		S3Timeout:              o.S3Timeout,
+		NestedMaskFile:         o.NestedMaskFile,
+		FollowExternalSymlinks: o.FollowExternalSymlinks,
+		Symlinks:               o.Symlinks,
+	}
+	clearDefaultMask(cmd, &cfg)
+
+	return server.New(server.WithConfig(cfg))
+}
+
+// clearDefaultMask drops the default inline mask when a mask file or URL, the exclude mode, or regex rules are used
+// without --mask.
+func clearDefaultMask(cmd *cobra.Command, cfg *server.Config) {
+	if (cfg.MaskFile != "" || cfg.MaskURL != "" || cfg.MaskMode == "exclude" || cfg.MaskType == "regex") && !cmd.Flags().Changed("mask") {
+		// Don't layer the default inline mask on top of the mask file's or URL's rules, use it to hide the very files it's
+		// meant to select, or parse its globs as regular expressions
+		cfg.Mask = ""
+	}
+}
@@ -0,0 +1,44 @@
+package cli
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/njhale/maskfs/pkg/fusefs"
+	"github.com/spf13/cobra"
+)
+
+type Mount struct {
+	MaskOptions
+	AllowOther   bool   `usage:"Let users other than the one mounting access the mount, requires user_allow_other in /etc/fuse.conf"`
+	CacheTimeout string `usage:"How long the kernel may cache names and attributes before looking them up again" default:"1s"`
+	FuseDebug    bool   `usage:"Log every FUSE request"`
+}
+
+func (m *Mount) Customize(cmd *cobra.Command) {
+	cmd.Use = "mount [flags] <mountpoint>"
+	cmd.Short = "Mount the masked view of a directory read-only with FUSE, where masked entries don't exist"
+	cmd.Args = cobra.ExactArgs(1)
+}
+
+func (m *Mount) Run(cmd *cobra.Command, args []string) error {
+	cacheTimeout, err := time.ParseDuration(m.CacheTimeout)
+	if err != nil {
+		return err
+	}
+
+	fsys, err := m.fs(cmd)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := signal.NotifyContext(cmd.Context(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+	return fusefs.Mount(ctx, args[0], fsys, fusefs.Options{
+		AllowOther:   m.AllowOther,
+		CacheTimeout: cacheTimeout,
+		Debug:        m.FuseDebug,
+	})
+}
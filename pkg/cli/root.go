@@ -11,7 +11,8 @@ import (
 )
 
 type MaskFS struct {
-	Debug bool `usage:"Enable debug logging"`
+	Debug     bool   `usage:"Enable debug logging"`
+	LogFormat string `usage:"Format of log records, text or json" default:"text"`
 }
 
 func (m *MaskFS) PersistentPre(*cobra.Command, []string) error {
@@ -23,6 +24,10 @@ func (m *MaskFS) PersistentPre(*cobra.Command, []string) error {
 		logger.SetDebug()
 	}
 
+	if err := logger.SetFormat(m.LogFormat); err != nil {
+		return err
+	}
+
 	return nil
 }
 
@@ -30,6 +35,15 @@ func New() *cobra.Command {
 	root := &MaskFS{}
 	return cmd.Command(root,
 		&Server{},
+		&Mount{},
+		&SFTP{},
+		&Explain{},
+		&Check{},
+		&Ls{},
+		&Tree{},
+		&Export{},
+		&Sync{},
+		&Sign{},
 	)
 }
 
@@ -14,6 +14,8 @@ type Server struct {
 }
 
 func (s *Server) Run(cmd *cobra.Command, _ []string) error {
+	clearDefaultMask(cmd, &s.Config)
+
 	ctx, cancel := signal.NotifyContext(cmd.Context(), os.Interrupt, os.Kill, syscall.SIGTERM)
 	defer cancel()
 	return server.Run(ctx, s.Config)
@@ -0,0 +1,56 @@
+package cli
+
+import (
+	"net"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/njhale/maskfs/pkg/sftpd"
+	"github.com/spf13/cobra"
+)
+
+type SFTP struct {
+	MaskOptions
+	Port           string `usage:"Port to listen on" default:"2022"`
+	HostKey        string `usage:"Path to the SSH host key, an ed25519 key is generated there if it doesn't exist" default:"maskfs_host_ed25519_key"`
+	AuthorizedKeys string `usage:"Path to a file of the public keys allowed to connect, in the authorized_keys format"`
+	MaxConnections int    `usage:"Maximum number of clients connected at once, 0 for no limit" default:"100"`
+	IdleTimeout    string `usage:"How long a client can go without sending anything before it's disconnected, 0 for no limit" default:"10m"`
+}
+
+func (s *SFTP) Customize(cmd *cobra.Command) {
+	cmd.Short = "Serve the masked view of a directory read-only over SFTP"
+}
+
+func (s *SFTP) Run(cmd *cobra.Command, _ []string) error {
+	fsys, err := s.fs(cmd)
+	if err != nil {
+		return err
+	}
+
+	idleTimeout, err := time.ParseDuration(s.IdleTimeout)
+	if err != nil {
+		return err
+	}
+
+	server, err := sftpd.New(fsys, sftpd.Config{
+		HostKeyFile:        s.HostKey,
+		AuthorizedKeysFile: s.AuthorizedKeys,
+		MaxConnections:     s.MaxConnections,
+		IdleTimeout:        idleTimeout,
+	})
+	if err != nil {
+		return err
+	}
+
+	l, err := net.Listen("tcp", ":"+s.Port)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := signal.NotifyContext(cmd.Context(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+	return server.Serve(ctx, l)
+}
@@ -0,0 +1,53 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/njhale/maskfs/pkg/server"
+	"github.com/spf13/cobra"
+)
+
+type Sign struct {
+	SigningKey     string `usage:"Key to sign the URL with, the same as the server's --signing-key"`
+	SigningKeyFile string `usage:"Path to a file containing the key to sign the URL with, instead of --signing-key"`
+	ExpiresIn      string `usage:"How long the signed URL grants access for" default:"1h"`
+}
+
+func (s *Sign) Customize(cmd *cobra.Command) {
+	cmd.Use = "sign [flags] <url>"
+	cmd.Short = "Sign the URL of a file so that it can be requested without authentication until it expires, while the mask still applies"
+	cmd.Args = cobra.ExactArgs(1)
+}
+
+func (s *Sign) Run(cmd *cobra.Command, args []string) error {
+	key, err := server.LoadSigningKey(s.SigningKey, s.SigningKeyFile)
+	if err != nil {
+		return err
+	}
+	if key == nil {
+		return errors.New("a signing key or a signing key file is required")
+	}
+
+	expiresIn, err := time.ParseDuration(s.ExpiresIn)
+	if err != nil {
+		return fmt.Errorf("invalid expiry %q: %w", s.ExpiresIn, err)
+	}
+	if expiresIn <= 0 {
+		return fmt.Errorf("invalid expiry %q, must be positive", s.ExpiresIn)
+	}
+
+	u, err := url.Parse(args[0])
+	if err != nil {
+		return fmt.Errorf("failed to parse URL: %w", err)
+	}
+
+	signed, err := server.SignURL(u, key, time.Now().Add(expiresIn))
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(cmd.OutOrStdout(), signed)
+	return nil
+}
@@ -0,0 +1,52 @@
+package cli
+
+import (
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSign(t *testing.T) {
+	keyFile := filepath.Join(t.TempDir(), "key")
+	if err := os.WriteFile(keyFile, []byte("key\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, args := range [][]string{
+		{"--signing-key", "key"},
+		{"--signing-key-file", keyFile},
+	} {
+		out, err := runCommand(t, append([]string{"sign", "--expires-in", "2h", "https://example.com/files/a.txt?download=1"}, args...)...)
+		if err != nil {
+			t.Fatalf("sign %q failed: %v\n%s", args, err, out)
+		}
+		u, err := url.Parse(strings.TrimSpace(out))
+		if err != nil {
+			t.Fatal(err)
+		}
+		query := u.Query()
+		if u.Host != "example.com" || u.Path != "/files/a.txt" || query.Get("download") != "1" || query.Get("signature") == "" {
+			t.Errorf("sign %q printed %q, want the URL with its query and a signature", args, out)
+		}
+		expires, err := strconv.ParseInt(query.Get("expires"), 10, 64)
+		if until := time.Until(time.Unix(expires, 0)); err != nil || until < time.Hour || until > 2*time.Hour {
+			t.Errorf("signed URL expires in %s, %v, want 2h", until, err)
+		}
+	}
+
+	for _, args := range [][]string{
+		{"sign", "https://example.com/files/a.txt"},
+		{"sign", "--signing-key", "key", "--signing-key-file", keyFile, "https://example.com/files/a.txt"},
+		{"sign", "--signing-key", "key", "--expires-in", "-1h", "https://example.com/files/a.txt"},
+		{"sign", "--signing-key", "key", "--expires-in", "soon", "https://example.com/files/a.txt"},
+		{"sign", "--signing-key", "key", "%zz"},
+	} {
+		if out, err := runCommand(t, args...); err == nil {
+			t.Errorf("%q succeeded, printing %q", args, out)
+		}
+	}
+}
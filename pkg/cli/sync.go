@@ -0,0 +1,237 @@
+package cli
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/njhale/maskfs/pkg/client"
+	"github.com/njhale/maskfs/pkg/index"
+	"github.com/spf13/cobra"
+)
+
+type Sync struct {
+	AuthToken string `usage:"Bearer token to authenticate to the server with"`
+	BasicAuth string `usage:"User and password to authenticate to the server with as user:password, instead of a bearer token"`
+	Delete    bool   `usage:"Delete local files and directories that the server doesn't serve anymore"`
+	Retries   int    `usage:"Number of times to retry requests that fail with network errors or 5xx responses" default:"3"`
+	StateFile string `usage:"File to remember the listings of the last sync in, so that directories whose listings' ETags haven't changed aren't listed again, empty for a file in the user's cache directory, - to disable"`
+}
+
+func (s *Sync) Customize(cmd *cobra.Command) {
+	cmd.Use = "sync [flags] <server-url> <dest-dir>"
+	cmd.Short = "Mirror the files a maskfs server serves under a URL, like http://localhost:9888/files/logs, into a directory, revalidating listings with their ETags and downloading only files whose size or modification time changed"
+	cmd.Args = cobra.ExactArgs(2)
+}
+
+func (s *Sync) Run(cmd *cobra.Command, args []string) error {
+	serverURL, dst := args[0], args[1]
+
+	opts := []client.Option{client.WithRetries(s.Retries, client.DefaultBackoff)}
+	switch {
+	case s.AuthToken != "":
+		opts = append(opts, client.WithBearerToken(s.AuthToken))
+	case s.BasicAuth != "":
+		user, password, ok := strings.Cut(s.BasicAuth, ":")
+		if !ok {
+			return fmt.Errorf("--basic-auth must be user:password")
+		}
+		opts = append(opts, client.WithBasicAuth(user, password))
+	}
+
+	if err := os.MkdirAll(dst, 0o755); err != nil {
+		return fmt.Errorf("failed to create destination: %w", err)
+	}
+	dst, err := filepath.Abs(dst)
+	if err != nil {
+		return fmt.Errorf("failed to resolve destination: %w", err)
+	}
+
+	stateFile, err := s.stateFile(serverURL, dst)
+	if err != nil {
+		return err
+	}
+	listings := loadListings(stateFile)
+	opts = append(opts, client.WithListingCache(listings))
+
+	c, err := client.New(serverURL, opts...)
+	if err != nil {
+		return err
+	}
+
+	var (
+		ctx                          = cmd.Context()
+		out                          = cmd.OutOrStdout()
+		remote                       = map[string]bool{}
+		downloaded, unchanged, total int
+	)
+	err = c.Walk(ctx, ".", func(p string, entry *index.Entry, err error) error {
+		if err != nil {
+			return err
+		}
+		remote[p] = true
+
+		// The client rejects listings with names that aren't names of children, but never write outside of dst even if
+		// it didn't
+		if p != "." && !client.ValidName(entry.Name) {
+			return fmt.Errorf("refusing to sync %q, the server listed it with an invalid name", p)
+		}
+		local := filepath.Join(dst, filepath.FromSlash(p))
+		if rel, err := filepath.Rel(dst, local); err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return fmt.Errorf("refusing to sync %q outside of %s", p, dst)
+		}
+
+		if entry.IsDir {
+			if entry.Loop {
+				return fs.SkipDir
+			}
+			if info, err := os.Lstat(local); err == nil && !info.IsDir() {
+				if err := os.Remove(local); err != nil {
+					return fmt.Errorf("failed to replace %s with a directory: %w", local, err)
+				}
+			}
+			if err := os.MkdirAll(local, 0o755); err != nil {
+				return fmt.Errorf("failed to create directory: %w", err)
+			}
+			return nil
+		}
+
+		total++
+		if info, err := os.Lstat(local); err == nil {
+			if info.Mode().IsRegular() && info.Size() == entry.Size && info.ModTime().Equal(entry.ModTime) {
+				unchanged++
+				return nil
+			}
+			if info.IsDir() {
+				if err := os.RemoveAll(local); err != nil {
+					return fmt.Errorf("failed to replace %s with a file: %w", local, err)
+				}
+			}
+		}
+
+		if err := c.DownloadEntry(ctx, p, entry, local); err != nil {
+			return fmt.Errorf("failed to download %s: %w", p, err)
+		}
+		downloaded++
+		fmt.Fprintf(out, "downloaded %s\n", p)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// Forget the listings of directories that are gone, and remember the rest for the next sync
+	for p := range listings {
+		if !remote[p] {
+			delete(listings, p)
+		}
+	}
+	if err := saveListings(stateFile, listings); err != nil {
+		return err
+	}
+
+	// Only delete once the whole tree was listed, so a failed listing can't make files look removed
+	var deleted int
+	if s.Delete {
+		err = filepath.WalkDir(dst, func(local string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			rel, err := filepath.Rel(dst, local)
+			if err != nil {
+				return err
+			}
+			if p := filepath.ToSlash(rel); remote[p] {
+				return nil
+			}
+
+			if err := os.RemoveAll(local); err != nil {
+				return fmt.Errorf("failed to delete %s: %w", local, err)
+			}
+			deleted++
+			fmt.Fprintf(out, "deleted %s\n", filepath.ToSlash(rel))
+			if d.IsDir() {
+				return fs.SkipDir
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	fmt.Fprintf(out, "%d files, %d downloaded, %d unchanged, %d deleted\n", total, downloaded, unchanged, deleted)
+	return nil
+}
+
+// stateFile returns the path of the file to remember the listings of the last sync of a server URL into a destination
+// in, empty if they aren't remembered.
+func (s *Sync) stateFile(serverURL, dst string) (string, error) {
+	switch s.StateFile {
+	case "-":
+		return "", nil
+	case "":
+	default:
+		return s.StateFile, nil
+	}
+
+	cacheDir, err := os.UserCacheDir()
+	if err != nil {
+		// Syncing without remembering listings only costs listing every directory
+		return "", nil
+	}
+	sum := sha256.Sum256([]byte(serverURL + "\x00" + dst))
+	return filepath.Join(cacheDir, "maskfs", "sync-"+hex.EncodeToString(sum[:16])+".json"), nil
+}
+
+// loadListings loads the listings remembered in a state file, which are empty if there's no state file or it can't be
+// read, in which case every directory is listed again.
+func loadListings(stateFile string) client.ListingCache {
+	listings := client.ListingCache{}
+	if stateFile == "" {
+		return listings
+	}
+	data, err := os.ReadFile(stateFile)
+	if err != nil {
+		return listings
+	}
+	if err := json.Unmarshal(data, &listings); err != nil {
+		return client.ListingCache{}
+	}
+	return listings
+}
+
+// saveListings remembers the listings in a state file, replacing it only once they're written in full.
+func saveListings(stateFile string, listings client.ListingCache) error {
+	if stateFile == "" {
+		return nil
+	}
+	data, err := json.Marshal(listings)
+	if err != nil {
+		return fmt.Errorf("failed to encode sync state: %w", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(stateFile), 0o700); err != nil {
+		return fmt.Errorf("failed to create sync state directory: %w", err)
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(stateFile), "."+filepath.Base(stateFile)+".*")
+	if err != nil {
+		return fmt.Errorf("failed to create sync state: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = tmp.Write(data)
+	if err = errors.Join(err, tmp.Close()); err != nil {
+		return fmt.Errorf("failed to write sync state: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), stateFile); err != nil {
+		return fmt.Errorf("failed to replace sync state: %w", err)
+	}
+	return nil
+}
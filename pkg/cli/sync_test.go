@@ -0,0 +1,114 @@
+package cli
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/njhale/maskfs/pkg/index"
+	"github.com/njhale/maskfs/pkg/server"
+	"github.com/spf13/cobra"
+)
+
+// runSync syncs the files served at a URL into a destination, returning what it printed.
+func runSync(t *testing.T, s *Sync, serverURL, dst string) (string, error) {
+	t.Helper()
+	var out bytes.Buffer
+	cmd := &cobra.Command{}
+	cmd.SetContext(context.Background())
+	cmd.SetOut(&out)
+	err := s.Run(cmd, []string{serverURL, dst})
+	return out.String(), err
+}
+
+func TestSync(t *testing.T) {
+	modTime := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	fsys := fstest.MapFS{
+		"a.txt":          {Data: []byte("hello"), ModTime: modTime, Mode: 0o644},
+		"dir/b.txt":      {Data: []byte("nested"), ModTime: modTime, Mode: 0o644},
+		"dir/secret.key": {Data: []byte("secret"), ModTime: modTime, Mode: 0o644},
+	}
+	s, err := server.New(server.WithFS(fsys), server.WithConfig(server.Config{Mask: "**\n!*.key", URLPrefix: "/"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := httptest.NewServer(s)
+	defer ts.Close()
+
+	dst := t.TempDir()
+	sync := &Sync{Delete: true, StateFile: filepath.Join(t.TempDir(), "state.json")}
+	if _, err := runSync(t, sync, ts.URL, dst); err != nil {
+		t.Fatal(err)
+	}
+	for name, want := range map[string]string{"a.txt": "hello", "dir/b.txt": "nested"} {
+		if data, err := os.ReadFile(filepath.Join(dst, name)); err != nil || string(data) != want {
+			t.Errorf("synced %s = %q, %v, want %q", name, data, err, want)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dst, "dir", "secret.key")); err == nil {
+		t.Error("masked file was synced")
+	}
+
+	// Files that didn't change aren't downloaded again, and those the server doesn't serve anymore are deleted
+	if err := os.WriteFile(filepath.Join(dst, "local.txt"), nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	delete(fsys, "dir/b.txt")
+	out, err := runSync(t, sync, ts.URL, dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "1 files, 0 downloaded, 1 unchanged, 2 deleted"; !strings.Contains(out, want) {
+		t.Errorf("sync printed %q, want %q", out, want)
+	}
+	for _, name := range []string{"local.txt", "dir/b.txt"} {
+		if _, err := os.Stat(filepath.Join(dst, name)); err == nil {
+			t.Errorf("%s wasn't deleted", name)
+		}
+	}
+}
+
+func TestSyncHostileServer(t *testing.T) {
+	modTime := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	for _, name := range []string{"../escape.txt", "../../.ssh/authorized_keys", `..\escape.txt`, "dir/../../escape.txt", ".."} {
+		t.Run(name, func(t *testing.T) {
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Query().Get("format") != "json" {
+					_, _ = w.Write([]byte("pwned"))
+					return
+				}
+				w.Header().Set("Content-Type", "application/json")
+				_ = json.NewEncoder(w).Encode(map[string]any{
+					"directory": &index.Entry{Name: "/", FSPath: ".", IsDir: true, ModTime: modTime},
+					"entries":   index.Entries{{Name: name, FSPath: name, Size: 5, ModTime: modTime}},
+				})
+			}))
+			defer ts.Close()
+
+			parent := t.TempDir()
+			dst := filepath.Join(parent, "dst")
+			if _, err := runSync(t, &Sync{StateFile: "-"}, ts.URL, dst); err == nil {
+				t.Error("sync of an entry with an invalid name succeeded")
+			}
+
+			err := filepath.WalkDir(parent, func(p string, d fs.DirEntry, err error) error {
+				if err == nil && !d.IsDir() {
+					t.Errorf("sync wrote %s", p)
+				}
+				return err
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
@@ -0,0 +1,522 @@
+// Package client talks to a maskfs file server, listing directories through its JSON listings and reading files with
+// plain GETs, so Go programs can browse and download the unmasked files of a server without scraping HTML.
+//
+// Paths are slash-separated and relative to the root the server serves, with "", ".", and "/" all naming the root.
+// Masked entries don't exist for clients, so they're reported as fs.ErrNotExist like any missing path. The names of
+// the entries of listings are checked before they're used, so a hostile server can't make paths built from them climb
+// out of the directory they were listed in.
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/njhale/maskfs/pkg/index"
+)
+
+// pageSize is the number of entries requested per page of a listing.
+const pageSize = 1000
+
+// The retries of clients created without WithRetries.
+const (
+	DefaultRetries = 3
+	DefaultBackoff = 200 * time.Millisecond
+)
+
+// Client is a client of a maskfs file server.
+type Client struct {
+	base     *url.URL // URL of the file server's prefix, without a trailing slash
+	http     *http.Client
+	token    string
+	user     string
+	password string
+	retries  int
+	backoff  time.Duration
+
+	listingsMu sync.Mutex
+	listings   ListingCache // Nil unless listings are cached, see WithListingCache
+}
+
+// Option configures a client created with New.
+type Option func(*Client)
+
+// WithHTTPClient sets the HTTP client requests are sent with, which defaults to http.DefaultClient.
+func WithHTTPClient(c *http.Client) Option {
+	return func(client *Client) {
+		client.http = c
+	}
+}
+
+// WithBearerToken authenticates requests with the given bearer token, like the server's --auth-token.
+func WithBearerToken(token string) Option {
+	return func(client *Client) {
+		client.token = token
+	}
+}
+
+// WithBasicAuth authenticates requests with the given user and password, like those of the server's --htpasswd file.
+func WithBasicAuth(user, password string) Option {
+	return func(client *Client) {
+		client.user, client.password = user, password
+	}
+}
+
+// WithRetries retries requests that fail with network errors, 429 Too Many Requests, or 5xx responses up to the given
+// number of times, waiting the given backoff before the first retry and doubling it before each one after that.
+// Clients retry DefaultRetries times after DefaultBackoff by default, and 0 retries disables retrying.
+func WithRetries(retries int, backoff time.Duration) Option {
+	return func(client *Client) {
+		client.retries, client.backoff = retries, backoff
+	}
+}
+
+// Listing is the whole listing of a directory, along with its entity tag if the server gave it one.
+type Listing struct {
+	Directory *index.Entry  `json:"directory"`
+	Entries   index.Entries `json:"entries"`
+	ETag      string        `json:"etag,omitempty"`
+}
+
+// ListingCache holds the listings of directories keyed by their paths, see WithListingCache.
+type ListingCache map[string]*Listing
+
+// WithListingCache makes List, and Walk with it, revalidate the listings in the cache with their entity tags rather
+// than fetching them again, and put the listings it fetches in the cache. Only listings that fit in a single page are
+// cached, since the server tags each page of a listing on its own. The cache can be saved as JSON and loaded again to
+// revalidate listings across runs, like the sync command does.
+func WithListingCache(cache ListingCache) Option {
+	return func(client *Client) {
+		client.listings = cache
+	}
+}
+
+// New returns a client of the file server at the given URL, which includes the server's URL prefix, like
+// http://localhost:9888/files.
+func New(serverURL string, opts ...Option) (*Client, error) {
+	base, err := url.Parse(serverURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid server url: %w", err)
+	}
+	if base.Scheme != "http" && base.Scheme != "https" {
+		return nil, fmt.Errorf("server url %q must be an http or https url", serverURL)
+	}
+	base.Path = strings.TrimSuffix(base.Path, "/")
+	base.RawQuery, base.Fragment = "", ""
+
+	c := &Client{
+		base:    base,
+		http:    http.DefaultClient,
+		retries: DefaultRetries,
+		backoff: DefaultBackoff,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c, nil
+}
+
+// StatusError is returned for responses with unexpected statuses, other than those reported as fs.ErrNotExist or
+// fs.ErrPermission.
+type StatusError struct {
+	StatusCode int
+	Status     string
+	Message    string // The start of the response body, which the server explains errors in
+}
+
+func (e *StatusError) Error() string {
+	if e.Message == "" {
+		return "unexpected response: " + e.Status
+	}
+	return fmt.Sprintf("unexpected response: %s: %s", e.Status, e.Message)
+}
+
+// List returns the entry of the directory at a path and the entries of its children, sorted by name. Listings the
+// server splits into pages are fetched page by page.
+func (c *Client) List(ctx context.Context, p string) (*index.Entry, index.Entries, error) {
+	p = cleanPath(p)
+	cached := c.cachedListing(p)
+
+	var (
+		directory *index.Entry
+		entries   index.Entries
+		token     string
+		etag      string
+	)
+	if cached != nil {
+		etag = cached.ETag
+	}
+	for first := true; ; first = false {
+		page, err := c.listPage(ctx, p, token, pageSize, etag)
+		if err != nil {
+			return nil, nil, err
+		}
+		if page.notModified {
+			return cached.Directory, cached.Entries, nil
+		}
+		etag = ""
+
+		directory = page.Directory
+		entries = append(entries, page.Entries...)
+		if token = page.NextToken; token == "" {
+			if first {
+				c.cacheListing(p, &Listing{Directory: directory, Entries: entries, ETag: page.etag})
+			}
+			return directory, entries, nil
+		}
+	}
+}
+
+// cachedListing returns the cached listing of a directory, nil if it isn't cached.
+func (c *Client) cachedListing(p string) *Listing {
+	c.listingsMu.Lock()
+	defer c.listingsMu.Unlock()
+
+	if l := c.listings[p]; l != nil && l.ETag != "" && l.Directory != nil {
+		return l
+	}
+	return nil
+}
+
+// cacheListing caches the listing of a directory, if listings are cached and the server tagged it.
+func (c *Client) cacheListing(p string, l *Listing) {
+	c.listingsMu.Lock()
+	defer c.listingsMu.Unlock()
+
+	if c.listings == nil {
+		return
+	}
+	if l.ETag == "" {
+		delete(c.listings, p)
+		return
+	}
+	c.listings[p] = l
+}
+
+// listing is a page of a JSON listing, see index.Entries.WriteJSON.
+type listing struct {
+	Directory *index.Entry  `json:"directory"`
+	Entries   index.Entries `json:"entries"`
+	NextToken string        `json:"next_token"`
+
+	etag        string // The page's entity tag
+	notModified bool   // Set instead of everything else when the page still has the entity tag it was requested with
+}
+
+// listPage fetches a page of up to limit entries of the listing of a directory, following the given continuation token.
+// If an entity tag is given, the page is only fetched if it no longer has it.
+func (c *Client) listPage(ctx context.Context, p, token string, limit int, etag string) (*listing, error) {
+	query := url.Values{"format": {"json"}, "limit": {strconv.Itoa(limit)}}
+	if token != "" {
+		query.Set("token", token)
+	}
+	header := http.Header{"Accept": {"application/json"}}
+	if etag != "" {
+		header.Set("If-None-Match", etag)
+	}
+
+	resp, err := c.send(ctx, http.MethodGet, p, query, header)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotModified {
+		return &listing{notModified: true}, nil
+	}
+
+	if mediaType, _, _ := strings.Cut(resp.Header.Get("Content-Type"), ";"); mediaType != "application/json" {
+		// Files are served as they are whatever the query
+		return nil, &fs.PathError{Op: "list", Path: p, Err: errors.New("not a directory")}
+	}
+
+	var page listing
+	if err := json.NewDecoder(resp.Body).Decode(&page); err != nil {
+		return nil, &fs.PathError{Op: "list", Path: p, Err: fmt.Errorf("failed to decode listing: %w", err)}
+	}
+	if page.Directory == nil || !page.Directory.IsDir {
+		return nil, &fs.PathError{Op: "list", Path: p, Err: errors.New("not a directory")}
+	}
+	for _, entry := range page.Entries {
+		if !ValidName(entry.Name) {
+			return nil, &fs.PathError{Op: "list", Path: p, Err: fmt.Errorf("server listed an entry with invalid name %q", entry.Name)}
+		}
+	}
+	page.etag = resp.Header.Get("ETag")
+	return &page, nil
+}
+
+// ValidName returns true if a name of an entry of a listing names a child of the directory, rather than the directory
+// itself, its parent, or a path below another directory.
+func ValidName(name string) bool {
+	return name != "" && name != "." && name != ".." && !strings.ContainsAny(name, "/\\\x00")
+}
+
+// Stat returns the entry at a path. Directories are described by the first page of their listings, and files by the
+// headers of a HEAD request, which don't carry their permissions, so files are reported with 0644 permissions.
+func (c *Client) Stat(ctx context.Context, p string) (*index.Entry, error) {
+	p = cleanPath(p)
+
+	// Ask for a JSON listing, which the server only answers with if the path is a directory
+	resp, err := c.send(ctx, http.MethodHead, p, url.Values{"format": {"json"}}, http.Header{
+		"Accept":          {"application/json"},
+		"Accept-Encoding": {"identity"},
+	})
+	if err != nil {
+		return nil, pathError("stat", p, err)
+	}
+	resp.Body.Close()
+
+	if mediaType, _, _ := strings.Cut(resp.Header.Get("Content-Type"), ";"); mediaType == "application/json" {
+		page, err := c.listPage(ctx, p, "", 1, "")
+		if err != nil {
+			return nil, pathError("stat", p, err)
+		}
+		return page.Directory, nil
+	}
+
+	entry := &index.Entry{
+		Name:   path.Base(p),
+		Size:   max(resp.ContentLength, 0),
+		Mode:   0o644,
+		FSPath: p,
+	}
+	if contentType := resp.Header.Get("Content-Type"); contentType != "" {
+		entry.MIMEType, _, _ = strings.Cut(contentType, ";")
+	}
+	if modTime, err := http.ParseTime(resp.Header.Get("Last-Modified")); err == nil {
+		entry.ModTime = modTime
+	}
+	return entry, nil
+}
+
+// pathError returns an error as a path error of the given operation and path, unwrapping the client's own path errors
+// so that the error names the operation and path the caller asked for.
+func pathError(op, p string, err error) error {
+	var pathErr *fs.PathError
+	if errors.As(err, &pathErr) {
+		err = pathErr.Err
+	}
+	return &fs.PathError{Op: op, Path: p, Err: err}
+}
+
+// Open returns the contents of the file at a path, which the caller must close.
+func (c *Client) Open(ctx context.Context, p string) (io.ReadCloser, error) {
+	return c.openAt(ctx, p, 0)
+}
+
+// openAt returns the contents of the file at a path from an offset on.
+func (c *Client) openAt(ctx context.Context, p string, offset int64) (io.ReadCloser, error) {
+	var header http.Header
+	if offset > 0 {
+		header = http.Header{"Range": {fmt.Sprintf("bytes=%d-", offset)}}
+	}
+
+	resp, err := c.send(ctx, http.MethodGet, p, nil, header)
+	if err != nil {
+		return nil, err
+	}
+	if offset > 0 && resp.StatusCode != http.StatusPartialContent {
+		resp.Body.Close()
+		return nil, &fs.PathError{Op: "read", Path: p, Err: errors.New("server ignored the range request")}
+	}
+	return resp.Body, nil
+}
+
+// send sends a GET or HEAD request for a path, retrying it as configured, and returns the response if it succeeded.
+// Responses with error statuses are closed and returned as errors.
+func (c *Client) send(ctx context.Context, method, p string, query url.Values, header http.Header) (*http.Response, error) {
+	target := c.base.JoinPath("/")
+	if p = cleanPath(p); p != "." {
+		target = c.base.JoinPath(strings.Split(p, "/")...)
+	}
+	target.RawQuery = query.Encode()
+
+	backoff := c.backoff
+	for attempt := 0; ; attempt++ {
+		resp, err := c.do(ctx, method, target.String(), header)
+		if err == nil && !retryable(resp.StatusCode) {
+			return c.check(p, resp)
+		}
+		if attempt >= c.retries {
+			if err != nil {
+				return nil, err
+			}
+			return c.check(p, resp)
+		}
+		if resp != nil {
+			_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+			resp.Body.Close()
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+// do sends a single request.
+func (c *Client) do(ctx context.Context, method, target string, header http.Header) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, target, nil)
+	if err != nil {
+		return nil, err
+	}
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	switch {
+	case c.token != "":
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	case c.user != "":
+		req.SetBasicAuth(c.user, c.password)
+	}
+	return c.http.Do(req)
+}
+
+// check returns the response if it succeeded or wasn't modified, or closes it and returns its status as an error.
+func (c *Client) check(p string, resp *http.Response) (*http.Response, error) {
+	if resp.StatusCode < 300 || resp.StatusCode == http.StatusNotModified {
+		return resp, nil
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusNotFound:
+		return nil, &fs.PathError{Op: "get", Path: p, Err: fs.ErrNotExist}
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return nil, &fs.PathError{Op: "get", Path: p, Err: fs.ErrPermission}
+	}
+
+	message, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+	return nil, &StatusError{
+		StatusCode: resp.StatusCode,
+		Status:     resp.Status,
+		Message:    strings.TrimSpace(string(message)),
+	}
+}
+
+// retryable returns true if a response status may be temporary.
+func retryable(status int) bool {
+	return status == http.StatusTooManyRequests || status >= 500
+}
+
+// cleanPath returns the path relative to the root, "." for the root itself.
+func cleanPath(p string) string {
+	p = strings.TrimPrefix(path.Clean("/"+p), "/")
+	if p == "" {
+		return "."
+	}
+	return p
+}
+
+// Download writes the file at a path to dst, replacing any file there only once the whole file was received, and
+// gives it the modification time it has on the server.
+func (c *Client) Download(ctx context.Context, p, dst string) error {
+	entry, err := c.Stat(ctx, p)
+	if err != nil {
+		return err
+	}
+	return c.DownloadEntry(ctx, p, entry, dst)
+}
+
+// DownloadEntry is Download for the entry at a path that was already listed, like the entries passed to a WalkFunc,
+// which saves stat'ing it again and gives the file the permissions it has on the server.
+func (c *Client) DownloadEntry(ctx context.Context, p string, entry *index.Entry, dst string) error {
+	if entry.IsDir {
+		return &fs.PathError{Op: "download", Path: p, Err: errors.New("is a directory")}
+	}
+
+	body, err := c.Open(ctx, p)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+
+	tmp, err := os.CreateTemp(filepath.Dir(dst), "."+filepath.Base(dst)+".*")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := io.Copy(tmp, body); err != nil {
+		_ = tmp.Close()
+		return fmt.Errorf("failed to download %s: %w", p, err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write %s: %w", dst, err)
+	}
+	if err := os.Chmod(tmp.Name(), entry.Mode.Perm()); err != nil {
+		return fmt.Errorf("failed to set permissions of %s: %w", dst, err)
+	}
+	if err := os.Chtimes(tmp.Name(), time.Time{}, entry.ModTime); err != nil {
+		return fmt.Errorf("failed to set modification time of %s: %w", dst, err)
+	}
+	if err := os.Rename(tmp.Name(), dst); err != nil {
+		return fmt.Errorf("failed to replace %s: %w", dst, err)
+	}
+	return nil
+}
+
+// WalkFunc is called by Walk for each entry, like fs.WalkDirFunc. The entry is nil if err is set, which is the error
+// of listing the directory at p. Returning fs.SkipDir skips the directory of the entry, or the rest of the directory
+// the entry is in if it's a file, and returning fs.SkipAll stops the walk.
+type WalkFunc func(p string, entry *index.Entry, err error) error
+
+// Walk walks the unmasked tree rooted at a path in lexical order, calling fn for the root and every entry below it,
+// like fs.WalkDir.
+func (c *Client) Walk(ctx context.Context, root string, fn WalkFunc) error {
+	entry, err := c.Stat(ctx, root)
+	if err != nil {
+		err = fn(cleanPath(root), nil, err)
+	} else {
+		err = c.walk(ctx, cleanPath(root), entry, fn)
+	}
+	if errors.Is(err, fs.SkipDir) || errors.Is(err, fs.SkipAll) {
+		return nil
+	}
+	return err
+}
+
+// walk calls fn for an entry and, if it's a directory, everything below it.
+func (c *Client) walk(ctx context.Context, p string, entry *index.Entry, fn WalkFunc) error {
+	if err := fn(p, entry, nil); err != nil || !entry.IsDir {
+		if errors.Is(err, fs.SkipDir) && entry.IsDir {
+			return nil
+		}
+		return err
+	}
+
+	_, entries, err := c.List(ctx, p)
+	if err != nil {
+		if err = fn(p, nil, err); err != nil {
+			if errors.Is(err, fs.SkipDir) {
+				return nil
+			}
+			return err
+		}
+	}
+
+	for _, child := range entries {
+		if err := c.walk(ctx, path.Join(p, child.Name), child, fn); err != nil {
+			if errors.Is(err, fs.SkipDir) {
+				break
+			}
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,237 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/njhale/maskfs/pkg/index"
+	"github.com/njhale/maskfs/pkg/server"
+)
+
+var modTime = time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+
+// newServer serves a tree through a maskfs server, masking .key files, returning a client of it.
+func newServer(t *testing.T, opts ...Option) *Client {
+	t.Helper()
+	fsys := fstest.MapFS{
+		"a.txt":          {Data: []byte("hello"), ModTime: modTime, Mode: 0o644},
+		"dir/b.txt":      {Data: []byte("nested"), ModTime: modTime, Mode: 0o644},
+		"dir/sub/c.txt":  {Data: []byte("deeper"), ModTime: modTime, Mode: 0o644},
+		"dir/secret.key": {Data: []byte("secret"), ModTime: modTime, Mode: 0o644},
+		"empty":          {Mode: fs.ModeDir | 0o755, ModTime: modTime},
+	}
+	s, err := server.New(server.WithFS(fsys), server.WithConfig(server.Config{Mask: "**\n!*.key", URLPrefix: "/files"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := httptest.NewServer(s)
+	t.Cleanup(ts.Close)
+
+	c, err := New(ts.URL+"/files", opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestClient(t *testing.T) {
+	ctx := context.Background()
+	c := newServer(t)
+
+	_, entries, err := c.List(ctx, "/dir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, entry := range entries {
+		names = append(names, entry.Name)
+	}
+	if len(names) != 2 || names[0] != "b.txt" || names[1] != "sub" {
+		t.Errorf("List(dir) = %q, want [b.txt sub]", names)
+	}
+
+	entry, err := c.Stat(ctx, "a.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry.IsDir || entry.Size != 5 || !entry.ModTime.Equal(modTime) {
+		t.Errorf("Stat(a.txt) = %+v, want a file of 5 bytes modified at %s", entry, modTime)
+	}
+	if entry, err := c.Stat(ctx, "dir"); err != nil || !entry.IsDir {
+		t.Errorf("Stat(dir) = %+v, %v, want a directory", entry, err)
+	}
+	for _, p := range []string{"dir/secret.key", "missing"} {
+		if _, err := c.Stat(ctx, p); !errors.Is(err, fs.ErrNotExist) {
+			t.Errorf("Stat(%q) = %v, want %v", p, err, fs.ErrNotExist)
+		}
+	}
+
+	dst := filepath.Join(t.TempDir(), "b.txt")
+	if err := c.Download(ctx, "dir/b.txt", dst); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := os.ReadFile(dst); err != nil || string(data) != "nested" {
+		t.Errorf("downloaded %q, %v, want %q", data, err, "nested")
+	}
+
+	var walked []string
+	err = c.Walk(ctx, ".", func(p string, entry *index.Entry, err error) error {
+		if err != nil {
+			return err
+		}
+		walked = append(walked, p)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{".", "a.txt", "dir", "dir/b.txt", "dir/sub", "dir/sub/c.txt", "empty"}
+	if len(walked) != len(want) {
+		t.Fatalf("Walk() = %q, want %q", walked, want)
+	}
+	for i := range want {
+		if walked[i] != want[i] {
+			t.Fatalf("Walk() = %q, want %q", walked, want)
+		}
+	}
+}
+
+func TestFS(t *testing.T) {
+	fsys := newServer(t).FS(context.Background())
+	if err := fstest.TestFS(fsys, "a.txt", "dir/b.txt", "dir/sub/c.txt", "empty"); err != nil {
+		t.Error(err)
+	}
+
+	f, err := fsys.Open("a.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err := f.(io.Seeker).Seek(0, 42); err == nil {
+		t.Error("seeking from an unknown origin succeeded")
+	}
+}
+
+func TestListingCache(t *testing.T) {
+	ctx := context.Background()
+	cache := ListingCache{}
+	c := newServer(t, WithListingCache(cache))
+
+	if _, _, err := c.List(ctx, "dir"); err != nil {
+		t.Fatal(err)
+	}
+	cached := cache["dir"]
+	if cached == nil || cached.ETag == "" {
+		t.Fatalf("listing wasn't cached with its ETag: %+v", cached)
+	}
+	if _, entries, err := c.List(ctx, "dir"); err != nil || len(entries) != len(cached.Entries) {
+		t.Errorf("List(dir) = %d entries, %v, want the %d cached", len(entries), err, len(cached.Entries))
+	}
+}
+
+// hostileServer serves a listing of the root with entries of the given names, whatever it's asked for.
+func hostileServer(t *testing.T, names ...string) *Client {
+	t.Helper()
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		entries := index.Entries{}
+		for _, name := range names {
+			entries = append(entries, &index.Entry{Name: name, FSPath: name, ModTime: modTime})
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"directory": &index.Entry{Name: "/", FSPath: ".", IsDir: true, ModTime: modTime},
+			"entries":   entries,
+		})
+	}))
+	t.Cleanup(ts.Close)
+
+	c, err := New(ts.URL, WithRetries(0, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestListInvalidNames(t *testing.T) {
+	for _, name := range []string{"", ".", "..", "../../.ssh/authorized_keys", "a/b", `..\..\evil`, "nul\x00"} {
+		c := hostileServer(t, "ok.txt", name)
+		if _, _, err := c.List(context.Background(), "."); err == nil {
+			t.Errorf("List() of an entry named %q succeeded", name)
+		}
+
+		err := c.Walk(context.Background(), ".", func(p string, entry *index.Entry, err error) error {
+			if err != nil {
+				return err
+			}
+			if entry.Name == name && p != "." {
+				t.Errorf("Walk() visited an entry named %q at %q", name, p)
+			}
+			return nil
+		})
+		if err == nil {
+			t.Errorf("Walk() of an entry named %q succeeded", name)
+		}
+	}
+}
+
+func TestRetries(t *testing.T) {
+	var requests atomic.Int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if requests.Add(1) <= 2 {
+			http.Error(w, "try again", http.StatusServiceUnavailable)
+			return
+		}
+		_, _ = io.WriteString(w, "hello")
+	}))
+	defer ts.Close()
+
+	for _, tt := range []struct {
+		retries int
+		ok      bool
+	}{
+		{retries: 2, ok: true},
+		{retries: 1},
+	} {
+		requests.Store(0)
+		c, err := New(ts.URL, WithRetries(tt.retries, time.Millisecond))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		body, err := c.Open(context.Background(), "a.txt")
+		if !tt.ok {
+			var statusErr *StatusError
+			if !errors.As(err, &statusErr) || statusErr.StatusCode != http.StatusServiceUnavailable {
+				t.Errorf("Open() with %d retries = %v, want a %d", tt.retries, err, http.StatusServiceUnavailable)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("Open() with %d retries = %v", tt.retries, err)
+		}
+		data, _ := io.ReadAll(body)
+		body.Close()
+		if string(data) != "hello" {
+			t.Errorf("Open() with %d retries read %q, want %q", tt.retries, data, "hello")
+		}
+	}
+}
+
+func TestNew(t *testing.T) {
+	for _, serverURL := range []string{"ftp://example.com", "://"} {
+		if _, err := New(serverURL); err == nil {
+			t.Errorf("New(%q) succeeded, want an error", serverURL)
+		}
+	}
+}
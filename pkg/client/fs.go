@@ -0,0 +1,191 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"time"
+
+	"github.com/njhale/maskfs/pkg/index"
+)
+
+// FS returns a read-only fs.FS of the files the server serves, so that fs.WalkDir, fs.ReadFile, http.FileServerFS, and
+// the rest of the standard library can use them. Every call is a request to the server made with the given context,
+// paths are stat'ed whenever they're opened or stat'ed, directories are listed whenever they're read, and files are read
+// with a request that starts over at every seek. Like Stat, files report 0644 permissions until they're listed.
+func (c *Client) FS(ctx context.Context) fs.FS {
+	return &remoteFS{ctx: ctx, client: c}
+}
+
+type remoteFS struct {
+	ctx    context.Context
+	client *Client
+}
+
+func (r *remoteFS) Open(name string) (fs.File, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+
+	entry, err := r.client.Stat(r.ctx, name)
+	if err != nil {
+		return nil, pathError("open", name, err)
+	}
+	if entry.IsDir {
+		return &dir{fsys: r, name: name, entry: entry}, nil
+	}
+	return &file{fsys: r, name: name, entry: entry}, nil
+}
+
+func (r *remoteFS) Stat(name string) (fs.FileInfo, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "stat", Path: name, Err: fs.ErrInvalid}
+	}
+
+	entry, err := r.client.Stat(r.ctx, name)
+	if err != nil {
+		return nil, pathError("stat", name, err)
+	}
+	return fileInfo{entry}, nil
+}
+
+func (r *remoteFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrInvalid}
+	}
+
+	_, entries, err := r.client.List(r.ctx, name)
+	if err != nil {
+		return nil, pathError("readdir", name, err)
+	}
+	dirEntries := make([]fs.DirEntry, len(entries))
+	for i, entry := range entries {
+		dirEntries[i] = fs.FileInfoToDirEntry(fileInfo{entry})
+	}
+	return dirEntries, nil
+}
+
+// fileInfo describes an entry of a listing.
+type fileInfo struct {
+	entry *index.Entry
+}
+
+func (i fileInfo) Name() string {
+	if i.entry.IsRoot() {
+		return "."
+	}
+	return i.entry.Name
+}
+
+func (i fileInfo) Size() int64        { return i.entry.Size }
+func (i fileInfo) Mode() fs.FileMode  { return i.entry.Mode }
+func (i fileInfo) ModTime() time.Time { return i.entry.ModTime }
+func (i fileInfo) IsDir() bool        { return i.entry.IsDir }
+func (i fileInfo) Sys() any           { return i.entry }
+
+// file is an open remote file, whose contents are requested on the first read after opening or seeking it.
+type file struct {
+	fsys   *remoteFS
+	name   string
+	entry  *index.Entry
+	body   io.ReadCloser
+	offset int64
+}
+
+func (f *file) Stat() (fs.FileInfo, error) {
+	return fileInfo{f.entry}, nil
+}
+
+func (f *file) Read(p []byte) (int, error) {
+	if f.body == nil {
+		if f.offset >= f.entry.Size {
+			return 0, io.EOF
+		}
+		body, err := f.fsys.client.openAt(f.fsys.ctx, f.name, f.offset)
+		if err != nil {
+			return 0, err
+		}
+		f.body = body
+	}
+
+	n, err := f.body.Read(p)
+	f.offset += int64(n)
+	return n, err
+}
+
+func (f *file) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += f.offset
+	case io.SeekEnd:
+		offset += f.entry.Size
+	default:
+		return 0, &fs.PathError{Op: "seek", Path: f.name, Err: fs.ErrInvalid}
+	}
+	if offset < 0 {
+		return 0, &fs.PathError{Op: "seek", Path: f.name, Err: fs.ErrInvalid}
+	}
+
+	if offset != f.offset && f.body != nil {
+		_ = f.body.Close()
+		f.body = nil
+	}
+	f.offset = offset
+	return offset, nil
+}
+
+func (f *file) Close() error {
+	if f.body == nil {
+		return nil
+	}
+	err := f.body.Close()
+	f.body = nil
+	return err
+}
+
+// dir is an open remote directory, which is listed on the first call to ReadDir.
+type dir struct {
+	fsys    *remoteFS
+	name    string
+	entry   *index.Entry
+	entries []fs.DirEntry
+	listed  bool
+}
+
+func (d *dir) Stat() (fs.FileInfo, error) {
+	return fileInfo{d.entry}, nil
+}
+
+func (d *dir) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.name, Err: errors.New("is a directory")}
+}
+
+func (d *dir) Close() error {
+	return nil
+}
+
+func (d *dir) ReadDir(n int) ([]fs.DirEntry, error) {
+	if !d.listed {
+		entries, err := d.fsys.ReadDir(d.name)
+		if err != nil {
+			return nil, err
+		}
+		d.entries, d.listed = entries, true
+	}
+
+	if n <= 0 {
+		entries := d.entries
+		d.entries = nil
+		return entries, nil
+	}
+	if len(d.entries) == 0 {
+		return nil, io.EOF
+	}
+
+	n = min(n, len(d.entries))
+	entries := d.entries[:n]
+	d.entries = d.entries[n:]
+	return entries, nil
+}
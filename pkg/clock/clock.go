@@ -0,0 +1,26 @@
+package clock
+
+import "time"
+
+// Clock tells the current time.
+// Time-dependent code takes a Clock instead of calling time.Now so that it can be tested deterministically.
+type Clock interface {
+	// Now returns the current time.
+	Now() time.Time
+}
+
+// Real is the Clock backed by the system's wall clock.
+var Real Clock = realClock{}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+// Fixed is a Clock frozen at a point in time.
+type Fixed time.Time
+
+func (f Fixed) Now() time.Time {
+	return time.Time(f)
+}
@@ -0,0 +1,17 @@
+// Package fusefs exposes an fs.FS as a read-only FUSE filesystem, on Linux and macOS.
+//
+// Combined with mask.FS, masked entries simply don't exist in the mounted filesystem, so any local tool can operate
+// on the masked view of a directory.
+package fusefs
+
+import "time"
+
+// Options configures a mount.
+type Options struct {
+	// AllowOther lets users other than the one mounting the filesystem access it.
+	AllowOther bool
+	// CacheTimeout is how long the kernel may cache names and attributes, 0 to look them up on every access.
+	CacheTimeout time.Duration
+	// Debug logs every FUSE request.
+	Debug bool
+}
@@ -0,0 +1,230 @@
+//go:build linux || darwin
+
+package fusefs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"sync"
+	"syscall"
+
+	gofs "github.com/hanwen/go-fuse/v2/fs"
+	"github.com/hanwen/go-fuse/v2/fuse"
+)
+
+// Mount mounts fsys read-only at the mountpoint and serves it until the context is canceled, when it's unmounted.
+func Mount(ctx context.Context, mountpoint string, fsys fs.FS, opts Options) error {
+	root := &node{fsys: fsys, name: "."}
+	server, err := gofs.Mount(mountpoint, root, &gofs.Options{
+		EntryTimeout:    &opts.CacheTimeout,
+		AttrTimeout:     &opts.CacheTimeout,
+		NegativeTimeout: &opts.CacheTimeout,
+		MountOptions: fuse.MountOptions{
+			AllowOther:  opts.AllowOther,
+			FsName:      "maskfs",
+			Name:        "maskfs",
+			Options:     []string{"ro"},
+			DirectMount: true,
+			Debug:       opts.Debug,
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to mount %q: %w", mountpoint, err)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		server.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		// Unmounted from outside, e.g. with fusermount -u
+		return nil
+	case <-ctx.Done():
+	}
+
+	if err := server.Unmount(); err != nil {
+		return fmt.Errorf("failed to unmount %q: %w", mountpoint, err)
+	}
+	<-done
+
+	return nil
+}
+
+// node is a file, directory, or symlink of the filesystem, named by its slash-separated path in fsys.
+type node struct {
+	gofs.Inode
+	fsys fs.FS
+	name string
+}
+
+var (
+	_ gofs.NodeLookuper   = (*node)(nil)
+	_ gofs.NodeGetattrer  = (*node)(nil)
+	_ gofs.NodeReaddirer  = (*node)(nil)
+	_ gofs.NodeReadlinker = (*node)(nil)
+	_ gofs.NodeOpener     = (*node)(nil)
+)
+
+func (n *node) Lookup(ctx context.Context, name string, out *fuse.EntryOut) (*gofs.Inode, syscall.Errno) {
+	child := &node{fsys: n.fsys, name: path.Join(n.name, name)}
+	info, err := fs.Lstat(n.fsys, child.name)
+	if err != nil {
+		return nil, errno(err)
+	}
+
+	setAttr(&out.Attr, info)
+	return n.NewInode(ctx, child, gofs.StableAttr{Mode: out.Attr.Mode & syscall.S_IFMT}), 0
+}
+
+func (n *node) Getattr(_ context.Context, _ gofs.FileHandle, out *fuse.AttrOut) syscall.Errno {
+	info, err := fs.Lstat(n.fsys, n.name)
+	if err != nil {
+		return errno(err)
+	}
+
+	setAttr(&out.Attr, info)
+	return 0
+}
+
+func (n *node) Readdir(context.Context) (gofs.DirStream, syscall.Errno) {
+	children, err := fs.ReadDir(n.fsys, n.name)
+	if err != nil {
+		return nil, errno(err)
+	}
+
+	entries := make([]fuse.DirEntry, 0, len(children))
+	for _, child := range children {
+		entries = append(entries, fuse.DirEntry{
+			Name: child.Name(),
+			Mode: fileType(child.Type()),
+		})
+	}
+
+	return gofs.NewListDirStream(entries), 0
+}
+
+func (n *node) Readlink(context.Context) ([]byte, syscall.Errno) {
+	target, err := fs.ReadLink(n.fsys, n.name)
+	if err != nil {
+		return nil, errno(err)
+	}
+	return []byte(target), 0
+}
+
+func (n *node) Open(_ context.Context, flags uint32) (gofs.FileHandle, uint32, syscall.Errno) {
+	if flags&(syscall.O_WRONLY|syscall.O_RDWR|syscall.O_APPEND|syscall.O_TRUNC|syscall.O_CREAT) != 0 {
+		return nil, 0, syscall.EROFS
+	}
+
+	f, err := n.fsys.Open(n.name)
+	if err != nil {
+		return nil, 0, errno(err)
+	}
+
+	return &handle{file: f}, fuse.FOPEN_KEEP_CACHE, 0
+}
+
+// handle is an open file. Files that can't read at an offset are read sequentially, seeking first if they can.
+type handle struct {
+	mu   sync.Mutex
+	file fs.File
+}
+
+var (
+	_ gofs.FileReader   = (*handle)(nil)
+	_ gofs.FileReleaser = (*handle)(nil)
+)
+
+func (h *handle) Read(_ context.Context, dest []byte, off int64) (fuse.ReadResult, syscall.Errno) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	var (
+		n   int
+		err error
+	)
+	switch f := h.file.(type) {
+	case io.ReaderAt:
+		n, err = f.ReadAt(dest, off)
+	case io.ReadSeeker:
+		if _, err = f.Seek(off, io.SeekStart); err == nil {
+			n, err = io.ReadFull(f, dest)
+		}
+	default:
+		return nil, syscall.ENOTSUP
+	}
+	if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return nil, errno(err)
+	}
+
+	return fuse.ReadResultData(dest[:n]), 0
+}
+
+func (h *handle) Release(context.Context) syscall.Errno {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	return errno(h.file.Close())
+}
+
+// setAttr fills out the attributes of a file from its info, using the underlying stat when the filesystem has one.
+func setAttr(out *fuse.Attr, info fs.FileInfo) {
+	if st, ok := info.Sys().(*syscall.Stat_t); ok {
+		out.FromStat(st)
+	} else {
+		out.Size = uint64(info.Size())
+		out.Blocks = (out.Size + 511) / 512
+		out.Nlink = 1
+		modTime := info.ModTime()
+		out.SetTimes(&modTime, &modTime, &modTime)
+	}
+
+	// The filesystem is read-only whatever the permissions of the underlying files
+	out.Mode = fileType(info.Mode().Type()) | uint32(info.Mode().Perm()&^0o222)
+}
+
+// fileType returns the S_IFMT bits of a file mode type.
+func fileType(mode fs.FileMode) uint32 {
+	switch {
+	case mode&fs.ModeDir != 0:
+		return syscall.S_IFDIR
+	case mode&fs.ModeSymlink != 0:
+		return syscall.S_IFLNK
+	case mode&fs.ModeNamedPipe != 0:
+		return syscall.S_IFIFO
+	case mode&fs.ModeSocket != 0:
+		return syscall.S_IFSOCK
+	case mode&fs.ModeDevice != 0 && mode&fs.ModeCharDevice != 0:
+		return syscall.S_IFCHR
+	case mode&fs.ModeDevice != 0:
+		return syscall.S_IFBLK
+	default:
+		return syscall.S_IFREG
+	}
+}
+
+// errno returns the error number best describing an error, EIO when there's nothing more specific.
+func errno(err error) syscall.Errno {
+	var e syscall.Errno
+	switch {
+	case err == nil:
+		return 0
+	case errors.As(err, &e):
+		return e
+	case errors.Is(err, fs.ErrNotExist):
+		return syscall.ENOENT
+	case errors.Is(err, fs.ErrPermission):
+		return syscall.EACCES
+	case errors.Is(err, fs.ErrInvalid):
+		return syscall.EINVAL
+	default:
+		return syscall.EIO
+	}
+}
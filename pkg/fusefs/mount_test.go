@@ -0,0 +1,102 @@
+//go:build linux || darwin
+
+package fusefs
+
+import (
+	"context"
+	"io/fs"
+	"slices"
+	"syscall"
+	"testing"
+	"testing/fstest"
+
+	"github.com/hanwen/go-fuse/v2/fuse"
+	"github.com/njhale/maskfs/pkg/mask"
+)
+
+func TestNode(t *testing.T) {
+	m, err := mask.NewGlobMask("**\n!*.key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fsys := mask.FS(fstest.MapFS{
+		"a.txt":     {Data: []byte("hello"), Mode: 0o644},
+		"b.key":     {Data: []byte("secret"), Mode: 0o600},
+		"dir/c.txt": {Data: []byte("world"), Mode: 0o666},
+		"link":      {Data: []byte("a.txt"), Mode: fs.ModeSymlink | 0o777},
+	}, m)
+	ctx := context.Background()
+	root := &node{fsys: fsys, name: "."}
+
+	// Masked entries are left out of listings
+	stream, errno := root.Readdir(ctx)
+	if errno != 0 {
+		t.Fatalf("Readdir() = %v", errno)
+	}
+	var names []string
+	for stream.HasNext() {
+		entry, errno := stream.Next()
+		if errno != 0 {
+			t.Fatalf("Next() = %v", errno)
+		}
+		names = append(names, entry.Name)
+	}
+	if want := []string{"a.txt", "dir", "link"}; !slices.Equal(names, want) {
+		t.Errorf("Readdir() = %v, want %v", names, want)
+	}
+
+	// Files are read-only whatever their permissions
+	var attr fuse.AttrOut
+	if errno := (&node{fsys: fsys, name: "dir/c.txt"}).Getattr(ctx, nil, &attr); errno != 0 {
+		t.Fatalf("Getattr() = %v", errno)
+	}
+	if attr.Mode != syscall.S_IFREG|0o444 || attr.Size != 5 {
+		t.Errorf("Getattr() = mode %o size %d, want mode %o size 5", attr.Mode, attr.Size, syscall.S_IFREG|0o444)
+	}
+	if errno := (&node{fsys: fsys, name: "b.key"}).Getattr(ctx, nil, &attr); errno != syscall.ENOENT {
+		t.Errorf("Getattr() of a masked file = %v, want %v", errno, syscall.ENOENT)
+	}
+
+	if target, errno := (&node{fsys: fsys, name: "link"}).Readlink(ctx); errno != 0 || string(target) != "a.txt" {
+		t.Errorf("Readlink() = %q, %v, want a.txt", target, errno)
+	}
+
+	a := &node{fsys: fsys, name: "a.txt"}
+	if _, _, errno := a.Open(ctx, syscall.O_RDWR); errno != syscall.EROFS {
+		t.Errorf("Open() for writing = %v, want %v", errno, syscall.EROFS)
+	}
+	if _, _, errno := (&node{fsys: fsys, name: "b.key"}).Open(ctx, syscall.O_RDONLY); errno != syscall.ENOENT {
+		t.Errorf("Open() of a masked file = %v, want %v", errno, syscall.ENOENT)
+	}
+	fh, _, errno := a.Open(ctx, syscall.O_RDONLY)
+	if errno != 0 {
+		t.Fatalf("Open() = %v", errno)
+	}
+	h := fh.(*handle)
+	defer h.Release(ctx)
+	result, errno := h.Read(ctx, make([]byte, 3), 2)
+	if errno != 0 {
+		t.Fatalf("Read() = %v", errno)
+	}
+	if data, _ := result.Bytes(nil); string(data) != "llo" {
+		t.Errorf("Read() at 2 = %q, want %q", data, "llo")
+	}
+}
+
+func TestErrno(t *testing.T) {
+	for _, tt := range []struct {
+		err  error
+		want syscall.Errno
+	}{
+		{err: nil, want: 0},
+		{err: &fs.PathError{Op: "open", Path: "a", Err: fs.ErrNotExist}, want: syscall.ENOENT},
+		{err: fs.ErrPermission, want: syscall.EACCES},
+		{err: fs.ErrInvalid, want: syscall.EINVAL},
+		{err: &fs.PathError{Op: "open", Path: "a", Err: syscall.ELOOP}, want: syscall.ELOOP},
+		{err: fs.ErrClosed, want: syscall.EIO},
+	} {
+		if got := errno(tt.err); got != tt.want {
+			t.Errorf("errno(%v) = %v, want %v", tt.err, got, tt.want)
+		}
+	}
+}
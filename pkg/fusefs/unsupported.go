@@ -0,0 +1,14 @@
+//go:build !linux && !darwin
+
+package fusefs
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+)
+
+// Mount always fails, since FUSE isn't supported on this platform.
+func Mount(context.Context, string, fs.FS, Options) error {
+	return errors.New("FUSE mounts aren't supported on this platform")
+}
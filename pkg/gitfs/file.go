@@ -0,0 +1,182 @@
+package gitfs
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"time"
+
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+// fileInfo describes a node by the name it was reached by, which is the symlink's rather than the target's when
+// following a symlink.
+type fileInfo struct {
+	n       *node
+	name    string
+	modTime time.Time
+}
+
+func (i fileInfo) Name() string       { return i.name }
+func (i fileInfo) Size() int64        { return i.n.size }
+func (i fileInfo) Mode() fs.FileMode  { return i.n.mode }
+func (i fileInfo) ModTime() time.Time { return i.modTime }
+func (i fileInfo) IsDir() bool        { return i.n.mode.IsDir() }
+func (i fileInfo) Sys() any           { return nil }
+
+// file reads the contents of a blob, reopening them whenever it's read from somewhere other than where the last read
+// left off, since blobs can only be read from their start.
+type file struct {
+	fsys   *FS
+	name   string
+	node   *node
+	info   fileInfo
+	offset int64
+	r      io.ReadCloser // Reader of the contents from offset, nil until the next read
+}
+
+func (f *file) Stat() (fs.FileInfo, error) { return f.info, nil }
+
+func (f *file) Read(p []byte) (int, error) {
+	if f.offset >= f.node.size {
+		return 0, io.EOF
+	}
+
+	if f.r == nil {
+		r, err := f.open()
+		if err != nil {
+			return 0, &fs.PathError{Op: "read", Path: f.name, Err: err}
+		}
+		f.r = r
+	}
+
+	n, err := f.r.Read(p)
+	f.offset += int64(n)
+	if errors.Is(err, io.EOF) && f.offset < f.node.size {
+		err = io.ErrUnexpectedEOF
+	}
+	return n, err
+}
+
+// open opens the blob, skipping up to the offset.
+func (f *file) open() (io.ReadCloser, error) {
+	f.fsys.mu.Lock()
+	blob, err := f.fsys.repo.BlobObject(f.node.hash)
+	if err != nil {
+		f.fsys.mu.Unlock()
+		return nil, err
+	}
+	r, err := blob.Reader()
+	f.fsys.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := io.CopyN(io.Discard, r, f.offset); err != nil {
+		_ = r.Close()
+		return nil, err
+	}
+	return r, nil
+}
+
+func (f *file) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += f.offset
+	case io.SeekEnd:
+		offset += f.node.size
+	default:
+		return 0, &fs.PathError{Op: "seek", Path: f.name, Err: fs.ErrInvalid}
+	}
+	if offset < 0 {
+		return 0, &fs.PathError{Op: "seek", Path: f.name, Err: fs.ErrInvalid}
+	}
+
+	if offset != f.offset && f.r != nil {
+		_ = f.r.Close()
+		f.r = nil
+	}
+	f.offset = offset
+
+	return offset, nil
+}
+
+func (f *file) Close() error {
+	if f.r == nil {
+		return nil
+	}
+	err := f.r.Close()
+	f.r = nil
+	return err
+}
+
+// dirEntry is an entry of a directory, whose object is read when its info is asked for.
+type dirEntry struct {
+	fsys  *FS
+	entry object.TreeEntry
+}
+
+func (e *dirEntry) Name() string { return e.entry.Name }
+func (e *dirEntry) IsDir() bool  { return e.entry.Mode == filemode.Dir }
+
+func (e *dirEntry) Type() fs.FileMode {
+	switch e.entry.Mode {
+	case filemode.Dir:
+		return fs.ModeDir
+	case filemode.Symlink:
+		return fs.ModeSymlink
+	default:
+		return 0
+	}
+}
+
+func (e *dirEntry) Info() (fs.FileInfo, error) {
+	n, _, err := e.fsys.node(e.entry)
+	if err != nil {
+		return nil, &fs.PathError{Op: "stat", Path: e.entry.Name, Err: err}
+	}
+	return fileInfo{n, n.name, e.fsys.modTime}, nil
+}
+
+// dir is an open directory, listed on the first call to ReadDir.
+type dir struct {
+	fsys    *FS
+	name    string
+	node    *node
+	info    fileInfo
+	entries []fs.DirEntry
+	listed  bool
+}
+
+func (d *dir) Stat() (fs.FileInfo, error) { return d.info, nil }
+
+func (d *dir) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.name, Err: errors.New("is a directory")}
+}
+
+func (d *dir) Close() error { return nil }
+
+func (d *dir) ReadDir(n int) ([]fs.DirEntry, error) {
+	if !d.listed {
+		entries, err := d.fsys.entries(d.node.tree)
+		if err != nil {
+			return nil, &fs.PathError{Op: "readdir", Path: d.name, Err: err}
+		}
+		d.entries, d.listed = entries, true
+	}
+
+	if n <= 0 {
+		entries := d.entries
+		d.entries = nil
+		return entries, nil
+	}
+	if len(d.entries) == 0 {
+		return nil, io.EOF
+	}
+
+	n = min(n, len(d.entries))
+	entries := d.entries[:n]
+	d.entries = d.entries[n:]
+	return entries, nil
+}
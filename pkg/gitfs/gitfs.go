@@ -0,0 +1,291 @@
+// Package gitfs exposes the tree of a commit in a git repository as a read-only fs.FS, so that a branch, tag, or any
+// other revision can be served and masked without checking it out.
+//
+// Git doesn't record modification times, so every entry has the time of the commit, like the entries of git archive.
+// Files have 0644 or 0755 permissions for executables, symlinks are followed within the tree, and submodules, whose
+// contents aren't part of the repository, don't exist.
+package gitfs
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+// maxSymlinkHops is the most symlinks followed when resolving a path, like the kernel's limit.
+const maxSymlinkHops = 40
+
+// FS is a read-only filesystem of the tree of a commit.
+type FS struct {
+	// The repository's object storage isn't safe for concurrent use, so every access to it is serialized. Trees and
+	// the readers of blobs are safe to use without it once they're read.
+	mu      sync.Mutex
+	repo    *git.Repository
+	root    *object.Tree
+	modTime time.Time
+}
+
+// Open opens the tree of the commit a revision of a repository resolves to. The repository can be bare or have a
+// working tree, which is ignored, and the revision is anything git rev-parse accepts, like a branch, a tag, a commit
+// hash, or HEAD~2. The revision is resolved once, so the filesystem doesn't change when the revision moves.
+func Open(repoPath, revision string) (*FS, error) {
+	repo, err := git.PlainOpen(repoPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open git repository: %w", err)
+	}
+	if revision == "" {
+		revision = "HEAD"
+	}
+
+	hash, err := repo.ResolveRevision(plumbing.Revision(revision))
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve git revision %q: %w", revision, err)
+	}
+	commit, err := repo.CommitObject(*hash)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get commit of git revision %q: %w", revision, err)
+	}
+	tree, err := commit.Tree()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get tree of git revision %q: %w", revision, err)
+	}
+
+	return &FS{
+		repo:    repo,
+		root:    tree,
+		modTime: commit.Committer.When,
+	}, nil
+}
+
+// Close closes the repository's object storage, after which the tree can no longer be read.
+func (f *FS) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if c, ok := f.repo.Storer.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+// node is an entry of the tree.
+type node struct {
+	name   string
+	mode   fs.FileMode
+	size   int64
+	tree   *object.Tree  // Tree of a directory
+	hash   plumbing.Hash // Blob of a file or symlink
+	target string        // Target of a symlink
+}
+
+// child returns the node of the entry of a tree with the given name. Git sorts the entries of trees by name, comparing
+// the names of directories as if they ended with a slash, so the entry is searched for as a file and as a directory.
+func (f *FS) child(t *object.Tree, name string) (*node, bool, error) {
+	for _, key := range []string{name, name + "/"} {
+		i, found := slices.BinarySearchFunc(t.Entries, key, func(e object.TreeEntry, key string) int {
+			return strings.Compare(treeKey(e), key)
+		})
+		if found {
+			return f.node(t.Entries[i])
+		}
+	}
+	return nil, false, nil
+}
+
+// treeKey returns the name a tree entry is sorted by in its tree.
+func treeKey(e object.TreeEntry) string {
+	if e.Mode == filemode.Dir {
+		return e.Name + "/"
+	}
+	return e.Name
+}
+
+// node returns the node of a tree entry, or false for submodules. The sizes of blobs are read from their headers, so
+// only the targets of symlinks are read.
+func (f *FS) node(e object.TreeEntry) (*node, bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	n := &node{name: e.Name, hash: e.Hash}
+	switch e.Mode {
+	case filemode.Dir:
+		tree, err := f.repo.TreeObject(e.Hash)
+		if err != nil {
+			return nil, false, err
+		}
+		n.mode, n.tree = fs.ModeDir|0o755, tree
+		return n, true, nil
+	case filemode.Submodule:
+		return nil, false, nil
+	case filemode.Symlink:
+		blob, err := f.repo.BlobObject(e.Hash)
+		if err != nil {
+			return nil, false, err
+		}
+		target, err := readBlob(blob)
+		if err != nil {
+			return nil, false, err
+		}
+		n.mode, n.target = fs.ModeSymlink|0o777, target
+		return n, true, nil
+	}
+
+	size, err := f.repo.Storer.EncodedObjectSize(e.Hash)
+	if err != nil {
+		return nil, false, err
+	}
+	n.size = size
+	n.mode = 0o644
+	if e.Mode == filemode.Executable {
+		n.mode = 0o755
+	}
+	return n, true, nil
+}
+
+// lookup returns the node of a path, following symlinks along it, and following a symlink at the end of it too if
+// follow is true.
+func (f *FS) lookup(op, name string, follow bool) (*node, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+
+	// The stack holds the directories leading to the current one, which is last
+	var (
+		stack = []*node{{name: ".", mode: fs.ModeDir | 0o755, tree: f.root}}
+		parts = strings.Split(name, "/")
+		hops  int
+	)
+	for len(parts) > 0 {
+		part := parts[0]
+		parts = parts[1:]
+
+		switch part {
+		case "", ".":
+			continue
+		case "..":
+			if len(stack) == 1 {
+				return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+			}
+			stack = stack[:len(stack)-1]
+			continue
+		}
+
+		dir := stack[len(stack)-1]
+		if dir.tree == nil {
+			return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+		}
+		child, ok, err := f.child(dir.tree, part)
+		if err != nil {
+			return nil, &fs.PathError{Op: op, Path: name, Err: err}
+		}
+		if !ok {
+			return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+		}
+		if child.mode&fs.ModeSymlink == 0 || (len(parts) == 0 && !follow) {
+			stack = append(stack, child)
+			continue
+		}
+
+		if hops++; hops > maxSymlinkHops {
+			return nil, &fs.PathError{Op: op, Path: name, Err: errors.New("too many levels of symlinks")}
+		}
+		if path.IsAbs(child.target) {
+			return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+		}
+		parts = append(strings.Split(child.target, "/"), parts...)
+	}
+
+	return stack[len(stack)-1], nil
+}
+
+func (f *FS) Open(name string) (fs.File, error) {
+	n, err := f.lookup("open", name, true)
+	if err != nil {
+		return nil, err
+	}
+	info := fileInfo{n, path.Base(name), f.modTime}
+	if n.tree != nil {
+		return &dir{fsys: f, name: name, node: n, info: info}, nil
+	}
+	return &file{fsys: f, name: name, node: n, info: info}, nil
+}
+
+func (f *FS) Stat(name string) (fs.FileInfo, error) {
+	n, err := f.lookup("stat", name, true)
+	if err != nil {
+		return nil, err
+	}
+	return fileInfo{n, path.Base(name), f.modTime}, nil
+}
+
+func (f *FS) Lstat(name string) (fs.FileInfo, error) {
+	n, err := f.lookup("lstat", name, false)
+	if err != nil {
+		return nil, err
+	}
+	return fileInfo{n, path.Base(name), f.modTime}, nil
+}
+
+func (f *FS) ReadLink(name string) (string, error) {
+	n, err := f.lookup("readlink", name, false)
+	if err != nil {
+		return "", err
+	}
+	if n.mode&fs.ModeSymlink == 0 {
+		return "", &fs.PathError{Op: "readlink", Path: name, Err: fs.ErrInvalid}
+	}
+	return n.target, nil
+}
+
+func (f *FS) ReadDir(name string) ([]fs.DirEntry, error) {
+	n, err := f.lookup("readdir", name, true)
+	if err != nil {
+		return nil, err
+	}
+	if n.tree == nil {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: errors.New("not a directory")}
+	}
+
+	entries, err := f.entries(n.tree)
+	if err != nil {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: err}
+	}
+	return entries, nil
+}
+
+// entries returns the entries of a tree, sorted by name rather than in git's order, which sorts directories as if
+// their names ended with a slash. Their objects aren't read until their infos are asked for.
+func (f *FS) entries(t *object.Tree) ([]fs.DirEntry, error) {
+	entries := make([]fs.DirEntry, 0, len(t.Entries))
+	for _, e := range t.Entries {
+		if e.Mode != filemode.Submodule {
+			entries = append(entries, &dirEntry{fsys: f, entry: e})
+		}
+	}
+	slices.SortFunc(entries, func(a, b fs.DirEntry) int { return strings.Compare(a.Name(), b.Name()) })
+
+	return entries, nil
+}
+
+// readBlob reads the whole contents of a small blob, like a symlink's target.
+func readBlob(blob *object.Blob) (string, error) {
+	r, err := blob.Reader()
+	if err != nil {
+		return "", err
+	}
+	defer r.Close()
+
+	data, err := io.ReadAll(io.LimitReader(r, 4096))
+	return string(data), err
+}
@@ -0,0 +1,199 @@
+package gitfs
+
+import (
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+var when = time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+
+// newRepo commits the given files to a new repository, returning its path. Files whose contents start with "-> " are
+// symlinks to the rest of their contents, and files ending with .sh are executable.
+func newRepo(t *testing.T, files map[string]string) string {
+	t.Helper()
+
+	dir := t.TempDir()
+	repo, err := git.PlainInit(dir, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wt, err := repo.Worktree()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, contents := range files {
+		name := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(name), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if target, ok := strings.CutPrefix(contents, "-> "); ok {
+			err = os.Symlink(target, name)
+		} else {
+			mode := os.FileMode(0o644)
+			if strings.HasSuffix(name, ".sh") {
+				mode = 0o755
+			}
+			err = os.WriteFile(name, []byte(contents), mode)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := wt.AddGlob("."); err != nil {
+		t.Fatal(err)
+	}
+	sig := &object.Signature{Name: "maskfs", Email: "maskfs@example.com", When: when}
+	if _, err := wt.Commit("test", &git.CommitOptions{Author: sig, Committer: sig}); err != nil {
+		t.Fatal(err)
+	}
+
+	return dir
+}
+
+var files = map[string]string{
+	"a.txt":           "hello",
+	"a-b.txt":         "sorts between a.txt and a/ in git",
+	"a/b.txt":         "nested",
+	"a/c/d.txt":       strings.Repeat("large ", 10000),
+	"run.sh":          "#!/bin/sh\n",
+	"link.txt":        "-> a/b.txt",
+	"dirlink":         "-> a/c",
+	"a/up.txt":        "-> ../a.txt",
+	"a/c/loop":        "-> ../../a",
+	"z/last.txt":      "last",
+	"a.dir/inner.txt": "sorts after a/ in git",
+}
+
+func TestFS(t *testing.T) {
+	fsys, err := Open(newRepo(t, files), "HEAD")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fsys.Close()
+
+	if err := fstest.TestFS(fsys, "a.txt", "a-b.txt", "a/b.txt", "a/c/d.txt", "run.sh", "link.txt", "z/last.txt", "a.dir/inner.txt"); err != nil {
+		t.Error(err)
+	}
+
+	for name, want := range map[string]string{
+		"a/c/d.txt":       files["a/c/d.txt"],
+		"link.txt":        "nested",
+		"dirlink/d.txt":   files["a/c/d.txt"],
+		"a/up.txt":        "hello",
+		"a/c/loop/b.txt":  "nested",
+		"a.dir/inner.txt": files["a.dir/inner.txt"],
+	} {
+		data, err := fs.ReadFile(fsys, name)
+		if err != nil || string(data) != want {
+			t.Errorf("ReadFile(%q) = %.20q, %v, want %.20q", name, data, err, want)
+		}
+	}
+	if _, err := fs.Stat(fsys, "a.txt/b"); err == nil {
+		t.Error("Stat(a.txt/b) succeeded")
+	}
+
+	info, err := fs.Stat(fsys, "run.sh")
+	if err != nil || info.Mode() != 0o755 || !info.ModTime().Equal(when) {
+		t.Errorf("Stat(run.sh) = %v, %v, want an executable modified at %v", info, err, when)
+	}
+	info, err = fsys.Lstat("link.txt")
+	if err != nil || info.Mode()&fs.ModeSymlink == 0 {
+		t.Errorf("Lstat(link.txt) = %v, %v, want a symlink", info, err)
+	}
+	if target, err := fsys.ReadLink("link.txt"); err != nil || target != "a/b.txt" {
+		t.Errorf("ReadLink(link.txt) = %q, %v, want %q", target, err, "a/b.txt")
+	}
+}
+
+func TestFSBrokenSymlinks(t *testing.T) {
+	fsys, err := Open(newRepo(t, map[string]string{
+		"broken":  "-> missing",
+		"outside": "-> ../../etc/passwd",
+		"abs":     "-> /etc/passwd",
+		"loop":    "-> loop",
+	}), "HEAD")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fsys.Close()
+
+	for _, name := range []string{"broken", "outside", "abs", "loop"} {
+		if _, err := fs.Stat(fsys, name); err == nil {
+			t.Errorf("Stat(%q) succeeded", name)
+		}
+		if _, err := fsys.Lstat(name); err != nil {
+			t.Errorf("Lstat(%q) = %v", name, err)
+		}
+	}
+}
+
+func TestFSSeek(t *testing.T) {
+	fsys, err := Open(newRepo(t, files), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fsys.Close()
+
+	f, err := fsys.Open("a/c/d.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	contents := files["a/c/d.txt"]
+	for _, offset := range []int64{600, 6, int64(len(contents)) - 6, 0} {
+		if _, err := f.(io.Seeker).Seek(offset, io.SeekStart); err != nil {
+			t.Fatal(err)
+		}
+		buf := make([]byte, 6)
+		if _, err := io.ReadFull(f, buf); err != nil {
+			t.Fatal(err)
+		}
+		if want := contents[offset : offset+6]; string(buf) != want {
+			t.Errorf("read %q at %d, want %q", buf, offset, want)
+		}
+	}
+}
+
+func TestFSConcurrent(t *testing.T) {
+	fsys, err := Open(newRepo(t, files), "HEAD")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fsys.Close()
+
+	var wg sync.WaitGroup
+	for range 8 {
+		wg.Go(func() {
+			for _, name := range []string{"a/c/d.txt", "link.txt", "a.txt"} {
+				if _, err := fs.ReadFile(fsys, name); err != nil {
+					t.Error(err)
+				}
+			}
+			if _, err := fs.ReadDir(fsys, "a"); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+	wg.Wait()
+}
+
+func TestOpenRevision(t *testing.T) {
+	if _, err := Open(newRepo(t, files), "no-such-branch"); err == nil {
+		t.Error("opened a revision that doesn't exist")
+	}
+	if _, err := Open(t.TempDir(), "HEAD"); err == nil {
+		t.Error("opened a directory that isn't a repository")
+	}
+}
@@ -0,0 +1,57 @@
+package index
+
+import (
+	"io/fs"
+	"sync"
+	"sync/atomic"
+)
+
+// child is the entry of a child of a directory, or the error getting it, see getChild.
+type child struct {
+	entry *Entry
+	err   error
+}
+
+// getChildren gets the entries of children of a directory, up to the configured number of them at once, and returns
+// them in the order of the children, so the result doesn't depend on which were stat'ed first. With FailBroken, the
+// children after the first one that fails may not be read, and have neither an entry nor an error.
+func getChildren(fsys fs.FS, dir string, children []fs.DirEntry, mask Mask, o getEntriesOptions) []child {
+	results := make([]child, len(children))
+
+	workers := min(o.workers, len(children))
+	if workers <= 1 {
+		for i, c := range children {
+			entry, err := getChild(fsys, dir, c.Name(), mask, o)
+			results[i] = child{entry, err}
+			if err != nil && o.broken == FailBroken {
+				break
+			}
+		}
+		return results
+	}
+
+	// Children are claimed in order, so every child before one that fails is still read
+	var (
+		next   atomic.Int64
+		failed atomic.Bool
+		wg     sync.WaitGroup
+	)
+	for range workers {
+		wg.Go(func() {
+			for !failed.Load() {
+				i := int(next.Add(1) - 1)
+				if i >= len(children) {
+					return
+				}
+				entry, err := getChild(fsys, dir, children[i].Name(), mask, o)
+				results[i] = child{entry, err}
+				if err != nil && o.broken == FailBroken {
+					failed.Store(true)
+				}
+			}
+		})
+	}
+	wg.Wait()
+
+	return results
+}
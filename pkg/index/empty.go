@@ -0,0 +1,54 @@
+package index
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+)
+
+// HasUnmasked returns true if the directory has at least one unmasked file below it, so that listing it would
+// eventually lead somewhere. The walk stops as soon as an unmasked file is found.
+// Symlinked directories are not descended into, to avoid loops, and count as unmasked if they aren't masked themselves.
+func HasUnmasked(fsys fs.FS, dir string, mask Mask) (bool, error) {
+	children, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return false, err
+	}
+
+	var dirs []*Entry
+	for _, child := range children {
+		entry, err := GetEntry(fsys, path.Join(dir, child.Name()))
+		if errors.Is(err, ErrBrokenSymlink) {
+			continue
+		}
+		if err != nil {
+			return false, fmt.Errorf("failed to get entry: %w", err)
+		}
+
+		if mask != nil && mask.Masked(entry) {
+			continue
+		}
+		if !entry.IsDir || entry.IsSymlink {
+			return true, nil
+		}
+
+		// Finish checking the files at this level before descending, since they're cheaper to check
+		dirs = append(dirs, entry)
+	}
+
+	for _, subdir := range dirs {
+		sub := mask
+		switch PruneHint(mask, subdir) {
+		case PruneMasked:
+			continue
+		case PruneUnmasked:
+			sub = nil
+		}
+		if found, err := HasUnmasked(fsys, subdir.FSPath, sub); found || err != nil {
+			return found, err
+		}
+	}
+
+	return false, nil
+}
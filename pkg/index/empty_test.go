@@ -0,0 +1,98 @@
+package index
+
+import (
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+// recordingMask masks keys and symlinks named up, recording the path of every entry it checks.
+type recordingMask struct {
+	checked []string
+}
+
+func (m *recordingMask) Masked(entry *Entry) bool {
+	m.checked = append(m.checked, entry.FSPath)
+	return path.Ext(entry.Name) == ".key" || entry.Name == "up"
+}
+
+func TestHasUnmasked(t *testing.T) {
+	root := t.TempDir()
+	for _, name := range []string{
+		"files/a.txt",
+		"keys/a.key",
+		"keys/sub/b.key",
+		"deep/sub/sub/a.txt",
+		"loop/sub/x.key",
+		"empty/",
+		"early/dir/x.txt",
+		"early/z.txt",
+	} {
+		dir, file := path.Split(name)
+		if err := os.MkdirAll(filepath.Join(root, filepath.FromSlash(dir)), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if file == "" {
+			continue
+		}
+		if err := os.WriteFile(filepath.Join(root, filepath.FromSlash(name)), nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for link, target := range map[string]string{
+		"linked/files":  "../files",
+		"loop/sub/up":   "..",
+		"loop/sub/self": ".",
+	} {
+		link = filepath.Join(root, filepath.FromSlash(link))
+		if err := os.MkdirAll(filepath.Dir(link), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Symlink(target, link); err != nil {
+			t.Fatal(err)
+		}
+	}
+	fsys := os.DirFS(root)
+
+	for _, tt := range []struct {
+		dir  string
+		want bool
+	}{
+		{dir: "files", want: true},
+		{dir: "keys", want: false},
+		{dir: "deep", want: true},
+		{dir: "empty", want: false},
+		// Symlinked directories aren't descended into, they count as unmasked files
+		{dir: "linked", want: true},
+		{dir: "loop", want: true},
+	} {
+		t.Run(tt.dir, func(t *testing.T) {
+			if got, err := HasUnmasked(fsys, tt.dir, &recordingMask{}); err != nil || got != tt.want {
+				t.Errorf("HasUnmasked(%q) = %t, %v, want %t", tt.dir, got, err, tt.want)
+			}
+		})
+	}
+
+	t.Run("loop without unmasked entries", func(t *testing.T) {
+		// With the only unmasked entry removed, the walk terminates rather than following the loop back up
+		if err := os.Remove(filepath.Join(root, "loop", "sub", "self")); err != nil {
+			t.Fatal(err)
+		}
+		if got, err := HasUnmasked(fsys, "loop", &recordingMask{}); err != nil || got {
+			t.Errorf("HasUnmasked(loop) = %t, %v, want false", got, err)
+		}
+	})
+
+	t.Run("early stop", func(t *testing.T) {
+		// The unmasked file is found before descending into the directory next to it
+		m := &recordingMask{}
+		if got, err := HasUnmasked(fsys, "early", m); err != nil || !got {
+			t.Fatalf("HasUnmasked(early) = %t, %v, want true", got, err)
+		}
+		if want := []string{"early/dir", "early/z.txt"}; !slices.Equal(m.checked, want) {
+			t.Errorf("checked %v, want %v", m.checked, want)
+		}
+	})
+}
@@ -3,46 +3,175 @@ package index
 import (
 	"errors"
 	"fmt"
-	"html/template"
 	"io"
 	"io/fs"
+	"net/http"
 	"net/url"
-	"path/filepath"
+	"path"
+	"sort"
+	"strings"
 	"time"
 )
 
-// GetEntry fetches file metadata and returns an Entry
-func GetEntry(fsys fs.FS, path string) (*Entry, error) {
-	info, err := fs.Stat(fsys, path)
+// ErrBrokenSymlink is returned when the target of a symlink can't be resolved,
+// either because it doesn't exist or because it's outside of the filesystem.
+var ErrBrokenSymlink = errors.New("broken symlink")
+
+// GetEntry fetches file metadata and returns an Entry, linked under DefaultLinkPrefix unless WithLinkPrefix is given.
+// Symlinks are resolved to their targets, but are marked as such on the Entry so they can be masked by their own path and type.
+func GetEntry(fsys fs.FS, path string, opts ...GetEntriesOption) (*Entry, error) {
+	return getEntry(fsys, path, newGetEntriesOptions(opts))
+}
+
+func getEntry(fsys fs.FS, path string, o getEntriesOptions) (*Entry, error) {
+	info, err := fs.Lstat(fsys, path)
 	if err != nil {
 		return nil, err
 	}
 
-	linkPath, err := url.JoinPath("/files", url.PathEscape(path))
+	isSymlink := info.Mode()&fs.ModeSymlink != 0
+	if isSymlink {
+		if info, err = fs.Stat(fsys, path); err != nil {
+			return nil, fmt.Errorf("%w %q: %w", ErrBrokenSymlink, path, err)
+		}
+	}
+
+	linkPath, err := LinkPath(o.linkPrefix, path)
 	if err != nil {
 		return nil, err
 	}
 
+	inode, links := fileIDOf(info)
+	var mimeType string
+	if !info.IsDir() {
+		mimeType = TypeByExtension(info.Name())
+	}
+
 	return &Entry{
-		Name:     info.Name(),
-		Size:     info.Size(),
-		Mode:     info.Mode(),
-		ModTime:  info.ModTime().Format(time.RFC3339),
-		IsDir:    info.IsDir(),
-		FSPath:   path,
-		LinkPath: linkPath,
+		Name:      info.Name(),
+		Size:      info.Size(),
+		Mode:      info.Mode(),
+		ModTime:   info.ModTime(),
+		IsDir:     info.IsDir(),
+		IsSymlink: isSymlink,
+		FSPath:    path,
+		LinkPath:  linkPath,
+		MIMEType:  mimeType,
+		Owner:     ownerOf(info),
+		Inode:     inode,
+		Links:     links,
+		fsys:      fsys,
 	}, nil
 }
 
+// DefaultLinkPrefix is the URL path of the file server that entries link under by default.
+const DefaultLinkPrefix = "/files"
+
+// LinkPath returns the URL-encoded path of the entry at the given filesystem path, for a file server under prefix.
+func LinkPath(prefix, fsPath string) (string, error) {
+	return url.JoinPath(prefix, url.PathEscape(fsPath))
+}
+
 // Entry represents file or directory metadata specifically for directory listing pages
 type Entry struct {
-	Name     string
-	Size     int64
-	Mode     fs.FileMode
-	ModTime  string
-	IsDir    bool
-	FSPath   string // File path relative to the filesystem's root directory (leading slash omitted)
-	LinkPath string // URL-encoded path for HTML links
+	Name      string            `json:"name"`
+	Size      int64             `json:"size"`
+	Mode      fs.FileMode       `json:"mode"`
+	ModTime   time.Time         `json:"mod_time"`
+	IsDir     bool              `json:"is_dir"`
+	IsSymlink bool              `json:"is_symlink,omitempty"`   // True if the entry is a symlink, in which case the other fields describe its target
+	FSPath    string            `json:"fs_path"`                // File path relative to the filesystem's root directory (leading slash omitted)
+	LinkPath  string            `json:"link_path"`              // URL-encoded path for HTML links
+	Metadata  map[string]string `json:"metadata,omitempty"`     // Extra fields supplied by a MetadataProvider, if any
+	Loop      bool              `json:"loop,omitempty"`         // True if the entry is a symlink to the directory containing it or one of its ancestors
+	TotalSize *int64            `json:"total_size,omitempty"`   // Total size of the unmasked files below a directory, if it was computed
+	MIMEType  string            `json:"content_type,omitempty"` // Content type of a file by its extension, or sniffed from its contents if requested, empty if unknown
+	Error     string            `json:"error,omitempty"`        // Why the entry couldn't be stat'ed, when GetEntries annotates broken entries, in which case only its names and paths are set
+	Owner     *Owner            `json:"-"`                      // The owner of the file, nil if the filesystem doesn't report one
+	Inode     uint64            `json:"-"`                      // Inode number of the file, 0 if the filesystem doesn't report one
+	Links     uint64            `json:"-"`                      // Number of hard links to the file, 0 if the filesystem doesn't report them
+	HostInfo  *HostInfo         `json:"host,omitempty"`         // The owner, inode, and link count of the file, only if requested with AddHostInfo
+
+	fsys        fs.FS  // The filesystem the entry was read from, nil if it wasn't read from one
+	contentType string // Cached by ContentType
+}
+
+// Owner identifies the user and group owning a file.
+type Owner struct {
+	UID uint32
+	GID uint32
+}
+
+// HostInfo describes a file the way the host's filesystem does, which reveals more about the host than the rest of an
+// entry, so it's only reported for auditing.
+type HostInfo struct {
+	UID   uint32 `json:"uid"`
+	GID   uint32 `json:"gid"`
+	User  string `json:"user,omitempty"`  // Name of the user, empty if the UID has none
+	Group string `json:"group,omitempty"` // Name of the group, empty if the GID has none
+	Inode uint64 `json:"inode,omitempty"` // 0 if the filesystem doesn't report one
+	Links uint64 `json:"nlink,omitempty"` // 0 if the filesystem doesn't report them
+}
+
+// AddHostInfo sets the entry's host info from its owner, inode, and link count, looking up the names of its user and
+// group. Entries whose filesystem doesn't report an owner get none.
+func (e *Entry) AddHostInfo() {
+	if e.Owner == nil || e.HostInfo != nil {
+		return
+	}
+	e.HostInfo = &HostInfo{
+		UID:   e.Owner.UID,
+		GID:   e.Owner.GID,
+		User:  userName(e.Owner.UID),
+		Group: groupName(e.Owner.GID),
+		Inode: e.Inode,
+		Links: e.Links,
+	}
+}
+
+// IsRoot returns true if the entry is the root directory of its filesystem.
+func (e *Entry) IsRoot() bool {
+	return e.FSPath == "."
+}
+
+// DisplayPath returns the absolute path of the entry within its filesystem, for display.
+func (e *Entry) DisplayPath() string {
+	if e.IsRoot() {
+		return "/"
+	}
+	return "/" + e.FSPath
+}
+
+// RelPath returns the slash-separated path of the entry relative to the given directory, which must be one of its ancestors.
+// For an immediate child of the directory, this is the same as its name.
+func (e *Entry) RelPath(dir *Entry) string {
+	if dir.IsRoot() {
+		return e.FSPath
+	}
+	return strings.TrimPrefix(e.FSPath, dir.FSPath+"/")
+}
+
+// ContentType returns the MIME type of a file, sniffed from its first 512 bytes with http.DetectContentType the first
+// time it's needed. Directories, and entries that weren't read from a filesystem by GetEntry, have no content type.
+func (e *Entry) ContentType() (string, error) {
+	if e.contentType != "" || e.IsDir || e.fsys == nil {
+		return e.contentType, nil
+	}
+
+	f, err := e.fsys.Open(e.FSPath)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	head := make([]byte, 512)
+	n, err := io.ReadFull(f, head)
+	if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return "", err
+	}
+
+	e.contentType = http.DetectContentType(head[:n])
+	return e.contentType, nil
 }
 
 // Mask masks entries from an index.
@@ -51,107 +180,215 @@ type Mask interface {
 	Masked(entry *Entry) bool
 }
 
+// MetadataProvider supplies extra metadata for entries in an index.
+type MetadataProvider interface {
+	// Enrich returns the extra metadata fields of the entry, or nil if it has none.
+	Enrich(entry *Entry) map[string]string
+}
+
 // Entries is a collection of Entry objects
 type Entries []*Entry
 
-// GetEntries returns a new index of entries from the given path.
+// BrokenEntries selects what GetEntries does with children it fails to get the entries of.
+type BrokenEntries int
+
+const (
+	// FailBroken fails the whole listing on the first child that can't be stat'ed, apart from broken symlinks, which
+	// are skipped without an error.
+	FailBroken BrokenEntries = iota
+
+	// SkipBroken leaves out the children that can't be stat'ed, broken symlinks included, and returns the rest of the
+	// listing along with a *ListingError of their errors.
+	SkipBroken
+
+	// AnnotateBroken lists the children that can't be stat'ed with only their names and paths, and their errors in
+	// Error, and returns the rest of the listing along with a *ListingError of their errors.
+	AnnotateBroken
+)
+
+// GetEntriesOption configures how GetEntries lists a directory, and how GetEntry gets the entries it lists.
+type GetEntriesOption func(*getEntriesOptions)
+
+type getEntriesOptions struct {
+	broken     BrokenEntries
+	workers    int
+	linkPrefix string
+}
+
+func newGetEntriesOptions(opts []GetEntriesOption) getEntriesOptions {
+	o := getEntriesOptions{linkPrefix: DefaultLinkPrefix}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// WithBrokenEntries sets what GetEntries does with children it fails to get the entries of, FailBroken by default.
+func WithBrokenEntries(broken BrokenEntries) GetEntriesOption {
+	return func(o *getEntriesOptions) {
+		o.broken = broken
+	}
+}
+
+// WithConcurrency makes GetEntries stat up to the given number of children at once, which hides the latency of
+// network filesystems, without changing the order of the entries. It stats one child at a time by default.
+func WithConcurrency(workers int) GetEntriesOption {
+	return func(o *getEntriesOptions) {
+		o.workers = workers
+	}
+}
+
+// WithLinkPrefix links entries under the URL path of a file server mounted somewhere other than DefaultLinkPrefix.
+func WithLinkPrefix(prefix string) GetEntriesOption {
+	return func(o *getEntriesOptions) {
+		o.linkPrefix = prefix
+	}
+}
+
+// ListingError is returned by GetEntries along with the entries it could get when it skips or annotates broken
+// children, joining their errors so they can be logged, and yielded by IterEntries for each of them.
+type ListingError struct {
+	Dir  string
+	Errs []error
+}
+
+func (e *ListingError) Error() string {
+	return fmt.Sprintf("failed to get %d entries of %q: %v", len(e.Errs), e.Dir, errors.Join(e.Errs...))
+}
+
+func (e *ListingError) Unwrap() []error {
+	return e.Errs
+}
+
+// GetEntries returns a new index of entries from the given directory.
 // If a mask is provided, it will be used to filter the entries.
-func GetEntries(fsys fs.FS, path string, mask Mask) (Entries, error) {
-	children, err := fs.ReadDir(fsys, path)
+// By default, any child that can't be stat'ed fails the listing, see WithBrokenEntries.
+func GetEntries(fsys fs.FS, dir string, mask Mask, opts ...GetEntriesOption) (Entries, error) {
+	o := newGetEntriesOptions(opts)
+
+	children, err := fs.ReadDir(fsys, dir)
 	if err != nil {
 		return nil, err
 	}
 
-	var masked Entries
-	for _, child := range children {
-		entry, err := GetEntry(fsys, filepath.Join(path, child.Name()))
-		if err != nil {
-			return nil, fmt.Errorf("failed to get entry: %w", err)
+	var (
+		masked Entries
+		broken []error
+	)
+	for _, child := range getChildren(fsys, dir, children, mask, o) {
+		if child.err != nil {
+			if o.broken == FailBroken {
+				return nil, fmt.Errorf("failed to get entry: %w", child.err)
+			}
+			broken = append(broken, child.err)
+		}
+		if child.entry != nil {
+			masked = append(masked, child.entry)
 		}
+	}
 
-		if entry == nil || (mask != nil && mask.Masked(entry)) {
-			// The entry is not valid or the entry is masked, skip it
-			continue
+	if len(broken) > 0 {
+		return masked, &ListingError{Dir: dir, Errs: broken}
+	}
+	return masked, nil
+}
+
+// getChild returns the entry of a child of a directory, or nil if it's masked or skipped, along with the error of a
+// child that couldn't be stat'ed, which only has an entry if it's annotated. Broken symlinks are skipped without an
+// error by FailBroken.
+func getChild(fsys fs.FS, dir, name string, mask Mask, o getEntriesOptions) (*Entry, error) {
+	fsPath := path.Join(dir, name)
+	entry, err := getEntry(fsys, fsPath, o)
+	if err != nil {
+		switch {
+		case o.broken == FailBroken && errors.Is(err, ErrBrokenSymlink):
+			// The symlink is dangling or leads outside of the filesystem, skip it
+			return nil, nil
+		case o.broken != AnnotateBroken:
+			return nil, err
 		}
+		entry = brokenEntry(o.linkPrefix, fsPath, err)
+	}
 
-		masked = append(masked, entry)
+	if entry == nil || (mask != nil && mask.Masked(entry)) {
+		// The entry is not valid or the entry is masked, skip it
+		return nil, err
 	}
 
-	return masked, nil
+	if entry.IsDir && entry.IsSymlink {
+		// Flag symlinks leading back up the tree so they aren't navigated endlessly
+		entry.Loop = isLoop(fsys, dir, entry.FSPath)
+	}
+
+	return entry, err
+}
+
+// brokenEntry returns the entry annotating a child that couldn't be stat'ed with its error.
+func brokenEntry(linkPrefix, fsPath string, err error) *Entry {
+	// Path errors name the path, which the entry already does
+	message := err.Error()
+	var pathErr *fs.PathError
+	if errors.As(err, &pathErr) {
+		message = pathErr.Err.Error()
+	}
+	isSymlink := errors.Is(err, ErrBrokenSymlink)
+	if isSymlink {
+		message = ErrBrokenSymlink.Error() + ": " + message
+	}
+
+	// The path was joined from names read from the filesystem, so it can always be escaped
+	linkPath, _ := LinkPath(linkPrefix, fsPath)
+	return &Entry{
+		Name:      path.Base(fsPath),
+		IsSymlink: isSymlink,
+		FSPath:    fsPath,
+		LinkPath:  linkPath,
+		Error:     message,
+	}
 }
 
-func (e Entries) WriteHTML(w io.Writer, directory *Entry, entries Entries) error {
-	if directory == nil {
-		return errors.New("invalid directory referenced")
+// Enrich attaches the metadata supplied by the given provider to each entry.
+func (e Entries) Enrich(provider MetadataProvider) {
+	for _, entry := range e {
+		entry.Metadata = provider.Enrich(entry)
 	}
-	for _, entry := range entries {
-		if entry == nil {
-			return errors.New("invalid entry referenced")
+}
+
+// ModifiedBetween returns the entries last modified within the inclusive range from..to.
+// A zero bound leaves that end of the range open. Directories are always kept unless dirs is true,
+// so that the range doesn't prevent navigating to matching entries further down the tree.
+func (e Entries) ModifiedBetween(from, to time.Time, dirs bool) Entries {
+	var filtered Entries
+	for _, entry := range e {
+		if entry.IsDir && !dirs {
+			filtered = append(filtered, entry)
+			continue
+		}
+		if !from.IsZero() && entry.ModTime.Before(from) {
+			continue
+		}
+		if !to.IsZero() && entry.ModTime.After(to) {
+			continue
 		}
+		filtered = append(filtered, entry)
 	}
 
-	data := struct {
-		Directory *Entry
-		Entries   Entries
-	}{
-		Directory: directory,
-		Entries:   entries,
+	return filtered
+}
+
+// MetadataKeys returns the sorted set of metadata keys across all entries.
+func (e Entries) MetadataKeys() []string {
+	seen := map[string]bool{}
+	var keys []string
+	for _, entry := range e {
+		for key := range entry.Metadata {
+			if !seen[key] {
+				seen[key] = true
+				keys = append(keys, key)
+			}
+		}
 	}
+	sort.Strings(keys)
 
-	tmpl, err := template.New("directory").Parse(htmlTemplate)
-	if err != nil {
-		return err
-	}
-
-	return tmpl.Execute(w, data)
-}
-
-const htmlTemplate = `<!DOCTYPE html>
-<html>
-<head>
-    <title>Directory listing for /{{.Directory.FSPath}}</title>
-    <style>
-        body { font-family: -apple-system, BlinkMacSystemFont, "Segoe UI", Roboto, Helvetica, Arial, sans-serif; }
-        .container { max-width: 1200px; margin: 0 auto; padding: 20px; }
-        table { width: 100%; border-collapse: collapse; }
-        th, td { text-align: left; padding: 12px; border-bottom: 1px solid #ddd; }
-        th { background-color: #f8f9fa; }
-        tr:hover { background-color: #f5f5f5; }
-        a { color: #0366d6; text-decoration: none; }
-        a:hover { text-decoration: underline; }
-    </style>
-</head>
-<body>
-    <div class="container">
-        <h1>Directory listing for /{{.Directory.FSPath}}</h1>
-        <table>
-            <thead>
-                <tr>
-                    <th>Name</th>
-                    <th>Size</th>
-                    <th>Mode</th>
-                    <th>Modified</th>
-                </tr>
-            </thead>
-            <tbody>
-                {{if ne .Directory.LinkPath "/"}}
-                <tr>
-                    <td><a href="{{.Directory.LinkPath}}/..">..</a></td>
-                    <td>-</td>
-                    <td>-</td>
-                    <td>-</td>
-                </tr>
-                {{end}}
-                {{range .Entries}}
-                <tr>
-                    <td><a href="{{.LinkPath}}">{{.Name}}</a></td>
-                    <td>{{if .IsDir}}-{{else}}{{.Size}}{{end}}</td>
-                    <td>{{.Mode}}</td>
-                    <td>{{.ModTime}}</td>
-                </tr>
-                {{end}}
-            </tbody>
-        </table>
-    </div>
-</body>
-</html>`
+	return keys
+}
@@ -0,0 +1,75 @@
+package index
+
+import (
+	"io/fs"
+	"slices"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+func TestModifiedBetween(t *testing.T) {
+	day := func(d int) time.Time { return time.Date(2024, time.January, d, 12, 0, 0, 0, time.UTC) }
+	entries := Entries{
+		{Name: "jan01.log", ModTime: day(1)},
+		{Name: "jan10.log", ModTime: day(10)},
+		{Name: "jan20.log", ModTime: day(20)},
+		{Name: "old", ModTime: day(1), IsDir: true},
+	}
+
+	for _, tt := range []struct {
+		name     string
+		from, to time.Time
+		dirs     bool
+		want     []string
+	}{
+		{name: "closed", from: day(5), to: day(15), want: []string{"jan10.log", "old"}},
+		{name: "closed inclusive", from: day(1), to: day(10), want: []string{"jan01.log", "jan10.log", "old"}},
+		{name: "open start", to: day(10), want: []string{"jan01.log", "jan10.log", "old"}},
+		{name: "open end", from: day(5), want: []string{"jan10.log", "jan20.log", "old"}},
+		{name: "open", want: []string{"jan01.log", "jan10.log", "jan20.log", "old"}},
+		{name: "directories filtered", from: day(5), dirs: true, want: []string{"jan10.log", "jan20.log"}},
+		{name: "directories in range", to: day(5), dirs: true, want: []string{"jan01.log", "old"}},
+		{name: "empty", from: day(11), to: day(19), want: []string{"old"}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := entries.ModifiedBetween(tt.from, tt.to, tt.dirs).names(); !slices.Equal(got, tt.want) {
+				t.Errorf("ModifiedBetween() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWithLinkPrefix(t *testing.T) {
+	fsys := fstest.MapFS{
+		"dir/a b.txt": {Data: []byte("hello")},
+		"dir/broken":  {Data: []byte("missing"), Mode: fs.ModeSymlink},
+	}
+
+	entry, err := GetEntry(fsys, "dir/a b.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "/files/dir%2Fa%20b.txt"; entry.LinkPath != want {
+		t.Errorf("LinkPath = %q, want %q", entry.LinkPath, want)
+	}
+
+	entry, err = GetEntry(fsys, "dir/a b.txt", WithLinkPrefix("/mnt/data"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "/mnt/data/dir%2Fa%20b.txt"; entry.LinkPath != want {
+		t.Errorf("LinkPath = %q, want %q", entry.LinkPath, want)
+	}
+
+	entries, _ := GetEntries(fsys, "dir", nil, WithLinkPrefix("/"), WithBrokenEntries(AnnotateBroken))
+	links := map[string]string{}
+	for _, entry := range entries {
+		links[entry.Name] = entry.LinkPath
+	}
+	for name, want := range map[string]string{"a b.txt": "/dir%2Fa%20b.txt", "broken": "/dir%2Fbroken"} {
+		if links[name] != want {
+			t.Errorf("LinkPath of %q = %q, want %q", name, links[name], want)
+		}
+	}
+}
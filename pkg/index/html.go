@@ -0,0 +1,375 @@
+package index
+
+import (
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"iter"
+	"strings"
+	"time"
+)
+
+// WriteOption configures how a listing is written.
+type WriteOption func(*writeOptions)
+
+type writeOptions struct {
+	refreshSeconds int
+	nextToken      string
+	prevLink       string
+	nextLink       string
+	humanized      bool
+	now            time.Time
+}
+
+func newWriteOptions(opts []WriteOption) writeOptions {
+	var o writeOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// WithRefresh makes an HTML listing reload itself every given number of seconds.
+// A non-positive value disables reloading.
+func WithRefresh(seconds int) WriteOption {
+	return func(o *writeOptions) {
+		o.refreshSeconds = seconds
+	}
+}
+
+// WithNextToken includes the continuation token of the listing's next page in a JSON listing.
+func WithNextToken(token string) WriteOption {
+	return func(o *writeOptions) {
+		o.nextToken = token
+	}
+}
+
+// WithPageLinks includes links to the previous and next pages of a paginated listing, either of which may be empty.
+func WithPageLinks(prev, next string) WriteOption {
+	return func(o *writeOptions) {
+		o.prevLink = prev
+		o.nextLink = next
+	}
+}
+
+// WithHumanized renders the sizes and modification times of an HTML listing's entries as 1.4 MiB and 3 hours ago
+// before the given time, rather than as byte counts and RFC 3339 times.
+func WithHumanized(now time.Time) WriteOption {
+	return func(o *writeOptions) {
+		o.humanized = true
+		o.now = now
+	}
+}
+
+// validateListing returns an error if the directory or any of the entries of a listing are missing.
+func validateListing(directory *Entry, entries Entries) error {
+	if directory == nil {
+		return errors.New("invalid directory referenced")
+	}
+	for _, entry := range entries {
+		if entry == nil {
+			return errors.New("invalid entry referenced")
+		}
+	}
+	return nil
+}
+
+// Listing is the data passed to the templates that render HTML listings.
+// Its fields are stable, so custom templates can rely on them.
+type Listing struct {
+	// Directory is the listed directory.
+	Directory *Entry
+
+	// Entries are the unmasked entries of the directory, in display order.
+	// Entries.MetadataKeys returns the keys of the metadata attached to them, if any.
+	Entries Entries
+
+	// RefreshSeconds is the interval at which the page should reload itself, or 0 if it shouldn't.
+	RefreshSeconds int
+
+	// PrevLink and NextLink link to the previous and next pages of a paginated listing, or are empty if there are none.
+	PrevLink string
+	NextLink string
+
+	// Humanized is true if sizes and times should be rendered for people, like 1.4 MiB and 3 hours ago, relative to Now.
+	Humanized bool
+	Now       time.Time
+}
+
+// listingColumns is the number of columns of the default template besides the metadata columns: name, size, type,
+// mode, and modification time.
+const listingColumns = 5
+
+// Columns returns the number of columns of a listing rendered with the default template, including a column for each
+// metadata key of its entries.
+func (l Listing) Columns() int {
+	return listingColumns + len(l.Entries.MetadataKeys())
+}
+
+// defaultTemplate renders listings when no custom template is given. Its header, row, empty, and footer templates
+// render the parts of a listing, so that WriteHTMLStream can render the rows one at a time.
+var defaultTemplate = template.Must(template.New("directory").Funcs(TemplateFuncs()).Funcs(template.FuncMap{
+	"row": func(listing Listing, metadataKeys []string, entry *Entry) listingRow {
+		return listingRow{Entry: entry, Directory: listing.Directory, MetadataKeys: metadataKeys, Humanized: listing.Humanized, Now: listing.Now}
+	},
+}).Parse(htmlTemplate))
+
+// listingRow is the data of the row template of an entry of the default template.
+type listingRow struct {
+	*Entry
+	Directory    *Entry
+	MetadataKeys []string
+	Humanized    bool
+	Now          time.Time
+}
+
+// TemplateFuncs returns the helper functions available to listing templates, in addition to the built-in ones:
+//
+//   - formatSize formats a size in bytes with a binary unit, e.g. 1.5 KiB
+//   - formatTime formats a time as RFC 3339, or with the given layout if there is one
+//   - formatAge formats how long before a time, usually the listing's Now, another time is, e.g. 3 hours ago
+//   - join joins a list of strings with a separator
+func TemplateFuncs() template.FuncMap {
+	return template.FuncMap{
+		"formatSize": formatSize,
+		"formatTime": func(t time.Time, layout ...string) string {
+			if len(layout) > 0 {
+				return t.Format(layout[0])
+			}
+			return t.Format(time.RFC3339)
+		},
+		"formatAge": formatAge,
+		"join":      strings.Join,
+	}
+}
+
+// ParseTemplate parses a listing template with the TemplateFuncs available to it, and checks that it renders
+// a sample Listing without error, so that a template referencing missing fields fails when it's loaded rather than
+// on every request.
+func ParseTemplate(text string) (*template.Template, error) {
+	tmpl, err := template.New("directory").Funcs(TemplateFuncs()).Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse listing template: %w", err)
+	}
+
+	sample := &Entry{Name: "sample", FSPath: "sample", LinkPath: "/files/sample", Metadata: map[string]string{"key": "value"}}
+	if err := tmpl.Execute(io.Discard, Listing{
+		Directory: &Entry{Name: ".", FSPath: ".", LinkPath: "/files", IsDir: true},
+		Entries:   Entries{sample},
+	}); err != nil {
+		return nil, fmt.Errorf("failed to render listing template: %w", err)
+	}
+
+	return tmpl, nil
+}
+
+// formatSize formats a size in bytes with the largest binary unit that keeps it at or above 1.
+func formatSize(size int64) string {
+	const unit = 1024
+	if size < unit {
+		return fmt.Sprintf("%d B", size)
+	}
+
+	div, exp := int64(unit), 0
+	for n := size / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(size)/float64(div), "KMGTPE"[exp])
+}
+
+// WriteHTML writes an HTML listing of a directory's entries using the built-in template.
+func (e Entries) WriteHTML(w io.Writer, directory *Entry, entries Entries, opts ...WriteOption) error {
+	return e.WriteHTMLTemplate(w, directory, entries, defaultTemplate, opts...)
+}
+
+// WriteHTMLTemplate writes an HTML listing of a directory's entries by executing the given template with a Listing.
+func (e Entries) WriteHTMLTemplate(w io.Writer, directory *Entry, entries Entries, tmpl *template.Template, opts ...WriteOption) error {
+	if err := validateListing(directory, entries); err != nil {
+		return err
+	}
+	if tmpl == nil {
+		return errors.New("invalid template referenced")
+	}
+
+	o := newWriteOptions(opts)
+
+	return tmpl.Execute(w, Listing{
+		Directory:      directory,
+		Entries:        entries,
+		RefreshSeconds: o.refreshSeconds,
+		PrevLink:       o.prevLink,
+		NextLink:       o.nextLink,
+		Humanized:      o.humanized,
+		Now:            o.now,
+	})
+}
+
+// WriteHTMLStream writes an HTML listing of a directory using the built-in template, rendering each entry as it's
+// yielded rather than once they all are. Since the metadata keys of the entries aren't known until they all are, the
+// listing has no metadata columns. Writing stops at the first error yielded, which is returned, leaving the listing
+// incomplete.
+func WriteHTMLStream(w io.Writer, directory *Entry, entries iter.Seq2[*Entry, error], opts ...WriteOption) error {
+	if directory == nil {
+		return errors.New("invalid directory referenced")
+	}
+
+	o := newWriteOptions(opts)
+	listing := Listing{
+		Directory:      directory,
+		RefreshSeconds: o.refreshSeconds,
+		PrevLink:       o.prevLink,
+		NextLink:       o.nextLink,
+		Humanized:      o.humanized,
+		Now:            o.now,
+	}
+	if err := defaultTemplate.ExecuteTemplate(w, "header", listing); err != nil {
+		return err
+	}
+
+	empty := true
+	for entry, err := range entries {
+		if err != nil {
+			return err
+		}
+		if entry == nil {
+			return errors.New("invalid entry referenced")
+		}
+		empty = false
+
+		if err := defaultTemplate.ExecuteTemplate(w, "row", listingRow{Entry: entry, Directory: directory, Humanized: o.humanized, Now: o.now}); err != nil {
+			return err
+		}
+	}
+	if empty {
+		if err := defaultTemplate.ExecuteTemplate(w, "empty", listing); err != nil {
+			return err
+		}
+	}
+
+	return defaultTemplate.ExecuteTemplate(w, "footer", listing)
+}
+
+// WriteHTMLDocument writes an HTML page of a file rendered as HTML, like a Markdown file, styled like the built-in
+// listings and linking to the file itself. The body is written as is, so it must already be safe to.
+func WriteHTMLDocument(w io.Writer, entry *Entry, body template.HTML) error {
+	if entry == nil {
+		return errors.New("invalid entry referenced")
+	}
+
+	return defaultTemplate.ExecuteTemplate(w, "document", struct {
+		Entry *Entry
+		Body  template.HTML
+	}{
+		Entry: entry,
+		Body:  body,
+	})
+}
+
+const htmlTemplate = `{{define "style"}}<style>
+        body { font-family: -apple-system, BlinkMacSystemFont, "Segoe UI", Roboto, Helvetica, Arial, sans-serif; }
+        .container { max-width: 1200px; margin: 0 auto; padding: 20px; }
+        table { width: 100%; border-collapse: collapse; }
+        th, td { text-align: left; padding: 12px; border-bottom: 1px solid #ddd; }
+        th { background-color: #f8f9fa; }
+        tr:hover { background-color: #f5f5f5; }
+        a { color: #0366d6; text-decoration: none; }
+        a:hover { text-decoration: underline; }
+        pre { background-color: #f8f9fa; padding: 12px; overflow-x: auto; }
+        code { background-color: #f8f9fa; }
+        img { max-width: 100%; }
+    </style>{{end}}
+{{- define "document"}}<!DOCTYPE html>
+<html>
+<head>
+    <title>{{.Entry.DisplayPath}}</title>
+    {{template "style"}}
+</head>
+<body>
+    <div class="container">
+        <p><a href="{{.Entry.LinkPath}}">{{.Entry.DisplayPath}}</a></p>
+        {{.Body}}
+    </div>
+</body>
+</html>{{end}}
+{{- define "header"}}<!DOCTYPE html>
+<html>
+<head>
+    {{if gt .RefreshSeconds 0}}<meta http-equiv="refresh" content="{{.RefreshSeconds}}">{{end}}
+    <title>Directory listing for {{.Directory.DisplayPath}}</title>
+    {{template "style"}}
+</head>
+<body>
+    <div class="container">
+        <h1>Directory listing for {{.Directory.DisplayPath}}</h1>
+        <table>
+            <thead>
+                <tr>
+                    <th>Name</th>
+                    <th>Size</th>
+                    <th>Type</th>
+                    <th>Mode</th>
+                    <th>Modified</th>
+                    {{range $.Entries.MetadataKeys}}
+                    <th>{{.}}</th>
+                    {{end}}
+                </tr>
+            </thead>
+            <tbody>
+                {{if not .Directory.IsRoot}}
+                <tr>
+                    <td><a href="{{.Directory.LinkPath}}/..">..</a></td>
+                    <td>-</td>
+                    <td>-</td>
+                    <td>-</td>
+                    <td>-</td>
+                    {{range $.Entries.MetadataKeys}}
+                    <td>-</td>
+                    {{end}}
+                </tr>
+                {{end}}
+{{end}}
+{{define "empty"}}
+                <tr>
+                    <td colspan="{{.Columns}}">No entries</td>
+                </tr>
+{{end}}
+{{define "row"}}
+                <tr>
+                    <td>{{if .Loop}}{{.RelPath .Directory}} (loop){{else if .Error}}{{.RelPath .Directory}} ({{.Error}}){{else}}<a href="{{.LinkPath}}">{{.RelPath .Directory}}</a>{{end}}</td>
+                    {{if .Humanized}}
+                    <td title="{{if .IsDir}}{{with .TotalSize}}{{.}}{{end}}{{else}}{{.Size}}{{end}}">{{or .HumanSize "-"}}</td>
+                    {{else}}
+                    <td>{{if .IsDir}}{{with .TotalSize}}{{.}}{{else}}-{{end}}{{else}}{{.Size}}{{end}}</td>
+                    {{end}}
+                    <td>{{if .IsDir}}-{{else}}{{.MIMEType}}{{end}}</td>
+                    <td>{{.Mode}}</td>
+                    {{if .Humanized}}
+                    <td title="{{.ModTime.Format "2006-01-02T15:04:05Z07:00"}}">{{.Age .Now}}</td>
+                    {{else}}
+                    <td>{{.ModTime.Format "2006-01-02T15:04:05Z07:00"}}</td>
+                    {{end}}
+                    {{$metadata := .Metadata}}
+                    {{range .MetadataKeys}}
+                    <td>{{index $metadata .}}</td>
+                    {{end}}
+                </tr>
+{{end}}
+{{define "footer"}}
+            </tbody>
+        </table>
+        {{if or .PrevLink .NextLink}}
+        <p>
+            {{if .PrevLink}}<a href="{{.PrevLink}}" rel="prev">Previous</a>{{end}}
+            {{if .NextLink}}<a href="{{.NextLink}}" rel="next">Next</a>{{end}}
+        </p>
+        {{end}}
+    </div>
+</body>
+</html>{{end}}
+{{- template "header" .}}
+                {{- if not .Entries}}{{template "empty" .}}{{end}}
+                {{- $keys := .Entries.MetadataKeys}}
+                {{- range .Entries}}{{template "row" (row $ $keys .)}}{{end}}
+                {{- template "footer" .}}`
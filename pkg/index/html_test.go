@@ -0,0 +1,78 @@
+package index
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+// rowCells returns the number of header and data cells of each row of an HTML table.
+func rowCells(html string) []int {
+	var cells []int
+	for _, row := range strings.Split(html, "<tr>")[1:] {
+		row, _, _ = strings.Cut(row, "</tr>")
+		n := strings.Count(row, "<th") + strings.Count(row, "<td")
+		if i := strings.Index(row, `colspan="`); i >= 0 {
+			var span int
+			for _, c := range row[i+len(`colspan="`):] {
+				if c < '0' || c > '9' {
+					break
+				}
+				span = span*10 + int(c-'0')
+			}
+			n += span - 1
+		}
+		cells = append(cells, n)
+	}
+	return cells
+}
+
+func TestWriteHTMLColumns(t *testing.T) {
+	dir := &Entry{Name: "dir", FSPath: "dir", LinkPath: "/files/dir", IsDir: true}
+	for _, tt := range []struct {
+		name    string
+		entries Entries
+	}{
+		{name: "entries", entries: Entries{{Name: "a.txt", FSPath: "dir/a.txt", LinkPath: "/files/dir/a.txt"}}},
+		{name: "metadata", entries: Entries{
+			{Name: "a.txt", FSPath: "dir/a.txt", LinkPath: "/files/dir/a.txt", Metadata: map[string]string{"owner": "a"}},
+			{Name: "b.txt", FSPath: "dir/b.txt", LinkPath: "/files/dir/b.txt", Metadata: map[string]string{"team": "b"}},
+		}},
+		{name: "no entries"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := tt.entries.WriteHTML(&buf, dir, tt.entries); err != nil {
+				t.Fatal(err)
+			}
+
+			cells := rowCells(buf.String())
+			if len(cells) < 2 {
+				t.Fatalf("listing has %d rows, want a header and a parent row at least", len(cells))
+			}
+			for i, n := range cells {
+				if n != cells[0] {
+					t.Errorf("row %d has %d cells, the header has %d", i, n, cells[0])
+				}
+			}
+		})
+	}
+}
+
+func TestWriteHTMLStreamEmpty(t *testing.T) {
+	root := &Entry{Name: ".", FSPath: ".", LinkPath: "/files", IsDir: true}
+
+	var buf bytes.Buffer
+	if err := WriteHTMLStream(&buf, root, func(func(*Entry, error) bool) {}); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), "No entries") {
+		t.Error("empty streamed listing doesn't say it has no entries")
+	}
+	if strings.Contains(buf.String(), `href="/files/.."`) {
+		t.Error("listing of the root links to its parent")
+	}
+	if cells := rowCells(buf.String()); len(cells) != 2 || cells[1] != cells[0] {
+		t.Errorf("empty streamed listing has rows of %v cells, want a header and a row as wide", cells)
+	}
+}
@@ -0,0 +1,61 @@
+package index
+
+import (
+	"fmt"
+	"time"
+)
+
+// HumanSize returns the size of a file, or the total size of a directory if it was computed, with a binary unit, e.g.
+// 1.4 MiB, or an empty string for a directory without a total size.
+func (e *Entry) HumanSize() string {
+	if e.IsDir {
+		if e.TotalSize == nil {
+			return ""
+		}
+		return formatSize(*e.TotalSize)
+	}
+	return formatSize(e.Size)
+}
+
+// Age returns how long before now the entry was modified, e.g. 3 hours ago.
+func (e *Entry) Age(now time.Time) string {
+	return formatAge(e.ModTime, now)
+}
+
+// formatAge formats how long before now a time is in its largest whole unit, e.g. 3 hours ago, or how long after now it
+// is for times in the future.
+func formatAge(t, now time.Time) string {
+	d := now.Sub(t)
+	suffix := "ago"
+	if d < 0 {
+		d, suffix = -d, "from now"
+	}
+
+	const (
+		day   = 24 * time.Hour
+		month = 30 * day
+		year  = 365 * day
+	)
+	var (
+		n    int64
+		unit string
+	)
+	switch {
+	case d < time.Minute:
+		return "just now"
+	case d < time.Hour:
+		n, unit = int64(d/time.Minute), "minute"
+	case d < day:
+		n, unit = int64(d/time.Hour), "hour"
+	case d < month:
+		n, unit = int64(d/day), "day"
+	case d < year:
+		n, unit = int64(d/month), "month"
+	default:
+		n, unit = int64(d/year), "year"
+	}
+	if n != 1 {
+		unit += "s"
+	}
+	return fmt.Sprintf("%d %s %s", n, unit, suffix)
+}
@@ -0,0 +1,81 @@
+package index
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFormatAge(t *testing.T) {
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	for _, tt := range []struct {
+		ago  time.Duration
+		want string
+	}{
+		{ago: 0, want: "just now"},
+		{ago: 59 * time.Second, want: "just now"},
+		{ago: time.Minute, want: "1 minute ago"},
+		{ago: 3*time.Hour + 59*time.Minute, want: "3 hours ago"},
+		{ago: 24 * time.Hour, want: "1 day ago"},
+		{ago: 45 * 24 * time.Hour, want: "1 month ago"},
+		{ago: 800 * 24 * time.Hour, want: "2 years ago"},
+		{ago: -2 * time.Hour, want: "2 hours from now"},
+	} {
+		if got := formatAge(now.Add(-tt.ago), now); got != tt.want {
+			t.Errorf("formatAge(%s before) = %q, want %q", tt.ago, got, tt.want)
+		}
+	}
+}
+
+func TestHumanSize(t *testing.T) {
+	total := int64(3 << 20)
+	for _, tt := range []struct {
+		entry *Entry
+		want  string
+	}{
+		{entry: &Entry{Size: 512}, want: "512 B"},
+		{entry: &Entry{Size: 1468006}, want: "1.4 MiB"},
+		{entry: &Entry{IsDir: true, Size: 4096}},
+		{entry: &Entry{IsDir: true, TotalSize: &total}, want: "3.0 MiB"},
+	} {
+		if got := tt.entry.HumanSize(); got != tt.want {
+			t.Errorf("HumanSize() of %+v = %q, want %q", tt.entry, got, tt.want)
+		}
+	}
+}
+
+func TestWriteHTMLHumanized(t *testing.T) {
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	modTime := now.Add(-3 * time.Hour)
+	directory := &Entry{Name: "dir", FSPath: "dir", LinkPath: "/files/dir/", IsDir: true, ModTime: modTime}
+	entries := Entries{{Name: "a.bin", FSPath: "dir/a.bin", LinkPath: "/files/dir/a.bin", Size: 1468006, ModTime: modTime}}
+
+	for name, write := range map[string]func(*bytes.Buffer, ...WriteOption) error{
+		"WriteHTML": func(w *bytes.Buffer, opts ...WriteOption) error {
+			return entries.WriteHTML(w, directory, entries, opts...)
+		},
+		"WriteHTMLStream": func(w *bytes.Buffer, opts ...WriteOption) error {
+			return WriteHTMLStream(w, directory, seq(entries, nil), opts...)
+		},
+	} {
+		var out bytes.Buffer
+		if err := write(&out, WithHumanized(now)); err != nil {
+			t.Fatal(err)
+		}
+		// The exact values are kept in the titles
+		for _, want := range []string{`<td title="1468006">1.4 MiB</td>`, `<td title="2024-06-01T09:00:00Z">3 hours ago</td>`} {
+			if !strings.Contains(out.String(), want) {
+				t.Errorf("%s() humanized = %s, want it to contain %s", name, out.String(), want)
+			}
+		}
+
+		out.Reset()
+		if err := write(&out); err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(out.String(), "<td>1468006</td>") || strings.Contains(out.String(), "ago") {
+			t.Errorf("%s() = %s, want exact sizes and times", name, out.String())
+		}
+	}
+}
@@ -0,0 +1,66 @@
+package index
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"iter"
+)
+
+// iterBatchSize is the number of children IterEntries reads from a directory at a time.
+const iterBatchSize = 1024
+
+// IterEntries returns an iterator over the entries of the given directory, like GetEntries, that reads the directory a
+// batch of children at a time instead of all at once, so that listing a huge directory takes memory for a batch rather
+// than for every entry. Entries are yielded in the order the filesystem lists them, which isn't necessarily by name.
+//
+// An error reading the directory, or of a child that can't be stat'ed with FailBroken, is yielded with a nil entry and
+// ends the iteration. Other children that can't be stat'ed are yielded with a *ListingError of their error and the
+// iteration goes on: with a nil entry if they're skipped, or with the entry annotating them if they're annotated.
+func IterEntries(fsys fs.FS, dir string, mask Mask, opts ...GetEntriesOption) iter.Seq2[*Entry, error] {
+	o := newGetEntriesOptions(opts)
+
+	return func(yield func(*Entry, error) bool) {
+		f, err := fsys.Open(dir)
+		if err != nil {
+			yield(nil, err)
+			return
+		}
+		defer f.Close()
+
+		d, ok := f.(fs.ReadDirFile)
+		if !ok {
+			yield(nil, &fs.PathError{Op: "readdir", Path: dir, Err: errors.New("not implemented")})
+			return
+		}
+
+		for {
+			children, err := d.ReadDir(iterBatchSize)
+			for _, child := range getChildren(fsys, dir, children, mask, o) {
+				entry, err := child.entry, child.err
+				switch {
+				case err != nil && o.broken == FailBroken:
+					yield(nil, fmt.Errorf("failed to get entry: %w", err))
+					return
+				case entry == nil && err == nil:
+					continue
+				}
+				if err != nil {
+					err = &ListingError{Dir: dir, Errs: []error{err}}
+				}
+				if !yield(entry, err) {
+					return
+				}
+			}
+
+			if errors.Is(err, io.EOF) || (err == nil && len(children) == 0) {
+				return
+			}
+			if err != nil {
+				yield(nil, err)
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,144 @@
+package index
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"iter"
+	"slices"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+func TestIterEntries(t *testing.T) {
+	// More children than are read at a time, so the directory is read in several batches
+	fsys := fstest.MapFS{"dir/x.key": {}}
+	var want []string
+	for i := range iterBatchSize + 5 {
+		name := fmt.Sprintf("f%04d.txt", i)
+		fsys["dir/"+name] = &fstest.MapFile{Data: []byte(name)}
+		want = append(want, name)
+	}
+
+	var names []string
+	for entry, err := range IterEntries(fsys, "dir", &recordingMask{}) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, entry.Name)
+	}
+	slices.Sort(names)
+	if !slices.Equal(names, want) {
+		t.Errorf("IterEntries() yielded %d entries, want the %d unmasked ones", len(names), len(want))
+	}
+
+	entries, err := GetEntries(fsys, "dir", &recordingMask{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := entries.names(); !slices.Equal(got, want) {
+		t.Errorf("GetEntries() = %d entries, want the %d IterEntries yielded", len(got), len(want))
+	}
+
+	// Iteration stops when the caller stops
+	var yielded int
+	for range IterEntries(fsys, "dir", nil) {
+		if yielded++; yielded == 3 {
+			break
+		}
+	}
+	if yielded != 3 {
+		t.Errorf("yielded %d entries after breaking on the 3rd", yielded)
+	}
+
+	var errs []error
+	for entry, err := range IterEntries(fsys, "missing", nil) {
+		if entry != nil {
+			t.Errorf("IterEntries() of a missing directory yielded %q", entry.Name)
+		}
+		errs = append(errs, err)
+	}
+	if len(errs) != 1 || !errors.Is(errs[0], fs.ErrNotExist) {
+		t.Errorf("IterEntries() of a missing directory yielded %v, want one %v", errs, fs.ErrNotExist)
+	}
+}
+
+// seq yields the given entries, and then the error if there is one.
+func seq(entries Entries, err error) iter.Seq2[*Entry, error] {
+	return func(yield func(*Entry, error) bool) {
+		for _, entry := range entries {
+			if !yield(entry, nil) {
+				return
+			}
+		}
+		if err != nil {
+			yield(nil, err)
+		}
+	}
+}
+
+func TestWriteStream(t *testing.T) {
+	modTime := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	directory := &Entry{Name: "dir", FSPath: "dir", LinkPath: "/files/dir/", IsDir: true, ModTime: modTime}
+	entries := Entries{
+		{Name: "a.txt", FSPath: "dir/a.txt", LinkPath: "/files/dir/a.txt", Size: 5, ModTime: modTime},
+		{Name: "sub", FSPath: "dir/sub", LinkPath: "/files/dir/sub/", IsDir: true, ModTime: modTime},
+	}
+
+	for _, tt := range []struct {
+		name    string
+		entries Entries
+		opts    []WriteOption
+	}{
+		{name: "entries", entries: entries},
+		{name: "no entries"},
+		{name: "options", entries: entries, opts: []WriteOption{WithRefresh(5), WithNextToken("next"), WithPageLinks("?page=1", "?page=3")}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			// Streams render listings just like the whole listings are
+			var want, got bytes.Buffer
+			if err := tt.entries.WriteJSON(&want, directory, tt.entries, tt.opts...); err != nil {
+				t.Fatal(err)
+			}
+			if err := WriteJSONStream(&got, directory, seq(tt.entries, nil), tt.opts...); err != nil {
+				t.Fatal(err)
+			}
+			if got.String() != want.String() {
+				t.Errorf("WriteJSONStream() = %s, want %s", got.String(), want.String())
+			}
+
+			want.Reset()
+			got.Reset()
+			if err := tt.entries.WriteHTML(&want, directory, tt.entries, tt.opts...); err != nil {
+				t.Fatal(err)
+			}
+			if err := WriteHTMLStream(&got, directory, seq(tt.entries, nil), tt.opts...); err != nil {
+				t.Fatal(err)
+			}
+			// The template definitions leave blank lines before the document, which don't matter
+			if strings.TrimSpace(got.String()) != strings.TrimSpace(want.String()) {
+				t.Errorf("WriteHTMLStream() = %s, want %s", got.String(), want.String())
+			}
+		})
+	}
+
+	// Listings stop at the first error, without being completed
+	failed := errors.New("failed")
+	var out bytes.Buffer
+	if err := WriteJSONStream(&out, directory, seq(entries[:1], failed)); !errors.Is(err, failed) {
+		t.Errorf("WriteJSONStream() = %v, want %v", err, failed)
+	}
+	if !strings.Contains(out.String(), "a.txt") || strings.HasSuffix(out.String(), "}\n") {
+		t.Errorf("WriteJSONStream() wrote %s, want an incomplete listing", out.String())
+	}
+	out.Reset()
+	if err := WriteHTMLStream(&out, directory, seq(entries[:1], failed)); !errors.Is(err, failed) {
+		t.Errorf("WriteHTMLStream() = %v, want %v", err, failed)
+	}
+	if strings.Contains(out.String(), "</html>") {
+		t.Errorf("WriteHTMLStream() wrote %s, want an incomplete listing", out.String())
+	}
+}
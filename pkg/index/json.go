@@ -0,0 +1,162 @@
+package index
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"iter"
+	"strings"
+)
+
+// MarshalJSON encodes the entry with its mode rendered as a string, e.g. "drwxr-xr-x", rather than an opaque integer.
+func (e *Entry) MarshalJSON() ([]byte, error) {
+	type entry Entry
+	return json.Marshal(struct {
+		*entry
+		Mode string `json:"mode"`
+	}{
+		entry: (*entry)(e),
+		Mode:  e.Mode.String(),
+	})
+}
+
+// UnmarshalJSON decodes an entry encoded by MarshalJSON, parsing its mode back from its string form.
+func (e *Entry) UnmarshalJSON(data []byte) error {
+	type entry Entry
+	decoded := struct {
+		*entry
+		Mode string `json:"mode"`
+	}{
+		entry: (*entry)(e),
+	}
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		return err
+	}
+
+	mode, err := ParseMode(decoded.Mode)
+	if err != nil {
+		return err
+	}
+	e.Mode = mode
+	return nil
+}
+
+// modeTypes are the letters fs.FileMode.String renders the type and special bits as, from the most significant bit.
+const modeTypes = "dalTLDpSugct?"
+
+// ParseMode parses a file mode rendered by fs.FileMode.String, like "drwxr-xr-x", back into the mode.
+func ParseMode(s string) (fs.FileMode, error) {
+	const perms = "rwxrwxrwx"
+	if len(s) < len(perms)+1 {
+		return 0, fmt.Errorf("invalid file mode %q", s)
+	}
+
+	var mode fs.FileMode
+	if types := s[:len(s)-len(perms)]; types != "-" {
+		for _, c := range types {
+			i := strings.IndexRune(modeTypes, c)
+			if i < 0 {
+				return 0, fmt.Errorf("invalid file mode %q", s)
+			}
+			mode |= 1 << uint(32-1-i)
+		}
+	}
+	for i, c := range s[len(s)-len(perms):] {
+		switch c {
+		case rune(perms[i]):
+			mode |= 1 << uint(len(perms)-1-i)
+		case '-':
+		default:
+			return 0, fmt.Errorf("invalid file mode %q", s)
+		}
+	}
+	return mode, nil
+}
+
+// WriteJSON writes a JSON listing of a directory's entries, the machine-readable counterpart of WriteHTML.
+func (e Entries) WriteJSON(w io.Writer, directory *Entry, entries Entries, opts ...WriteOption) error {
+	if err := validateListing(directory, entries); err != nil {
+		return err
+	}
+
+	o := newWriteOptions(opts)
+	if entries == nil {
+		// Always encode an array so clients don't have to handle null
+		entries = Entries{}
+	}
+
+	return json.NewEncoder(w).Encode(struct {
+		Directory *Entry  `json:"directory"`
+		Entries   Entries `json:"entries"`
+		NextToken string  `json:"next_token,omitempty"`
+		PrevLink  string  `json:"prev_link,omitempty"`
+		NextLink  string  `json:"next_link,omitempty"`
+	}{
+		Directory: directory,
+		Entries:   entries,
+		NextToken: o.nextToken,
+		PrevLink:  o.prevLink,
+		NextLink:  o.nextLink,
+	})
+}
+
+// WriteJSONStream writes a JSON listing of a directory, like WriteJSON, encoding each entry as it's yielded rather
+// than once they all are. Writing stops at the first error yielded, which is returned, leaving the listing incomplete
+// and invalid JSON, so that clients can't mistake it for a complete one.
+func WriteJSONStream(w io.Writer, directory *Entry, entries iter.Seq2[*Entry, error], opts ...WriteOption) error {
+	if directory == nil {
+		return errors.New("invalid directory referenced")
+	}
+	o := newWriteOptions(opts)
+
+	bw := bufio.NewWriter(w)
+	data, err := json.Marshal(directory)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(bw, `{"directory":%s,"entries":[`, data)
+
+	first := true
+	for entry, err := range entries {
+		if err != nil {
+			_ = bw.Flush()
+			return err
+		}
+		if entry == nil {
+			_ = bw.Flush()
+			return errors.New("invalid entry referenced")
+		}
+
+		if data, err = json.Marshal(entry); err != nil {
+			_ = bw.Flush()
+			return err
+		}
+		if !first {
+			bw.WriteByte(',')
+		}
+		first = false
+		bw.Write(data)
+	}
+	bw.WriteByte(']')
+
+	for _, field := range []struct{ name, value string }{
+		{"next_token", o.nextToken},
+		{"prev_link", o.prevLink},
+		{"next_link", o.nextLink},
+	} {
+		if field.value == "" {
+			continue
+		}
+		value, err := json.Marshal(field.value)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(bw, `,%q:%s`, field.name, value)
+	}
+	bw.WriteString("}\n")
+
+	return bw.Flush()
+}
@@ -0,0 +1,27 @@
+package index
+
+import (
+	"io/fs"
+	"os"
+	"path"
+)
+
+// isLoop returns true if the directory at target is dir or one of its ancestors,
+// meaning a symlink to target from within dir leads back up the tree it was reached from.
+// Directories are compared by file identity, so loops can only be detected on filesystems backed by the OS.
+func isLoop(fsys fs.FS, dir, target string) bool {
+	targetInfo, err := fs.Stat(fsys, target)
+	if err != nil || !targetInfo.IsDir() {
+		return false
+	}
+
+	for ancestor := dir; ; ancestor = path.Dir(ancestor) {
+		info, err := fs.Stat(fsys, ancestor)
+		if err == nil && os.SameFile(info, targetInfo) {
+			return true
+		}
+		if ancestor == "." {
+			return false
+		}
+	}
+}
@@ -0,0 +1,69 @@
+package index
+
+import (
+	"bytes"
+	"maps"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// unmasked masks nothing.
+type unmasked struct{}
+
+func (unmasked) Masked(*Entry) bool { return false }
+
+func TestGetEntriesLoops(t *testing.T) {
+	root := t.TempDir()
+	for _, dir := range []string{"dir/sub", "other"} {
+		if err := os.MkdirAll(filepath.Join(root, dir), 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(root, "dir", "a.txt"), nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	for name, target := range map[string]string{
+		"up":      "..",
+		"self":    ".",
+		"sibling": "../other",
+		"child":   "sub",
+		"file":    "a.txt",
+	} {
+		if err := os.Symlink(target, filepath.Join(root, "dir", name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	fsys := os.DirFS(root)
+	entries, err := GetEntries(fsys, "dir", unmasked{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	loops := map[string]bool{}
+	for _, e := range entries {
+		loops[e.Name] = e.Loop
+	}
+	want := map[string]bool{"a.txt": false, "sub": false, "up": true, "self": true, "sibling": false, "child": false, "file": false}
+	if !maps.Equal(loops, want) {
+		t.Errorf("loops = %v, want %v", loops, want)
+	}
+
+	// Loops can't be navigated into from HTML listings
+	directory, err := GetEntry(fsys, "dir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := entries.WriteHTML(&buf, directory, entries); err != nil {
+		t.Fatal(err)
+	}
+	html := buf.String()
+	if !strings.Contains(html, "up (loop)") || strings.Contains(html, ">up</a>") {
+		t.Errorf("HTML listing links to the loop:\n%s", html)
+	}
+	if !strings.Contains(html, ">sibling</a>") {
+		t.Errorf("HTML listing doesn't link to a symlink that isn't a loop:\n%s", html)
+	}
+}
@@ -0,0 +1,64 @@
+package index
+
+import (
+	"bytes"
+	"encoding/json"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// describer is a MetadataProvider describing files by their names, and tagging the ones named like logs.
+type describer struct{}
+
+func (describer) Enrich(entry *Entry) map[string]string {
+	if entry.IsDir {
+		return nil
+	}
+	metadata := map[string]string{"description": "all about " + entry.Name}
+	if strings.HasSuffix(entry.Name, ".log") {
+		metadata["tag"] = "log"
+	}
+	return metadata
+}
+
+func TestEnrich(t *testing.T) {
+	entries := Entries{
+		{Name: "a.txt", FSPath: "a.txt", LinkPath: "/a.txt"},
+		{Name: "b.log", FSPath: "b.log", LinkPath: "/b.log"},
+		{Name: "dir", FSPath: "dir", LinkPath: "/dir", IsDir: true},
+	}
+	entries.Enrich(describer{})
+
+	if keys := entries.MetadataKeys(); !slices.Equal(keys, []string{"description", "tag"}) {
+		t.Errorf("MetadataKeys() = %v, want [description tag]", keys)
+	}
+	if entries[2].Metadata != nil {
+		t.Errorf("metadata of a directory = %v, want none", entries[2].Metadata)
+	}
+
+	data, err := json.Marshal(entries[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded struct {
+		Metadata map[string]string
+	}
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if got := decoded.Metadata["description"]; got != "all about a.txt" {
+		t.Errorf("description in %s = %q, want %q", data, got, "all about a.txt")
+	}
+
+	// Every metadata key gets a column, which entries without the key leave blank
+	var buf bytes.Buffer
+	if err := entries.WriteHTML(&buf, &Entry{Name: ".", FSPath: ".", LinkPath: "/", IsDir: true}, entries); err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []string{"<th>description</th>", "<th>tag</th>", "<td>all about b.log</td>", "<td>log</td>"} {
+		if !strings.Contains(buf.String(), s) {
+			t.Errorf("HTML listing doesn't contain %q", s)
+		}
+	}
+}
@@ -0,0 +1,64 @@
+package index
+
+import (
+	"mime"
+	"path"
+	"strings"
+)
+
+// extraTypes are the content types of common extensions that Go's built-in table, and the system's mime.types files
+// where there are any, leave out.
+var extraTypes = map[string]string{
+	".c":        "text/x-c; charset=utf-8",
+	".conf":     "text/plain; charset=utf-8",
+	".csv":      "text/csv; charset=utf-8",
+	".diff":     "text/x-diff; charset=utf-8",
+	".go":       "text/x-go; charset=utf-8",
+	".gz":       "application/gzip",
+	".h":        "text/x-c; charset=utf-8",
+	".ini":      "text/plain; charset=utf-8",
+	".jsonl":    "application/jsonl",
+	".log":      "text/plain; charset=utf-8",
+	".markdown": "text/markdown; charset=utf-8",
+	".md":       "text/markdown; charset=utf-8",
+	".ndjson":   "application/x-ndjson",
+	".patch":    "text/x-diff; charset=utf-8",
+	".py":       "text/x-python; charset=utf-8",
+	".rs":       "text/x-rust; charset=utf-8",
+	".sh":       "application/x-sh",
+	".tar":      "application/x-tar",
+	".tgz":      "application/gzip",
+	".toml":     "application/toml",
+	".txt":      "text/plain; charset=utf-8",
+	".yaml":     "application/yaml",
+	".yml":      "application/yaml",
+	".zip":      "application/zip",
+}
+
+// TypeByExtension returns the content type of a file by the extension of its name, like mime.TypeByExtension but
+// knowing more extensions, or an empty string if the extension is unknown.
+func TypeByExtension(name string) string {
+	ext := strings.ToLower(path.Ext(name))
+	if ext == "" {
+		return ""
+	}
+	if typ := mime.TypeByExtension(ext); typ != "" {
+		return typ
+	}
+	return extraTypes[ext]
+}
+
+// SniffMIMEType sets the content type of a file whose extension doesn't tell it to the one sniffed from its contents by
+// ContentType.
+func (e *Entry) SniffMIMEType() error {
+	if e.MIMEType != "" || e.IsDir {
+		return nil
+	}
+
+	contentType, err := e.ContentType()
+	if err != nil {
+		return err
+	}
+	e.MIMEType = contentType
+	return nil
+}
@@ -0,0 +1,25 @@
+//go:build !unix
+
+package index
+
+import "io/fs"
+
+// ownerOf returns nil, since files don't have numeric owners on this platform.
+func ownerOf(fs.FileInfo) *Owner {
+	return nil
+}
+
+// fileIDOf returns zeros, since files don't have inode numbers on this platform.
+func fileIDOf(fs.FileInfo) (inode, links uint64) {
+	return 0, 0
+}
+
+// userName returns an empty string, since files don't have numeric owners to name on this platform.
+func userName(uint32) string {
+	return ""
+}
+
+// groupName returns an empty string, since files don't have numeric owners to name on this platform.
+func groupName(uint32) string {
+	return ""
+}
@@ -0,0 +1,62 @@
+//go:build unix
+
+package index
+
+import (
+	"io/fs"
+	"os/user"
+	"strconv"
+	"sync"
+	"syscall"
+)
+
+// ownerOf returns the owner of a file, if its info comes from the operating system.
+func ownerOf(info fs.FileInfo) *Owner {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return nil
+	}
+	return &Owner{UID: st.Uid, GID: st.Gid}
+}
+
+// fileIDOf returns the inode number and hard link count of a file, or zeros if its info doesn't come from the operating
+// system.
+func fileIDOf(info fs.FileInfo) (inode, links uint64) {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, 0
+	}
+	return uint64(st.Ino), uint64(st.Nlink)
+}
+
+// userNames and groupNames remember the names of IDs, or the empty string for IDs without one, since looking them up
+// can read the whole user or group database.
+var userNames, groupNames sync.Map
+
+// userName returns the name of the user with the given ID, or an empty string if it has none.
+func userName(uid uint32) string {
+	if name, ok := userNames.Load(uid); ok {
+		return name.(string)
+	}
+
+	var name string
+	if u, err := user.LookupId(strconv.FormatUint(uint64(uid), 10)); err == nil {
+		name = u.Username
+	}
+	userNames.Store(uid, name)
+	return name
+}
+
+// groupName returns the name of the group with the given ID, or an empty string if it has none.
+func groupName(gid uint32) string {
+	if name, ok := groupNames.Load(gid); ok {
+		return name.(string)
+	}
+
+	var name string
+	if g, err := user.LookupGroupId(strconv.FormatUint(uint64(gid), 10)); err == nil {
+		name = g.Name
+	}
+	groupNames.Store(gid, name)
+	return name
+}
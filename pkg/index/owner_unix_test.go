@@ -0,0 +1,45 @@
+//go:build unix
+
+package index
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestAddHostInfo(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "a.txt"), []byte("a"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	entry, err := GetEntry(os.DirFS(dir), "a.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry.Owner == nil || entry.Inode == 0 || entry.Links != 1 {
+		t.Fatalf("GetEntry didn't read the owner, inode, and link count: %+v", entry)
+	}
+
+	data, err := json.Marshal(entry)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), `"host"`) || strings.Contains(string(data), `"uid"`) {
+		t.Errorf("entry without host info reports it: %s", data)
+	}
+
+	entry.AddHostInfo()
+	if entry.HostInfo == nil || entry.HostInfo.UID != uint32(os.Getuid()) || entry.HostInfo.Inode != entry.Inode {
+		t.Fatalf("AddHostInfo() = %+v, want the entry's owner and inode", entry.HostInfo)
+	}
+	if data, err = json.Marshal(entry); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), `"nlink":1`) {
+		t.Errorf("entry with host info doesn't report it: %s", data)
+	}
+}
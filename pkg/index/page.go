@@ -0,0 +1,65 @@
+package index
+
+import (
+	"encoding/base64"
+	"fmt"
+	"sort"
+)
+
+// EncodeToken returns an opaque continuation token that resumes a path-sorted listing after the given entry.
+func EncodeToken(entry *Entry) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(entry.FSPath))
+}
+
+// DecodeToken returns the path of the last-seen entry encoded in a continuation token.
+func DecodeToken(token string) (string, error) {
+	name, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return "", fmt.Errorf("malformed continuation token: %w", err)
+	}
+
+	return string(name), nil
+}
+
+// Page returns up to limit entries following the position encoded in the given continuation token,
+// along with the token of the next page. The next token is empty when there are no more entries.
+// The entries must be sorted by path, which for the children of a single directory is the same as by name. An empty token starts at the first entry and a non-positive limit returns all remaining entries.
+//
+// Tokens encode the path of the last-seen entry rather than an offset, so entries added or removed between requests
+// never cause the remaining entries to be skipped or repeated.
+func (e Entries) Page(token string, limit int) (Entries, string, error) {
+	var after string
+	if token != "" {
+		var err error
+		if after, err = DecodeToken(token); err != nil {
+			return nil, "", err
+		}
+	}
+
+	start := 0
+	if token != "" {
+		start = sort.Search(len(e), func(i int) bool {
+			return e[i].FSPath > after
+		})
+	}
+
+	page := e[start:]
+	if limit <= 0 || len(page) <= limit {
+		return page, "", nil
+	}
+
+	page = page[:limit]
+	return page, EncodeToken(page[len(page)-1]), nil
+}
+
+// Offset returns the given 1-based page of entries, with up to perPage entries per page, and whether there are more
+// entries after it. A page past the end of the entries is empty.
+func (e Entries) Offset(page, perPage int) (Entries, bool) {
+	start := (page - 1) * perPage
+	if page < 1 || perPage < 1 || start >= len(e) {
+		return Entries{}, false
+	}
+
+	end := min(start+perPage, len(e))
+	return e[start:end], end < len(e)
+}
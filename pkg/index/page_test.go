@@ -0,0 +1,67 @@
+package index
+
+import (
+	"slices"
+	"testing"
+)
+
+// named returns entries with the given names.
+func named(names ...string) Entries {
+	var entries Entries
+	for _, name := range names {
+		entries = append(entries, &Entry{Name: name, FSPath: name})
+	}
+	return entries
+}
+
+func (e Entries) names() []string {
+	var names []string
+	for _, entry := range e {
+		names = append(names, entry.Name)
+	}
+	return names
+}
+
+func TestPage(t *testing.T) {
+	page, next, err := named("a", "c", "e", "g").Page("", 2)
+	if err != nil || !slices.Equal(page.names(), []string{"a", "c"}) || next == "" {
+		t.Fatalf("Page(\"\", 2) = %v, %q, %v, want the first 2 entries and a next token", page.names(), next, err)
+	}
+
+	// Entries added before and after the last one seen, or removing it, neither skip nor repeat the remaining entries
+	var rest []string
+	for entries, token := named("a", "b", "d", "e", "g"), next; token != ""; {
+		if page, token, err = entries.Page(token, 2); err != nil {
+			t.Fatal(err)
+		}
+		rest = append(rest, page.names()...)
+	}
+	if want := []string{"d", "e", "g"}; !slices.Equal(rest, want) {
+		t.Errorf("pages after the first = %v, want %v", rest, want)
+	}
+
+	for _, tt := range []struct {
+		name  string
+		limit int
+		want  []string
+		next  bool
+	}{
+		{name: "no limit", limit: 0, want: []string{"a", "c", "e", "g"}},
+		{name: "limit of every entry", limit: 4, want: []string{"a", "c", "e", "g"}},
+		{name: "limit", limit: 3, want: []string{"a", "c", "e"}, next: true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			page, next, err := named("a", "c", "e", "g").Page("", tt.limit)
+			if err != nil || !slices.Equal(page.names(), tt.want) || (next != "") != tt.next {
+				t.Errorf("Page(\"\", %d) = %v, %q, %v, want %v", tt.limit, page.names(), next, err, tt.want)
+			}
+		})
+	}
+
+	if page, _, err := named("a").Page(EncodeToken(&Entry{Name: "z", FSPath: "z"}), 1); err != nil || len(page) != 0 {
+		t.Errorf("Page() past the last entry = %v, %v, want no entries", page.names(), err)
+	}
+	if _, _, err := named("a").Page("!!!", 1); err == nil {
+		t.Error("Page() accepted a malformed token")
+	}
+}
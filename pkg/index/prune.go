@@ -0,0 +1,33 @@
+package index
+
+// Prune is a mask's decision for every entry below a directory, see PruneHinter.
+type Prune int
+
+const (
+	// PruneNone means entries below the directory have to be checked one by one.
+	PruneNone Prune = iota
+	// PruneMasked means every entry below the directory is masked, so its contents don't need to be read.
+	PruneMasked
+	// PruneUnmasked means no entry below the directory is masked, so its contents don't need to be checked.
+	PruneUnmasked
+)
+
+// PruneHinter is implemented by masks that can tell when a directory decides the outcome for everything below it,
+// so that recursive operations can skip checking its descendants one by one.
+type PruneHinter interface {
+	// PruneHint returns the mask's decision for every entry below the directory, or PruneNone if it can't tell.
+	// It says nothing about whether the directory itself is masked.
+	PruneHint(dir *Entry) Prune
+}
+
+// PruneHint returns the mask's decision for every entry below the directory.
+// A nil mask masks nothing, and masks that don't implement PruneHinter can't tell.
+func PruneHint(mask Mask, dir *Entry) Prune {
+	if mask == nil {
+		return PruneUnmasked
+	}
+	if h, ok := mask.(PruneHinter); ok {
+		return h.PruneHint(dir)
+	}
+	return PruneNone
+}
@@ -0,0 +1,50 @@
+package index
+
+import (
+	"cmp"
+	"fmt"
+	"sort"
+)
+
+// Keys entries can be sorted by with SortBy.
+const (
+	SortByName    = "name"
+	SortBySize    = "size"
+	SortByModTime = "mtime"
+)
+
+// SortBy sorts the entries by the given key, in descending order if desc is set.
+// Entries that compare equal are ordered by path, in ascending order regardless of desc, so that the order is
+// the same across requests.
+func (e Entries) SortBy(key string, desc bool) error {
+	var compare func(a, b *Entry) int
+	switch key {
+	case SortByName:
+		compare = func(a, b *Entry) int {
+			return cmp.Compare(a.Name, b.Name)
+		}
+	case SortBySize:
+		compare = func(a, b *Entry) int {
+			return cmp.Compare(a.Size, b.Size)
+		}
+	case SortByModTime:
+		compare = func(a, b *Entry) int {
+			return a.ModTime.Compare(b.ModTime)
+		}
+	default:
+		return fmt.Errorf("unsupported sort key %q", key)
+	}
+
+	sort.Slice(e, func(i, j int) bool {
+		c := compare(e[i], e[j])
+		if desc {
+			c = -c
+		}
+		if c != 0 {
+			return c < 0
+		}
+		return e[i].FSPath < e[j].FSPath
+	})
+
+	return nil
+}
@@ -0,0 +1,57 @@
+package index
+
+import (
+	"errors"
+	"io/fs"
+	"sort"
+)
+
+// WalkFunc is called by Walk for each unmasked entry, along with the entry's depth below the walked directory,
+// starting at 1 for its immediate children.
+// Returning fs.SkipDir for a directory skips its contents, and returning any other error stops the walk.
+type WalkFunc func(entry *Entry, depth int) error
+
+// Walk calls fn for every unmasked entry below the given directory, depth first and in name order.
+// Masked directories are pruned along with everything below them, so their contents are never read.
+// Symlinked directories are passed to fn but not descended into, to avoid loops.
+// Directories are only checked entry by entry when the mask's PruneHint can't decide everything below them.
+// Each directory is listed by GetEntries with the given options.
+func Walk(fsys fs.FS, dir string, mask Mask, fn WalkFunc, opts ...GetEntriesOption) error {
+	return walk(fsys, dir, mask, 1, fn, opts)
+}
+
+func walk(fsys fs.FS, dir string, mask Mask, depth int, fn WalkFunc, opts []GetEntriesOption) error {
+	entries, err := GetEntries(fsys, dir, mask, opts...)
+	if err != nil {
+		return err
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Name < entries[j].Name
+	})
+
+	for _, entry := range entries {
+		if err := fn(entry, depth); err != nil {
+			if errors.Is(err, fs.SkipDir) {
+				continue
+			}
+			return err
+		}
+
+		if entry.IsDir && !entry.IsSymlink {
+			sub := mask
+			switch PruneHint(mask, entry) {
+			case PruneMasked:
+				// Nothing below the directory would be passed to fn, don't bother reading it
+				continue
+			case PruneUnmasked:
+				sub = nil
+			}
+			if err := walk(fsys, entry.FSPath, sub, depth+1, fn, opts); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,84 @@
+package index
+
+import (
+	"io/fs"
+	"path"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+// readDirFS records the directories read from a filesystem.
+type readDirFS struct {
+	fstest.MapFS
+	read []string
+}
+
+func (f *readDirFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	f.read = append(f.read, name)
+	return f.MapFS.ReadDir(name)
+}
+
+// prefixMask masks the entries below any of the given directories, hinting that they're masked, and hints that
+// everything below the other directories is unmasked, counting the entries it checks.
+type prefixMask struct {
+	dirs    []string
+	checked map[string]bool
+}
+
+func (m *prefixMask) Masked(entry *Entry) bool {
+	m.checked[entry.FSPath] = true
+	for _, dir := range m.dirs {
+		if strings.HasPrefix(entry.FSPath, dir+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *prefixMask) PruneHint(dir *Entry) Prune {
+	for _, masked := range m.dirs {
+		if dir.FSPath == masked {
+			return PruneMasked
+		}
+	}
+	if path.Dir(dir.FSPath) == "." {
+		return PruneNone
+	}
+	return PruneUnmasked
+}
+
+func TestWalkPrunes(t *testing.T) {
+	fsys := &readDirFS{MapFS: fstest.MapFS{
+		"masked/a.txt":         {},
+		"masked/sub/b.txt":     {},
+		"public/c.txt":         {},
+		"public/sub/d.txt":     {},
+		"public/sub/deep/e.md": {},
+	}}
+	mask := &prefixMask{dirs: []string{"masked"}, checked: map[string]bool{}}
+
+	var walked []string
+	err := Walk(fsys, ".", mask, func(entry *Entry, depth int) error {
+		walked = append(walked, entry.FSPath)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{"masked", "public", "public/c.txt", "public/sub", "public/sub/d.txt", "public/sub/deep", "public/sub/deep/e.md"}
+	if strings.Join(walked, " ") != strings.Join(want, " ") {
+		t.Errorf("Walk() = %q, want %q", walked, want)
+	}
+	for _, dir := range fsys.read {
+		if dir == "masked" || strings.HasPrefix(dir, "masked/") {
+			t.Errorf("masked directory %q was read", dir)
+		}
+	}
+	for p := range mask.checked {
+		if strings.HasPrefix(p, "public/sub/") {
+			t.Errorf("%q below an unmasked directory was checked", p)
+		}
+	}
+}
@@ -0,0 +1,23 @@
+package logger
+
+import "context"
+
+// fieldsKey is the context key of the fields that ForContext adds to loggers.
+type fieldsKey struct{}
+
+// ContextWithFields returns a copy of the context carrying the given alternating keys and values, along with those it
+// already carries, so that everything logged for it through ForContext has them, like the ID of a request.
+func ContextWithFields(ctx context.Context, kv ...any) context.Context {
+	fields, _ := ctx.Value(fieldsKey{}).([]any)
+	return context.WithValue(ctx, fieldsKey{}, append(fields[:len(fields):len(fields)], kv...))
+}
+
+// ForContext returns a logger adding the fields the context carries to every record, or the logger itself if the
+// context carries none.
+func ForContext(ctx context.Context, l Logger) Logger {
+	fields, _ := ctx.Value(fieldsKey{}).([]any)
+	if len(fields) == 0 {
+		return l
+	}
+	return l.Fields(fields...)
+}
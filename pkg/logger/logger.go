@@ -1,6 +1,7 @@
 package logger
 
 import (
+	"fmt"
 	"io"
 	"runtime"
 	"strings"
@@ -33,7 +34,7 @@ func Package() Logger {
 }
 
 func NewWithFields(fields logrus.Fields) Logger {
-	return Logger{
+	return &logrusLogger{
 		log:    logrus.StandardLogger(),
 		fields: fields,
 	}
@@ -49,16 +50,48 @@ func New(name string) Logger {
 	return NewWithFields(fields)
 }
 
+// SetFormat sets the format of log records, either text for human-readable lines or json for one JSON object per
+// record, for log aggregation pipelines. JSON records carry the same fields as text ones, with the time under "timestamp".
+func SetFormat(format string) error {
+	switch format {
+	case "", "text":
+		logrus.SetFormatter(&logrus.TextFormatter{})
+	case "json":
+		logrus.SetFormatter(&logrus.JSONFormatter{
+			FieldMap: logrus.FieldMap{
+				logrus.FieldKeyTime: "timestamp",
+			},
+		})
+	default:
+		return fmt.Errorf("unsupported log format %q, must be text or json", format)
+	}
+	return nil
+}
+
 func SetOutput(out io.Writer) {
 	logrus.SetOutput(out)
 }
 
-type Logger struct {
+// Logger is what maskfs logs through, with printf-style messages and key-value fields.
+// New returns a Logger backed by logrus, which the Set functions of this package configure, and Slog adapts a
+// *slog.Logger, so that programs embedding maskfs can route its logs wherever their own go.
+type Logger interface {
+	// Fields returns a logger adding the given alternating keys and values to every record.
+	Fields(kv ...any) Logger
+	Debugf(msg string, args ...any)
+	Infof(msg string, args ...any)
+	Warnf(msg string, args ...any)
+	Errorf(msg string, args ...any)
+	// IsDebug returns true if debug records are logged, so that expensive debug output can be skipped otherwise.
+	IsDebug() bool
+}
+
+type logrusLogger struct {
 	log    *logrus.Logger
 	fields logrus.Fields
 }
 
-func (l *Logger) FieldsMap(kv map[string]any) *Logger {
+func (l *logrusLogger) FieldsMap(kv map[string]any) Logger {
 	newFields := map[string]any{}
 	for k, v := range l.fields {
 		newFields[k] = v
@@ -66,13 +99,13 @@ func (l *Logger) FieldsMap(kv map[string]any) *Logger {
 	for k, v := range kv {
 		newFields[k] = v
 	}
-	return &Logger{
+	return &logrusLogger{
 		log:    l.log,
 		fields: newFields,
 	}
 }
 
-func (l *Logger) Fields(kv ...any) *Logger {
+func (l *logrusLogger) Fields(kv ...any) Logger {
 	newFields := map[string]any{}
 	for k, v := range l.fields {
 		newFields[k] = v
@@ -82,36 +115,36 @@ func (l *Logger) Fields(kv ...any) *Logger {
 			newFields[kv[i-1].(string)] = v
 		}
 	}
-	return &Logger{
+	return &logrusLogger{
 		log:    l.log,
 		fields: newFields,
 	}
 }
 
-func (l *Logger) Infof(msg string, args ...any) {
+func (l *logrusLogger) Infof(msg string, args ...any) {
 	l.log.WithFields(l.fields).Infof(msg, args...)
 }
 
-func (l *Logger) Errorf(msg string, args ...any) {
+func (l *logrusLogger) Errorf(msg string, args ...any) {
 	l.log.WithFields(l.fields).Errorf(msg, args...)
 }
 
-func (l *Logger) Tracef(msg string, args ...any) {
+func (l *logrusLogger) Tracef(msg string, args ...any) {
 	l.log.WithFields(l.fields).Tracef(msg, args...)
 }
 
-func (l *Logger) Warnf(msg string, args ...any) {
+func (l *logrusLogger) Warnf(msg string, args ...any) {
 	l.log.WithFields(l.fields).Warnf(msg, args...)
 }
 
-func (l *Logger) IsDebug() bool {
+func (l *logrusLogger) IsDebug() bool {
 	return l.log.IsLevelEnabled(logrus.DebugLevel)
 }
 
-func (l *Logger) Debugf(msg string, args ...any) {
+func (l *logrusLogger) Debugf(msg string, args ...any) {
 	l.log.WithFields(l.fields).Debugf(msg, args...)
 }
 
-func (l *Logger) Fatalf(msg string, args ...any) {
+func (l *logrusLogger) Fatalf(msg string, args ...any) {
 	l.log.WithFields(l.fields).Fatalf(msg, args...)
 }
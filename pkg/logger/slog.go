@@ -0,0 +1,48 @@
+package logger
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+)
+
+// Slog returns a Logger that logs through the given slog logger, at the slog level of each method's name.
+func Slog(l *slog.Logger) Logger {
+	return slogLogger{l}
+}
+
+type slogLogger struct {
+	log *slog.Logger
+}
+
+func (l slogLogger) Fields(kv ...any) Logger {
+	return slogLogger{l.log.With(kv...)}
+}
+
+func (l slogLogger) Debugf(msg string, args ...any) {
+	l.logf(slog.LevelDebug, msg, args...)
+}
+
+func (l slogLogger) Infof(msg string, args ...any) {
+	l.logf(slog.LevelInfo, msg, args...)
+}
+
+func (l slogLogger) Warnf(msg string, args ...any) {
+	l.logf(slog.LevelWarn, msg, args...)
+}
+
+func (l slogLogger) Errorf(msg string, args ...any) {
+	l.logf(slog.LevelError, msg, args...)
+}
+
+func (l slogLogger) IsDebug() bool {
+	return l.log.Enabled(context.Background(), slog.LevelDebug)
+}
+
+// logf formats the message only if the level is enabled, since debug messages are formatted for every request.
+func (l slogLogger) logf(level slog.Level, msg string, args ...any) {
+	if !l.log.Enabled(context.Background(), level) {
+		return
+	}
+	l.log.Log(context.Background(), level, fmt.Sprintf(msg, args...))
+}
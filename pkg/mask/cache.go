@@ -0,0 +1,75 @@
+package mask
+
+import (
+	"container/list"
+	"sync"
+)
+
+// decisionKey identifies what a glob mask decision depends on, the path of an entry and whether it's matched as a
+// directory.
+type decisionKey struct {
+	path  string
+	isDir bool
+}
+
+// decision is the outcome of matching an entry against a glob mask's rules, see GlobMask.decide.
+type decision struct {
+	rule       *rule
+	index      int
+	included   bool
+	conditions []ruleRef // The modification time rules an included file has to satisfy
+	err        error
+}
+
+// decisionCache is a fixed-size cache of glob mask decisions that evicts the least recently used decision when full.
+type decisionCache struct {
+	mu      sync.Mutex
+	max     int
+	order   *list.List // Keys, most recently used first
+	entries map[decisionKey]*list.Element
+}
+
+// cachedDecision is the value of the elements of a decisionCache's order.
+type cachedDecision struct {
+	key decisionKey
+	decision
+}
+
+func newDecisionCache(max int) *decisionCache {
+	return &decisionCache{
+		max:     max,
+		order:   list.New(),
+		entries: map[decisionKey]*list.Element{},
+	}
+}
+
+func (c *decisionCache) get(key decisionKey) (decision, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.entries[key]
+	if !ok {
+		return decision{}, false
+	}
+	c.order.MoveToFront(e)
+
+	return e.Value.(*cachedDecision).decision, true
+}
+
+func (c *decisionCache) put(key decisionKey, d decision) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if e, ok := c.entries[key]; ok {
+		e.Value.(*cachedDecision).decision = d
+		c.order.MoveToFront(e)
+		return
+	}
+
+	if c.order.Len() >= c.max {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*cachedDecision).key)
+	}
+	c.entries[key] = c.order.PushFront(&cachedDecision{key: key, decision: d})
+}
@@ -0,0 +1,105 @@
+package mask
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/njhale/maskfs/pkg/index"
+)
+
+func TestDecisionCache(t *testing.T) {
+	c := newDecisionCache(2)
+	a, b, d := decisionKey{path: "a"}, decisionKey{path: "b"}, decisionKey{path: "d"}
+
+	c.put(a, decision{index: 1})
+	c.put(b, decision{index: 2})
+	if got, ok := c.get(a); !ok || got.index != 1 {
+		t.Fatalf("get(a) = %+v, %t, want the decision put", got, ok)
+	}
+
+	// b is now the least recently used, so it's evicted first
+	c.put(d, decision{index: 3})
+	if _, ok := c.get(b); ok {
+		t.Error("least recently used decision wasn't evicted")
+	}
+	for _, key := range []decisionKey{a, d} {
+		if _, ok := c.get(key); !ok {
+			t.Errorf("get(%q) missed a recently used decision", key.path)
+		}
+	}
+
+	c.put(a, decision{index: 4})
+	if got, _ := c.get(a); got.index != 4 {
+		t.Errorf("get(a) = %+v, want the decision replaced", got)
+	}
+	if _, ok := c.get(decisionKey{path: "a", isDir: true}); ok {
+		t.Error("directory hit the decision of a file at the same path")
+	}
+}
+
+// benchmarkRules returns a mask of many rules, like a large .gitignore, that can't be narrowed down by their literal
+// parts, along with the entries of a large directory.
+func benchmarkRules(rules, entries int) (string, []*index.Entry) {
+	var b strings.Builder
+	b.WriteString("**\n")
+	for i := range rules {
+		fmt.Fprintf(&b, "!**/build-%d-*/\n", i)
+	}
+
+	dir := make([]*index.Entry, entries)
+	for i := range dir {
+		dir[i] = entry(fmt.Sprintf("src/pkg/file-%d.txt", i), false)
+	}
+
+	return b.String(), dir
+}
+
+// BenchmarkGlobMaskListing masks every entry of a large directory, the way each listing of it does.
+func BenchmarkGlobMaskListing(b *testing.B) {
+	rules, dir := benchmarkRules(400, 5000)
+
+	for _, size := range []int{0, len(dir)} {
+		b.Run(fmt.Sprintf("cache=%d", size), func(b *testing.B) {
+			m, err := NewGlobMask(rules)
+			if err != nil {
+				b.Fatal(err)
+			}
+			m.CacheDecisions(size)
+
+			for b.Loop() {
+				for _, e := range dir {
+					m.Masked(e)
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkGlobMaskListingLayers is BenchmarkGlobMaskListing with per-directory rule files enabled, whose rules are
+// matched too for every entry that isn't cached.
+func BenchmarkGlobMaskListingLayers(b *testing.B) {
+	rules, dir := benchmarkRules(400, 5000)
+	fsys := fstest.MapFS{
+		"src/.maskfs":     {Data: []byte("!*.log\n")},
+		"src/pkg/.maskfs": {Data: []byte("!*.tmp\n!testdata/\n")},
+	}
+
+	for _, size := range []int{0, len(dir)} {
+		b.Run(fmt.Sprintf("cache=%d", size), func(b *testing.B) {
+			m, err := NewGlobMask(rules)
+			if err != nil {
+				b.Fatal(err)
+			}
+			m.LayerFiles(fsys, ".maskfs")
+			m.CacheDecisions(size)
+
+			for b.Loop() {
+				for _, e := range dir {
+					m.Masked(e)
+				}
+			}
+		})
+	}
+}
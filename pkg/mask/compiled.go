@@ -0,0 +1,137 @@
+package mask
+
+import (
+	"path"
+	"slices"
+	"strings"
+)
+
+// ruleIndex narrows down the rules of a glob mask that may match a path, so that a path is only matched against the
+// rules that share a literal part with it, along with the rules that have no literal parts at all, instead of against
+// every rule. Matching is still left to the rules' patterns, so the index never changes which rule decides a path.
+type ruleIndex struct {
+	prefixes *trieNode        // Rules anchored to the root, keyed by their leading literal components, like "/docs/*.md"
+	names    map[string][]int // Rules matching only paths with a component of the given name, like "node_modules/"
+	suffixes map[string][]int // Rules matching only paths with a component of the given extension, like "*.log"
+	others   []int            // Rules every path has to be matched against, like "*_test.go"
+}
+
+// trieNode is a node of a trie of path components.
+type trieNode struct {
+	children map[string]*trieNode
+	rules    []int // Rules whose leading literal components lead to the node
+}
+
+// newRuleIndex indexes the rules by their literal parts. Rules are indexed the way gitignore.ParsePattern parses them.
+func newRuleIndex(rules []rule) *ruleIndex {
+	idx := &ruleIndex{
+		prefixes: &trieNode{},
+		names:    map[string][]int{},
+		suffixes: map[string][]int{},
+	}
+
+	for i, r := range rules {
+		if r.pattern == nil {
+			// Modification time rules don't match paths
+			continue
+		}
+
+		pattern := strings.TrimSuffix(strings.TrimPrefix(r.line, "!"), "/")
+		if strings.Contains(pattern, `\`) {
+			// Leave escapes to the pattern
+			idx.others = append(idx.others, i)
+			continue
+		}
+
+		components := strings.Split(pattern, "/")
+		if len(components) == 1 {
+			// Without a slash, the pattern matches any component of the path by name
+			switch name := components[0]; {
+			case isLiteral(name):
+				idx.names[name] = append(idx.names[name], i)
+			case len(name) > 1 && name[0] == '*' && isLiteral(name[1:]) && path.Ext(name[1:]) == name[1:]:
+				idx.suffixes[name[1:]] = append(idx.suffixes[name[1:]], i)
+			default:
+				idx.others = append(idx.others, i)
+			}
+			continue
+		}
+
+		// With a slash, the pattern is anchored to the root, optionally with a leading slash, and matches its leading
+		// literal components against the path's leading components one by one
+		node := idx.prefixes
+		leading := components
+		if leading[0] == "" {
+			leading = leading[1:]
+		}
+		for _, c := range leading {
+			if !isLiteral(c) || c == "" {
+				break
+			}
+			if node.children == nil {
+				node.children = map[string]*trieNode{}
+			}
+			child, ok := node.children[c]
+			if !ok {
+				child = &trieNode{}
+				node.children[c] = child
+			}
+			node = child
+		}
+		if node != idx.prefixes {
+			node.rules = append(node.rules, i)
+			continue
+		}
+
+		// Otherwise, a path can only match if one of its components equals any literal component of the pattern,
+		// even after a leading **
+		if name, ok := literalComponent(components); ok {
+			idx.names[name] = append(idx.names[name], i)
+			continue
+		}
+		idx.others = append(idx.others, i)
+	}
+
+	return idx
+}
+
+// candidates returns the indexes of the rules that may match the path's components, in decreasing order.
+func (idx *ruleIndex) candidates(parts []string) []int {
+	candidates := slices.Clone(idx.others)
+
+	node := idx.prefixes
+	for _, part := range parts {
+		if node = node.children[part]; node == nil {
+			break
+		}
+		candidates = append(candidates, node.rules...)
+	}
+
+	for _, part := range parts {
+		candidates = append(candidates, idx.names[part]...)
+		if ext := path.Ext(part); ext != "" {
+			candidates = append(candidates, idx.suffixes[ext]...)
+		}
+	}
+
+	slices.Sort(candidates)
+	candidates = slices.Compact(candidates)
+	slices.Reverse(candidates)
+
+	return candidates
+}
+
+// literalComponent returns a component of a pattern without wildcards, if it has one.
+func literalComponent(components []string) (string, bool) {
+	for _, c := range components {
+		if c != "" && isLiteral(c) {
+			return c, true
+		}
+	}
+	return "", false
+}
+
+// isLiteral returns true if a pattern has no wildcards, so it only matches names equal to it.
+func isLiteral(pattern string) bool {
+	return !strings.ContainsAny(pattern, `*?[\`)
+}
@@ -0,0 +1,150 @@
+package mask
+
+import (
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/njhale/maskfs/pkg/index"
+)
+
+// indexRules are rules of every kind the rule index tells apart, and some it leaves to their patterns.
+var indexRules = []string{
+	"**",
+	"!node_modules/",
+	"!*.log",
+	"/docs/*.md",
+	"!docs/internal/**",
+	"src/**/testdata/",
+	"!**/vendor/**",
+	"!*_test.go",
+	"build/",
+	`!\#*`,
+	"!a/*/c",
+	"a/b/c/",
+	"!**/cache",
+	"*.log",
+	"!/docs/internal/public.md",
+	"!*.tar.gz",
+}
+
+// indexPaths are paths that may or may not be matched by indexRules.
+var indexPaths = []string{
+	"README.md",
+	"docs/a.md",
+	"docs/internal/b.md",
+	"docs/internal/public.md",
+	"docs/deep/c.md",
+	"node_modules/x/index.js",
+	"src/node_modules",
+	"src/pkg/testdata/in.txt",
+	"src/testdata",
+	"vendor/lib/lib.go",
+	"pkg/vendor/lib.go",
+	"pkg/a_test.go",
+	"pkg/a.go",
+	"build/out",
+	"build",
+	"#scratch#",
+	"a/b/c",
+	"a/x/c",
+	"a/b/c/d",
+	"cache",
+	"x/cache/y",
+	"debug.log",
+	"logs/debug.log",
+	"dist/release.tar.gz",
+	"release.gz",
+}
+
+// matchLinear matches a path against every rule, last first, the way the mask would without its index.
+func matchLinear(m *GlobMask, parts []string, isDir bool) decision {
+	for i := len(m.rules) - 1; i >= 0; i-- {
+		if d, ok := m.matchRule(m.rules, i, parts, isDir); ok {
+			return d
+		}
+	}
+	return decision{index: -1, included: m.exclude}
+}
+
+func TestRuleIndex(t *testing.T) {
+	// Every suffix of the rules, so that each rule gets to be the last one matching some path
+	for start := range indexRules {
+		rules := strings.Join(indexRules[start:], "\n")
+		for _, exclude := range []bool{false, true} {
+			m, err := newGlobMask(rules, exclude)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for _, p := range indexPaths {
+				for _, isDir := range []bool{false, true} {
+					parts := strings.Split(p, "/")
+					got, want := m.matchOwn(parts, isDir), matchLinear(m, parts, isDir)
+					if got.index != want.index || got.included != want.included {
+						t.Errorf("rules %q, exclude %t: matchOwn(%q, dir %t) = rule %d, included %t, want rule %d, included %t",
+							indexRules[start:], exclude, p, isDir, got.index, got.included, want.index, want.included)
+					}
+				}
+			}
+		}
+	}
+}
+
+func TestPruneHintSound(t *testing.T) {
+	// The paths and every directory above them
+	isDir := map[string]bool{}
+	for _, p := range indexPaths {
+		if _, ok := isDir[p]; !ok {
+			isDir[p] = false
+		}
+		for dir := path.Dir(p); dir != "."; dir = path.Dir(dir) {
+			isDir[dir] = true
+		}
+	}
+
+	// Rules anchored to directories, which let directories be pruned, along with the suffixes of indexRules
+	ruleSets := [][]string{
+		{"docs/**", "!docs/internal/"},
+		{"/src/", "!/src/pkg/"},
+		{"**", "!/vendor/", "!/node_modules/"},
+		{"/a/b/", "!/a/b/c/d", "/a/b/c/"},
+		{"/docs/internal/**", "!/docs/*.md"},
+	}
+	for start := range indexRules {
+		ruleSets = append(ruleSets, indexRules[start:])
+	}
+
+	var pruned int
+	for _, rules := range ruleSets {
+		for _, exclude := range []bool{false, true} {
+			m, err := newGlobMask(strings.Join(rules, "\n"), exclude)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// Whatever the hint of a directory, every entry below it has to be decided that way
+			for dir := range isDir {
+				if !isDir[dir] {
+					continue
+				}
+				prune := m.PruneHint(entry(dir, true))
+				if prune == index.PruneNone {
+					continue
+				}
+				pruned++
+				for p := range isDir {
+					if !strings.HasPrefix(p, dir+"/") {
+						continue
+					}
+					if masked := m.Masked(entry(p, isDir[p])); masked != (prune == index.PruneMasked) {
+						t.Errorf("rules %q, exclude %t: PruneHint(%q) = %v, but Masked(%q) = %t", rules, exclude, dir, prune, p, masked)
+					}
+				}
+			}
+		}
+	}
+	if pruned == 0 {
+		t.Error("no directory was ever pruned")
+	}
+}
@@ -0,0 +1,109 @@
+package mask
+
+import (
+	"github.com/njhale/maskfs/pkg/index"
+)
+
+// All returns a mask that masks an entry if any of the given masks does, so an entry must pass all of them to be included.
+// Nil masks mask nothing and are ignored.
+func All(masks ...index.Mask) index.Mask {
+	return allOf(compact(masks))
+}
+
+// Any returns a mask that masks an entry only if all of the given masks do, so an entry passing any of them is included.
+// Nil masks mask nothing, so an Any with a nil member never masks.
+func Any(masks ...index.Mask) index.Mask {
+	return anyOf(masks)
+}
+
+// Not returns a mask that masks exactly the entries the given mask includes.
+// A nil mask masks nothing, so its inverse masks everything.
+func Not(mask index.Mask) index.Mask {
+	return not{mask}
+}
+
+type allOf []index.Mask
+
+func (a allOf) Masked(entry *index.Entry) bool {
+	for _, m := range a {
+		if m.Masked(entry) {
+			return true
+		}
+	}
+	return false
+}
+
+// PruneHint masks everything below the directory if any of the masks does, and nothing if none of them do.
+func (a allOf) PruneHint(dir *index.Entry) index.Prune {
+	prune := index.PruneUnmasked
+	for _, m := range a {
+		switch index.PruneHint(m, dir) {
+		case index.PruneMasked:
+			return index.PruneMasked
+		case index.PruneNone:
+			prune = index.PruneNone
+		}
+	}
+	return prune
+}
+
+type anyOf []index.Mask
+
+func (a anyOf) Masked(entry *index.Entry) bool {
+	if len(a) == 0 {
+		return false
+	}
+	for _, m := range a {
+		if m == nil || !m.Masked(entry) {
+			return false
+		}
+	}
+	return true
+}
+
+// PruneHint masks nothing below the directory if any of the masks does, and everything if all of them do.
+func (a anyOf) PruneHint(dir *index.Entry) index.Prune {
+	if len(a) == 0 {
+		return index.PruneUnmasked
+	}
+
+	prune := index.PruneMasked
+	for _, m := range a {
+		switch index.PruneHint(m, dir) {
+		case index.PruneUnmasked:
+			return index.PruneUnmasked
+		case index.PruneNone:
+			prune = index.PruneNone
+		}
+	}
+	return prune
+}
+
+type not struct {
+	mask index.Mask
+}
+
+func (n not) Masked(entry *index.Entry) bool {
+	return n.mask == nil || !n.mask.Masked(entry)
+}
+
+func (n not) PruneHint(dir *index.Entry) index.Prune {
+	switch index.PruneHint(n.mask, dir) {
+	case index.PruneMasked:
+		return index.PruneUnmasked
+	case index.PruneUnmasked:
+		return index.PruneMasked
+	}
+	return index.PruneNone
+}
+
+// compact returns the non-nil masks.
+func compact(masks []index.Mask) []index.Mask {
+	var nonNil []index.Mask
+	for _, m := range masks {
+		if m != nil {
+			nonNil = append(nonNil, m)
+		}
+	}
+	return nonNil
+}
@@ -0,0 +1,111 @@
+package mask
+
+import (
+	"testing"
+
+	"github.com/njhale/maskfs/pkg/index"
+)
+
+// constMask masks every entry or none.
+type constMask bool
+
+func (m constMask) Masked(*index.Entry) bool {
+	return bool(m)
+}
+
+func TestComposite(t *testing.T) {
+	const (
+		masking   = constMask(true)
+		unmasking = constMask(false)
+	)
+
+	for _, tt := range []struct {
+		name   string
+		mask   index.Mask
+		masked bool
+	}{
+		{name: "all of nothing", mask: All(), masked: false},
+		{name: "all of nil", mask: All(nil), masked: false},
+		{name: "all of nil and a masking mask", mask: All(nil, masking), masked: true},
+		{name: "all of with a masking mask", mask: All(unmasking, masking), masked: true},
+		{name: "all of unmasking masks", mask: All(unmasking, unmasking), masked: false},
+		{name: "any of nothing", mask: Any(), masked: false},
+		{name: "any of nil", mask: Any(nil), masked: false},
+		{name: "any of nil and a masking mask", mask: Any(nil, masking), masked: false},
+		{name: "any of with an unmasking mask", mask: Any(masking, unmasking), masked: false},
+		{name: "any of masking masks", mask: Any(masking, masking), masked: true},
+		{name: "all of any ofs", mask: All(Any(masking, unmasking), Any(masking, masking)), masked: true},
+		{name: "not masking", mask: Not(masking), masked: false},
+		{name: "not unmasking", mask: Not(unmasking), masked: true},
+		{name: "not nil", mask: Not(nil), masked: true},
+		{name: "not not", mask: Not(Not(masking)), masked: true},
+		{name: "not all of", mask: Not(All(masking, unmasking)), masked: false},
+		{name: "not any of", mask: Not(Any(masking, unmasking)), masked: true},
+		{name: "not all of nothing", mask: Not(All()), masked: true},
+		{name: "all of nots", mask: All(Not(unmasking), Not(masking)), masked: true},
+		{name: "any of all ofs", mask: Any(All(masking, unmasking), All(unmasking)), masked: false},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			if masked := tt.mask.Masked(&index.Entry{Name: "a", FSPath: "a"}); masked != tt.masked {
+				t.Errorf("Masked() = %t, want %t", masked, tt.masked)
+			}
+		})
+	}
+}
+
+// hintMask masks every entry or none, and hints the given prune decision for every directory.
+type hintMask struct {
+	masked bool
+	prune  index.Prune
+}
+
+func (m hintMask) Masked(*index.Entry) bool {
+	return m.masked
+}
+
+func (m hintMask) PruneHint(*index.Entry) index.Prune {
+	return m.prune
+}
+
+// noHintMask masks nothing, and can't tell anything about directories.
+type noHintMask struct{}
+
+func (noHintMask) Masked(*index.Entry) bool {
+	return false
+}
+
+func TestCompositePruneHint(t *testing.T) {
+	var (
+		masked   = hintMask{masked: true, prune: index.PruneMasked}
+		unmasked = hintMask{prune: index.PruneUnmasked}
+		unknown  = hintMask{prune: index.PruneNone}
+		noHint   = noHintMask{}
+	)
+
+	for _, tt := range []struct {
+		name string
+		mask index.Mask
+		want index.Prune
+	}{
+		{name: "all of nothing", mask: All(), want: index.PruneUnmasked},
+		{name: "all of nil", mask: All(nil), want: index.PruneUnmasked},
+		{name: "all of with a masking mask", mask: All(unknown, masked), want: index.PruneMasked},
+		{name: "all of unmasking masks", mask: All(unmasked, unmasked), want: index.PruneUnmasked},
+		{name: "all of with an unknown mask", mask: All(unmasked, unknown), want: index.PruneNone},
+		{name: "all of with a mask without hints", mask: All(unmasked, noHint), want: index.PruneNone},
+		{name: "any of nothing", mask: Any(), want: index.PruneUnmasked},
+		{name: "any of with an unmasking mask", mask: Any(unknown, unmasked), want: index.PruneUnmasked},
+		{name: "any of masking masks", mask: Any(masked, masked), want: index.PruneMasked},
+		{name: "any of with an unknown mask", mask: Any(masked, unknown), want: index.PruneNone},
+		{name: "not masking", mask: Not(masked), want: index.PruneUnmasked},
+		{name: "not unmasking", mask: Not(unmasked), want: index.PruneMasked},
+		{name: "not unknown", mask: Not(unknown), want: index.PruneNone},
+		{name: "not nil", mask: Not(nil), want: index.PruneMasked},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := index.PruneHint(tt.mask, &index.Entry{Name: "dir", FSPath: "dir", IsDir: true}); got != tt.want {
+				t.Errorf("PruneHint() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,166 @@
+package mask
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"path"
+
+	"github.com/njhale/maskfs/pkg/index"
+)
+
+// FS returns a filesystem that hides the entries of fsys masked by m, as if they didn't exist.
+// Masked entries can't be opened or stat'd and are left out of directory listings.
+// The root directory is never masked, matching the file server, and a nil mask masks nothing.
+//
+// The returned filesystem implements fs.ReadDirFS, fs.StatFS, and fs.ReadLinkFS, so it can be handed to anything
+// accepting an fs.FS, like http.FileServerFS or archive writers, to apply a mask without running the server.
+func FS(fsys fs.FS, m index.Mask) fs.FS {
+	if m == nil {
+		m = All()
+	}
+	return &maskedFS{
+		fsys: fsys,
+		mask: m,
+	}
+}
+
+type maskedFS struct {
+	fsys fs.FS
+	mask index.Mask
+}
+
+// check returns an error if the named entry can't be accessed through the filesystem.
+func (m *maskedFS) check(op, name string) error {
+	if !fs.ValidPath(name) {
+		return &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+	if name == "." {
+		return nil
+	}
+
+	entry, err := index.GetEntry(m.fsys, name)
+	if err != nil {
+		return &fs.PathError{Op: op, Path: name, Err: err}
+	}
+	if m.mask.Masked(entry) {
+		return &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+	}
+
+	return nil
+}
+
+func (m *maskedFS) Open(name string) (fs.File, error) {
+	if err := m.check("open", name); err != nil {
+		return nil, err
+	}
+
+	f, err := m.fsys.Open(name)
+	if err != nil {
+		return nil, err
+	}
+
+	// Files like *os.File implement ReadDir whether or not they're directories, so check what was opened.
+	// Regular files are returned as they are, so they keep any other methods they implement, like io.Seeker.
+	dir, ok := f.(fs.ReadDirFile)
+	if !ok {
+		return f, nil
+	}
+	info, err := f.Stat()
+	if err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+	if !info.IsDir() {
+		return f, nil
+	}
+
+	return &maskedDir{ReadDirFile: dir, fs: m, name: name}, nil
+}
+
+func (m *maskedFS) Stat(name string) (fs.FileInfo, error) {
+	if err := m.check("stat", name); err != nil {
+		return nil, err
+	}
+	return fs.Stat(m.fsys, name)
+}
+
+func (m *maskedFS) Lstat(name string) (fs.FileInfo, error) {
+	if err := m.check("lstat", name); err != nil {
+		return nil, err
+	}
+	return fs.Lstat(m.fsys, name)
+}
+
+func (m *maskedFS) ReadLink(name string) (string, error) {
+	if err := m.check("readlink", name); err != nil {
+		return "", err
+	}
+	return fs.ReadLink(m.fsys, name)
+}
+
+func (m *maskedFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	if err := m.check("readdir", name); err != nil {
+		return nil, err
+	}
+
+	children, err := fs.ReadDir(m.fsys, name)
+	if err != nil {
+		return nil, err
+	}
+
+	return m.filter(name, children), nil
+}
+
+// filter returns the children of the named directory that aren't masked.
+func (m *maskedFS) filter(dir string, children []fs.DirEntry) []fs.DirEntry {
+	if entry, err := index.GetEntry(m.fsys, dir); err == nil {
+		switch index.PruneHint(m.mask, entry) {
+		case index.PruneMasked:
+			return nil
+		case index.PruneUnmasked:
+			return children
+		}
+	}
+
+	var unmasked []fs.DirEntry
+	for _, child := range children {
+		entry, err := index.GetEntry(m.fsys, path.Join(dir, child.Name()))
+		if err != nil || m.mask.Masked(entry) {
+			// Entries that can't be inspected can't be shown to be unmasked, so hide them
+			continue
+		}
+		unmasked = append(unmasked, child)
+	}
+
+	return unmasked
+}
+
+// maskedDir is an open directory whose listings leave out masked entries.
+type maskedDir struct {
+	fs.ReadDirFile
+	fs   *maskedFS
+	name string
+}
+
+func (d *maskedDir) ReadDir(n int) ([]fs.DirEntry, error) {
+	if n <= 0 {
+		children, err := d.ReadDirFile.ReadDir(n)
+		return d.fs.filter(d.name, children), err
+	}
+
+	// Keep reading until there are n unmasked entries or the directory is exhausted
+	var unmasked []fs.DirEntry
+	for len(unmasked) < n {
+		children, err := d.ReadDirFile.ReadDir(n - len(unmasked))
+		unmasked = append(unmasked, d.fs.filter(d.name, children)...)
+		if err != nil {
+			if errors.Is(err, io.EOF) && len(unmasked) > 0 {
+				return unmasked, nil
+			}
+			return unmasked, err
+		}
+	}
+
+	return unmasked, nil
+}
@@ -0,0 +1,62 @@
+package mask
+
+import (
+	"errors"
+	"io/fs"
+	"testing"
+	"testing/fstest"
+)
+
+func TestFS(t *testing.T) {
+	fsys := fstest.MapFS{
+		"a.txt":         {Data: []byte("a")},
+		"b.key":         {Data: []byte("b")},
+		"dir/c.txt":     {Data: []byte("c")},
+		"dir/d.key":     {Data: []byte("d")},
+		"dir/e.txt":     {Data: []byte("e")},
+		"secret/f.txt":  {Data: []byte("f")},
+		"secret/g/h.md": {Data: []byte("h")},
+	}
+	m, err := NewGlobMask("**\n!*.key\n!secret/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	masked := FS(fsys, m)
+
+	if err := fstest.TestFS(masked, "a.txt", "dir/c.txt", "dir/e.txt"); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"b.key", "dir/d.key", "secret", "secret/f.txt"} {
+		if _, err := fs.Stat(masked, name); !errors.Is(err, fs.ErrNotExist) {
+			t.Errorf("Stat(%q) = %v, want %v", name, err, fs.ErrNotExist)
+		}
+		if _, err := masked.Open(name); !errors.Is(err, fs.ErrNotExist) {
+			t.Errorf("Open(%q) = %v, want %v", name, err, fs.ErrNotExist)
+		}
+	}
+	if _, err := masked.Open("../a.txt"); !errors.Is(err, fs.ErrInvalid) {
+		t.Errorf("Open(../a.txt) = %v, want %v", err, fs.ErrInvalid)
+	}
+
+	// Reading a directory in batches skips masked entries without returning short batches
+	f, err := masked.Open("dir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	batch, err := f.(fs.ReadDirFile).ReadDir(2)
+	if err != nil || len(batch) != 2 || batch[0].Name() != "c.txt" || batch[1].Name() != "e.txt" {
+		t.Errorf("ReadDir(2) = %v, %v, want [c.txt e.txt]", batch, err)
+	}
+}
+
+func TestFSNilMask(t *testing.T) {
+	fsys := fstest.MapFS{
+		"a.txt":     {Data: []byte("a")},
+		"dir/b.key": {Data: []byte("b")},
+	}
+	if err := fstest.TestFS(FS(fsys, nil), "a.txt", "dir/b.key"); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -1,44 +1,456 @@
 package mask
 
 import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"slices"
 	"strings"
+	"sync"
+	"sync/atomic"
 
 	"github.com/go-git/go-git/v5/plumbing/format/gitignore"
+	"github.com/njhale/maskfs/pkg/clock"
 	"github.com/njhale/maskfs/pkg/index"
 )
 
 // GlobMask is responsible for determining which files and directories are included
 type GlobMask struct {
-	matcher gitignore.Matcher
+	rules      []rule
+	index      *ruleIndex // Narrows down the rules that may match a path
+	exclude    bool       // Rules select what to mask instead of what to include, see NewExcludeGlobMask
+	conditions []ruleRef  // The modification time rules among the rules
+	clock      clock.Clock
+
+	// Per-directory rule files, see LayerFiles
+	fsys      fs.FS
+	layerName string
+
+	cacheSize int                       // Maximum number of cached decisions, see CacheDecisions
+	state     atomic.Pointer[maskState] // Replaced as a whole by InvalidateLayers
+}
+
+// maskState is what a glob mask remembers as it's used, the rule files it read and the decisions it made with them,
+// which are forgotten together so that no decision outlives the rule files it was made with.
+type maskState struct {
+	layersMu sync.Mutex
+	layers   map[string]layer // Rule file contents keyed by the directory they were found in
+	cache    *decisionCache   // Recent decisions, nil unless they're cached
+}
+
+// rule is a parsed pattern, or a modification time condition, along with the line it was parsed from.
+type rule struct {
+	line      string
+	file      string // The per-directory rule file the rule was read from, empty for the mask's own rules
+	pattern   gitignore.Pattern
+	condition *modTimeCondition // Set instead of the pattern for modification time rules
+}
+
+// ruleRef refers to a rule by its index among the rules that apply to an entry, see GlobMask.Explain.
+type ruleRef struct {
+	rule  *rule
+	index int
+}
+
+// layer holds the rules of a single per-directory rule file.
+type layer struct {
+	rules []rule
+	err   error // Set if the rule file exists but couldn't be read
 }
 
 func (m *GlobMask) Masked(entry *index.Entry) bool {
+	_, _, included, err := m.decide(entry)
+	return err != nil || !included
+}
+
+// Explicit returns true if the entry is unmasked by a rule naming it literally, rather than by a rule with wildcards.
+func (m *GlobMask) Explicit(entry *index.Entry) bool {
+	r, _, included, err := m.decide(entry)
+	if err != nil || !included || r == nil {
+		return false
+	}
+
+	name := path.Base(strings.TrimSuffix(strings.TrimPrefix(r.line, "!"), "/"))
+	return !strings.ContainsAny(name, `*?[\`)
+}
+
+// Explain returns whether the entry is masked along with the rule that decided it and the rule's index, counting the
+// mask's own rules first and then those of the per-directory rule files that apply to the entry, from the root down.
+// The rule is empty and the index is -1 if no rule matched the entry.
+func (m *GlobMask) Explain(entry *index.Entry) (masked bool, rule string, index int) {
+	r, i, included, err := m.decide(entry)
+	if r == nil || err != nil {
+		return err != nil || !included, "", -1
+	}
+	return !included, r.line, i
+}
+
+// RuleFile returns the path, relative to the root, of the per-directory rule file whose rule decides whether the entry
+// is masked. It's empty if the deciding rule is one of the mask's own rules, or if no rule matched the entry.
+func (m *GlobMask) RuleFile(entry *index.Entry) string {
+	r, _, _, err := m.decide(entry)
+	if r == nil || err != nil {
+		return ""
+	}
+	return r.file
+}
+
+// decide returns whether the entry is included, along with the rule that decided it and the rule's index.
+// The rule is nil and the index is -1 if no rule matched the entry.
+// A file the rules include is masked by the first modification time rule it doesn't satisfy.
+// An error is returned if the entry should be masked because a rule file that applies to it couldn't be read.
+func (m *GlobMask) decide(entry *index.Entry) (*rule, int, bool, error) {
 	if entry == nil {
 		// The entry is not valid, mask it
-		return true
+		return nil, -1, false, nil
+	}
+
+	if m.layerName != "" && entry.Name == m.layerName {
+		// Never expose the rule files themselves
+		return nil, -1, false, nil
+	}
+
+	// Like git, a symlink is matched as a file regardless of its target, so a directory rule can't unmask a symlink.
+	key := decisionKey{path: entry.FSPath, isDir: entry.IsDir && !entry.IsSymlink}
+	st := m.currentState()
+	if st.cache == nil {
+		return m.checkConditions(entry, m.match(st, key))
+	}
+
+	d, ok := st.cache.get(key)
+	if !ok {
+		d = m.match(st, key)
+		st.cache.put(key, d)
+	}
+	return m.checkConditions(entry, d)
+}
+
+// checkConditions masks an included entry that doesn't satisfy the modification time rules that apply to it.
+// Modification times change without the path changing, so conditions are checked after decisions are cached.
+func (m *GlobMask) checkConditions(entry *index.Entry, d decision) (*rule, int, bool, error) {
+	if d.included && len(d.conditions) > 0 {
+		now := m.clock.Now()
+		for _, c := range d.conditions {
+			if !c.rule.condition.satisfied(entry.ModTime, now) {
+				return c.rule, c.index, false, nil
+			}
+		}
+	}
+	return d.rule, d.index, d.included, d.err
+}
+
+// currentState returns what the mask currently remembers.
+func (m *GlobMask) currentState() *maskState {
+	if st := m.state.Load(); st != nil {
+		return st
 	}
+	// Masks that are used before they're configured, which they usually aren't, remember nothing until they are
+	return &maskState{layers: map[string]layer{}}
+}
 
+// match matches a path against the mask's own rules and against those of the rule files that apply to it.
+func (m *GlobMask) match(st *maskState, key decisionKey) decision {
 	// Normalize the path
-	parts := strings.Split(entry.FSPath, "/")
+	parts := strings.Split(key.path, "/")
+
+	d := m.matchOwn(parts, key.isDir)
+	if !key.isDir {
+		d.conditions = m.conditions
+	}
+	if m.fsys == nil {
+		return d
+	}
+
+	layers, err := st.layered(m, key.path)
+	if err != nil {
+		// A rule file that can't be read might have masked the entry, so err on the side of masking it
+		return decision{index: -1, err: err}
+	}
+
+	// Rules are indexed after the mask's own, from the root down
+	offset := len(m.rules)
+	for _, l := range layers {
+		if !key.isDir {
+			// Every rule file's modification time rules apply to the files below it too
+			for j := range l.rules {
+				if l.rules[j].condition != nil {
+					d.conditions = append(slices.Clip(d.conditions), ruleRef{rule: &l.rules[j], index: offset + j})
+				}
+			}
+		}
+		offset += len(l.rules)
+	}
+
+	// Like nested .gitignore files, the last matching rule of the deepest rule file with one decides the path, whether
+	// it selects or negates, and the mask's own rules only decide paths that no rule file has a matching rule for
+	for i := len(layers) - 1; i >= 0; i-- {
+		rules := layers[i].rules
+		offset -= len(rules)
+		for j := len(rules) - 1; j >= 0; j-- {
+			if ld, ok := m.matchRule(rules, j, parts, key.isDir); ok {
+				ld.index, ld.conditions = offset+j, d.conditions
+				return ld
+			}
+		}
+	}
+
+	return d
+}
+
+// matchOwn matches a path against the mask's own rules, the last matching rule takes precedence.
+func (m *GlobMask) matchOwn(parts []string, isDir bool) decision {
+	// Only match the rules that may match the path
+	for _, i := range m.index.candidates(parts) {
+		if d, ok := m.matchRule(m.rules, i, parts, isDir); ok {
+			return d
+		}
+	}
+
+	// Entries not selected by any rule are only included in exclude mode
+	return decision{index: -1, included: m.exclude}
+}
+
+// matchRule returns the decision of the rule at the given index if it matches the path.
+// Modification time rules don't match paths.
+func (m *GlobMask) matchRule(rules []rule, i int, parts []string, isDir bool) (decision, bool) {
+	if rules[i].pattern == nil {
+		return decision{}, false
+	}
+	switch rules[i].pattern.Match(parts, isDir) {
+	case gitignore.Exclude:
+		// Matched a rule selecting the entry, for inclusion unless in exclude mode
+		return decision{rule: &rules[i], index: i, included: !m.exclude}, true
+	case gitignore.Include:
+		// Matched a negated rule, which masks the entry unless in exclude mode
+		return decision{rule: &rules[i], index: i, included: m.exclude}, true
+	}
+	return decision{}, false
+}
+
+// LayerFiles enables per-directory rule files, composing them the way git composes nested .gitignore files.
+// The rules of a file only apply to the directory it's in and below, using the rules the way the mask does, and the
+// last matching rule of the deepest file with one decides whether an entry is masked, negations included. The mask's
+// own rules only decide entries that no rule file has a matching rule for. Rule files are read from fsys the first
+// time they're needed, until InvalidateLayers is called, and are masked themselves.
+func (m *GlobMask) LayerFiles(fsys fs.FS, name string) {
+	m.fsys = fsys
+	m.layerName = name
+	m.state.Store(m.newState())
+}
+
+// LayerName returns the name of the per-directory rule files, empty unless they're enabled. Files with the name are
+// always masked, and shouldn't be written by clients either.
+func (m *GlobMask) LayerName() string {
+	return m.layerName
+}
+
+// InvalidateLayers forgets the rule files read so far, along with the decisions made with them, so that rule files
+// that changed since are read again the next time they're needed.
+func (m *GlobMask) InvalidateLayers() {
+	if m.fsys == nil {
+		return
+	}
+	m.state.Store(m.newState())
+}
+
+// newState returns an empty state, with a decision cache if decisions are cached.
+func (m *GlobMask) newState() *maskState {
+	st := &maskState{layers: map[string]layer{}}
+	if m.cacheSize > 0 {
+		st.cache = newDecisionCache(m.cacheSize)
+	}
+	return st
+}
+
+// PruneHint returns whether every entry below the directory is masked or not, which is the case when the rule that
+// decides the directory, if any, is the last rule that may match anything below it.
+// Rule files can decide the entries below the directory either way, so it can't tell with rule files enabled, and
+// modification time rules can mask entries of their own, so when there are any it can only tell that every entry is
+// masked.
+func (m *GlobMask) PruneHint(dir *index.Entry) index.Prune {
+	if dir == nil || !dir.IsDir || dir.IsSymlink || m.fsys != nil {
+		return index.PruneNone
+	}
+
+	var (
+		parts    []string
+		from     int
+		included = m.exclude
+	)
+	if !dir.IsRoot() {
+		// A rule matching a directory matches everything below it too, so only later rules can change the outcome
+		parts = strings.Split(dir.FSPath, "/")
+		d := m.matchOwn(parts, true)
+		from, included = d.index+1, d.included
+	}
+
+	for _, r := range m.rules[from:] {
+		if r.pattern != nil && mayMatchBelow(r.line, parts) {
+			return index.PruneNone
+		}
+	}
+
+	switch {
+	case !included:
+		return index.PruneMasked
+	case len(m.conditions) > 0:
+		return index.PruneNone
+	default:
+		return index.PruneUnmasked
+	}
+}
+
+// mayMatchBelow returns false if a rule can't match any path below the directory with the given components.
+// It errs on the side of returning true.
+func mayMatchBelow(line string, dir []string) bool {
+	pattern := strings.TrimSuffix(strings.TrimPrefix(line, "!"), "/")
+	if strings.Contains(pattern, `\`) || !strings.Contains(pattern, "/") {
+		// Patterns without a slash match names at any depth
+		return true
+	}
+
+	components := strings.Split(strings.TrimPrefix(pattern, "/"), "/")
+	for i, c := range components {
+		if i >= len(dir) || c == "" || strings.Contains(c, "**") {
+			return true
+		}
+		if matched, err := path.Match(c, dir[i]); err != nil || !matched {
+			return false
+		}
+	}
+	return true
+}
+
+// CacheDecisions enables caching the mask's decisions for up to size of the most recently checked paths, so that
+// repeated requests and listings of large directories don't match every path against every rule again.
+// The mask's own rules never change after they're parsed, so decisions are only forgotten along with the rule files
+// they were made with, see InvalidateLayers. Create a new mask to pick up new rules instead.
+func (m *GlobMask) CacheDecisions(size int) {
+	m.cacheSize = max(size, 0)
+	m.state.Store(m.newState())
+}
+
+// layered returns the rule files in the ancestors of the given path, from the root down.
+func (st *maskState) layered(m *GlobMask, fsPath string) ([]layer, error) {
+	var dirs []string
+	for dir := path.Dir(fsPath); ; dir = path.Dir(dir) {
+		dirs = append(dirs, dir)
+		if dir == "." {
+			break
+		}
+	}
+
+	layers := make([]layer, 0, len(dirs))
+	for i := len(dirs) - 1; i >= 0; i-- {
+		l := st.layer(m, dirs[i])
+		if l.err != nil {
+			return nil, l.err
+		}
+		layers = append(layers, l)
+	}
+
+	return layers, nil
+}
+
+// layer returns the rules of the rule file in the given directory, reading it if it hasn't been already.
+func (st *maskState) layer(m *GlobMask, dir string) layer {
+	st.layersMu.Lock()
+	defer st.layersMu.Unlock()
+
+	if l, ok := st.layers[dir]; ok {
+		return l
+	}
+
+	var l layer
+	rules, err := fs.ReadFile(m.fsys, path.Join(dir, m.layerName))
+	switch {
+	case err == nil:
+		var domain []string
+		if dir != "." {
+			domain = strings.Split(dir, "/")
+		}
+		if l.rules, err = parseRules(string(rules), domain); err != nil {
+			l.err = fmt.Errorf("failed to parse %s: %w", path.Join(dir, m.layerName), err)
+			break
+		}
+		for i := range l.rules {
+			l.rules[i].file = path.Join(dir, m.layerName)
+		}
+	case !errors.Is(err, fs.ErrNotExist):
+		l.err = err
+	}
+	st.layers[dir] = l
 
-	// Check if the path matches the rules
-	return !m.matcher.Match(parts, entry.IsDir)
+	return l
 }
 
 // NewGlobMask creates a new GlobMask from a new-line delimited list of rules.
 // The rules are processed in the order they are given and the last rule takes precedence.
 // Note: GlobMask rules use the same syntax as .gitignore, but instead of selecting files to ignore -- like Git does -- GlobMask uses them to select files to include in the index.
+// Rules like mtime:<30d or mtime:>30d also only expose the files modified less or more than 30 days ago, wherever
+// they're listed, in either mode. Ages are given in days, weeks like 2w, or the units of time.ParseDuration.
 func NewGlobMask(rules string) (*GlobMask, error) {
-	var patterns []gitignore.Pattern
+	return newGlobMask(rules, false)
+}
+
+// NewExcludeGlobMask creates a new GlobMask that uses its rules the way Git does, as a deny list selecting files to mask.
+// Entries not selected by any rule are included, and negated rules re-include entries masked by earlier rules,
+// so existing .gitignore files can be used as they are. Per-directory rule files are used the same way.
+func NewExcludeGlobMask(rules string) (*GlobMask, error) {
+	return newGlobMask(rules, true)
+}
+
+func newGlobMask(rules string, exclude bool) (*GlobMask, error) {
+	parsed, err := parseRules(rules, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	m := &GlobMask{
+		rules:   parsed,
+		index:   newRuleIndex(parsed),
+		exclude: exclude,
+		clock:   clock.Real,
+	}
+	for i := range m.rules {
+		if m.rules[i].condition != nil {
+			m.conditions = append(m.conditions, ruleRef{rule: &m.rules[i], index: i})
+		}
+	}
+	m.state.Store(m.newState())
+
+	return m, nil
+}
+
+// SetClock sets the clock modification time rules are checked with, which defaults to the system's wall clock.
+func (m *GlobMask) SetClock(c clock.Clock) {
+	m.clock = c
+}
+
+// parseRules parses a new-line delimited list of rules, scoping them to the given domain.
+func parseRules(rules string, domain []string) ([]rule, error) {
+	var parsed []rule
 	for _, line := range strings.Split(rules, "\n") {
 		line = strings.TrimSpace(line)
 		if line == "" || strings.HasPrefix(line, "#") {
 			continue
 		}
-		patterns = append(patterns, gitignore.ParsePattern(line, nil))
+
+		condition, err := parseModTimeCondition(line)
+		if err != nil {
+			return nil, err
+		}
+		if condition != nil {
+			parsed = append(parsed, rule{line: line, condition: condition})
+			continue
+		}
+
+		parsed = append(parsed, rule{
+			line:    line,
+			pattern: gitignore.ParsePattern(line, domain),
+		})
 	}
 
-	return &GlobMask{
-		matcher: gitignore.NewMatcher(patterns),
-	}, nil
+	return parsed, nil
 }
@@ -0,0 +1,260 @@
+package mask
+
+import (
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/njhale/maskfs/pkg/clock"
+	"github.com/njhale/maskfs/pkg/index"
+)
+
+func TestGlobMaskLayerFiles(t *testing.T) {
+	fsys := fstest.MapFS{
+		"public/.maskfs":          {Data: []byte("!*.key\n")},
+		"public/docs/.maskfs":     {Data: []byte("**\n")},
+		"public/nested/.maskfs":   {Data: []byte("!secret/\n")},
+		"private/.maskfs":         {Data: []byte("**\n")},
+		"broken/.maskfs/x":        {},
+		"public/nested/a.txt":     {},
+		"public/nested/secret/b":  {},
+		"public/docs/c.md":        {},
+		"public/server.key":       {},
+		"private/d.txt":           {},
+		"public/docs/server.key":  {},
+		"public/nested/e.key":     {},
+		"public/nested/secret/.x": {},
+		"broken/f.txt":            {},
+	}
+
+	for _, tt := range []struct {
+		name    string
+		exclude bool
+		rules   string
+		path    string
+		isDir   bool
+		masked  bool
+	}{
+		{name: "own rules include", rules: "public/**", path: "public/nested/a.txt"},
+		{name: "layer masks below its directory", rules: "public/**", path: "public/server.key", masked: true},
+		{name: "parent layer masks deeper entries", rules: "public/**", path: "public/nested/e.key", masked: true},
+		{name: "deeper layer unmasks what a parent layer masks", rules: "public/**", path: "public/docs/server.key"},
+		{name: "layer unmasks what own rules mask", rules: "public/**\n!*.md", path: "public/docs/c.md"},
+		{name: "layer masks a directory", rules: "public/**", path: "public/nested/secret", isDir: true, masked: true},
+		{name: "layer masks below a masked directory", rules: "public/**", path: "public/nested/secret/b", masked: true},
+		{name: "layer doesn't apply above its directory", rules: "", path: "public/README", masked: true},
+		{name: "unreadable layer masks", rules: "**", path: "broken/f.txt", masked: true},
+		{name: "selection in a layer widens", rules: "public/**", path: "private/d.txt"},
+		{name: "rule files are masked", rules: "**", path: "public/.maskfs", masked: true},
+		{name: "exclude mode layer selection masks what own rules mask", exclude: true, rules: "private/\n", path: "private/d.txt", masked: true},
+		{name: "exclude mode layer selection masks", exclude: true, rules: "", path: "public/docs/c.md", masked: true},
+		{name: "exclude mode layer negation unmasks", exclude: true, rules: "**/*.key", path: "public/server.key"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			var (
+				m   *GlobMask
+				err error
+			)
+			if tt.exclude {
+				m, err = NewExcludeGlobMask(tt.rules)
+			} else {
+				m, err = NewGlobMask(tt.rules)
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			m.LayerFiles(fsys, ".maskfs")
+			m.CacheDecisions(16)
+
+			if masked := m.Masked(entry(tt.path, tt.isDir)); masked != tt.masked {
+				t.Errorf("Masked(%q) = %t, want %t", tt.path, masked, tt.masked)
+			}
+		})
+	}
+}
+
+func TestGlobMaskInvalidateLayers(t *testing.T) {
+	fsys := fstest.MapFS{
+		"dir/file": {},
+	}
+	m, err := NewGlobMask("**")
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.LayerFiles(fsys, ".maskfs")
+	m.CacheDecisions(16)
+
+	file := entry("dir/file", false)
+	if m.Masked(file) {
+		t.Fatal("file masked before a rule file masks it")
+	}
+
+	fsys["dir/.maskfs"] = &fstest.MapFile{Data: []byte("!file\n")}
+	if m.Masked(file) {
+		t.Fatal("cached decision forgotten before the layers were invalidated")
+	}
+	m.InvalidateLayers()
+	if !m.Masked(file) {
+		t.Fatal("file unmasked after the layers were invalidated")
+	}
+}
+
+func TestGlobMaskPruneHint(t *testing.T) {
+	for _, tt := range []struct {
+		name   string
+		rules  string
+		layers bool
+		dir    string
+		want   index.Prune
+	}{
+		{name: "masked directory", rules: "a/**", dir: "b", want: index.PruneMasked},
+		{name: "included directory", rules: "a/", dir: "a", want: index.PruneUnmasked},
+		{name: "masked directory with layers", rules: "a/**", layers: true, dir: "b", want: index.PruneNone},
+		{name: "included directory with layers", rules: "a/", layers: true, dir: "a", want: index.PruneNone},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			m, err := NewGlobMask(tt.rules)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.layers {
+				m.LayerFiles(fstest.MapFS{}, ".maskfs")
+			}
+
+			if got := m.PruneHint(entry(tt.dir, true)); got != tt.want {
+				t.Errorf("PruneHint(%q) = %v, want %v", tt.dir, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGlobMaskModTime(t *testing.T) {
+	now := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	fsys := fstest.MapFS{
+		"old/.maskfs": {Data: []byte("mtime:<7d\n")},
+	}
+
+	for _, tt := range []struct {
+		name    string
+		exclude bool
+		rules   string
+		path    string
+		isDir   bool
+		age     time.Duration
+		want    bool
+	}{
+		{name: "recent file", rules: "**\nmtime:<30d", path: "a.txt", age: 24 * time.Hour, want: false},
+		{name: "old file", rules: "**\nmtime:<30d", path: "a.txt", age: 31 * 24 * time.Hour, want: true},
+		{name: "old directory", rules: "**\nmtime:<30d", path: "dir", isDir: true, age: 365 * 24 * time.Hour, want: false},
+		{name: "rule order doesn't matter", rules: "mtime:<2w\n**", path: "a.txt", age: 15 * 24 * time.Hour, want: true},
+		{name: "older than", rules: "**\nmtime:>12h", path: "a.txt", age: time.Hour, want: true},
+		{name: "older than satisfied", rules: "**\nmtime:>12h", path: "a.txt", age: 13 * time.Hour, want: false},
+		{name: "path rules mask first", rules: "*.md\nmtime:<30d", path: "a.txt", age: time.Hour, want: true},
+		{name: "exclude mode", exclude: true, rules: "mtime:<30d", path: "a.txt", age: 31 * 24 * time.Hour, want: true},
+		{name: "rule file", rules: "**", path: "old/a.txt", age: 8 * 24 * time.Hour, want: true},
+		{name: "rule file outside its directory", rules: "**", path: "a.txt", age: 8 * 24 * time.Hour, want: false},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			var (
+				m   *GlobMask
+				err error
+			)
+			if tt.exclude {
+				m, err = NewExcludeGlobMask(tt.rules)
+			} else {
+				m, err = NewGlobMask(tt.rules)
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			m.SetClock(clock.Fixed(now))
+			m.LayerFiles(fsys, ".maskfs")
+			m.CacheDecisions(16)
+
+			e := entry(tt.path, tt.isDir)
+			e.ModTime = now.Add(-tt.age)
+			// Check twice, so that the second decision comes from the cache
+			for range 2 {
+				if got := m.Masked(e); got != tt.want {
+					t.Errorf("Masked(%q) = %v, want %v", tt.path, got, tt.want)
+				}
+			}
+		})
+	}
+
+	// Cached decisions still check the modification time, which changes without the path changing
+	m, err := NewGlobMask("**\nmtime:<30d")
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.SetClock(clock.Fixed(now))
+	m.CacheDecisions(16)
+	e := entry("a.txt", false)
+	e.ModTime = now
+	if m.Masked(e) {
+		t.Error("Masked(a.txt) = true for a recent file")
+	}
+	e.ModTime = now.Add(-31 * 24 * time.Hour)
+	if !m.Masked(e) {
+		t.Error("Masked(a.txt) = false for an old file with a cached decision")
+	}
+
+	// Modification time rules can mask the files below an included directory, but not include those below a masked one
+	m, err = NewGlobMask("mtime:<30d\n**\n!secret/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := m.PruneHint(entry("dir", true)); got != index.PruneNone {
+		t.Errorf("PruneHint(dir) = %v, want %v", got, index.PruneNone)
+	}
+	if got := m.PruneHint(entry("secret", true)); got != index.PruneMasked {
+		t.Errorf("PruneHint(secret) = %v, want %v", got, index.PruneMasked)
+	}
+
+	for _, rules := range []string{"mtime:30d", "mtime:<", "mtime:<-1d", "mtime:<soon", "!mtime:<30d"} {
+		if _, err := NewGlobMask(rules); err == nil {
+			t.Errorf("NewGlobMask(%q) succeeded, want an error", rules)
+		}
+	}
+}
+
+func TestGlobMaskExplain(t *testing.T) {
+	fsys := fstest.MapFS{
+		"dir/.maskfs": {Data: []byte("!*.key\n")},
+	}
+	m, err := NewGlobMask("**\n!*.tmp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.LayerFiles(fsys, ".maskfs")
+
+	for _, tt := range []struct {
+		path     string
+		masked   bool
+		rule     string
+		index    int
+		ruleFile string
+	}{
+		{path: "dir/a.txt", rule: "**", index: 0},
+		{path: "dir/a.tmp", masked: true, rule: "!*.tmp", index: 1},
+		{path: "dir/a.key", masked: true, rule: "!*.key", index: 2, ruleFile: "dir/.maskfs"},
+		{path: "dir/.maskfs", masked: true, index: -1},
+	} {
+		masked, rule, i := m.Explain(entry(tt.path, false))
+		if masked != tt.masked || rule != tt.rule || i != tt.index {
+			t.Errorf("Explain(%q) = %t, %q, %d, want %t, %q, %d", tt.path, masked, rule, i, tt.masked, tt.rule, tt.index)
+		}
+		if ruleFile := m.RuleFile(entry(tt.path, false)); ruleFile != tt.ruleFile {
+			t.Errorf("RuleFile(%q) = %q, want %q", tt.path, ruleFile, tt.ruleFile)
+		}
+	}
+
+	// Without rule files, unmatched entries are decided by the mode
+	exclude, err := NewExcludeGlobMask("*.key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if masked, rule, i := exclude.Explain(entry("a.txt", false)); masked || rule != "" || i != -1 {
+		t.Errorf("Explain(a.txt) in exclude mode = %t, %q, %d, want false, \"\", -1", masked, rule, i)
+	}
+}
@@ -0,0 +1,55 @@
+package mask
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/njhale/maskfs/pkg/index"
+)
+
+// JunkMask masks entries whose names match junk file patterns, like editor backups and OS metadata files.
+type JunkMask struct {
+	patterns []string
+}
+
+func (m *JunkMask) Masked(entry *index.Entry) bool {
+	if entry == nil {
+		// The entry is not valid, mask it
+		return true
+	}
+
+	for _, pattern := range m.patterns {
+		if matched, _ := path.Match(pattern, entry.Name); matched {
+			return true
+		}
+	}
+
+	return false
+}
+
+// PruneHint only decides the entries below a directory if there are no patterns, since any name might be junk.
+func (m *JunkMask) PruneHint(*index.Entry) index.Prune {
+	if len(m.patterns) == 0 {
+		return index.PruneUnmasked
+	}
+	return index.PruneNone
+}
+
+// NewJunkMask creates a new JunkMask from a new-line delimited list of name patterns.
+// Patterns use path.Match syntax and are matched against entry names only, so a pattern like "#*#" is not a comment.
+func NewJunkMask(patterns string) (*JunkMask, error) {
+	m := &JunkMask{}
+	for _, line := range strings.Split(patterns, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		if _, err := path.Match(line, ""); err != nil {
+			return nil, fmt.Errorf("invalid junk pattern %q: %w", line, err)
+		}
+		m.patterns = append(m.patterns, line)
+	}
+
+	return m, nil
+}
@@ -0,0 +1,67 @@
+package mask
+
+import (
+	"path"
+	"testing"
+
+	"github.com/njhale/maskfs/pkg/index"
+)
+
+func TestJunkMask(t *testing.T) {
+	for _, tt := range []struct {
+		name     string
+		patterns string
+		masked   []string
+		unmasked []string
+	}{
+		{
+			name:     "backups and metadata",
+			patterns: "*~\n.DS_Store\nThumbs.db\n#*#",
+			masked:   []string{"notes.txt~", ".DS_Store", "Thumbs.db", "#notes.txt#", "dir/.DS_Store"},
+			unmasked: []string{"notes.txt", "DS_Store", "#notes.txt", "thumbs.db~x"},
+		},
+		{
+			name:     "names only",
+			patterns: "*.bak",
+			masked:   []string{"a.bak", "dir/b.bak"},
+			unmasked: []string{"a.bak.txt", "dir.bak/c.txt"},
+		},
+		{
+			name:     "blank lines and spaces",
+			patterns: "\n  *.tmp  \n\n",
+			masked:   []string{"a.tmp"},
+			unmasked: []string{"a.txt"},
+		},
+		{
+			name:     "none",
+			patterns: "",
+			unmasked: []string{"a.txt~", ".DS_Store"},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			m, err := NewJunkMask(tt.patterns)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, p := range tt.masked {
+				if !m.Masked(entry(p, false)) {
+					t.Errorf("Masked(%q) = false, want true", p)
+				}
+			}
+			for _, p := range tt.unmasked {
+				if m.Masked(entry(p, false)) {
+					t.Errorf("Masked(%q) = true, want false", p)
+				}
+			}
+		})
+	}
+
+	if _, err := NewJunkMask("[a-"); err == nil {
+		t.Error("NewJunkMask() accepted a malformed pattern")
+	}
+}
+
+// entry returns an entry at the given path.
+func entry(fsPath string, isDir bool) *index.Entry {
+	return &index.Entry{Name: path.Base(fsPath), FSPath: fsPath, IsDir: isDir}
+}
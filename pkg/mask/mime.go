@@ -0,0 +1,97 @@
+package mask
+
+import (
+	"fmt"
+	"mime"
+	"path"
+	"strings"
+
+	"github.com/njhale/maskfs/pkg/index"
+)
+
+// MIMEMask masks files by the MIME type of their content, sniffed from their first bytes, like binaries in a tree
+// that should only expose text. Directories are never masked by content type.
+//
+// Sniffing a file means opening it, so combine MIMEMasks after cheaper masks with All, which stops at the first mask
+// that masks an entry, to only sniff the files that the other masks include.
+type MIMEMask struct {
+	patterns []string
+	exclude  bool // Patterns select what to mask instead of what to include
+}
+
+func (m *MIMEMask) Masked(entry *index.Entry) bool {
+	if entry == nil {
+		// The entry is not valid, mask it
+		return true
+	}
+	if entry.IsDir {
+		return false
+	}
+
+	contentType, err := entry.ContentType()
+	if err != nil {
+		// The file can't be shown to have an included type, so mask it
+		return true
+	}
+	if contentType == "" {
+		// Entries without content to sniff, like the targets of uploads, can't be decided by their type
+		return false
+	}
+
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return true
+	}
+
+	for _, pattern := range m.patterns {
+		if matched, _ := path.Match(pattern, mediaType); matched {
+			return m.exclude
+		}
+	}
+	return !m.exclude
+}
+
+// NewMIMEMask creates a new MIMEMask that includes only the files whose content type matches one of a new-line
+// delimited list of patterns, like text/* or application/json. Patterns use path.Match syntax and are matched against
+// types as detected by http.DetectContentType, without parameters, so binary files are application/octet-stream.
+func NewMIMEMask(patterns string) (*MIMEMask, error) {
+	parsed, err := parseMIMEPatterns(patterns)
+	if err != nil {
+		return nil, err
+	}
+
+	return &MIMEMask{
+		patterns: parsed,
+	}, nil
+}
+
+// NewExcludeMIMEMask creates a new MIMEMask that masks the files whose content type matches one of the patterns,
+// which are the same as NewMIMEMask's.
+func NewExcludeMIMEMask(patterns string) (*MIMEMask, error) {
+	parsed, err := parseMIMEPatterns(patterns)
+	if err != nil {
+		return nil, err
+	}
+
+	return &MIMEMask{
+		patterns: parsed,
+		exclude:  true,
+	}, nil
+}
+
+// parseMIMEPatterns validates a new-line delimited list of content type patterns.
+func parseMIMEPatterns(patterns string) ([]string, error) {
+	var parsed []string
+	for _, line := range strings.Split(patterns, "\n") {
+		line = strings.ToLower(strings.TrimSpace(line))
+		if line == "" {
+			continue
+		}
+		if _, err := path.Match(line, ""); err != nil || !strings.Contains(line, "/") {
+			return nil, fmt.Errorf("invalid content type pattern %q, must be a type like text/* or application/json", line)
+		}
+		parsed = append(parsed, line)
+	}
+
+	return parsed, nil
+}
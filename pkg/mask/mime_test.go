@@ -0,0 +1,120 @@
+package mask
+
+import (
+	"io/fs"
+	"testing"
+	"testing/fstest"
+
+	"github.com/njhale/maskfs/pkg/index"
+)
+
+// openCounter counts the files opened from a filesystem.
+type openCounter struct {
+	fs.FS
+	opened map[string]int
+}
+
+func (c *openCounter) Open(name string) (fs.File, error) {
+	c.opened[name]++
+	return c.FS.Open(name)
+}
+
+func TestMIMEMask(t *testing.T) {
+	fsys := fstest.MapFS{
+		"a.txt":   {Data: []byte("plain text\n")},
+		"b.html":  {Data: []byte("<!DOCTYPE html><html></html>")},
+		"c.bin":   {Data: []byte{0x00, 0x01, 0x02, 0xff}},
+		"d.json":  {Data: []byte(`{"a": 1}`)},
+		"e.png":   {Data: []byte("\x89PNG\r\n\x1a\n")},
+		"empty":   {Data: nil},
+		"dir/f.c": {Data: []byte("int main() {}")},
+	}
+
+	for _, tt := range []struct {
+		name     string
+		exclude  bool
+		patterns string
+		path     string
+		masked   bool
+	}{
+		{name: "matching type", patterns: "text/*", path: "a.txt"},
+		{name: "matching html", patterns: "text/*", path: "b.html"},
+		{name: "other type", patterns: "text/*", path: "c.bin", masked: true},
+		{name: "case insensitive", patterns: "IMAGE/PNG", path: "e.png"},
+		{name: "several patterns", patterns: "image/*\napplication/octet-stream", path: "c.bin"},
+		{name: "empty file", patterns: "text/*", path: "empty"},
+		{name: "directory", patterns: "image/*", path: "dir"},
+		{name: "exclude matching", exclude: true, patterns: "application/octet-stream", path: "c.bin", masked: true},
+		{name: "exclude other", exclude: true, patterns: "application/octet-stream", path: "a.txt"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			var (
+				m   *MIMEMask
+				err error
+			)
+			if tt.exclude {
+				m, err = NewExcludeMIMEMask(tt.patterns)
+			} else {
+				m, err = NewMIMEMask(tt.patterns)
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			e, err := index.GetEntry(fsys, tt.path)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if masked := m.Masked(e); masked != tt.masked {
+				t.Errorf("Masked(%q) = %t, want %t", tt.path, masked, tt.masked)
+			}
+		})
+	}
+
+	for _, patterns := range []string{"text", "text/[", "*"} {
+		if _, err := NewMIMEMask(patterns); err == nil {
+			t.Errorf("NewMIMEMask(%q) succeeded", patterns)
+		}
+	}
+
+	// Entries that weren't read from a filesystem, like the targets of uploads, aren't masked by type
+	m, err := NewMIMEMask("image/*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Masked(entry("new.txt", false)) {
+		t.Error("Masked() masked an entry without content")
+	}
+}
+
+func TestMIMEMaskSniffsOnce(t *testing.T) {
+	counter := &openCounter{
+		FS:     fstest.MapFS{"a.txt": {Data: []byte("text")}, "b.key": {Data: []byte("key")}},
+		opened: map[string]int{},
+	}
+	glob, err := NewGlobMask("**\n!*.key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	types, err := NewMIMEMask("text/*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := All(glob, types)
+
+	for _, name := range []string{"a.txt", "b.key"} {
+		e, err := index.GetEntry(counter, name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// Only count the opens to sniff the file, not those to stat it
+		delete(counter.opened, name)
+		m.Masked(e)
+		m.Masked(e)
+	}
+
+	// Files are sniffed once per entry, and files masked by an earlier mask aren't sniffed at all
+	if counter.opened["a.txt"] != 1 || counter.opened["b.key"] != 0 {
+		t.Errorf("opened %v, want a.txt once and b.key never", counter.opened)
+	}
+}
@@ -0,0 +1,41 @@
+package mask
+
+import (
+	"io/fs"
+
+	"github.com/njhale/maskfs/pkg/index"
+)
+
+// ModeMask masks entries whose file mode satisfies a predicate.
+type ModeMask struct {
+	predicate func(mode fs.FileMode) bool
+}
+
+func (m *ModeMask) Masked(entry *index.Entry) bool {
+	if entry == nil {
+		// The entry is not valid, mask it
+		return true
+	}
+
+	return m.predicate(entry.Mode)
+}
+
+// NewModeMask creates a new ModeMask that masks entries for which the predicate returns true.
+func NewModeMask(predicate func(mode fs.FileMode) bool) *ModeMask {
+	return &ModeMask{
+		predicate: predicate,
+	}
+}
+
+// WorldWritable is a ModeMask predicate matching modes that grant write permission to everyone.
+func WorldWritable(mode fs.FileMode) bool {
+	return mode.Perm()&0o002 != 0
+}
+
+// LacksPerm returns a ModeMask predicate matching modes that lack any of the given permission bits,
+// e.g. 0o004 to mask entries that aren't world-readable.
+func LacksPerm(perm fs.FileMode) func(mode fs.FileMode) bool {
+	return func(mode fs.FileMode) bool {
+		return mode.Perm()&perm != perm
+	}
+}
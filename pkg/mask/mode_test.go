@@ -0,0 +1,64 @@
+package mask
+
+import (
+	"io/fs"
+	"testing"
+
+	"github.com/njhale/maskfs/pkg/index"
+)
+
+func TestModeMask(t *testing.T) {
+	m := NewModeMask(WorldWritable)
+
+	for _, tt := range []struct {
+		mode   fs.FileMode
+		masked bool
+	}{
+		{mode: 0o644},
+		{mode: 0o664},
+		{mode: 0o666, masked: true},
+		{mode: 0o777 | fs.ModeDir, masked: true},
+		{mode: 0o755 | fs.ModeDir},
+		{mode: 0o1777 | fs.ModeDir | fs.ModeSticky, masked: true},
+	} {
+		if masked := m.Masked(&index.Entry{Name: "a", FSPath: "a", Mode: tt.mode}); masked != tt.masked {
+			t.Errorf("Masked(%v) = %t, want %t", tt.mode, masked, tt.masked)
+		}
+	}
+	if !m.Masked(nil) {
+		t.Error("Masked(nil) = false, want true")
+	}
+
+	// Combined with a glob mask, world-writable entries are masked even when the rules include them
+	glob, err := NewGlobMask("**")
+	if err != nil {
+		t.Fatal(err)
+	}
+	combined := All(glob, m)
+	if combined.Masked(&index.Entry{Name: "a", FSPath: "a", Mode: 0o644}) || !combined.Masked(&index.Entry{Name: "b", FSPath: "b", Mode: 0o666}) {
+		t.Error("All(glob, world writable) doesn't mask exactly the world-writable entry")
+	}
+}
+
+func TestLacksPerm(t *testing.T) {
+	m := NewModeMask(LacksPerm(0o004))
+
+	for _, tt := range []struct {
+		mode   fs.FileMode
+		masked bool
+	}{
+		{mode: 0o644},
+		{mode: 0o755 | fs.ModeDir},
+		{mode: 0o640, masked: true},
+		{mode: 0o700 | fs.ModeDir, masked: true},
+	} {
+		if masked := m.Masked(&index.Entry{Name: "a", FSPath: "a", Mode: tt.mode}); masked != tt.masked {
+			t.Errorf("Masked(%v) = %t, want %t", tt.mode, masked, tt.masked)
+		}
+	}
+
+	// Every bit is required
+	if m := NewModeMask(LacksPerm(0o044)); !m.Masked(&index.Entry{Name: "a", FSPath: "a", Mode: 0o604}) {
+		t.Error("LacksPerm(0o044) doesn't mask 0o604")
+	}
+}
@@ -0,0 +1,71 @@
+package mask
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// modTimePrefix starts the rules that select files by when they were last modified rather than by their paths.
+const modTimePrefix = "mtime:"
+
+// modTimeCondition is a rule like mtime:<30d, which only exposes files modified less than 30 days ago, for sharing
+// recent work, or mtime:>30d, which only exposes files modified more than 30 days ago.
+// Directories are never masked by modification time, since theirs doesn't change when the files below them do.
+type modTimeCondition struct {
+	within bool // Files have to be modified within age of now, instead of before
+	age    time.Duration
+}
+
+// satisfied returns true if a file modified at the given time is exposed by the condition at the given time.
+// Symlinks are matched by the modification time of their targets.
+func (c *modTimeCondition) satisfied(modTime, now time.Time) bool {
+	if c.within {
+		return modTime.After(now.Add(-c.age))
+	}
+	return modTime.Before(now.Add(-c.age))
+}
+
+// parseModTimeCondition parses a modification time rule, returning nil if the line isn't one.
+func parseModTimeCondition(line string) (*modTimeCondition, error) {
+	negated := strings.HasPrefix(line, "!")
+	condition, ok := strings.CutPrefix(strings.TrimPrefix(line, "!"), modTimePrefix)
+	if !ok {
+		return nil, nil
+	}
+	if negated {
+		return nil, fmt.Errorf("invalid rule %q, modification time rules can't be negated", line)
+	}
+
+	c := &modTimeCondition{}
+	switch {
+	case strings.HasPrefix(condition, "<"):
+		c.within = true
+	case strings.HasPrefix(condition, ">"):
+	default:
+		return nil, fmt.Errorf("invalid rule %q, must compare the modification time with < or >, like %s<30d", line, modTimePrefix)
+	}
+
+	age, err := parseAge(condition[1:])
+	if err != nil || age <= 0 {
+		return nil, fmt.Errorf("invalid rule %q, must compare the modification time with a positive age like 30d, 2w, or 12h", line)
+	}
+	c.age = age
+
+	return c, nil
+}
+
+// parseAge parses an age in whole days like 30d or weeks like 2w, or in the units of time.ParseDuration.
+func parseAge(age string) (time.Duration, error) {
+	for suffix, unit := range map[string]time.Duration{"d": 24 * time.Hour, "w": 7 * 24 * time.Hour} {
+		if n, ok := strings.CutSuffix(age, suffix); ok {
+			count, err := strconv.Atoi(n)
+			if err != nil {
+				return 0, fmt.Errorf("invalid age %q", age)
+			}
+			return time.Duration(count) * unit, nil
+		}
+	}
+	return time.ParseDuration(age)
+}
@@ -0,0 +1,29 @@
+package mask
+
+import (
+	"github.com/njhale/maskfs/pkg/index"
+)
+
+// OwnerMask masks entries that aren't owned by a given user or group, so that only the files of a user are exposed.
+// Entries whose owner isn't known, because their filesystem doesn't report one, are masked too.
+type OwnerMask struct {
+	uid, gid int // -1 to allow any
+}
+
+func (m *OwnerMask) Masked(entry *index.Entry) bool {
+	if entry == nil || entry.Owner == nil {
+		// The entry is not valid or its owner is unknown, mask it
+		return true
+	}
+
+	return (m.uid >= 0 && entry.Owner.UID != uint32(m.uid)) || (m.gid >= 0 && entry.Owner.GID != uint32(m.gid))
+}
+
+// NewOwnerMask creates a new OwnerMask that masks entries not owned by the given user and group.
+// Either can be -1 to allow entries owned by any user or group.
+func NewOwnerMask(uid, gid int) *OwnerMask {
+	return &OwnerMask{
+		uid: uid,
+		gid: gid,
+	}
+}
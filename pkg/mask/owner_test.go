@@ -0,0 +1,35 @@
+package mask
+
+import (
+	"testing"
+
+	"github.com/njhale/maskfs/pkg/index"
+)
+
+func TestOwnerMask(t *testing.T) {
+	owned := func(uid, gid uint32) *index.Entry {
+		e := entry("a.txt", false)
+		e.Owner = &index.Owner{UID: uid, GID: gid}
+		return e
+	}
+
+	for _, tt := range []struct {
+		name     string
+		uid, gid int
+		entry    *index.Entry
+		masked   bool
+	}{
+		{name: "owned by user", uid: 1000, gid: -1, entry: owned(1000, 50)},
+		{name: "owned by other user", uid: 1000, gid: -1, entry: owned(1001, 50), masked: true},
+		{name: "owned by group", uid: -1, gid: 50, entry: owned(1001, 50)},
+		{name: "owned by other group", uid: -1, gid: 50, entry: owned(1000, 51), masked: true},
+		{name: "owned by user and group", uid: 1000, gid: 50, entry: owned(1000, 50)},
+		{name: "owned by user but not group", uid: 1000, gid: 50, entry: owned(1000, 51), masked: true},
+		{name: "unknown owner", uid: -1, gid: -1, entry: entry("a.txt", false), masked: true},
+		{name: "nil entry", uid: -1, gid: -1, masked: true},
+	} {
+		if masked := NewOwnerMask(tt.uid, tt.gid).Masked(tt.entry); masked != tt.masked {
+			t.Errorf("%s: Masked() = %t, want %t", tt.name, masked, tt.masked)
+		}
+	}
+}
@@ -0,0 +1,105 @@
+package mask
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/njhale/maskfs/pkg/index"
+)
+
+// RegexMask is a mask whose rules are RE2 regular expressions matched against the paths of entries relative to the
+// root, for patterns gitignore globs can't express, like directories named only by digits.
+type RegexMask struct {
+	rules   []regexRule
+	exclude bool // Rules select what to mask instead of what to include, see NewExcludeRegexMask
+}
+
+// regexRule is a compiled pattern along with whether it's negated and the line it was parsed from.
+type regexRule struct {
+	line    string
+	pattern *regexp.Regexp
+	negated bool
+}
+
+func (m *RegexMask) Masked(entry *index.Entry) bool {
+	masked, _, _ := m.Explain(entry)
+	return masked
+}
+
+// Explain returns whether the entry is masked along with the rule that decided it and the rule's index.
+// The rule is empty and the index is -1 if no rule matched the entry.
+func (m *RegexMask) Explain(entry *index.Entry) (masked bool, rule string, index int) {
+	if entry == nil {
+		// The entry is not valid, mask it
+		return true, "", -1
+	}
+
+	// The last matching rule takes precedence
+	for i := len(m.rules) - 1; i >= 0; i-- {
+		if m.rules[i].pattern.MatchString(entry.FSPath) {
+			return m.rules[i].negated != m.exclude, m.rules[i].line, i
+		}
+	}
+
+	// Entries not selected by any rule are only included in exclude mode
+	return !m.exclude, "", -1
+}
+
+// Explicit always returns false, since a regular expression doesn't name entries literally the way a glob can.
+func (m *RegexMask) Explicit(*index.Entry) bool {
+	return false
+}
+
+// NewRegexMask creates a new RegexMask from a new-line delimited list of rules selecting the entries to include.
+// Every rule is an RE2 regular expression matched against entry paths, like "a/b.txt" for a file in directory a.
+// Patterns match anywhere in the path unless anchored with ^ and $.
+// Like glob rules, lines starting with # are comments, a leading ! negates a rule, and the last matching rule takes
+// precedence. Escape a leading # or ! with a backslash to match it literally.
+func NewRegexMask(rules string) (*RegexMask, error) {
+	parsed, err := parseRegexRules(rules)
+	if err != nil {
+		return nil, err
+	}
+
+	return &RegexMask{
+		rules: parsed,
+	}, nil
+}
+
+// NewExcludeRegexMask creates a new RegexMask whose rules select the entries to mask, the way NewExcludeGlobMask does.
+func NewExcludeRegexMask(rules string) (*RegexMask, error) {
+	parsed, err := parseRegexRules(rules)
+	if err != nil {
+		return nil, err
+	}
+
+	return &RegexMask{
+		rules:   parsed,
+		exclude: true,
+	}, nil
+}
+
+// parseRegexRules compiles a new-line delimited list of rules.
+func parseRegexRules(rules string) ([]regexRule, error) {
+	var parsed []regexRule
+	for _, line := range strings.Split(rules, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		negated := strings.HasPrefix(line, "!")
+		pattern, err := regexp.Compile(strings.TrimPrefix(line, "!"))
+		if err != nil {
+			return nil, fmt.Errorf("invalid regex rule %q: %w", line, err)
+		}
+		parsed = append(parsed, regexRule{
+			line:    line,
+			pattern: pattern,
+			negated: negated,
+		})
+	}
+
+	return parsed, nil
+}
@@ -0,0 +1,75 @@
+package mask
+
+import (
+	"testing"
+)
+
+func TestRegexMask(t *testing.T) {
+	for _, tt := range []struct {
+		name    string
+		exclude bool
+		rules   string
+		path    string
+		masked  bool
+	}{
+		{name: "unselected", rules: `\.txt$`, path: "a.md", masked: true},
+		{name: "selected", rules: `\.txt$`, path: "dir/a.txt"},
+		{name: "unanchored", rules: `logs/`, path: "var/logs/a"},
+		{name: "anchored", rules: `^logs/`, path: "var/logs/a", masked: true},
+		{name: "digits", rules: `(^|/)[0-9]+(/|$)`, path: "build/2024/a"},
+		{name: "digits only", rules: `(^|/)[0-9]+(/|$)`, path: "build/v2024/a", masked: true},
+		{name: "negated", rules: "\\.txt$\n!^secret/", path: "secret/a.txt", masked: true},
+		{name: "last rule wins", rules: "!^secret/\n\\.txt$", path: "secret/a.txt"},
+		{name: "comment", rules: "# .*\n\\.md$", path: "a.txt", masked: true},
+		{name: "escaped comment", rules: `\#`, path: "#a#"},
+		{name: "exclude unselected", exclude: true, rules: `\.key$`, path: "a.txt"},
+		{name: "exclude selected", exclude: true, rules: `\.key$`, path: "a.key", masked: true},
+		{name: "exclude negated", exclude: true, rules: "\\.key$\n!^public\\.key$", path: "public.key"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			var (
+				m   *RegexMask
+				err error
+			)
+			if tt.exclude {
+				m, err = NewExcludeRegexMask(tt.rules)
+			} else {
+				m, err = NewRegexMask(tt.rules)
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if masked := m.Masked(entry(tt.path, false)); masked != tt.masked {
+				t.Errorf("Masked(%q) = %t, want %t", tt.path, masked, tt.masked)
+			}
+		})
+	}
+
+	if _, err := NewRegexMask("a(b"); err == nil {
+		t.Error("NewRegexMask() accepted an invalid regular expression")
+	}
+}
+
+func TestRegexMaskExplain(t *testing.T) {
+	m, err := NewRegexMask("\\.txt$\n!^secret/")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		path   string
+		masked bool
+		rule   string
+		index  int
+	}{
+		{path: "a.txt", rule: `\.txt$`, index: 0},
+		{path: "secret/a.txt", masked: true, rule: "!^secret/", index: 1},
+		{path: "a.md", masked: true, index: -1},
+	} {
+		masked, rule, i := m.Explain(entry(tt.path, false))
+		if masked != tt.masked || rule != tt.rule || i != tt.index {
+			t.Errorf("Explain(%q) = %t, %q, %d, want %t, %q, %d", tt.path, masked, rule, i, tt.masked, tt.rule, tt.index)
+		}
+	}
+}
@@ -0,0 +1,41 @@
+package mask
+
+import (
+	"fmt"
+
+	"github.com/njhale/maskfs/pkg/index"
+)
+
+// SizeMask masks files whose size is outside of a range, like large binaries and model weights in a source tree.
+// Directories are never masked by size.
+type SizeMask struct {
+	min, max int64
+}
+
+func (m *SizeMask) Masked(entry *index.Entry) bool {
+	if entry == nil {
+		// The entry is not valid, mask it
+		return true
+	}
+	if entry.IsDir {
+		return false
+	}
+
+	return entry.Size < m.min || (m.max > 0 && entry.Size > m.max)
+}
+
+// NewSizeMask creates a new SizeMask that masks files smaller than min or larger than max bytes.
+// A max of 0 means there's no upper limit. Symlinks are masked by the size of their targets.
+func NewSizeMask(min, max int64) (*SizeMask, error) {
+	if min < 0 || max < 0 {
+		return nil, fmt.Errorf("invalid file size range %d-%d, sizes can't be negative", min, max)
+	}
+	if max > 0 && min > max {
+		return nil, fmt.Errorf("invalid file size range %d-%d, the minimum is larger than the maximum", min, max)
+	}
+
+	return &SizeMask{
+		min: min,
+		max: max,
+	}, nil
+}
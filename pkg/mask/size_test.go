@@ -0,0 +1,42 @@
+package mask
+
+import (
+	"testing"
+
+	"github.com/njhale/maskfs/pkg/index"
+)
+
+func TestSizeMask(t *testing.T) {
+	for _, tt := range []struct {
+		name     string
+		min, max int64
+		size     int64
+		isDir    bool
+		masked   bool
+	}{
+		{name: "within range", min: 10, max: 100, size: 50},
+		{name: "at minimum", min: 10, max: 100, size: 10},
+		{name: "at maximum", min: 10, max: 100, size: 100},
+		{name: "too small", min: 10, max: 100, size: 9, masked: true},
+		{name: "too large", min: 10, max: 100, size: 101, masked: true},
+		{name: "no maximum", min: 10, size: 1 << 40},
+		{name: "empty file", min: 1, size: 0, masked: true},
+		{name: "directory", min: 10, max: 100, size: 4096, isDir: true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			m, err := NewSizeMask(tt.min, tt.max)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if masked := m.Masked(&index.Entry{Name: "a", FSPath: "a", Size: tt.size, IsDir: tt.isDir}); masked != tt.masked {
+				t.Errorf("Masked(%d bytes) = %t, want %t", tt.size, masked, tt.masked)
+			}
+		})
+	}
+
+	for _, r := range [][2]int64{{-1, 0}, {0, -1}, {100, 10}} {
+		if _, err := NewSizeMask(r[0], r[1]); err == nil {
+			t.Errorf("NewSizeMask(%d, %d) succeeded", r[0], r[1])
+		}
+	}
+}
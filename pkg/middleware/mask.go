@@ -0,0 +1,52 @@
+// Package middleware applies masks to existing HTTP handlers, so that deployments of http.FileServer, or of any other
+// handler serving a directory, can hide masked files without adopting the maskfs server.
+package middleware
+
+import (
+	"net/http"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/njhale/maskfs/pkg/index"
+)
+
+// Mask returns middleware that responds 404 Not Found to requests for the entries of the directory at root that the
+// mask masks, and passes every other request on to the next handler. Request paths are resolved relative to root the
+// way http.FileServer resolves them, so the middleware belongs after any http.StripPrefix in front of the file server.
+// Like the file server, the root itself is never masked, and paths that don't exist are left to the next handler.
+//
+// Since http.FileServer serves the index.html of a directory in its place, requests for a directory with a masked
+// index.html are not found either. The middleware can't change what the next handler lists, so directory listings
+// still name masked entries. To leave them out as well, serve http.FileServerFS(mask.FS(os.DirFS(root), m)) instead.
+func Mask(m index.Mask, root string) func(http.Handler) http.Handler {
+	fsys := os.DirFS(root)
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			fsPath := strings.TrimPrefix(path.Clean("/"+r.URL.Path), "/")
+			if fsPath == "" {
+				fsPath = "."
+			}
+
+			entry, err := index.GetEntry(fsys, fsPath)
+			if err != nil {
+				next.ServeHTTP(w, r)
+				return
+			}
+			if !entry.IsRoot() && m.Masked(entry) {
+				http.NotFound(w, r)
+				return
+			}
+
+			if entry.IsDir {
+				if page, err := index.GetEntry(fsys, path.Join(fsPath, "index.html")); err == nil && m.Masked(page) {
+					http.NotFound(w, r)
+					return
+				}
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,64 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/njhale/maskfs/pkg/mask"
+)
+
+func TestMask(t *testing.T) {
+	root := t.TempDir()
+	for name, contents := range map[string]string{
+		"a.txt":           "hello",
+		"secret.key":      "secret",
+		"site/index.html": "site",
+		"docs/index.key":  "index",
+		"docs/b.txt":      "docs",
+		"hidden/c.key":    "hidden",
+	} {
+		name = filepath.Join(root, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(name), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(name, []byte(contents), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	m, err := mask.NewGlobMask("**\n!*.key\n!site/index.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := Mask(m, root)(http.FileServerFS(os.DirFS(root)))
+
+	for _, tt := range []struct {
+		path string
+		code int
+	}{
+		{path: "/", code: http.StatusOK},
+		{path: "/a.txt", code: http.StatusOK},
+		{path: "/docs/", code: http.StatusOK},
+		{path: "/missing.txt", code: http.StatusNotFound},
+		{path: "/secret.key", code: http.StatusNotFound},
+		{path: "/hidden/c.key", code: http.StatusNotFound},
+		// Masked files can't be reached by climbing out of and back into the root either
+		{path: "/docs/../secret.key", code: http.StatusNotFound},
+		{path: "/../secret.key", code: http.StatusNotFound},
+		// The file server would serve the masked index.html in place of the directory
+		{path: "/site/", code: http.StatusNotFound},
+		{path: "/site/index.html", code: http.StatusNotFound},
+	} {
+		t.Run(tt.path, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+
+			if w.Code != tt.code {
+				t.Errorf("GET %s = %d, want %d", tt.path, w.Code, tt.code)
+			}
+		})
+	}
+}
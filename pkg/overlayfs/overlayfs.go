@@ -0,0 +1,193 @@
+// Package overlayfs layers several filesystems into one read-only fs.FS, like a simple overlayfs.
+//
+// Later layers shadow earlier ones: a path is served from the last layer it exists in, and directories that exist in
+// several layers are merged, listing the entries of every layer below the one a path is served from for as long as
+// the path is a directory in them. A file, or anything else that isn't a directory, hides the whole tree below its path
+// in earlier layers, so a path only exists in a layer if no later layer has a non-directory along it. There are no
+// whiteouts, so a later layer can't remove an entry of an earlier one. Symlinks are followed within the layer they're
+// in, so a symlink in one layer never resolves to a file of another.
+package overlayfs
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"path"
+	"slices"
+	"strings"
+)
+
+// FS is a read-only filesystem of layers.
+type FS struct {
+	layers []fs.FS // From the bottom layer to the top
+}
+
+// New returns the filesystem of the given layers, from the bottom layer to the top, which shadows the rest.
+func New(layers ...fs.FS) *FS {
+	return &FS{layers: slices.Clone(layers)}
+}
+
+// layer returns the index of the top layer a path exists in, along with its info without following a symlink at the
+// end of the path, which is what decides whether the path shadows earlier layers.
+func (f *FS) layer(op, name string) (int, fs.FileInfo, error) {
+	if !fs.ValidPath(name) {
+		return 0, nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+
+	for i := len(f.layers) - 1; i >= 0; i-- {
+		info, err := fs.Lstat(f.layers[i], name)
+		if err == nil {
+			return i, info, nil
+		}
+		if !errors.Is(err, fs.ErrNotExist) {
+			return 0, nil, err
+		}
+		if f.shadows(i, name) {
+			break
+		}
+	}
+	return 0, nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+}
+
+// shadows returns true if a layer has a non-directory along a path it doesn't have, hiding the path in earlier layers.
+func (f *FS) shadows(i int, name string) bool {
+	if name == "." {
+		return false
+	}
+
+	ancestor := "."
+	for _, part := range strings.Split(path.Dir(name), "/") {
+		if part == "." {
+			break
+		}
+		ancestor = path.Join(ancestor, part)
+
+		info, err := fs.Lstat(f.layers[i], ancestor)
+		if err != nil {
+			// Nothing deeper can exist in the layer
+			return false
+		}
+		if !info.IsDir() {
+			return true
+		}
+	}
+	return false
+}
+
+func (f *FS) Open(name string) (fs.File, error) {
+	i, _, err := f.layer("open", name)
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := f.layers[i].Open(name)
+	if err != nil {
+		return nil, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		_ = file.Close()
+		return nil, err
+	}
+	if !info.IsDir() {
+		return file, nil
+	}
+	return &dir{File: file, fsys: f, name: name, top: i}, nil
+}
+
+func (f *FS) Stat(name string) (fs.FileInfo, error) {
+	i, _, err := f.layer("stat", name)
+	if err != nil {
+		return nil, err
+	}
+	return fs.Stat(f.layers[i], name)
+}
+
+func (f *FS) Lstat(name string) (fs.FileInfo, error) {
+	_, info, err := f.layer("lstat", name)
+	if err != nil {
+		return nil, err
+	}
+	return info, nil
+}
+
+func (f *FS) ReadLink(name string) (string, error) {
+	i, _, err := f.layer("readlink", name)
+	if err != nil {
+		return "", err
+	}
+	return fs.ReadLink(f.layers[i], name)
+}
+
+func (f *FS) ReadDir(name string) ([]fs.DirEntry, error) {
+	i, _, err := f.layer("readdir", name)
+	if err != nil {
+		return nil, err
+	}
+	return f.readDir(name, i)
+}
+
+// readDir merges the entries of a directory in the top layer it's served from and every layer below, for as long as
+// it's a directory in them, with the entries of later layers shadowing those of earlier ones. Entries are sorted by
+// name.
+func (f *FS) readDir(name string, top int) ([]fs.DirEntry, error) {
+	var (
+		merged []fs.DirEntry
+		seen   = map[string]bool{}
+	)
+	for i := top; i >= 0; i-- {
+		if i < top {
+			if info, err := fs.Stat(f.layers[i], name); err != nil || !info.IsDir() {
+				break
+			}
+		}
+
+		entries, err := fs.ReadDir(f.layers[i], name)
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range entries {
+			if !seen[entry.Name()] {
+				seen[entry.Name()] = true
+				merged = append(merged, entry)
+			}
+		}
+	}
+	slices.SortFunc(merged, func(a, b fs.DirEntry) int { return strings.Compare(a.Name(), b.Name()) })
+
+	return merged, nil
+}
+
+// dir is an open directory of the top layer it's served from, whose entries are merged with those of earlier layers.
+type dir struct {
+	fs.File
+	fsys    *FS
+	name    string
+	top     int
+	entries []fs.DirEntry
+	listed  bool
+}
+
+func (d *dir) ReadDir(n int) ([]fs.DirEntry, error) {
+	if !d.listed {
+		entries, err := d.fsys.readDir(d.name, d.top)
+		if err != nil {
+			return nil, err
+		}
+		d.entries, d.listed = entries, true
+	}
+
+	if n <= 0 {
+		entries := d.entries
+		d.entries = nil
+		return entries, nil
+	}
+	if len(d.entries) == 0 {
+		return nil, io.EOF
+	}
+
+	n = min(n, len(d.entries))
+	entries := d.entries[:n]
+	d.entries = d.entries[n:]
+	return entries, nil
+}
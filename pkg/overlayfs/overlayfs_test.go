@@ -0,0 +1,112 @@
+package overlayfs
+
+import (
+	"errors"
+	"io/fs"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func newLayers() *FS {
+	base := fstest.MapFS{
+		"a.txt":            {Data: []byte("base a")},
+		"b.txt":            {Data: []byte("base b")},
+		"dir/c.txt":        {Data: []byte("base c")},
+		"dir/sub/d.txt":    {Data: []byte("base d")},
+		"shadowed/e.txt":   {Data: []byte("base e")},
+		"replaced.txt":     {Data: []byte("base file")},
+		"link-to-b":        {Data: []byte("b.txt"), Mode: fs.ModeSymlink},
+		"deep/nested/f.md": {Data: []byte("base f")},
+	}
+	override := fstest.MapFS{
+		"b.txt":                 {Data: []byte("override b")},
+		"dir/g.txt":             {Data: []byte("override g")},
+		"shadowed":              {Data: []byte("a file now")},
+		"replaced.txt/h.txt":    {Data: []byte("a directory now")},
+		"deep/nested/other.txt": {Data: []byte("override other")},
+	}
+	return New(base, override)
+}
+
+func TestFS(t *testing.T) {
+	fsys := newLayers()
+	if err := fstest.TestFS(fsys, "a.txt", "b.txt", "dir/c.txt", "dir/g.txt", "dir/sub/d.txt", "shadowed", "replaced.txt/h.txt", "deep/nested/f.md", "deep/nested/other.txt"); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestShadowing(t *testing.T) {
+	fsys := newLayers()
+
+	for name, want := range map[string]string{
+		"a.txt":              "base a",
+		"b.txt":              "override b",
+		"dir/c.txt":          "base c",
+		"dir/g.txt":          "override g",
+		"dir/sub/d.txt":      "base d",
+		"shadowed":           "a file now",
+		"replaced.txt/h.txt": "a directory now",
+		"link-to-b":          "base b", // Symlinks are followed within their own layer
+	} {
+		data, err := fs.ReadFile(fsys, name)
+		if err != nil || string(data) != want {
+			t.Errorf("ReadFile(%q) = %q, %v, want %q", name, data, err, want)
+		}
+	}
+
+	// A file in a later layer hides the tree below its path in earlier layers
+	for _, name := range []string{"shadowed/e.txt", "missing.txt", "a.txt/x"} {
+		if _, err := fs.Stat(fsys, name); !errors.Is(err, fs.ErrNotExist) {
+			t.Errorf("Stat(%q) = %v, want %v", name, err, fs.ErrNotExist)
+		}
+	}
+	if _, err := fsys.Open("../a.txt"); !errors.Is(err, fs.ErrInvalid) {
+		t.Errorf("Open(../a.txt) = %v, want %v", err, fs.ErrInvalid)
+	}
+
+	info, err := fsys.Lstat("link-to-b")
+	if err != nil || info.Mode().Type() != fs.ModeSymlink {
+		t.Errorf("Lstat(link-to-b) = %v, %v, want a symlink", info, err)
+	}
+	if target, err := fsys.ReadLink("link-to-b"); err != nil || target != "b.txt" {
+		t.Errorf("ReadLink(link-to-b) = %q, %v, want %q", target, err, "b.txt")
+	}
+}
+
+func TestReadDir(t *testing.T) {
+	fsys := newLayers()
+
+	for _, tt := range []struct {
+		dir  string
+		want string
+	}{
+		{dir: ".", want: "a.txt b.txt deep dir link-to-b replaced.txt shadowed"},
+		{dir: "dir", want: "c.txt g.txt sub"},
+		{dir: "deep/nested", want: "f.md other.txt"},
+		{dir: "replaced.txt", want: "h.txt"},
+	} {
+		entries, err := fs.ReadDir(fsys, tt.dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var names []string
+		for _, entry := range entries {
+			names = append(names, entry.Name())
+		}
+		if got := strings.Join(names, " "); got != tt.want {
+			t.Errorf("ReadDir(%q) = %s, want %s", tt.dir, got, tt.want)
+		}
+	}
+
+	// The merged entry of a path is the one of the layer it's served from
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range entries {
+		if entry.Name() == "shadowed" && entry.IsDir() {
+			t.Error("ReadDir(.) listed a directory shadowed by a file as a directory")
+		}
+	}
+}
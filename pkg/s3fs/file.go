@@ -0,0 +1,217 @@
+package s3fs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// fileInfo describes an object or a directory.
+type fileInfo struct {
+	name    string
+	size    int64
+	modTime time.Time
+	dir     bool
+}
+
+func (i *fileInfo) Name() string       { return i.name }
+func (i *fileInfo) Size() int64        { return i.size }
+func (i *fileInfo) ModTime() time.Time { return i.modTime }
+func (i *fileInfo) IsDir() bool        { return i.dir }
+func (i *fileInfo) Sys() any           { return nil }
+
+func (i *fileInfo) Mode() fs.FileMode {
+	if i.dir {
+		return fs.ModeDir | 0o555
+	}
+	return 0o444
+}
+
+// file reads an object, starting a new ranged GET whenever it's read from somewhere other than where the last read
+// left off, so that serving ranges of large objects doesn't download them whole. It implements io.Seeker and
+// io.ReaderAt.
+type file struct {
+	fsys   *FS
+	name   string
+	info   *fileInfo
+	offset int64
+	body   io.ReadCloser // Body of the GET reading from offset, nil until the next read
+}
+
+func (f *file) Stat() (fs.FileInfo, error) { return f.info, nil }
+
+func (f *file) Read(p []byte) (int, error) {
+	if f.offset >= f.info.size {
+		return 0, io.EOF
+	}
+	if f.body == nil {
+		body, err := f.get(f.offset, f.info.size-1)
+		if err != nil {
+			return 0, err
+		}
+		f.body = body
+	}
+
+	n, err := f.body.Read(p)
+	f.offset += int64(n)
+	if errors.Is(err, io.EOF) && f.offset < f.info.size {
+		// The object changed under the read
+		err = io.ErrUnexpectedEOF
+	}
+	return n, err
+}
+
+func (f *file) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += f.offset
+	case io.SeekEnd:
+		offset += f.info.size
+	default:
+		return 0, &fs.PathError{Op: "seek", Path: f.name, Err: fs.ErrInvalid}
+	}
+	if offset < 0 {
+		return 0, &fs.PathError{Op: "seek", Path: f.name, Err: fs.ErrInvalid}
+	}
+
+	if offset != f.offset && f.body != nil {
+		_ = f.body.Close()
+		f.body = nil
+	}
+	f.offset = offset
+
+	return offset, nil
+}
+
+func (f *file) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, &fs.PathError{Op: "read", Path: f.name, Err: fs.ErrInvalid}
+	}
+	if off >= f.info.size {
+		return 0, io.EOF
+	}
+
+	end := min(off+int64(len(p)), f.info.size)
+	body, err := f.get(off, end-1)
+	if err != nil {
+		return 0, err
+	}
+	defer body.Close()
+
+	n, err := io.ReadFull(body, p[:end-off])
+	if err != nil {
+		return n, err
+	}
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func (f *file) Close() error {
+	if f.body == nil {
+		return nil
+	}
+	err := f.body.Close()
+	f.body = nil
+	return err
+}
+
+// get starts a GET of the bytes of the object from start to end, inclusive. Objects can take longer than the timeout to
+// read, so it only bounds how long the bucket can go without sending anything.
+func (f *file) get(start, end int64) (io.ReadCloser, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	var timer *time.Timer
+	if f.fsys.timeout > 0 {
+		timer = time.AfterFunc(f.fsys.timeout, cancel)
+	}
+
+	out, err := f.fsys.client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(f.fsys.bucket),
+		Key:    aws.String(f.fsys.key(f.name)),
+		Range:  aws.String(fmt.Sprintf("bytes=%d-%d", start, end)),
+	})
+	if timer != nil {
+		timer.Stop()
+	}
+	if err != nil {
+		cancel()
+		return nil, &fs.PathError{Op: "read", Path: f.name, Err: mapError(err)}
+	}
+	return &idleBody{body: out.Body, timer: timer, timeout: f.fsys.timeout, cancel: cancel}, nil
+}
+
+// idleBody is the body of a GET, which is canceled once a read has been waiting on the bucket for the timeout. Time
+// between reads doesn't count, so slow readers aren't cut off.
+type idleBody struct {
+	body    io.ReadCloser
+	timer   *time.Timer // Nil without a timeout
+	timeout time.Duration
+	cancel  context.CancelFunc
+}
+
+func (b *idleBody) Read(p []byte) (int, error) {
+	if b.timer == nil {
+		return b.body.Read(p)
+	}
+
+	b.timer.Reset(b.timeout)
+	defer b.timer.Stop()
+	return b.body.Read(p)
+}
+
+func (b *idleBody) Close() error {
+	if b.timer != nil {
+		b.timer.Stop()
+	}
+	defer b.cancel()
+	return b.body.Close()
+}
+
+// dir is an open directory, listed on the first call to ReadDir.
+type dir struct {
+	fsys    *FS
+	name    string
+	info    *fileInfo
+	entries []fs.DirEntry
+	listed  bool
+}
+
+func (d *dir) Stat() (fs.FileInfo, error) { return d.info, nil }
+
+func (d *dir) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.name, Err: errors.New("is a directory")}
+}
+
+func (d *dir) Close() error { return nil }
+
+func (d *dir) ReadDir(n int) ([]fs.DirEntry, error) {
+	if !d.listed {
+		entries, err := d.fsys.readDir(d.name)
+		if err != nil {
+			return nil, err
+		}
+		d.entries, d.listed = entries, true
+	}
+
+	if n <= 0 {
+		entries := d.entries
+		d.entries = nil
+		return entries, nil
+	}
+	if len(d.entries) == 0 {
+		return nil, io.EOF
+	}
+
+	n = min(n, len(d.entries))
+	entries := d.entries[:n]
+	d.entries = d.entries[n:]
+	return entries, nil
+}
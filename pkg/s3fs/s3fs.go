@@ -0,0 +1,371 @@
+// Package s3fs exposes the objects of an S3 bucket, or of an S3 compatible object store like MinIO, as a read-only
+// fs.FS.
+//
+// Object keys are split into paths at slashes, so objects are files and the common prefixes of their keys are
+// directories, like the folders of the S3 console. Directories have no metadata of their own, so they have a zero
+// modification time, and keys that aren't valid fs.FS paths, like those with empty or dot components, are skipped.
+package s3fs
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
+)
+
+// Config configures the bucket to expose and how to reach it.
+type Config struct {
+	// Bucket is the name of the bucket.
+	Bucket string
+	// Prefix is the key prefix of the objects to expose, which is the root of the filesystem, empty for the whole bucket.
+	Prefix string
+	// Region is the region of the bucket, empty to use the region of the AWS configuration.
+	Region string
+	// Endpoint is the URL of an S3 compatible object store to use instead of AWS, like http://localhost:9000 for MinIO.
+	Endpoint string
+	// PathStyle addresses the bucket in the URL path rather than in the host name, which most S3 compatible object
+	// stores need.
+	PathStyle bool
+	// AccessKeyID and SecretAccessKey are static credentials to use instead of the default credential chain of
+	// environment variables, shared configuration files, and IAM roles.
+	AccessKeyID     string
+	SecretAccessKey string
+	// StatCacheTTL is how long the stats of the objects in a listing are remembered, so that statting the entries of a
+	// directory right after reading it doesn't take a request per entry, 0 to always ask the bucket.
+	StatCacheTTL time.Duration
+	// Timeout bounds how long a request to the bucket can take, and how long the bucket can go without sending any of
+	// an object being read, 0 for no limit.
+	Timeout time.Duration
+}
+
+// FS is a read-only filesystem of the objects in a bucket.
+type FS struct {
+	client  *s3.Client
+	bucket  string
+	prefix  string // Key prefix of the root, empty or ending with a slash
+	ttl     time.Duration
+	timeout time.Duration
+
+	mu    sync.Mutex
+	stats map[string]cachedStat
+}
+
+// maxStats is the maximum number of stats to cache.
+const maxStats = 10000
+
+// cachedStat is the stat of an entry seen in a listing, valid until it expires.
+type cachedStat struct {
+	info    *fileInfo
+	expires time.Time
+}
+
+// New creates a filesystem of the configured bucket. The bucket isn't contacted until the filesystem is used.
+func New(ctx context.Context, cfg Config) (*FS, error) {
+	if cfg.Bucket == "" {
+		return nil, errors.New("a bucket is required")
+	}
+	if (cfg.AccessKeyID == "") != (cfg.SecretAccessKey == "") {
+		return nil, errors.New("static credentials need both an access key ID and a secret access key")
+	}
+
+	var opts []func(*config.LoadOptions) error
+	if cfg.Region != "" {
+		opts = append(opts, config.WithRegion(cfg.Region))
+	}
+	if cfg.AccessKeyID != "" {
+		opts = append(opts, config.WithCredentialsProvider(credentials.NewStaticCredentialsProvider(cfg.AccessKeyID, cfg.SecretAccessKey, "")))
+	}
+	awsCfg, err := config.LoadDefaultConfig(ctx, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load aws configuration: %w", err)
+	}
+	if awsCfg.Region == "" && cfg.Endpoint != "" {
+		// S3 compatible object stores rarely care about the region, but requests still have to be signed for one
+		awsCfg.Region = "us-east-1"
+	}
+
+	client := s3.NewFromConfig(awsCfg, func(o *s3.Options) {
+		if cfg.Endpoint != "" {
+			o.BaseEndpoint = aws.String(cfg.Endpoint)
+		}
+		o.UsePathStyle = cfg.PathStyle
+	})
+
+	prefix := strings.Trim(cfg.Prefix, "/")
+	if prefix != "" {
+		prefix += "/"
+	}
+
+	return &FS{
+		client:  client,
+		bucket:  cfg.Bucket,
+		prefix:  prefix,
+		ttl:     cfg.StatCacheTTL,
+		timeout: cfg.Timeout,
+		stats:   map[string]cachedStat{},
+	}, nil
+}
+
+// ParseURL parses an s3://bucket/prefix URL into its bucket and prefix, returning false if it isn't one.
+func ParseURL(rawURL string) (bucket, prefix string, ok bool) {
+	rest, ok := strings.CutPrefix(rawURL, "s3://")
+	if !ok {
+		return "", "", false
+	}
+	bucket, prefix, _ = strings.Cut(rest, "/")
+	return bucket, prefix, true
+}
+
+// context returns the context of a request to the bucket, which is canceled once the timeout passes.
+func (f *FS) context() (context.Context, context.CancelFunc) {
+	if f.timeout <= 0 {
+		return context.WithCancel(context.Background())
+	}
+	return context.WithTimeout(context.Background(), f.timeout)
+}
+
+// key returns the key of the object of a path.
+func (f *FS) key(name string) string {
+	if name == "." {
+		return strings.TrimSuffix(f.prefix, "/")
+	}
+	return f.prefix + name
+}
+
+// dirPrefix returns the key prefix of the objects below the directory of a path.
+func (f *FS) dirPrefix(name string) string {
+	if name == "." {
+		return f.prefix
+	}
+	return f.prefix + name + "/"
+}
+
+func (f *FS) Open(name string) (fs.File, error) {
+	info, err := f.stat("open", name)
+	if err != nil {
+		return nil, err
+	}
+	if info.IsDir() {
+		return &dir{fsys: f, name: name, info: info}, nil
+	}
+	return &file{fsys: f, name: name, info: info}, nil
+}
+
+func (f *FS) Stat(name string) (fs.FileInfo, error) {
+	info, err := f.stat("stat", name)
+	if err != nil {
+		return nil, err
+	}
+	return info, nil
+}
+
+// stat returns the info of the object of a path, or of the directory the path is a common prefix of.
+func (f *FS) stat(op, name string) (*fileInfo, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+	if name == "." {
+		return &fileInfo{name: ".", dir: true}, nil
+	}
+	if info, ok := f.cachedStat(name); ok {
+		return info, nil
+	}
+
+	ctx, cancel := f.context()
+	defer cancel()
+
+	head, err := f.client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(f.bucket),
+		Key:    aws.String(f.key(name)),
+	})
+	if err == nil {
+		return &fileInfo{
+			name:    path.Base(name),
+			size:    aws.ToInt64(head.ContentLength),
+			modTime: aws.ToTime(head.LastModified),
+		}, nil
+	}
+	if !isNotFound(err) {
+		return nil, &fs.PathError{Op: op, Path: name, Err: mapError(err)}
+	}
+
+	// Without an object of its own, the path is a directory if any object's key starts with it
+	list, err := f.client.ListObjectsV2(ctx, &s3.ListObjectsV2Input{
+		Bucket:  aws.String(f.bucket),
+		Prefix:  aws.String(f.dirPrefix(name)),
+		MaxKeys: aws.Int32(1),
+	})
+	if err != nil {
+		return nil, &fs.PathError{Op: op, Path: name, Err: mapError(err)}
+	}
+	if len(list.Contents) == 0 && len(list.CommonPrefixes) == 0 {
+		return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+	}
+	return &fileInfo{name: path.Base(name), dir: true}, nil
+}
+
+func (f *FS) ReadDir(name string) ([]fs.DirEntry, error) {
+	info, err := f.stat("readdir", name)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: errors.New("not a directory")}
+	}
+	return f.readDir(name)
+}
+
+// readDir lists the objects and common prefixes directly below the directory of a path, sorted by name.
+func (f *FS) readDir(name string) ([]fs.DirEntry, error) {
+	prefix := f.dirPrefix(name)
+	pages := s3.NewListObjectsV2Paginator(f.client, &s3.ListObjectsV2Input{
+		Bucket:    aws.String(f.bucket),
+		Prefix:    aws.String(prefix),
+		Delimiter: aws.String("/"),
+	})
+
+	var infos []*fileInfo
+	for pages.HasMorePages() {
+		ctx, cancel := f.context()
+		page, err := pages.NextPage(ctx)
+		cancel()
+		if err != nil {
+			return nil, &fs.PathError{Op: "readdir", Path: name, Err: mapError(err)}
+		}
+		for _, p := range page.CommonPrefixes {
+			child := strings.TrimSuffix(strings.TrimPrefix(aws.ToString(p.Prefix), prefix), "/")
+			if validName(child) {
+				infos = append(infos, &fileInfo{name: child, dir: true})
+			}
+		}
+		for _, o := range page.Contents {
+			// Keys ending with a slash, like the one of the listed directory itself, are folder markers, not files
+			child := strings.TrimPrefix(aws.ToString(o.Key), prefix)
+			if validName(child) {
+				infos = append(infos, &fileInfo{name: child, size: aws.ToInt64(o.Size), modTime: aws.ToTime(o.LastModified)})
+			}
+		}
+	}
+
+	// Common prefixes and objects are listed separately, and keys are ordered by bytes rather than by path, so sort them
+	// by name. An object named like a common prefix shadows it, like it does when statting the path.
+	slices.SortStableFunc(infos, func(a, b *fileInfo) int {
+		if c := strings.Compare(a.name, b.name); c != 0 {
+			return c
+		}
+		return cmp.Compare(boolInt(a.dir), boolInt(b.dir))
+	})
+	infos = slices.CompactFunc(infos, func(a, b *fileInfo) bool { return a.name == b.name })
+
+	entries := make([]fs.DirEntry, 0, len(infos))
+	for _, info := range infos {
+		entries = append(entries, fs.FileInfoToDirEntry(info))
+	}
+
+	f.cacheStats(name, infos)
+
+	return entries, nil
+}
+
+// cachedStat returns the stat of a path remembered from a listing of its directory, if it hasn't expired.
+func (f *FS) cachedStat(name string) (*fileInfo, bool) {
+	if f.ttl <= 0 {
+		return nil, false
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	cached, ok := f.stats[name]
+	if !ok {
+		return nil, false
+	}
+	if time.Now().After(cached.expires) {
+		delete(f.stats, name)
+		return nil, false
+	}
+	return cached.info, true
+}
+
+// cacheStats remembers the stats of the entries listed in a directory, dropping any that have expired, and arbitrary
+// others once there are too many to remember.
+func (f *FS) cacheStats(dir string, infos []*fileInfo) {
+	if f.ttl <= 0 {
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	now := time.Now()
+	for name, cached := range f.stats {
+		if now.After(cached.expires) {
+			delete(f.stats, name)
+		}
+	}
+	for _, info := range infos {
+		name := path.Join(dir, info.name)
+		if _, ok := f.stats[name]; !ok {
+			for k := range f.stats {
+				if len(f.stats) < maxStats {
+					break
+				}
+				// Evict an arbitrary stat to make room, map iteration order is random
+				delete(f.stats, k)
+			}
+		}
+		f.stats[name] = cachedStat{info: info, expires: now.Add(f.ttl)}
+	}
+}
+
+func boolInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// validName returns true if a name below a directory is a single valid path element.
+func validName(name string) bool {
+	return name != "" && name != "." && name != ".." && !strings.Contains(name, "/")
+}
+
+// isNotFound returns true if an error is S3's answer for a missing object.
+func isNotFound(err error) bool {
+	var (
+		notFound *types.NotFound
+		noKey    *types.NoSuchKey
+	)
+	return errors.As(err, &notFound) || errors.As(err, &noKey)
+}
+
+// mapError maps S3 errors to their fs equivalents, so that callers can tell them apart with errors.Is.
+func mapError(err error) error {
+	if isNotFound(err) {
+		return fs.ErrNotExist
+	}
+
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) {
+		switch apiErr.ErrorCode() {
+		case "AccessDenied", "Forbidden":
+			return fmt.Errorf("%w: %v", fs.ErrPermission, err)
+		case "NoSuchBucket":
+			return fmt.Errorf("%w: %v", fs.ErrNotExist, err)
+		}
+	}
+	return err
+}
@@ -0,0 +1,232 @@
+package s3fs
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+// bucket serves just enough of the S3 API, addressed in the path, for a bucket of the given objects.
+type bucket struct {
+	name    string
+	objects map[string]string
+	stall   time.Duration // How long to stall before sending the body of an object
+}
+
+type listResult struct {
+	XMLName        xml.Name `xml:"ListBucketResult"`
+	Contents       []listObject
+	CommonPrefixes []listPrefix
+	IsTruncated    bool
+}
+
+type listObject struct {
+	Key          string
+	Size         int64
+	LastModified string
+}
+
+type listPrefix struct {
+	Prefix string
+}
+
+var modTime = time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+
+func (b *bucket) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	key, ok := strings.CutPrefix(r.URL.Path, "/"+b.name)
+	if !ok {
+		http.Error(w, "<Error><Code>NoSuchBucket</Code></Error>", http.StatusNotFound)
+		return
+	}
+	key = strings.TrimPrefix(key, "/")
+
+	if r.URL.Query().Get("list-type") == "2" {
+		b.list(w, r)
+		return
+	}
+
+	contents, ok := b.objects[key]
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		if r.Method != http.MethodHead {
+			_, _ = io.WriteString(w, "<Error><Code>NoSuchKey</Code></Error>")
+		}
+		return
+	}
+
+	w.Header().Set("Last-Modified", modTime.Format(http.TimeFormat))
+	if r.Method == http.MethodHead {
+		w.Header().Set("Content-Length", strconv.Itoa(len(contents)))
+		return
+	}
+
+	var start, end int
+	if _, err := fmt.Sscanf(r.Header.Get("Range"), "bytes=%d-%d", &start, &end); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Length", strconv.Itoa(end-start+1))
+	w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, len(contents)))
+	w.WriteHeader(http.StatusPartialContent)
+	if b.stall > 0 {
+		w.(http.Flusher).Flush()
+		select {
+		case <-time.After(b.stall):
+		case <-r.Context().Done():
+			return
+		}
+	}
+	_, _ = io.WriteString(w, contents[start:end+1])
+}
+
+func (b *bucket) list(w http.ResponseWriter, r *http.Request) {
+	var (
+		prefix    = r.URL.Query().Get("prefix")
+		delimiter = r.URL.Query().Get("delimiter")
+		result    listResult
+	)
+	keys := make([]string, 0, len(b.objects))
+	for key := range b.objects {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+
+	for _, key := range keys {
+		rest, ok := strings.CutPrefix(key, prefix)
+		if !ok {
+			continue
+		}
+		if i := strings.Index(rest, delimiter); delimiter != "" && i >= 0 {
+			common := listPrefix{Prefix: prefix + rest[:i+1]}
+			if !slices.Contains(result.CommonPrefixes, common) {
+				result.CommonPrefixes = append(result.CommonPrefixes, common)
+			}
+			continue
+		}
+		result.Contents = append(result.Contents, listObject{
+			Key:          key,
+			Size:         int64(len(b.objects[key])),
+			LastModified: modTime.Format(time.RFC3339),
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/xml")
+	_ = xml.NewEncoder(w).Encode(result)
+}
+
+func newTestFS(t *testing.T, b *bucket, cfg Config) *FS {
+	t.Helper()
+
+	srv := httptest.NewServer(b)
+	t.Cleanup(srv.Close)
+
+	cfg.Bucket = b.name
+	cfg.Endpoint = srv.URL
+	cfg.PathStyle = true
+	cfg.Region = "us-east-1"
+	cfg.AccessKeyID, cfg.SecretAccessKey = "id", "secret"
+	fsys, err := New(context.Background(), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return fsys
+}
+
+func TestFS(t *testing.T) {
+	b := &bucket{
+		name: "bucket",
+		objects: map[string]string{
+			"root/a.txt":         "hello",
+			"root/dir/b.txt":     "world",
+			"root/dir/c/d.txt":   "nested",
+			"root/folder/":       "",
+			"root/bad//name.txt": "skipped",
+			"other.txt":          "outside of the prefix",
+		},
+	}
+	fsys := newTestFS(t, b, Config{Prefix: "root", StatCacheTTL: time.Minute})
+
+	if err := fstest.TestFS(fsys, "a.txt", "dir/b.txt", "dir/c/d.txt"); err != nil {
+		t.Error(err)
+	}
+
+	data, err := fs.ReadFile(fsys, "dir/c/d.txt")
+	if err != nil || string(data) != "nested" {
+		t.Errorf("ReadFile(dir/c/d.txt) = %q, %v, want %q", data, err, "nested")
+	}
+	if _, err := fs.Stat(fsys, "other.txt"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Stat(other.txt) = %v, want %v", err, fs.ErrNotExist)
+	}
+	if info, err := fs.Stat(fsys, "folder"); err != nil || !info.IsDir() {
+		t.Errorf("Stat(folder) = %v, %v, want a directory", info, err)
+	}
+}
+
+func TestFSTimeout(t *testing.T) {
+	b := &bucket{name: "bucket", objects: map[string]string{"a.txt": "hello"}, stall: time.Second}
+	fsys := newTestFS(t, b, Config{Timeout: 50 * time.Millisecond})
+
+	f, err := fsys.Open("a.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	start := time.Now()
+	if _, err := io.ReadAll(f); err == nil {
+		t.Error("read of a stalled object succeeded")
+	}
+	if elapsed := time.Since(start); elapsed >= b.stall {
+		t.Errorf("read of a stalled object took %v, want it canceled after the timeout", elapsed)
+	}
+}
+
+func TestCacheStats(t *testing.T) {
+	fsys := &FS{ttl: time.Minute, stats: map[string]cachedStat{}}
+
+	infos := make([]*fileInfo, maxStats+10)
+	for i := range infos {
+		infos[i] = &fileInfo{name: strconv.Itoa(i)}
+	}
+	fsys.cacheStats("dir", infos)
+	if len(fsys.stats) > maxStats {
+		t.Errorf("cached %d stats, want at most %d", len(fsys.stats), maxStats)
+	}
+	if _, ok := fsys.cachedStat("dir/" + strconv.Itoa(len(infos)-1)); !ok {
+		t.Error("latest stat wasn't cached")
+	}
+
+	fsys.ttl = -1
+	if _, ok := fsys.cachedStat("dir/" + strconv.Itoa(len(infos)-1)); ok {
+		t.Error("stat was cached without a ttl")
+	}
+}
+
+func TestParseURL(t *testing.T) {
+	for _, tt := range []struct {
+		url            string
+		bucket, prefix string
+		ok             bool
+	}{
+		{url: "s3://bucket", bucket: "bucket", ok: true},
+		{url: "s3://bucket/some/prefix", bucket: "bucket", prefix: "some/prefix", ok: true},
+		{url: "/srv/files"},
+		{url: "https://bucket/prefix"},
+	} {
+		bucket, prefix, ok := ParseURL(tt.url)
+		if bucket != tt.bucket || prefix != tt.prefix || ok != tt.ok {
+			t.Errorf("ParseURL(%q) = %q, %q, %t, want %q, %q, %t", tt.url, bucket, prefix, ok, tt.bucket, tt.prefix, tt.ok)
+		}
+	}
+}
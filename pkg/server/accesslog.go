@@ -0,0 +1,186 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+
+	"github.com/njhale/maskfs/pkg/clock"
+)
+
+// accessLog returns middleware that writes a line for every request to w in the Common Log Format, or the Combined
+// Log Format if combined is set, followed by the time taken to handle the request in microseconds and the request's ID.
+func accessLog(w io.Writer, combined bool, c clock.Clock) func(http.Handler) http.Handler {
+	var mu sync.Mutex
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+			start := c.Now()
+			recorder := &statusRecorder{ResponseWriter: rw}
+			r, user := withUserRecorder(r)
+			next.ServeHTTP(recorder, r)
+
+			client, _, err := net.SplitHostPort(r.RemoteAddr)
+			if err != nil {
+				client = r.RemoteAddr
+			}
+
+			status := recorder.status
+			if status == 0 {
+				// Nothing was written, which net/http answers with a 200
+				status = http.StatusOK
+			}
+
+			authUser := "-"
+			if *user != "" {
+				authUser = *user
+			}
+
+			line := fmt.Appendf(nil, "%s - %s [%s] %s %d %s",
+				client,
+				authUser,
+				start.Format("02/Jan/2006:15:04:05 -0700"),
+				strconv.Quote(r.Method+" "+r.URL.RequestURI()+" "+r.Proto),
+				status,
+				clfBytes(recorder.bytes),
+			)
+			if combined {
+				line = fmt.Appendf(line, " %s %s", clfQuote(r.Referer()), clfQuote(r.UserAgent()))
+			}
+			line = fmt.Appendf(line, " %d %s\n", c.Now().Sub(start).Microseconds(), clfString(requestIDOf(r.Context())))
+
+			mu.Lock()
+			defer mu.Unlock()
+			_, _ = w.Write(line)
+		})
+	}
+}
+
+// logFile is a log file that's reopened whenever the configuration is reloaded, so that it can be rotated by renaming
+// it and reloading the configuration afterwards.
+type logFile struct {
+	name string
+	mu   sync.Mutex
+	f    *os.File
+}
+
+// openLogFile opens a log file for appending, creating it if it doesn't exist.
+func openLogFile(name string) (*logFile, error) {
+	f, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	return &logFile{name: name, f: f}, nil
+}
+
+func (l *logFile) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.f.Write(p)
+}
+
+// reopen opens the log file at its name again, closing the file it replaces. If the file can't be opened, lines are
+// still written to the file that was open.
+func (l *logFile) reopen() error {
+	f, err := os.OpenFile(l.name, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+
+	l.mu.Lock()
+	old := l.f
+	l.f = f
+	l.mu.Unlock()
+	return old.Close()
+}
+
+func (l *logFile) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.f.Close()
+}
+
+// userKey is the context key of the authenticated user of a request being logged.
+type userKey struct{}
+
+// withUserRecorder returns the request with somewhere for authentication middleware to record its user, along with
+// where it's recorded, reusing the one an outer handler already gave it.
+func withUserRecorder(r *http.Request) (*http.Request, *string) {
+	if u, ok := r.Context().Value(userKey{}).(*string); ok {
+		return r, u
+	}
+	u := new(string)
+	return r.WithContext(context.WithValue(r.Context(), userKey{}, u)), u
+}
+
+// recordUser records the authenticated user of a request in the access and audit logs, if the request is being logged.
+func recordUser(r *http.Request, user string) {
+	if u, ok := r.Context().Value(userKey{}).(*string); ok {
+		*u = user
+	}
+}
+
+// userOf returns the authenticated user recorded for a request, empty if none was.
+func userOf(r *http.Request) string {
+	if u, ok := r.Context().Value(userKey{}).(*string); ok {
+		return *u
+	}
+	return ""
+}
+
+// clfBytes formats a response size for the Common Log Format, which uses a dash for empty responses.
+func clfBytes(n int64) string {
+	if n == 0 {
+		return "-"
+	}
+	return strconv.FormatInt(n, 10)
+}
+
+// clfString formats a string for the Common Log Format, which uses a dash for missing values.
+func clfString(value string) string {
+	if value == "" {
+		return "-"
+	}
+	return value
+}
+
+// clfQuote quotes a header value for the Combined Log Format, which uses a quoted dash for missing values.
+func clfQuote(value string) string {
+	if value == "" {
+		value = "-"
+	}
+	return strconv.Quote(value)
+}
+
+// statusRecorder is an http.ResponseWriter that records the status and size of the response written through it.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+func (s *statusRecorder) WriteHeader(status int) {
+	if s.status == 0 {
+		s.status = status
+	}
+	s.ResponseWriter.WriteHeader(status)
+}
+
+func (s *statusRecorder) Write(p []byte) (int, error) {
+	if s.status == 0 {
+		s.status = http.StatusOK
+	}
+	n, err := s.ResponseWriter.Write(p)
+	s.bytes += int64(n)
+	return n, err
+}
+
+// Unwrap returns the underlying response writer, so that http.ResponseController can reach it.
+func (s *statusRecorder) Unwrap() http.ResponseWriter {
+	return s.ResponseWriter
+}
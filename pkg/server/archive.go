@@ -0,0 +1,259 @@
+package server
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+
+	"github.com/njhale/maskfs/pkg/index"
+)
+
+// archiver writes the files of an archive one at a time.
+type archiver interface {
+	// add writes a file to the archive under the given name, with the contents read from r.
+	add(name string, entry *index.Entry, r io.Reader) error
+
+	// Close finishes the archive, without closing the underlying writer.
+	Close() error
+}
+
+type tarGzArchiver struct {
+	gz *gzip.Writer
+	tw *tar.Writer
+}
+
+func newTarGzArchiver(w io.Writer) *tarGzArchiver {
+	gz := gzip.NewWriter(w)
+	return &tarGzArchiver{gz: gz, tw: tar.NewWriter(gz)}
+}
+
+func (a *tarGzArchiver) add(name string, entry *index.Entry, r io.Reader) error {
+	if err := a.tw.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     name,
+		Mode:     int64(entry.Mode.Perm()),
+		Size:     entry.Size,
+		ModTime:  entry.ModTime,
+	}); err != nil {
+		return err
+	}
+
+	// Copy exactly the size in the header, a file that changed size since it was listed fails the archive
+	_, err := io.CopyN(a.tw, r, entry.Size)
+	return err
+}
+
+func (a *tarGzArchiver) Close() error {
+	if err := a.tw.Close(); err != nil {
+		return err
+	}
+	return a.gz.Close()
+}
+
+type zipArchiver struct {
+	zw *zip.Writer
+}
+
+func (a *zipArchiver) add(name string, entry *index.Entry, r io.Reader) error {
+	header := &zip.FileHeader{
+		Name:     name,
+		Method:   zip.Deflate,
+		Modified: entry.ModTime,
+	}
+	header.SetMode(entry.Mode.Perm())
+
+	fw, err := a.zw.CreateHeader(header)
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(fw, r)
+	return err
+}
+
+func (a *zipArchiver) Close() error {
+	return a.zw.Close()
+}
+
+// writeArchive adds the unmasked files below a directory to an archive, under the given top-level directory, and
+// finishes the archive. Only files are archived, so directories without any unmasked files below them are left out.
+func writeArchive(a archiver, fsys fs.FS, mask index.Mask, directory *index.Entry, name string) error {
+	err := index.Walk(fsys, directory.FSPath, mask, func(entry *index.Entry, _ int) error {
+		if entry.IsDir {
+			return nil
+		}
+
+		f, err := fsys.Open(entry.FSPath)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		if err := a.add(path.Join(name, entry.RelPath(directory)), entry, f); err != nil {
+			return fmt.Errorf("failed to archive %q: %w", entry.FSPath, err)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return a.Close()
+}
+
+// serveArchive streams an archive of the unmasked files below a directory in the given format, tar.gz or zip.
+// Files are stored under a top-level directory named after the requested one.
+// Only files are archived, so directories without any unmasked files below them are left out.
+//
+// The archive is streamed as the tree is walked, so an error after the first write can no longer change the response status.
+// The response is cut short instead, leaving the client with a truncated archive that fails to extract.
+func (s *Server) serveArchive(w http.ResponseWriter, r *http.Request, fsys fs.FS, m *masks, directory *index.Entry, format string) {
+	name := s.archiveName(directory)
+
+	var (
+		out = &trackingWriter{w: w}
+		a   archiver
+	)
+	switch format {
+	case "tar.gz":
+		w.Header().Set("Content-Type", "application/gzip")
+		a = newTarGzArchiver(out)
+	case "zip":
+		w.Header().Set("Content-Type", "application/zip")
+		a = &zipArchiver{zw: zip.NewWriter(out)}
+	default:
+		http.Error(w, fmt.Sprintf("unsupported archive format %q", format), http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name+"."+format))
+
+	err := writeArchive(a, fsys, m.all, directory, name)
+	if err == nil {
+		return
+	}
+
+	if !out.written {
+		w.Header().Del("Content-Disposition")
+		s.writeError(w, r, err)
+		return
+	}
+
+	// Don't finish the archive, so that it can't be mistaken for a complete one
+	s.requestLogger(r).Errorf("Aborting archive of %q: %v", directory.FSPath, err)
+	panic(http.ErrAbortHandler)
+}
+
+// archiveName returns the name of the top-level directory of archives of a directory, which is the directory's name,
+// or the name of the root on the host for the root, if it is one.
+func (s *Server) archiveName(directory *index.Entry) string {
+	if !directory.IsRoot() {
+		return directory.Name
+	}
+	if name := filepath.Base(s.root); s.root != "" && name != string(filepath.Separator) {
+		return name
+	}
+	return "root"
+}
+
+// Export writes an archive of the unmasked files below a directory, relative to the root, in the given format, tar.gz
+// or zip. Like archives served with ?archive, files are stored under a top-level directory named after the directory.
+func (s *Server) Export(w io.Writer, dir, format string) error {
+	var a archiver
+	switch format {
+	case "tar.gz":
+		a = newTarGzArchiver(w)
+	case "zip":
+		a = &zipArchiver{zw: zip.NewWriter(w)}
+	default:
+		return fmt.Errorf("unsupported archive format %q, must be tar.gz or zip", format)
+	}
+
+	directory, err := s.exportDir(dir)
+	if err != nil {
+		return err
+	}
+	return writeArchive(a, s.fsys, s.masks.Load().all, directory, s.archiveName(directory))
+}
+
+// ExportDir copies the unmasked files below a directory, relative to the root, into the destination directory,
+// preserving their permissions and modification times. The destination is created if it doesn't exist, but files
+// already in it are never overwritten.
+func (s *Server) ExportDir(dst, dir string) error {
+	directory, err := s.exportDir(dir)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(dst, 0o755); err != nil {
+		return fmt.Errorf("failed to create destination: %w", err)
+	}
+	root, err := os.OpenRoot(dst)
+	if err != nil {
+		return fmt.Errorf("failed to open destination: %w", err)
+	}
+	defer root.Close()
+
+	return writeArchive(&dirArchiver{root: root}, s.fsys, s.masks.Load().all, directory, "")
+}
+
+// exportDir returns the directory to export, which doesn't exist if it or any of its parents are masked.
+func (s *Server) exportDir(dir string) (*index.Entry, error) {
+	directory, err := index.GetEntry(s.FS(), dir)
+	if err != nil {
+		return nil, err
+	}
+	if !directory.IsDir {
+		return nil, fmt.Errorf("%q isn't a directory", dir)
+	}
+	return directory, nil
+}
+
+// dirArchiver writes the files of an "archive" into a directory instead.
+type dirArchiver struct {
+	root *os.Root
+}
+
+func (a *dirArchiver) add(name string, entry *index.Entry, r io.Reader) error {
+	if err := a.root.MkdirAll(path.Dir(name), 0o755); err != nil {
+		return err
+	}
+
+	f, err := a.root.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_EXCL, entry.Mode.Perm())
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, r); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	// Apply the permissions regardless of the umask
+	if err := a.root.Chmod(name, entry.Mode.Perm()); err != nil {
+		return err
+	}
+	return a.root.Chtimes(name, entry.ModTime, entry.ModTime)
+}
+
+func (a *dirArchiver) Close() error {
+	return nil
+}
+
+// trackingWriter records whether anything has been written to the underlying writer.
+type trackingWriter struct {
+	w       io.Writer
+	written bool
+}
+
+func (t *trackingWriter) Write(p []byte) (int, error) {
+	t.written = true
+	return t.w.Write(p)
+}
@@ -0,0 +1,181 @@
+package server
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestServeArchive(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"tree/a.txt":      "a",
+		"tree/sub/b.txt":  "b",
+		"tree/sub/c.key":  "c",
+		"tree/keys/d.key": "d",
+		"tree/.hidden/e":  "e",
+		"outside/f.txt":   "f",
+	})
+	want := map[string]string{"tree/a.txt": "a", "tree/sub/b.txt": "b"}
+	h := newHandler(t, Config{Root: dir, Mask: "**\n!*.key\n!.hidden/", Archives: true})
+
+	for format, read := range map[string]func(t *testing.T, body []byte) map[string]string{
+		"tar.gz": readTarGz,
+		"zip":    readZip,
+	} {
+		t.Run(format, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/files/tree/?archive="+format, nil))
+			if w.Code != http.StatusOK {
+				t.Fatalf("GET = %d %s", w.Code, w.Body)
+			}
+			if got, want := w.Header().Get("Content-Disposition"), `attachment; filename="tree.`+format+`"`; got != want {
+				t.Errorf("Content-Disposition = %q, want %q", got, want)
+			}
+			if files := read(t, w.Body.Bytes()); !maps.Equal(files, want) {
+				t.Errorf("archived %v, want %v", files, want)
+			}
+		})
+	}
+
+	t.Run("unsupported format", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/files/tree/?archive=rar", nil))
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("GET = %d, want %d", w.Code, http.StatusBadRequest)
+		}
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		newHandler(t, Config{Root: dir, Mask: "**"}).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/files/tree/?archive=zip", nil))
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("GET = %d, want %d", w.Code, http.StatusBadRequest)
+		}
+	})
+}
+
+func TestExport(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"tree/a.txt":      "a",
+		"tree/sub/b.txt":  "b",
+		"tree/sub/c.key":  "c",
+		"tree/keys/d.key": "d",
+		"secret/e.txt":    "e",
+	})
+	s := newTestServer(t, Config{Root: dir, Mask: "**\n!*.key\n!secret/"})
+
+	for format, read := range map[string]func(t *testing.T, body []byte) map[string]string{
+		"tar.gz": readTarGz,
+		"zip":    readZip,
+	} {
+		var buf bytes.Buffer
+		if err := s.Export(&buf, "tree", format); err != nil {
+			t.Fatal(err)
+		}
+		if files, want := read(t, buf.Bytes()), map[string]string{"tree/a.txt": "a", "tree/sub/b.txt": "b"}; !maps.Equal(files, want) {
+			t.Errorf("exported %s %v, want %v", format, files, want)
+		}
+	}
+	if err := s.Export(io.Discard, "tree", "rar"); err == nil {
+		t.Error("Export() in an unsupported format succeeded")
+	}
+	for _, d := range []string{"secret", "tree/a.txt", "missing"} {
+		if err := s.Export(io.Discard, d, "zip"); err == nil {
+			t.Errorf("Export(%q) succeeded", d)
+		}
+	}
+
+	// Files copied into a directory keep their permissions and modification times
+	modTime := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	src := filepath.Join(dir, "tree", "sub", "b.txt")
+	if err := os.Chmod(src, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(src, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+	dst := filepath.Join(t.TempDir(), "export")
+	if err := s.ExportDir(dst, "."); err != nil {
+		t.Fatal(err)
+	}
+	for name, want := range map[string]string{"tree/a.txt": "a", "tree/sub/b.txt": "b"} {
+		if data, err := os.ReadFile(filepath.Join(dst, filepath.FromSlash(name))); err != nil || string(data) != want {
+			t.Errorf("exported %s = %q, %v, want %q", name, data, err, want)
+		}
+	}
+	for _, name := range []string{"tree/sub/c.key", "tree/keys", "secret"} {
+		if _, err := os.Stat(filepath.Join(dst, filepath.FromSlash(name))); !errors.Is(err, os.ErrNotExist) {
+			t.Errorf("masked %s was exported: %v", name, err)
+		}
+	}
+	info, err := os.Stat(filepath.Join(dst, "tree", "sub", "b.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0o600 || !info.ModTime().Equal(modTime) {
+		t.Errorf("exported file has mode %v and modification time %v, want %v and %v", info.Mode().Perm(), info.ModTime(), os.FileMode(0o600), modTime)
+	}
+
+	// Existing files are never overwritten
+	if err := s.ExportDir(dst, "."); err == nil {
+		t.Error("ExportDir() into a directory with the same files succeeded")
+	}
+}
+
+func readTarGz(t *testing.T, body []byte) map[string]string {
+	t.Helper()
+
+	gz, err := gzip.NewReader(bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]string{}
+	tr := tar.NewReader(gz)
+	for {
+		header, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return files
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		contents, err := io.ReadAll(tr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		files[header.Name] = string(contents)
+	}
+}
+
+func readZip(t *testing.T, body []byte) map[string]string {
+	t.Helper()
+
+	zr, err := zip.NewReader(bytes.NewReader(body), int64(len(body)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]string{}
+	for _, f := range zr.File {
+		r, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		contents, err := io.ReadAll(r)
+		r.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		files[f.Name] = string(contents)
+	}
+	return files
+}
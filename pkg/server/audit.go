@@ -0,0 +1,120 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/njhale/maskfs/pkg/index"
+)
+
+// auditEvent is a line of the audit log, recording a request that resolved to a masked entry.
+type auditEvent struct {
+	Time      time.Time `json:"timestamp"`
+	RequestID string    `json:"request_id,omitempty"`
+	Client    string    `json:"client"`
+	User      string    `json:"user,omitempty"`
+	Profile   string    `json:"profile,omitempty"`
+	Method    string    `json:"method"`
+	URI       string    `json:"uri"`
+	FSPath    string    `json:"fs_path"`
+	Rule      string    `json:"rule,omitempty"` // The path rule that masked the entry, empty if another mask, like the junk patterns, did
+	RuleIndex int       `json:"rule_index"`     // The index of the rule among the path rules, -1 without one
+	RuleFile  string    `json:"rule_file,omitempty"`
+}
+
+// auditLog writes the audit events of masked entry requests as JSON lines.
+type auditLog struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+var (
+	auditLogsMu sync.Mutex
+	// auditLogs are the open audit logs keyed by file name, shared by the servers of every mount and configuration
+	// reload, so that they neither interleave partial lines nor leak a file for every reload.
+	auditLogs = map[string]*auditLog{}
+)
+
+// openAuditLog returns the audit log writing to the given file, or to stdout if it's -, opening the file for appending
+// if it isn't open already.
+func openAuditLog(name string) (*auditLog, error) {
+	auditLogsMu.Lock()
+	defer auditLogsMu.Unlock()
+
+	if log, ok := auditLogs[name]; ok {
+		return log, nil
+	}
+
+	var w io.Writer = os.Stdout
+	if name != "-" {
+		f, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o600)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open audit log: %w", err)
+		}
+		w = f
+	}
+
+	log := &auditLog{w: w}
+	auditLogs[name] = log
+	return log, nil
+}
+
+func (l *auditLog) write(event auditEvent) error {
+	line, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	_, err = l.w.Write(append(line, '\n'))
+	return err
+}
+
+// audit records a request that resolved to a masked entry in the audit log, if it's enabled, along with the rule that
+// masked the entry.
+func (s *Server) audit(r *http.Request, m *masks, entry *index.Entry) {
+	if s.auditLog == nil {
+		return
+	}
+
+	explanation := m.explain(entry)
+	event := auditEvent{
+		Time:      s.clock.Now().UTC(),
+		RequestID: requestIDOf(r.Context()),
+		Client:    s.clientAddr(r),
+		User:      userOf(r),
+		Profile:   profileOf(r),
+		Method:    r.Method,
+		URI:       r.RequestURI,
+		FSPath:    entry.FSPath,
+		RuleIndex: -1,
+	}
+	if explanation.PathMasked {
+		event.Rule, event.RuleIndex, event.RuleFile = explanation.Rule, explanation.Index, explanation.RuleFile
+	}
+
+	if err := s.auditLog.write(event); err != nil {
+		s.requestLogger(r).Errorf("Failed to write audit event: %v", err)
+	}
+}
+
+// clientAddr returns the address of the client making a request, the one the IP filter sees if clients are filtered.
+func (s *Server) clientAddr(r *http.Request) string {
+	if s.ipFilter != nil {
+		if addr := s.ipFilter.clientIP(r); addr.IsValid() {
+			return addr.String()
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
@@ -0,0 +1,68 @@
+package server
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestAuditLog(t *testing.T) {
+	root := writeFiles(t, map[string]string{"a.txt": "a", "secret.key": "secret", "a.txt~": "backup"})
+	auditFile := filepath.Join(t.TempDir(), "audit.log")
+	h := newTestReloadingHandler(t, Config{
+		Root:      root,
+		Mask:      "**\n!*.key",
+		HideJunk:  "*~",
+		URLPrefix: "/files",
+		StatAPI:   true,
+		AuditLog:  auditFile,
+	})
+
+	for _, r := range []*http.Request{
+		httptest.NewRequest(http.MethodGet, "/files/a.txt", nil),
+		httptest.NewRequest(http.MethodGet, "/files/secret.key", nil),
+		httptest.NewRequest(http.MethodGet, "/files/a.txt~", nil),
+		httptest.NewRequest(http.MethodGet, "/files/missing.key", nil),
+		httptest.NewRequest(http.MethodPost, "/api/stat", strings.NewReader(`["a.txt", "secret.key"]`)),
+	} {
+		h.ServeHTTP(httptest.NewRecorder(), r)
+	}
+
+	f, err := os.Open(auditFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var events []auditEvent
+	for scanner := bufio.NewScanner(f); scanner.Scan(); {
+		var event auditEvent
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			t.Fatalf("audit line %q isn't JSON: %v", scanner.Text(), err)
+		}
+		events = append(events, event)
+	}
+
+	// Only requests of entries that exist but are masked are audited, along with the path rule masking them, if any
+	want := []auditEvent{
+		{Client: "192.0.2.1", Method: http.MethodGet, URI: "/files/secret.key", FSPath: "secret.key", Rule: "!*.key", RuleIndex: 1},
+		{Client: "192.0.2.1", Method: http.MethodGet, URI: "/files/a.txt~", FSPath: "a.txt~", RuleIndex: -1},
+		{Client: "192.0.2.1", Method: http.MethodPost, URI: "/api/stat", FSPath: "secret.key", Rule: "!*.key", RuleIndex: 1},
+	}
+	if len(events) != len(want) {
+		t.Fatalf("audit log has %d events, want %d: %+v", len(events), len(want), events)
+	}
+	for i, event := range events {
+		if event.Time.IsZero() {
+			t.Errorf("event %d has no time", i)
+		}
+		event.Time = want[i].Time
+		if event != want[i] {
+			t.Errorf("event %d = %+v, want %+v", i, event, want[i])
+		}
+	}
+}
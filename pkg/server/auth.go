@@ -0,0 +1,55 @@
+package server
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"net/http"
+	"strings"
+)
+
+// bearerAuth returns middleware that rejects requests that don't carry one of the given tokens in an
+// "Authorization: Bearer <token>" header with a 401. Requests are bound to the mask profile their token is bound to.
+func bearerAuth(tokens map[string]string) func(http.Handler) http.Handler {
+	// Compare digests rather than the tokens themselves so that the comparison doesn't leak the tokens' lengths
+	want := make(map[[sha256.Size]byte]string, len(tokens))
+	for token, profile := range tokens {
+		want[sha256.Sum256([]byte(token))] = profile
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			scheme, given, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+			if !ok || !strings.EqualFold(scheme, "Bearer") {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="maskfs"`)
+				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+				return
+			}
+
+			profile, ok := lookupToken(want, strings.TrimSpace(given))
+			if !ok {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="maskfs", error="invalid_token"`)
+				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+				return
+			}
+
+			next.ServeHTTP(w, withProfile(r, profile))
+		})
+	}
+}
+
+// lookupToken returns the mask profile of the token whose digest matches the given token's, comparing it against every
+// digest in constant time.
+func lookupToken(digests map[[sha256.Size]byte]string, token string) (string, bool) {
+	got := sha256.Sum256([]byte(token))
+
+	var (
+		profile string
+		found   bool
+	)
+	for want, p := range digests {
+		if subtle.ConstantTimeCompare(got[:], want[:]) == 1 {
+			profile, found = p, true
+		}
+	}
+	return profile, found
+}
@@ -0,0 +1,95 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"sync/atomic"
+	"time"
+
+	"github.com/njhale/maskfs/pkg/clock"
+)
+
+// errBudgetExceeded is returned by a budgetFS once its request has used up its budget.
+var errBudgetExceeded = errors.New("request budget exceeded")
+
+// budgetFS wraps a filesystem to cap the work done on behalf of a single request.
+// Every operation fails with errBudgetExceeded once the request's context is done or its deadline has passed,
+// and lstats fail once more than the allowed number of entries have been walked.
+type budgetFS struct {
+	fs.FS
+	ctx       context.Context
+	clock     clock.Clock
+	deadline  time.Time    // zero when the time is unlimited
+	remaining atomic.Int64 // negative when the number of entries is unlimited
+}
+
+// newBudgetFS returns a budgetFS allowing maxEntries lstats within the given timeout.
+// The number of lstats is unlimited if maxEntries is not positive, and the time is unlimited if timeout is not positive.
+func newBudgetFS(ctx context.Context, c clock.Clock, fsys fs.FS, maxEntries int, timeout time.Duration) *budgetFS {
+	b := &budgetFS{
+		FS:    fsys,
+		ctx:   ctx,
+		clock: c,
+	}
+	if timeout > 0 {
+		b.deadline = c.Now().Add(timeout)
+	}
+	if maxEntries > 0 {
+		b.remaining.Store(int64(maxEntries))
+	} else {
+		b.remaining.Store(-1)
+	}
+
+	return b
+}
+
+func (b *budgetFS) check() error {
+	if err := b.ctx.Err(); err != nil {
+		return fmt.Errorf("%w: %w", errBudgetExceeded, err)
+	}
+	if !b.deadline.IsZero() && b.clock.Now().After(b.deadline) {
+		return fmt.Errorf("%w: request took too long", errBudgetExceeded)
+	}
+	return nil
+}
+
+func (b *budgetFS) Open(name string) (fs.File, error) {
+	if err := b.check(); err != nil {
+		return nil, err
+	}
+	return b.FS.Open(name)
+}
+
+func (b *budgetFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	if err := b.check(); err != nil {
+		return nil, err
+	}
+	return fs.ReadDir(b.FS, name)
+}
+
+func (b *budgetFS) Stat(name string) (fs.FileInfo, error) {
+	if err := b.check(); err != nil {
+		return nil, err
+	}
+	return fs.Stat(b.FS, name)
+}
+
+// Lstat counts against the budget since every entry walked is lstat'd exactly once, whether or not it's a symlink.
+func (b *budgetFS) Lstat(name string) (fs.FileInfo, error) {
+	if err := b.check(); err != nil {
+		return nil, err
+	}
+	if b.remaining.Load() >= 0 && b.remaining.Add(-1) < 0 {
+		return nil, fmt.Errorf("%w: too many entries walked", errBudgetExceeded)
+	}
+	return fs.Lstat(b.FS, name)
+}
+
+func (b *budgetFS) ReadLink(name string) (string, error) {
+	if err := b.check(); err != nil {
+		return "", err
+	}
+	return fs.ReadLink(b.FS, name)
+}
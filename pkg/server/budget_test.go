@@ -0,0 +1,98 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/njhale/maskfs/pkg/clock"
+	"github.com/njhale/maskfs/pkg/index"
+	"github.com/njhale/maskfs/pkg/mask"
+)
+
+func TestBudgetFS(t *testing.T) {
+	fsys := fstest.MapFS{}
+	for i := range 1000 {
+		fsys[fmt.Sprintf("big/%d", i)] = &fstest.MapFile{}
+	}
+	fsys["small/a"] = &fstest.MapFile{}
+	m, err := mask.NewGlobMask("**")
+	if err != nil {
+		t.Fatal(err)
+	}
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	for _, tt := range []struct {
+		name       string
+		ctx        context.Context
+		dir        string
+		maxEntries int
+		timeout    time.Duration
+		exceeded   bool
+	}{
+		{name: "within budget", ctx: context.Background(), dir: "small", maxEntries: 100},
+		{name: "unlimited", ctx: context.Background(), dir: "big", maxEntries: 0},
+		{name: "too many entries", ctx: context.Background(), dir: "big", maxEntries: 100, exceeded: true},
+		{name: "canceled", ctx: canceled, dir: "small", maxEntries: 0, exceeded: true},
+		{name: "within deadline", ctx: context.Background(), dir: "small", timeout: time.Hour},
+		{name: "past deadline", ctx: context.Background(), dir: "big", timeout: time.Minute, exceeded: true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			// Every stat takes a second on the stepping clock, so walking big takes over 16 minutes
+			c := &steppingClock{now: time.Date(2024, time.June, 1, 12, 0, 0, 0, time.UTC), step: time.Second}
+			_, err := index.GetEntries(newBudgetFS(tt.ctx, c, fsys, tt.maxEntries, tt.timeout), tt.dir, m)
+			if exceeded := errors.Is(err, errBudgetExceeded); exceeded != tt.exceeded {
+				t.Errorf("GetEntries(%q) = %v, want the budget exceeded %t", tt.dir, err, tt.exceeded)
+			}
+		})
+	}
+}
+
+func TestBudgetFSFixedClock(t *testing.T) {
+	fsys := fstest.MapFS{"a": &fstest.MapFile{}}
+	m, err := mask.NewGlobMask("**")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A frozen clock never passes the deadline, however long the walk takes on the wall clock
+	budget := newBudgetFS(context.Background(), clock.Fixed(time.Now()), fsys, 0, time.Nanosecond)
+	time.Sleep(time.Millisecond)
+	if _, err := index.GetEntries(budget, ".", m); err != nil {
+		t.Errorf("GetEntries with a fixed clock = %v, want no error", err)
+	}
+}
+
+// steppingClock is a clock.Clock that advances by step every time it's read.
+type steppingClock struct {
+	now  time.Time
+	step time.Duration
+}
+
+func (c *steppingClock) Now() time.Time {
+	c.now = c.now.Add(c.step)
+	return c.now
+}
+
+func TestServeBudget(t *testing.T) {
+	files := map[string]string{"small/a": ""}
+	for i := range 200 {
+		files[fmt.Sprintf("big/%d", i)] = ""
+	}
+	dir := writeFiles(t, files)
+	h := newHandler(t, Config{Mask: "**", MaxWalkEntries: 100})
+
+	for p, want := range map[string]int{"/small/": http.StatusOK, "/big/": http.StatusServiceUnavailable} {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/files"+dir+p, nil))
+		if w.Code != want {
+			t.Errorf("GET %s = %d, want %d", p, w.Code, want)
+		}
+	}
+}
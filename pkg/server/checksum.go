@@ -0,0 +1,150 @@
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"sync"
+
+	"github.com/njhale/maskfs/pkg/index"
+)
+
+// sha256File returns the hex-encoded SHA-256 digest of a file's contents.
+func sha256File(fsys fs.FS, path string) (string, error) {
+	f, err := fsys.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// checksumKey identifies a version of a file by its path, modification time, and size,
+// so that a cached checksum is never returned for a file that has since been modified.
+type checksumKey struct {
+	path    string
+	modTime int64
+	size    int64
+}
+
+// checksumCache caches the checksums of files, so that repeated requests don't re-read large files.
+type checksumCache struct {
+	mu   sync.Mutex
+	sums map[checksumKey]string
+	max  int // Maximum number of cached checksums, 0 to disable caching
+}
+
+func newChecksumCache(max int) *checksumCache {
+	return &checksumCache{
+		sums: map[checksumKey]string{},
+		max:  max,
+	}
+}
+
+func (c *checksumCache) get(key checksumKey) (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	sum, ok := c.sums[key]
+	return sum, ok
+}
+
+func (c *checksumCache) put(key checksumKey, sum string) {
+	if c.max <= 0 {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for k := range c.sums {
+		if len(c.sums) < c.max {
+			break
+		}
+		// Evict an arbitrary checksum to make room, map iteration order is random
+		delete(c.sums, k)
+	}
+	c.sums[key] = sum
+}
+
+// checksum returns the hex-encoded SHA-256 digest of a file's contents, from the cache if the file hasn't changed.
+func (s *Server) checksum(fsys fs.FS, entry *index.Entry) (string, error) {
+	key := checksumKey{
+		path:    entry.FSPath,
+		modTime: entry.ModTime.UnixNano(),
+		size:    entry.Size,
+	}
+	if sum, ok := s.checksumCache.get(key); ok {
+		return sum, nil
+	}
+
+	sum, err := sha256File(fsys, entry.FSPath)
+	if err != nil {
+		return "", err
+	}
+
+	s.checksumCache.put(key, sum)
+	return sum, nil
+}
+
+// writeChecksum writes the checksum of a single file as JSON.
+func (s *Server) writeChecksum(w http.ResponseWriter, r *http.Request, fsys fs.FS, entry *index.Entry) {
+	if s.maxChecksumSize > 0 && entry.Size > s.maxChecksumSize {
+		http.Error(w, fmt.Sprintf("File size %d exceeds the checksum size cap of %d bytes", entry.Size, s.maxChecksumSize), http.StatusBadRequest)
+		return
+	}
+
+	sum, err := s.checksum(fsys, entry)
+	if err != nil {
+		s.writeError(w, r, fmt.Errorf("failed to compute checksum of %q: %w", entry.FSPath, err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(struct {
+		FSPath    string `json:"fs_path"`
+		Algorithm string `json:"algorithm"`
+		Digest    string `json:"digest"`
+	}{
+		FSPath:    entry.FSPath,
+		Algorithm: "sha256",
+		Digest:    sum,
+	})
+}
+
+// writeChecksums writes a SHA256SUMS file, compatible with `sha256sum -c` from within the directory, for the files among its given entries.
+// Directories and files larger than the checksum size cap are left out.
+func (s *Server) writeChecksums(w http.ResponseWriter, r *http.Request, fsys fs.FS, directory *index.Entry, entries index.Entries) {
+	var sums []byte
+	for _, entry := range entries {
+		if entry.IsDir {
+			continue
+		}
+		if s.maxChecksumSize > 0 && entry.Size > s.maxChecksumSize {
+			s.requestLogger(r).Debugf("Skipping checksum of %q, size %d exceeds the cap", entry.FSPath, entry.Size)
+			continue
+		}
+
+		sum, err := s.checksum(fsys, entry)
+		if err != nil {
+			s.writeError(w, r, fmt.Errorf("failed to compute checksum of %q: %w", entry.FSPath, err))
+			return
+		}
+
+		sums = fmt.Appendf(sums, "%s  %s\n", sum, entry.RelPath(directory))
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Content-Disposition", `attachment; filename="SHA256SUMS"`)
+	_, _ = w.Write(sums)
+}
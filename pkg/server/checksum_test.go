@@ -0,0 +1,62 @@
+package server
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestChecksums(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"a.txt":      "hello",
+		"b.txt":      "world\n",
+		"large.bin":  strings.Repeat("x", 64),
+		"sub/c.txt":  "nested",
+		"secret.key": "secret",
+	})
+	h := newHandler(t, Config{Mask: "**\n!*.key", Checksums: true, MaxChecksumSize: 32})
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/files"+dir+"/?checksums=1", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("GET ?checksums=1 = %d, want %d", w.Code, http.StatusOK)
+	}
+	if got, want := w.Header().Get("Content-Disposition"), `attachment; filename="SHA256SUMS"`; got != want {
+		t.Errorf("Content-Disposition = %q, want %q", got, want)
+	}
+
+	// Verify every line like sha256sum -c would from within the directory, against the files themselves
+	verified := map[string]bool{}
+	scanner := bufio.NewScanner(w.Body)
+	for scanner.Scan() {
+		sum, name, ok := strings.Cut(scanner.Text(), "  ")
+		if !ok {
+			t.Fatalf("malformed SHA256SUMS line %q", scanner.Text())
+		}
+		data, err := os.ReadFile(filepath.Join(dir, filepath.FromSlash(name)))
+		if err != nil {
+			t.Fatalf("SHA256SUMS names %q, which can't be read: %v", name, err)
+		}
+		if digest := sha256.Sum256(data); sum != hex.EncodeToString(digest[:]) {
+			t.Errorf("checksum of %q = %s, want %x", name, sum, digest)
+		}
+		verified[name] = true
+	}
+	// Directories, masked files, and files larger than the cap are left out
+	if len(verified) != 2 || !verified["a.txt"] || !verified["b.txt"] {
+		t.Errorf("SHA256SUMS verified %v, want a.txt and b.txt", verified)
+	}
+
+	// Checksums are opt-in
+	w = httptest.NewRecorder()
+	newHandler(t, Config{Mask: "**"}).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/files"+dir+"/?checksums=1", nil))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("GET ?checksums=1 with checksums disabled = %d, want %d", w.Code, http.StatusBadRequest)
+	}
+}
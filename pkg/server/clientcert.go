@@ -0,0 +1,85 @@
+package server
+
+import (
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// loadClientCAs reads a PEM encoded bundle of the CA certificates that client certificates must be signed by.
+func loadClientCAs(name string) (*x509.CertPool, error) {
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read TLS client CA bundle: %w", err)
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("no PEM encoded certificates found in TLS client CA bundle %q", name)
+	}
+	return pool, nil
+}
+
+// parseClientProfiles parses client certificate names bound to mask profiles given as name=profile, returning the
+// profiles keyed by name.
+func parseClientProfiles(specs []string, profiles map[string]string) (map[string]string, error) {
+	bound := map[string]string{}
+	for _, spec := range specs {
+		// Profile names can't contain =, but URI subject alternative names could
+		i := strings.LastIndex(spec, "=")
+		if i <= 0 || i == len(spec)-1 {
+			return nil, fmt.Errorf("invalid TLS client profile %q, must be name=profile", spec)
+		}
+		name, profile := spec[:i], spec[i+1:]
+		if _, ok := profiles[profile]; !ok {
+			return nil, fmt.Errorf("TLS client %q is bound to undefined mask profile %q", name, profile)
+		}
+		bound[name] = profile
+	}
+	return bound, nil
+}
+
+// certNames returns the names of a client certificate, its subject common name followed by its DNS, email, and URI
+// subject alternative names.
+func certNames(cert *x509.Certificate) []string {
+	var names []string
+	if cert.Subject.CommonName != "" {
+		names = append(names, cert.Subject.CommonName)
+	}
+	names = append(names, cert.DNSNames...)
+	names = append(names, cert.EmailAddresses...)
+	for _, uri := range cert.URIs {
+		names = append(names, uri.String())
+	}
+	return names
+}
+
+// clientCertAuth returns middleware that records the first name of each request's verified client certificate as its
+// user and binds the request to the mask profile of the first of its names bound to one. Handshakes already fail
+// without a verified certificate when maskfs serves TLS itself, but requests that reach the handler without one, like
+// those of servers that mount it with their own TLS configuration, are forbidden.
+func clientCertAuth(profiles map[string]string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+				http.Error(w, "A verified TLS client certificate is required", http.StatusForbidden)
+				return
+			}
+
+			names := certNames(r.TLS.VerifiedChains[0][0])
+			if len(names) > 0 {
+				recordUser(r, names[0])
+			}
+			for _, name := range names {
+				if profile, ok := profiles[name]; ok {
+					r = withProfile(r, profile)
+					break
+				}
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
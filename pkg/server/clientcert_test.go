@@ -0,0 +1,71 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+func TestClientCertAuth(t *testing.T) {
+	spiffe, err := url.Parse("spiffe://example.com/ci")
+	if err != nil {
+		t.Fatal(err)
+	}
+	profiles, err := parseClientProfiles([]string{"ci.example.com=public", "spiffe://example.com/ci=ci"}, map[string]string{"public": "", "ci": ""})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var profile string
+	h := clientCertAuth(profiles)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		profile = profileOf(r)
+	}))
+
+	for _, tt := range []struct {
+		name    string
+		cert    *x509.Certificate
+		code    int
+		profile string
+		user    string
+	}{
+		{name: "no certificate", code: http.StatusForbidden},
+		{name: "unbound", cert: &x509.Certificate{Subject: pkix.Name{CommonName: "alice"}}, code: http.StatusOK, user: "alice"},
+		{name: "bound common name", cert: &x509.Certificate{Subject: pkix.Name{CommonName: "ci.example.com"}}, code: http.StatusOK, profile: "public", user: "ci.example.com"},
+		{name: "bound DNS name", cert: &x509.Certificate{Subject: pkix.Name{CommonName: "build"}, DNSNames: []string{"ci.example.com"}}, code: http.StatusOK, profile: "public", user: "build"},
+		{name: "bound URI", cert: &x509.Certificate{URIs: []*url.URL{spiffe}}, code: http.StatusOK, profile: "ci", user: "spiffe://example.com/ci"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			profile = ""
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tt.cert != nil {
+				r.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{tt.cert}}}
+			}
+			// The access log records the user the middleware finds
+			var user string
+			r = r.WithContext(context.WithValue(r.Context(), userKey{}, &user))
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+
+			if w.Code != tt.code {
+				t.Fatalf("code = %d, want %d", w.Code, tt.code)
+			}
+			if profile != tt.profile || user != tt.user {
+				t.Errorf("profile, user = %q, %q, want %q, %q", profile, user, tt.profile, tt.user)
+			}
+		})
+	}
+}
+
+func TestParseClientProfiles(t *testing.T) {
+	profiles := map[string]string{"public": ""}
+	for _, spec := range []string{"alice", "=public", "alice=", "alice=undefined"} {
+		if _, err := parseClientProfiles([]string{spec}, profiles); err == nil {
+			t.Errorf("parseClientProfiles(%q) succeeded, want an error", spec)
+		}
+	}
+}
@@ -0,0 +1,286 @@
+package server
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// compressibleTypes are the media types, besides text/* and +json and +xml types, of responses worth compressing.
+// Everything else, like images, video, and archives, is usually compressed already.
+var compressibleTypes = map[string]bool{
+	"application/javascript": true,
+	"application/json":       true,
+	"application/jsonl":      true,
+	"application/toml":       true,
+	"application/wasm":       true,
+	"application/x-ndjson":   true,
+	"application/x-sh":       true,
+	"application/xml":        true,
+	"application/yaml":       true,
+	"image/svg+xml":          true,
+}
+
+var (
+	gzipWriters = sync.Pool{New: func() any { return gzip.NewWriter(nil) }}
+	zstdWriters = sync.Pool{New: func() any {
+		w, _ := zstd.NewWriter(nil, zstd.WithEncoderConcurrency(1))
+		return w
+	}}
+)
+
+// compressor compresses the responses of compressible content types with zstd or gzip, as requested by the
+// Accept-Encoding header, once they're at least minSize bytes long.
+type compressor struct {
+	minSize int64
+	exclude []string // Patterns of media types never to compress
+}
+
+// parseCompressExclude parses a new-line delimited list of media type patterns, like text/csv or video/*.
+func parseCompressExclude(patterns string) ([]string, error) {
+	var parsed []string
+	for _, pattern := range strings.Split(patterns, "\n") {
+		if pattern = strings.TrimSpace(pattern); pattern == "" {
+			continue
+		}
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid content type pattern %q: %w", pattern, err)
+		}
+		parsed = append(parsed, pattern)
+	}
+	return parsed, nil
+}
+
+// compress returns middleware that compresses the responses of compressible content types.
+func compress(minSize int64, exclude []string) func(http.Handler) http.Handler {
+	c := &compressor{minSize: minSize, exclude: exclude}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			encoding := acceptedEncoding(r)
+			if encoding == "" || r.Method == http.MethodHead {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			cw := &compressWriter{ResponseWriter: w, compressor: c, encoding: encoding}
+			defer cw.Close()
+			next.ServeHTTP(cw, r)
+		})
+	}
+}
+
+// compressible returns true if responses of a content type are compressed.
+func (c *compressor) compressible(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	for _, pattern := range c.exclude {
+		if matched, _ := path.Match(pattern, mediaType); matched {
+			return false
+		}
+	}
+	return strings.HasPrefix(mediaType, "text/") || strings.HasSuffix(mediaType, "+json") || strings.HasSuffix(mediaType, "+xml") || compressibleTypes[mediaType]
+}
+
+// acceptedEncoding returns the compression encoding the request's Accept-Encoding header prefers, zstd or gzip, or an
+// empty string if it accepts neither. Ties go to zstd.
+func acceptedEncoding(r *http.Request) string {
+	var zstdQ, gzipQ float64
+	for _, accept := range r.Header.Values("Accept-Encoding") {
+		for _, coding := range strings.Split(accept, ",") {
+			name, params, _ := strings.Cut(coding, ";")
+			q := 1.0
+			if value, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+				var err error
+				if q, err = strconv.ParseFloat(value, 64); err != nil {
+					continue
+				}
+			}
+
+			switch strings.ToLower(strings.TrimSpace(name)) {
+			case "zstd":
+				zstdQ = max(zstdQ, q)
+			case "gzip", "x-gzip":
+				gzipQ = max(gzipQ, q)
+			}
+		}
+	}
+
+	switch {
+	case zstdQ > 0 && zstdQ >= gzipQ:
+		return "zstd"
+	case gzipQ > 0:
+		return "gzip"
+	}
+	return ""
+}
+
+// compressWriter compresses the response written through it, if it's compressible. Responses without a Content-Length
+// are buffered until they reach the minimum size, since it isn't known before then whether they'll be compressed.
+type compressWriter struct {
+	http.ResponseWriter
+	compressor *compressor
+	encoding   string
+
+	status  int            // The status the handler wrote, 0 until it writes one
+	decided bool           // Whether it's decided if the response is compressed, once the headers are written
+	buf     bytes.Buffer   // What's written before deciding
+	encoder io.WriteCloser // Nil unless the response is compressed
+}
+
+func (cw *compressWriter) WriteHeader(status int) {
+	if cw.status != 0 || cw.decided {
+		return
+	}
+	if status < http.StatusOK || status == http.StatusNoContent || status == http.StatusNotModified || status == http.StatusPartialContent {
+		// Nothing to compress, or part of a representation that can't be compressed on its own
+		cw.decide(false)
+		cw.ResponseWriter.WriteHeader(status)
+		return
+	}
+	cw.status = status
+
+	h := cw.Header()
+	if h.Get("Content-Encoding") != "" || !cw.compressor.compressible(h.Get("Content-Type")) {
+		cw.decide(false)
+		return
+	}
+	h.Add("Vary", "Accept-Encoding")
+	if length := h.Get("Content-Length"); length != "" {
+		n, err := strconv.ParseInt(length, 10, 64)
+		cw.decide(err == nil && n >= cw.compressor.minSize)
+	}
+}
+
+func (cw *compressWriter) Write(p []byte) (int, error) {
+	if cw.status == 0 && !cw.decided {
+		if cw.Header().Get("Content-Type") == "" {
+			cw.Header().Set("Content-Type", http.DetectContentType(p))
+		}
+		cw.WriteHeader(http.StatusOK)
+	}
+
+	switch {
+	case cw.encoder != nil:
+		return cw.encoder.Write(p)
+	case cw.decided:
+		return cw.ResponseWriter.Write(p)
+	}
+
+	n, _ := cw.buf.Write(p)
+	if int64(cw.buf.Len()) >= cw.compressor.minSize {
+		cw.decide(true)
+		if err := cw.flushBuffer(); err != nil {
+			return 0, err
+		}
+	}
+	return n, nil
+}
+
+// decide writes the headers of the response, compressed or not.
+func (cw *compressWriter) decide(compressed bool) {
+	if cw.decided {
+		return
+	}
+	cw.decided = true
+
+	if compressed {
+		h := cw.Header()
+		h.Set("Content-Encoding", cw.encoding)
+		// The compressed length isn't known until the whole response is written, and ranges of it can't be served
+		h.Del("Content-Length")
+		h.Del("Accept-Ranges")
+		if etag := h.Get("ETag"); strings.HasPrefix(etag, `"`) {
+			// The compressed bytes differ from those the strong entity tag of the file identifies
+			h.Set("ETag", "W/"+etag)
+		}
+
+		switch cw.encoding {
+		case "zstd":
+			encoder := zstdWriters.Get().(*zstd.Encoder)
+			encoder.Reset(cw.ResponseWriter)
+			cw.encoder = encoder
+		default:
+			encoder := gzipWriters.Get().(*gzip.Writer)
+			encoder.Reset(cw.ResponseWriter)
+			cw.encoder = encoder
+		}
+	}
+	if cw.status != 0 {
+		cw.ResponseWriter.WriteHeader(cw.status)
+	}
+}
+
+// flushBuffer writes what's been buffered before deciding.
+func (cw *compressWriter) flushBuffer() error {
+	if cw.buf.Len() == 0 {
+		return nil
+	}
+	var err error
+	if cw.encoder != nil {
+		_, err = cw.encoder.Write(cw.buf.Bytes())
+	} else {
+		_, err = cw.ResponseWriter.Write(cw.buf.Bytes())
+	}
+	cw.buf.Reset()
+	return err
+}
+
+// Flush writes what's been written so far to the client, deciding to compress the response if it's compressible, since
+// a response flushed as it's written, like a streamed listing, is likely to grow past the minimum size.
+func (cw *compressWriter) Flush() {
+	if !cw.decided {
+		if cw.status == 0 {
+			// Nothing's been written to flush
+			return
+		}
+		cw.decide(true)
+	}
+	if err := cw.flushBuffer(); err != nil {
+		return
+	}
+	if flusher, ok := cw.encoder.(interface{ Flush() error }); ok {
+		_ = flusher.Flush()
+	}
+	_ = http.NewResponseController(cw.ResponseWriter).Flush()
+}
+
+// Close writes whatever is left of the response, uncompressed if it never reached the minimum size, and returns the
+// encoder to its pool.
+func (cw *compressWriter) Close() error {
+	if cw.status != 0 && !cw.decided {
+		cw.decide(false)
+	}
+	err := cw.flushBuffer()
+	if cw.encoder == nil {
+		return err
+	}
+
+	if closeErr := cw.encoder.Close(); err == nil {
+		err = closeErr
+	}
+	switch encoder := cw.encoder.(type) {
+	case *zstd.Encoder:
+		zstdWriters.Put(encoder)
+	case *gzip.Writer:
+		gzipWriters.Put(encoder)
+	}
+	cw.encoder = nil
+	return err
+}
+
+// Unwrap returns the underlying response writer, so that http.ResponseController can reach it.
+func (cw *compressWriter) Unwrap() http.ResponseWriter {
+	return cw.ResponseWriter
+}
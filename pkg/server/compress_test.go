@@ -0,0 +1,155 @@
+package server
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+func TestAcceptedEncoding(t *testing.T) {
+	for accept, want := range map[string]string{
+		"":                        "",
+		"identity":                "",
+		"gzip":                    "gzip",
+		"x-gzip, deflate":         "gzip",
+		"gzip, zstd":              "zstd",
+		"zstd;q=0.5, gzip;q=0.8":  "gzip",
+		"zstd;q=0, gzip;q=0":      "",
+		"GZIP;q=0.1, br":          "gzip",
+		"zstd;q=invalid, gzip":    "gzip",
+		"zstd;q=0.9, gzip;q=0.90": "zstd",
+	} {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		if accept != "" {
+			r.Header.Set("Accept-Encoding", accept)
+		}
+		if got := acceptedEncoding(r); got != want {
+			t.Errorf("acceptedEncoding(%q) = %q, want %q", accept, got, want)
+		}
+	}
+}
+
+// decodeBody decodes a response body of the given content encoding.
+func decodeBody(t *testing.T, encoding string, body io.Reader) string {
+	t.Helper()
+
+	var (
+		r   io.Reader
+		err error
+	)
+	switch encoding {
+	case "":
+		r = body
+	case "gzip":
+		r, err = gzip.NewReader(body)
+	case "zstd":
+		var d *zstd.Decoder
+		d, err = zstd.NewReader(body)
+		if err == nil {
+			defer d.Close()
+		}
+		r = d
+	default:
+		t.Fatalf("unexpected content encoding %q", encoding)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestCompress(t *testing.T) {
+	long := strings.Repeat("compressible ", 100)
+	for _, tt := range []struct {
+		name        string
+		accept      string
+		contentType string
+		length      bool // Whether the handler sets a Content-Length
+		status      int
+		body        string
+		encoding    string
+	}{
+		{name: "gzip", accept: "gzip", contentType: "text/plain", body: long, encoding: "gzip"},
+		{name: "zstd", accept: "zstd, gzip", contentType: "application/json", body: long, encoding: "zstd"},
+		{name: "with length", accept: "gzip", contentType: "text/html; charset=utf-8", length: true, body: long, encoding: "gzip"},
+		{name: "sniffed", accept: "gzip", body: long, encoding: "gzip"},
+		{name: "not accepted", contentType: "text/plain", body: long},
+		{name: "too short", accept: "gzip", contentType: "text/plain", body: "short"},
+		{name: "too short with length", accept: "gzip", contentType: "text/plain", length: true, body: "short"},
+		{name: "incompressible", accept: "gzip", contentType: "image/png", body: long},
+		{name: "excluded", accept: "gzip", contentType: "text/csv", body: long},
+		{name: "partial content", accept: "gzip", contentType: "text/plain", status: http.StatusPartialContent, body: long},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			h := compress(64, []string{"text/csv"})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if tt.contentType != "" {
+					w.Header().Set("Content-Type", tt.contentType)
+				}
+				if tt.length {
+					w.Header().Set("Content-Length", strconv.Itoa(len(tt.body)))
+				}
+				if tt.status != 0 {
+					w.WriteHeader(tt.status)
+				}
+				// Write in pieces, so that short pieces are buffered until the response is long enough
+				for i := 0; i < len(tt.body); i += 10 {
+					_, _ = io.WriteString(w, tt.body[i:min(i+10, len(tt.body))])
+				}
+			}))
+
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tt.accept != "" {
+				r.Header.Set("Accept-Encoding", tt.accept)
+			}
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+
+			encoding := w.Header().Get("Content-Encoding")
+			if encoding != tt.encoding {
+				t.Errorf("Content-Encoding = %q, want %q", encoding, tt.encoding)
+			}
+			if encoding != "" && w.Header().Get("Content-Length") != "" {
+				t.Errorf("compressed response has the uncompressed Content-Length %s", w.Header().Get("Content-Length"))
+			}
+			if body := decodeBody(t, encoding, w.Body); body != tt.body {
+				t.Errorf("body = %q, want %q", body, tt.body)
+			}
+		})
+	}
+}
+
+func TestParseCompressExclude(t *testing.T) {
+	patterns, err := parseCompressExclude("text/csv\n\n video/* \n")
+	if err != nil || len(patterns) != 2 || patterns[0] != "text/csv" || patterns[1] != "video/*" {
+		t.Errorf("parseCompressExclude() = %q, %v, want [text/csv video/*]", patterns, err)
+	}
+	if _, err := parseCompressExclude("text/["); err == nil {
+		t.Error("parseCompressExclude() accepted a malformed pattern")
+	}
+}
+
+func TestServeCompression(t *testing.T) {
+	root := writeFiles(t, map[string]string{"a.txt": strings.Repeat("a", 2048)})
+	h := newTestReloadingHandler(t, Config{Root: root, Mask: "**", URLPrefix: "/files", Compression: true, CompressionMinSize: 1024})
+
+	r := httptest.NewRequest(http.MethodGet, "/files/a.txt", nil)
+	r.Header.Set("Accept-Encoding", "gzip")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if encoding := w.Header().Get("Content-Encoding"); encoding != "gzip" {
+		t.Fatalf("Content-Encoding = %q, want gzip", encoding)
+	}
+	if body := decodeBody(t, "gzip", w.Body); body != strings.Repeat("a", 2048) {
+		t.Errorf("body = %d bytes, want the 2048 of the file", len(body))
+	}
+}
@@ -0,0 +1,94 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestRunPprof(t *testing.T) {
+	root := writeFiles(t, map[string]string{"a.txt": "hello"})
+	addr, pprofAddr := freeAddr(t), freeAddr(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan error, 1)
+	go func() {
+		done <- Run(ctx, Config{
+			Root:            root,
+			Mask:            "**",
+			Listen:          []string{"tcp://" + addr},
+			EnablePprof:     true,
+			PprofAddr:       pprofAddr,
+			SocketMode:      "0600",
+			ShutdownTimeout: "5s",
+			RequestTimeout:  "0",
+		})
+	}()
+	waitForServer(t, addr)
+	waitForServer(t, pprofAddr)
+
+	get := func(url string) int {
+		t.Helper()
+		resp, err := http.Get(url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		return resp.StatusCode
+	}
+	for _, path := range []string{"/debug/pprof/", "/debug/pprof/cmdline"} {
+		if code := get("http://" + pprofAddr + path); code != http.StatusOK {
+			t.Errorf("GET %s on the pprof address = %d, want %d", path, code, http.StatusOK)
+		}
+	}
+	resp, err := http.Get("http://" + pprofAddr + "/debug/vars")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var vars map[string]any
+	err = json.NewDecoder(resp.Body).Decode(&vars)
+	resp.Body.Close()
+	if err != nil || vars["memstats"] == nil {
+		t.Errorf("GET /debug/vars = %v, %v, want the expvar variables", vars, err)
+	}
+
+	// The debug endpoints are never served with the files, and the files never with them
+	if code := get("http://" + addr + "/debug/pprof/"); code == http.StatusOK {
+		t.Errorf("GET /debug/pprof/ on the file server's address = %d", code)
+	}
+	if code := get("http://" + pprofAddr + "/files/a.txt"); code != http.StatusNotFound {
+		t.Errorf("GET /files/a.txt on the pprof address = %d, want %d", code, http.StatusNotFound)
+	}
+
+	cancel()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("Run() = %v, want a clean shutdown", err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("Run() didn't return after the context was canceled")
+	}
+}
+
+func TestRunPprofListenError(t *testing.T) {
+	l := listenLoopback(t)
+	defer l.Close()
+
+	err := Run(context.Background(), Config{
+		Root:            t.TempDir(),
+		Mask:            "**",
+		Listen:          []string{"tcp://" + freeAddr(t)},
+		EnablePprof:     true,
+		PprofAddr:       l.Addr().String(),
+		SocketMode:      "0600",
+		ShutdownTimeout: "5s",
+		RequestTimeout:  "0",
+	})
+	if err == nil {
+		t.Error("Run() with the pprof address in use succeeded")
+	}
+}
@@ -0,0 +1,143 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/njhale/maskfs/pkg/clock"
+	"github.com/njhale/maskfs/pkg/index"
+)
+
+func TestServeDelete(t *testing.T) {
+	now := time.Date(2024, time.June, 1, 12, 0, 0, 0, time.UTC)
+	trashed := filepath.Join(".trash", "20240601T120000.000000000Z")
+
+	for _, tt := range []struct {
+		name    string
+		path    string
+		code    int
+		gone    []string // Paths that must no longer exist
+		kept    []string // Paths that must still exist
+		trashed []string // Paths that must have been moved into the trash
+	}{
+		{name: "file", path: "uploads/a.txt", code: http.StatusNoContent, gone: []string{"uploads/a.txt"}, trashed: []string{"uploads/a.txt"}},
+		{name: "broken symlink", path: "uploads/broken", code: http.StatusNoContent, gone: []string{"uploads/broken"}, trashed: []string{"uploads/broken"}},
+		{name: "masked", path: "uploads/secret.key", code: http.StatusNotFound, kept: []string{"uploads/secret.key"}},
+		{name: "missing", path: "uploads/missing.txt", code: http.StatusNotFound},
+		{name: "denied by the write mask", path: "readonly.txt", code: http.StatusForbidden, kept: []string{"readonly.txt"}},
+		{name: "directory", path: "uploads/sub", code: http.StatusConflict, kept: []string{"uploads/sub/b.txt"}},
+		{name: "rule file", path: "uploads/.maskfs", code: http.StatusForbidden, kept: []string{"uploads/.maskfs"}},
+		{name: "trash", path: ".trash/old/c.txt", code: http.StatusNotFound, kept: []string{".trash/old/c.txt"}},
+		{name: "root", path: "", code: http.StatusNotFound},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := writeFiles(t, map[string]string{
+				"uploads/a.txt":      "a",
+				"uploads/.maskfs":    "!*.tmp\n",
+				"uploads/secret.key": "",
+				"uploads/sub/b.txt":  "",
+				"readonly.txt":       "",
+				".trash/old/c.txt":   "",
+			})
+			if err := os.Symlink("missing", filepath.Join(dir, "uploads", "broken")); err != nil {
+				t.Fatal(err)
+			}
+			s := newTestServer(t, Config{Root: dir, Mask: "**\n!*.key", WriteMask: "**\n!readonly.txt", TrashDir: ".trash", NestedMaskFile: ".maskfs"})
+			s.SetClock(clock.Fixed(now))
+
+			w := httptest.NewRecorder()
+			http.StripPrefix("/files/", s).ServeHTTP(w, httptest.NewRequest(http.MethodDelete, "/files/"+tt.path, nil))
+			if w.Code != tt.code {
+				t.Errorf("DELETE %s = %d %s, want %d", tt.path, w.Code, w.Body, tt.code)
+			}
+			for _, name := range tt.gone {
+				if _, err := os.Lstat(filepath.Join(dir, filepath.FromSlash(name))); !os.IsNotExist(err) {
+					t.Errorf("%s still exists", name)
+				}
+			}
+			for _, name := range tt.kept {
+				if _, err := os.Lstat(filepath.Join(dir, filepath.FromSlash(name))); err != nil {
+					t.Errorf("%s is gone: %v", name, err)
+				}
+			}
+			for _, name := range tt.trashed {
+				if _, err := os.Lstat(filepath.Join(dir, trashed, filepath.FromSlash(name))); err != nil {
+					t.Errorf("%s isn't in the trash: %v", name, err)
+				}
+			}
+		})
+	}
+
+	t.Run("deletes disabled", func(t *testing.T) {
+		dir := writeFiles(t, map[string]string{"a.txt": ""})
+		w := httptest.NewRecorder()
+		newHandler(t, Config{Root: dir, Mask: "**", WriteMask: "**"}).ServeHTTP(w, httptest.NewRequest(http.MethodDelete, "/files/a.txt", nil))
+		if w.Code != http.StatusMethodNotAllowed {
+			t.Errorf("DELETE = %d, want %d", w.Code, http.StatusMethodNotAllowed)
+		}
+	})
+
+	t.Run("trash hidden from listings", func(t *testing.T) {
+		dir := writeFiles(t, map[string]string{"a.txt": "", ".trash/old/c.txt": ""})
+		h := newHandler(t, Config{Root: dir, Mask: "**", WriteMask: "**", TrashDir: ".trash"})
+
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/files/", nil))
+		if !strings.Contains(w.Body.String(), ">a.txt</a>") || strings.Contains(w.Body.String(), ".trash") {
+			t.Errorf("listing doesn't show exactly a.txt:\n%s", w.Body)
+		}
+		for _, p := range []string{"/files/.trash/", "/files/.trash/old/c.txt"} {
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, p, nil))
+			if w.Code != http.StatusNotFound {
+				t.Errorf("GET %s = %d, want %d", p, w.Code, http.StatusNotFound)
+			}
+		}
+	})
+}
+
+func TestParseTrashDir(t *testing.T) {
+	for _, tt := range []struct {
+		dir  string
+		want string
+		err  bool
+	}{
+		{dir: "", want: ""},
+		{dir: ".trash", want: ".trash"},
+		{dir: "a/b/", want: "a/b"},
+		{dir: "a/../trash", want: "trash"},
+		{dir: ".", err: true},
+		{dir: "a/..", err: true},
+		{dir: "/trash", err: true},
+		{dir: "../trash", err: true},
+		{dir: "a/../../trash", err: true},
+	} {
+		got, err := parseTrashDir(tt.dir)
+		if (err != nil) != tt.err || got != tt.want {
+			t.Errorf("parseTrashDir(%q) = %q, %v, want %q, error %t", tt.dir, got, err, tt.want, tt.err)
+		}
+	}
+}
+
+func TestHiddenDir(t *testing.T) {
+	h := hiddenDir("a/trash")
+	for fsPath, want := range map[string]bool{
+		"a/trash":     true,
+		"a/trash/b":   true,
+		"a/trash/b/c": true,
+		"a":           false,
+		"a/trashcan":  false,
+		"a/trash.txt": false,
+		"b/a/trash":   false,
+		"trash":       false,
+	} {
+		if got := h.Masked(&index.Entry{FSPath: fsPath}); got != want {
+			t.Errorf("Masked(%q) = %t, want %t", fsPath, got, want)
+		}
+	}
+}
@@ -0,0 +1,127 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/njhale/maskfs/pkg/index"
+	"github.com/njhale/maskfs/pkg/logger"
+)
+
+// dirSizeKey identifies the total size of a directory as seen through a set of masks, since clients with different mask
+// profiles, or the same clients after the mask is reloaded, see different files below it.
+type dirSizeKey struct {
+	path  string
+	masks *masks
+}
+
+type dirSize struct {
+	size     int64
+	computed time.Time
+}
+
+// dirSizeCache caches the total sizes of directories, so that listing a directory doesn't walk everything below its
+// subdirectories every time. Sizes expire after a while, since files can change below a directory without changing
+// the directory itself, and are dropped as soon as the server itself writes or deletes a file below them.
+type dirSizeCache struct {
+	mu    sync.Mutex
+	sizes map[dirSizeKey]dirSize
+	ttl   time.Duration // How long sizes are cached, 0 to compute them for every listing
+}
+
+// maxDirSizes is the maximum number of directory sizes to cache.
+const maxDirSizes = 10000
+
+func newDirSizeCache(ttl time.Duration) *dirSizeCache {
+	return &dirSizeCache{
+		sizes: map[dirSizeKey]dirSize{},
+		ttl:   ttl,
+	}
+}
+
+func (c *dirSizeCache) get(key dirSizeKey, now time.Time) (int64, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	size, ok := c.sizes[key]
+	if !ok || now.Sub(size.computed) >= c.ttl {
+		return 0, false
+	}
+	return size.size, true
+}
+
+func (c *dirSizeCache) put(key dirSizeKey, size int64, now time.Time) {
+	if c.ttl <= 0 {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for k := range c.sizes {
+		if len(c.sizes) < maxDirSizes {
+			break
+		}
+		// Evict an arbitrary size to make room, map iteration order is random
+		delete(c.sizes, k)
+	}
+	c.sizes[key] = dirSize{size: size, computed: now}
+}
+
+// invalidate drops the sizes of every directory above the given path, whose total sizes include it.
+func (c *dirSizeCache) invalidate(fsPath string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for k := range c.sizes {
+		if k.path == "." || strings.HasPrefix(fsPath, k.path+"/") {
+			delete(c.sizes, k)
+		}
+	}
+}
+
+// setDirSizes sets the total size of each directory among the given entries to the sum of the sizes of the unmasked
+// files below it, from the cache if it was computed recently. Symlinked directories, which aren't walked, loops, and
+// directories with something below them that can't be read are left without one.
+func (s *Server) setDirSizes(ctx context.Context, fsys fs.FS, m *masks, entries ...*index.Entry) error {
+	if !s.dirSizes {
+		return nil
+	}
+
+	for _, entry := range entries {
+		if !entry.IsDir || entry.IsSymlink || entry.Loop {
+			continue
+		}
+
+		key := dirSizeKey{path: entry.FSPath, masks: m}
+		if size, ok := s.dirSizeCache.get(key, s.clock.Now()); ok {
+			entry.TotalSize = &size
+			continue
+		}
+
+		var size int64
+		err := index.Walk(fsys, entry.FSPath, m.all, func(descendant *index.Entry, _ int) error {
+			if !descendant.IsDir {
+				size += descendant.Size
+			}
+			return nil
+		})
+		if errors.Is(err, errBudgetExceeded) {
+			return err
+		}
+		if err != nil {
+			// A partial size would be misleading, leave it out rather than failing the whole listing
+			logger.ForContext(ctx, s.logger).Debugf("Not reporting the size of %q: %v", entry.FSPath, err)
+			continue
+		}
+
+		s.dirSizeCache.put(key, size, s.clock.Now())
+		entry.TotalSize = &size
+	}
+
+	return nil
+}
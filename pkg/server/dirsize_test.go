@@ -0,0 +1,89 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/njhale/maskfs/pkg/clock"
+)
+
+func TestServeDirSizes(t *testing.T) {
+	root := writeFiles(t, map[string]string{
+		"a.txt":          "hello",
+		"dir/b.txt":      "123",
+		"dir/secret.key": "secret",
+		"dir/sub/c.txt":  "1234567",
+	})
+	if err := os.Symlink("dir", filepath.Join(root, "link")); err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	s := newTestServer(t, Config{Root: root, Mask: "**\n!*.key", DirSizes: true, DirSizeCache: "1m", WriteMask: "dir/**"})
+	s.clock = clock.Fixed(now)
+	h := http.StripPrefix("/files/", s)
+
+	sizes := func(target string) map[string]*int64 {
+		t.Helper()
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, target, nil))
+		var listing struct {
+			Entries []struct {
+				Name      string `json:"name"`
+				TotalSize *int64 `json:"total_size"`
+			} `json:"entries"`
+		}
+		if err := json.NewDecoder(w.Body).Decode(&listing); err != nil {
+			t.Fatal(err)
+		}
+		sizes := map[string]*int64{}
+		for _, entry := range listing.Entries {
+			sizes[entry.Name] = entry.TotalSize
+		}
+		return sizes
+	}
+	expect := func(target, name string, want int64) {
+		t.Helper()
+		if size := sizes(target)[name]; size == nil || *size != want {
+			t.Errorf("total size of %s in %s = %v, want %d", name, target, size, want)
+		}
+	}
+
+	// Sizes only count unmasked files, and symlinked directories and files have none
+	expect("/files/?format=json", "dir", 10)
+	expect("/files/dir/?format=json", "sub", 7)
+	for _, name := range []string{"a.txt", "link"} {
+		if size := sizes("/files/?format=json")[name]; size != nil {
+			t.Errorf("total size of %s = %d, want none", name, *size)
+		}
+	}
+
+	// Sizes are cached until they expire, unless the server itself writes below them
+	if err := os.WriteFile(filepath.Join(root, "dir", "d.txt"), []byte("12"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	expect("/files/?format=json", "dir", 10)
+	s.clock = clock.Fixed(now.Add(time.Minute))
+	expect("/files/?format=json", "dir", 12)
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodPut, "/files/dir/sub/e.txt", strings.NewReader("1234")))
+	if w.Code != http.StatusCreated {
+		t.Fatalf("PUT = %d %s, want %d", w.Code, w.Body, http.StatusCreated)
+	}
+	expect("/files/?format=json", "dir", 16)
+}
+
+func TestServeDirSizesDisabled(t *testing.T) {
+	root := writeFiles(t, map[string]string{"dir/a.txt": "hello"})
+	w := httptest.NewRecorder()
+	newHandler(t, Config{Root: root, Mask: "**"}).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/files/?format=json", nil))
+	if strings.Contains(w.Body.String(), "total_size") {
+		t.Errorf("listing %s has total sizes, want none", w.Body)
+	}
+}
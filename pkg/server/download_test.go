@@ -0,0 +1,39 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestServeDownloads(t *testing.T) {
+	root := writeFiles(t, map[string]string{"a.txt": "a", "b.SVG": "<svg/>", "c.exe": "", "naïve file.txt": ""})
+	h := newHandler(t, Config{Root: root, Mask: "**", DownloadExtensions: []string{".svg", "EXE", " "}})
+
+	for _, tt := range []struct {
+		target      string
+		code        int
+		disposition string
+	}{
+		{target: "/files/a.txt", code: http.StatusOK},
+		{target: "/files/a.txt?download=false", code: http.StatusOK},
+		{target: "/files/a.txt?download=1", code: http.StatusOK, disposition: `attachment; filename=a.txt`},
+		// Extensions match regardless of case and of whether they were given with a dot
+		{target: "/files/b.SVG", code: http.StatusOK, disposition: `attachment; filename=b.SVG`},
+		{target: "/files/c.exe", code: http.StatusOK, disposition: `attachment; filename=c.exe`},
+		// Names that aren't plain tokens are encoded
+		{target: "/files/na%C3%AFve%20file.txt?download=true", code: http.StatusOK, disposition: `attachment; filename*=utf-8''na%C3%AFve%20file.txt`},
+		{target: "/files/a.txt?download=maybe", code: http.StatusBadRequest},
+	} {
+		t.Run(tt.target, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, tt.target, nil))
+			if w.Code != tt.code {
+				t.Fatalf("GET %s = %d, want %d", tt.target, w.Code, tt.code)
+			}
+			if disposition := w.Header().Get("Content-Disposition"); disposition != tt.disposition {
+				t.Errorf("Content-Disposition = %q, want %q", disposition, tt.disposition)
+			}
+		})
+	}
+}
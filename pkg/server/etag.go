@@ -0,0 +1,107 @@
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/njhale/maskfs/pkg/index"
+)
+
+var (
+	// maskEpoch tells the mask versions of this process apart from those of earlier ones, which counted from 1 too.
+	maskEpoch = strconv.FormatInt(time.Now().UnixNano(), 36)
+	// maskVersions counts the masks loaded by the process.
+	maskVersions atomic.Uint64
+)
+
+// newMaskVersion returns a version identifying newly loaded masks, which is mixed into the validators of responses so
+// that clients revalidating them after the mask changes don't keep entries whose masked status changed.
+func newMaskVersion() string {
+	return maskEpoch + "." + strconv.FormatUint(maskVersions.Add(1), 36)
+}
+
+// listingETag returns a weak entity tag identifying a rendered listing, derived from everything that goes into it:
+// the version of the masks, the format, the directory and entries along with their metadata, and the links to the
+// adjacent pages.
+func listingETag(m *masks, format string, directory *index.Entry, entries index.Entries, prevLink, nextLink string) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\x00%s\x00%s\x00%s\x00%s\x00", m.version, format, directory.FSPath, prevLink, nextLink)
+	for _, entry := range entries {
+		fmt.Fprintf(h, "%s\x00%d\x00%d\x00%s\x00%t\x00%s\x00", entry.FSPath, entry.Size, entry.ModTime.UnixNano(), entry.Mode, entry.Loop, entry.Error)
+		if entry.Owner != nil {
+			// Changing owners doesn't change modification times
+			fmt.Fprintf(h, "owner=%d:%d\x00", entry.Owner.UID, entry.Owner.GID)
+		}
+		if entry.HostInfo != nil {
+			// Nor does adding hard links
+			fmt.Fprintf(h, "host=%d:%d\x00", entry.HostInfo.Inode, entry.HostInfo.Links)
+		}
+		if entry.TotalSize != nil {
+			fmt.Fprintf(h, "total=%d\x00", *entry.TotalSize)
+		}
+
+		keys := make([]string, 0, len(entry.Metadata))
+		for key := range entry.Metadata {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			fmt.Fprintf(h, "%s=%s\x00", key, entry.Metadata[key])
+		}
+	}
+
+	return `W/"` + hex.EncodeToString(h.Sum(nil)[:16]) + `"`
+}
+
+// fileETag returns a strong entity tag identifying the contents of a file along with the version of the masks it was
+// served with. Like the entity tags of most servers, it's derived from the file's modification time and size rather
+// than from its contents, and kind is mixed in for the representations derived from the file, like rendered pages.
+func fileETag(m *masks, kind string, entry *index.Entry) string {
+	return fmt.Sprintf(`"%s%s-%x-%x"`, kind, m.version, entry.ModTime.UnixNano(), entry.Size)
+}
+
+// lastModified returns the latest modification time of a directory and the given entries, or the time the masks were
+// loaded if that's later, since loading other masks can change which entries are listed.
+// Adding or removing entries changes the directory's own modification time.
+func lastModified(m *masks, directory *index.Entry, entries index.Entries) time.Time {
+	latest := directory.ModTime
+	for _, entry := range entries {
+		if entry.ModTime.After(latest) {
+			latest = entry.ModTime
+		}
+	}
+	if m.loaded.After(latest) {
+		latest = m.loaded
+	}
+	return latest
+}
+
+// notModified returns true if the request's conditional headers show that the client already has the current
+// representation. If-None-Match takes precedence over If-Modified-Since, as required by RFC 9110.
+func notModified(r *http.Request, etag string, modTime time.Time) bool {
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		for _, candidate := range strings.Split(inm, ",") {
+			candidate = strings.TrimSpace(candidate)
+			// Conditional GETs use the weak comparison, which ignores the weak indicator
+			if candidate == "*" || strings.TrimPrefix(candidate, "W/") == strings.TrimPrefix(etag, "W/") {
+				return true
+			}
+		}
+		return false
+	}
+
+	if ims := r.Header.Get("If-Modified-Since"); ims != "" && !modTime.IsZero() {
+		t, err := http.ParseTime(ims)
+		// HTTP dates have a resolution of a second
+		return err == nil && !modTime.Truncate(time.Second).After(t)
+	}
+
+	return false
+}
@@ -0,0 +1,60 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/njhale/maskfs/pkg/index"
+)
+
+// Explanation describes why an entry is masked or not.
+type Explanation struct {
+	FSPath     string `json:"fs_path"`
+	Masked     bool   `json:"masked"`              // True if any mask masks the entry
+	PathMasked bool   `json:"path_masked"`         // True if the path rules alone mask the entry, otherwise another mask like the junk patterns did
+	Rule       string `json:"rule,omitempty"`      // The path rule that decided whether the entry is masked, empty if no rule matched it
+	Index      int    `json:"index"`               // The index of the rule among the path rules, -1 if no rule matched the entry
+	RuleFile   string `json:"rule_file,omitempty"` // The per-directory mask file the rule was read from, empty for the configured rules
+}
+
+// Explain returns why the entry at the given path is masked or not by the server's current mask.
+// The root directory is never masked, like it's never masked for requests.
+func (s *Server) Explain(fsPath string) (*Explanation, error) {
+	entry, err := index.GetEntry(s.fsys, fsPath)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.ExplainEntry(entry), nil
+}
+
+// ExplainEntry returns why the given entry is masked or not by the server's current mask. The entry doesn't have to
+// exist, so masks can be checked against paths before files are created at them.
+func (s *Server) ExplainEntry(entry *index.Entry) *Explanation {
+	return s.masks.Load().explain(entry)
+}
+
+func (m *masks) explain(entry *index.Entry) *Explanation {
+	pathMasked, rule, i := m.path.Explain(entry)
+	explanation := &Explanation{
+		FSPath:     entry.FSPath,
+		Masked:     !entry.IsRoot() && m.all.Masked(entry),
+		PathMasked: !entry.IsRoot() && pathMasked,
+		Rule:       rule,
+		Index:      i,
+	}
+
+	// Only glob masks layer per-directory mask files
+	if layered, ok := m.path.(interface{ RuleFile(*index.Entry) string }); ok {
+		explanation.RuleFile = layered.RuleFile(entry)
+	}
+
+	return explanation
+}
+
+// writeExplanation writes why the entry is masked or not as JSON.
+func writeExplanation(w http.ResponseWriter, m *masks, entry *index.Entry) error {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	return json.NewEncoder(w).Encode(m.explain(entry))
+}
@@ -0,0 +1,73 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/njhale/maskfs/pkg/logger"
+)
+
+func TestServeExplain(t *testing.T) {
+	dir := writeFiles(t, map[string]string{"a.txt": "a", "b.key": "b", "c.md": "c", ".DS_Store": "", "sub/.maskfs": "*.txt\n", "sub/e.txt": "e"})
+	cfg := Config{Root: dir, Mask: "**\n!*.key", HideJunk: ".DS_Store", NestedMaskFile: ".maskfs", ExplainMasks: true}
+
+	for _, tt := range []struct {
+		path string
+		code int
+		want Explanation
+	}{
+		{path: "a.txt", code: http.StatusOK, want: Explanation{FSPath: "a.txt", Rule: "**", Index: 0}},
+		{path: "c.md", code: http.StatusOK, want: Explanation{FSPath: "c.md", Rule: "**", Index: 0}},
+		{path: "sub/e.txt", code: http.StatusOK, want: Explanation{FSPath: "sub/e.txt", Rule: "*.txt", Index: 2, RuleFile: "sub/.maskfs"}},
+		// Masked entries aren't explained, so explanations can't tell them apart from missing ones
+		{path: "b.key", code: http.StatusNotFound},
+		{path: ".DS_Store", code: http.StatusNotFound},
+		{path: "missing.txt", code: http.StatusNotFound},
+	} {
+		w := httptest.NewRecorder()
+		newHandler(t, cfg).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/files/"+tt.path+"?explain=1", nil))
+		if w.Code != tt.code {
+			t.Errorf("GET %s?explain=1 = %d, want %d", tt.path, w.Code, tt.code)
+		}
+		if w.Code != http.StatusOK {
+			continue
+		}
+
+		var got Explanation
+		if err := json.NewDecoder(w.Body).Decode(&got); err != nil {
+			t.Fatal(err)
+		}
+		if got != tt.want {
+			t.Errorf("GET %s?explain=1 = %+v, want %+v", tt.path, got, tt.want)
+		}
+	}
+
+	// Explanations are refused to clients bound to a mask profile
+	w := httptest.NewRecorder()
+	newHandler(t, cfg).ServeHTTP(w, withProfile(httptest.NewRequest(http.MethodGet, "/files/a.txt?explain=1", nil), "guest"))
+	if w.Code != http.StatusForbidden {
+		t.Errorf("GET a.txt?explain=1 bound to a mask profile = %d, want %d", w.Code, http.StatusForbidden)
+	}
+
+	// Explanations are refused unless they're enabled
+	cfg.ExplainMasks = false
+	w = httptest.NewRecorder()
+	newHandler(t, cfg).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/files/a.txt?explain=1", nil))
+	if w.Code != http.StatusForbidden {
+		t.Errorf("GET a.txt?explain=1 with explanations disabled = %d, want %d", w.Code, http.StatusForbidden)
+	}
+}
+
+func TestExplainMasksRequiresAuth(t *testing.T) {
+	h := &reloadingHandler{
+		ctx:    context.Background(),
+		cfg:    Config{Root: t.TempDir(), Mask: "**", ExplainMasks: true, ShutdownTimeout: "5s", RequestTimeout: "0"},
+		logger: logger.New("test"),
+	}
+	if err := h.Reload(); err == nil {
+		t.Error("Reload() enabled mask explanations without authentication")
+	}
+}
@@ -0,0 +1,85 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"path"
+	"strings"
+
+	"golang.org/x/net/http/httpguts"
+)
+
+// headerRule sets a response header on requests whose URL paths match a glob.
+type headerRule struct {
+	glob  string // Empty to match every request
+	name  string
+	value string
+}
+
+// parseHeaderRule parses a header rule given as [glob=]Name: value, like /files/static/**=Cache-Control: max-age=300.
+// Globs are matched against the whole URL path, where * matches within a path segment and ** matches any number of
+// segments.
+func parseHeaderRule(spec string) (headerRule, error) {
+	before, value, ok := strings.Cut(spec, ":")
+	if !ok {
+		return headerRule{}, fmt.Errorf("invalid header %q, must be [glob=]Name: value", spec)
+	}
+
+	var rule headerRule
+	if glob, name, ok := strings.Cut(before, "="); ok {
+		rule.glob, before = strings.TrimSpace(glob), name
+		if _, err := path.Match(strings.ReplaceAll(rule.glob, "**", "*"), ""); err != nil || !strings.HasPrefix(rule.glob, "/") {
+			return headerRule{}, fmt.Errorf("invalid glob %q of header %q, must be an absolute URL path pattern", rule.glob, spec)
+		}
+	}
+	rule.name, rule.value = http.CanonicalHeaderKey(strings.TrimSpace(before)), strings.TrimSpace(value)
+	if !httpguts.ValidHeaderFieldName(rule.name) || !httpguts.ValidHeaderFieldValue(rule.value) {
+		return headerRule{}, fmt.Errorf("invalid header %q, must be [glob=]Name: value", spec)
+	}
+
+	return rule, nil
+}
+
+// setHeaders returns middleware that sets the headers of the rules matching each request's URL path before handling
+// it, in order, so that later rules replace the headers of earlier ones. Handlers can still replace them too, like the
+// file server does with Content-Type.
+func setHeaders(rules []headerRule) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			for _, rule := range rules {
+				if rule.glob == "" || matchURLPath(rule.glob, r.URL.Path) {
+					w.Header().Set(rule.name, rule.value)
+				}
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// matchURLPath returns true if a URL path matches a glob, segment by segment, where ** matches any number of segments,
+// including none, and the other segments are matched with path.Match.
+func matchURLPath(glob, urlPath string) bool {
+	return matchSegments(strings.Split(strings.Trim(glob, "/"), "/"), strings.Split(strings.Trim(urlPath, "/"), "/"))
+}
+
+func matchSegments(glob, segments []string) bool {
+	for len(glob) > 0 {
+		if glob[0] == "**" {
+			for i := len(segments); i >= 0; i-- {
+				if matchSegments(glob[1:], segments[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+
+		if len(segments) == 0 {
+			return false
+		}
+		if matched, _ := path.Match(glob[0], segments[0]); !matched {
+			return false
+		}
+		glob, segments = glob[1:], segments[1:]
+	}
+	return len(segments) == 0
+}
@@ -0,0 +1,98 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestParseHeaderRule(t *testing.T) {
+	for _, tt := range []struct {
+		spec string
+		want headerRule
+	}{
+		{spec: "X-Frame-Options: DENY", want: headerRule{name: "X-Frame-Options", value: "DENY"}},
+		{spec: "cache-control:no-store", want: headerRule{name: "Cache-Control", value: "no-store"}},
+		{spec: "/files/static/**=Cache-Control: max-age=300", want: headerRule{glob: "/files/static/**", name: "Cache-Control", value: "max-age=300"}},
+		{spec: "X-Empty:", want: headerRule{name: "X-Empty"}},
+	} {
+		if got, err := parseHeaderRule(tt.spec); err != nil || got != tt.want {
+			t.Errorf("parseHeaderRule(%q) = %+v, %v, want %+v", tt.spec, got, err, tt.want)
+		}
+	}
+
+	for _, spec := range []string{
+		"X-Frame-Options",
+		"Bad Name: value",
+		": value",
+		"X-Injected: a\nb",
+		"files/**=X-Relative: glob",
+		"/files/[=X-Malformed: glob",
+	} {
+		if _, err := parseHeaderRule(spec); err == nil {
+			t.Errorf("parseHeaderRule(%q) succeeded, want an error", spec)
+		}
+	}
+}
+
+func TestMatchURLPath(t *testing.T) {
+	for _, tt := range []struct {
+		glob, urlPath string
+		want          bool
+	}{
+		{glob: "/files/*.css", urlPath: "/files/site.css", want: true},
+		{glob: "/files/*.css", urlPath: "/files/static/site.css"},
+		{glob: "/files/**", urlPath: "/files/", want: true},
+		{glob: "/files/**", urlPath: "/files/a/b/c.txt", want: true},
+		{glob: "/files/**", urlPath: "/other/a.txt"},
+		{glob: "/**/*.js", urlPath: "/files/static/app.js", want: true},
+		{glob: "/**/*.js", urlPath: "/app.js", want: true},
+		{glob: "/files/static/**/*.js", urlPath: "/files/static/app.css"},
+		{glob: "/files", urlPath: "/files/", want: true},
+	} {
+		if got := matchURLPath(tt.glob, tt.urlPath); got != tt.want {
+			t.Errorf("matchURLPath(%q, %q) = %t, want %t", tt.glob, tt.urlPath, got, tt.want)
+		}
+	}
+}
+
+func TestServeHeaders(t *testing.T) {
+	root := writeFiles(t, map[string]string{"a.txt": "a", "static/site.css": "body {}"})
+	h := newTestReloadingHandler(t, Config{
+		Root:      root,
+		Mask:      "**",
+		URLPrefix: "/files",
+		Header: []string{
+			"X-Frame-Options: DENY",
+			"Cache-Control: no-store",
+			// Later rules replace the headers of earlier ones
+			"/files/static/**=Cache-Control: max-age=300",
+			// Handlers still set their own headers
+			"Content-Type: application/x-overridden",
+		},
+	})
+
+	for _, tt := range []struct {
+		target       string
+		cacheControl string
+	}{
+		{target: "/files/a.txt", cacheControl: "no-store"},
+		{target: "/files/static/site.css", cacheControl: "max-age=300"},
+	} {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, tt.target, nil))
+		if got := w.Header().Get("X-Frame-Options"); got != "DENY" {
+			t.Errorf("GET %s X-Frame-Options = %q, want DENY", tt.target, got)
+		}
+		if got := w.Header().Get("Cache-Control"); got != tt.cacheControl {
+			t.Errorf("GET %s Cache-Control = %q, want %q", tt.target, got, tt.cacheControl)
+		}
+		if got := w.Header().Get("Content-Type"); got == "application/x-overridden" {
+			t.Errorf("GET %s Content-Type = %q, want the file's own", tt.target, got)
+		}
+	}
+
+	if _, err := New(WithConfig(Config{Root: root, Header: []string{"invalid"}, ShutdownTimeout: "5s", RequestTimeout: "0"})); err == nil {
+		t.Error("New() accepted an invalid header")
+	}
+}
@@ -0,0 +1,98 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// canonicalHost returns middleware that permanently redirects requests for any host other than the canonical host to
+// the same path and query on the canonical host. If the canonical host has no port, only the hostnames are compared.
+// The scheme of requests from the filter's trusted proxies is the one they forward.
+func canonicalHost(host string, proxies *ipFilter) func(http.Handler) http.Handler {
+	_, _, err := net.SplitHostPort(host)
+	withPort := err == nil
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requested := r.Host
+			if !withPort {
+				if hostname, _, err := net.SplitHostPort(requested); err == nil {
+					requested = hostname
+				}
+			}
+
+			if strings.EqualFold(requested, host) {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			http.Redirect(w, r, scheme(r, proxies)+"://"+host+r.URL.RequestURI(), http.StatusMovedPermanently)
+		})
+	}
+}
+
+// scheme returns the scheme the client used to make a request, respecting the X-Forwarded-Proto header set by the
+// filter's trusted proxies. Anyone else could claim any scheme with it.
+func scheme(r *http.Request, proxies *ipFilter) string {
+	if proto := r.Header.Get("X-Forwarded-Proto"); proto != "" && proxies.fromProxy(r) {
+		// Proxies may append to the header, the first value is the one the client used
+		proto, _, _ = strings.Cut(proto, ",")
+		if proto = strings.ToLower(strings.TrimSpace(proto)); proto == "http" || proto == "https" {
+			return proto
+		}
+	}
+	if r.TLS != nil {
+		return "https"
+	}
+	return "http"
+}
+
+// parseVirtualHost parses a virtual host given as host=root or host=root=mask, returning the host and the configuration
+// of the server backing it. Like a mount, the virtual host shares every setting of the given configuration except for
+// its root and, when one is given, its mask, which replaces the mask file and mask URL too. Mounts and virtual hosts
+// only apply to the default host.
+func parseVirtualHost(cfg Config, spec string) (string, Config, error) {
+	parts := strings.SplitN(spec, "=", 3)
+	if len(parts) < 2 || parts[0] == "" || parts[1] == "" {
+		return "", Config{}, fmt.Errorf("malformed virtual host %q, must be host=root or host=root=mask", spec)
+	}
+	host := strings.ToLower(parts[0])
+	if strings.ContainsAny(host, "/ ") {
+		return "", Config{}, fmt.Errorf("invalid virtual host %q", parts[0])
+	}
+
+	cfg.Root = parts[1]
+	cfg.GitRepo = ""
+	cfg.Overlay = nil
+	if len(parts) == 3 {
+		cfg.Mask = parts[2]
+		cfg.MaskFile = ""
+		cfg.MaskURL = ""
+	}
+	cfg.Mount = nil
+	cfg.VirtualHost = nil
+
+	return host, cfg, nil
+}
+
+// virtualHosts returns a handler routing requests by their host to the handler of that host, or to the default handler
+// for any other host. Hosts without a port match requests for the host on any port.
+func virtualHosts(hosts map[string]http.Handler, fallback http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requested := strings.ToLower(r.Host)
+		if h, ok := hosts[requested]; ok {
+			h.ServeHTTP(w, r)
+			return
+		}
+		if hostname, _, err := net.SplitHostPort(requested); err == nil {
+			if h, ok := hosts[hostname]; ok {
+				h.ServeHTTP(w, r)
+				return
+			}
+		}
+
+		fallback.ServeHTTP(w, r)
+	})
+}
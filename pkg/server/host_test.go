@@ -0,0 +1,144 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/njhale/maskfs/pkg/logger"
+)
+
+func TestCanonicalHost(t *testing.T) {
+	proxies, err := newIPFilter(nil, nil, []string{"10.0.0.0/8"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	})
+
+	for _, tt := range []struct {
+		name      string
+		canonical string
+		host      string
+		remote    string
+		proto     string
+		location  string
+	}{
+		{name: "matching", canonical: "files.example.com", host: "files.example.com"},
+		{name: "matching case", canonical: "files.example.com", host: "Files.Example.COM"},
+		{name: "matching on any port", canonical: "files.example.com", host: "files.example.com:8080"},
+		{name: "mismatched", canonical: "files.example.com", host: "example.com", location: "http://files.example.com/files/a.txt?download=1"},
+		{name: "mismatched port", canonical: "files.example.com:443", host: "files.example.com:8080", location: "http://files.example.com:443/files/a.txt?download=1"},
+		{name: "forwarded proto", canonical: "files.example.com", host: "lb.example.com", remote: "10.0.0.1:1234", proto: "HTTPS, http", location: "https://files.example.com/files/a.txt?download=1"},
+		{name: "bogus forwarded proto", canonical: "files.example.com", host: "lb.example.com", remote: "10.0.0.1:1234", proto: "gopher", location: "http://files.example.com/files/a.txt?download=1"},
+		{name: "untrusted forwarded proto", canonical: "files.example.com", host: "lb.example.com", proto: "https", location: "http://files.example.com/files/a.txt?download=1"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/files/a.txt?download=1", nil)
+			r.Host = tt.host
+			if tt.remote != "" {
+				r.RemoteAddr = tt.remote
+			}
+			if tt.proto != "" {
+				r.Header.Set("X-Forwarded-Proto", tt.proto)
+			}
+			w := httptest.NewRecorder()
+			canonicalHost(tt.canonical, proxies)(next).ServeHTTP(w, r)
+
+			if tt.location == "" {
+				if w.Code != http.StatusTeapot {
+					t.Errorf("GET on %s = %d, want it passed through", tt.host, w.Code)
+				}
+				return
+			}
+			if w.Code != http.StatusMovedPermanently || w.Header().Get("Location") != tt.location {
+				t.Errorf("GET on %s = %d %s, want %d %s", tt.host, w.Code, w.Header().Get("Location"), http.StatusMovedPermanently, tt.location)
+			}
+		})
+	}
+}
+
+func TestServeCanonicalHost(t *testing.T) {
+	root := writeFiles(t, map[string]string{"a.txt": "a"})
+	for _, tt := range []struct {
+		prefix   string
+		path     string
+		code     int
+		location string
+	}{
+		{prefix: "/files", path: "/", code: http.StatusOK},
+		{prefix: "/files", path: "/files/a.txt", code: http.StatusMovedPermanently, location: "http://files.example.com/files/a.txt"},
+		{prefix: "/files", path: "/other", code: http.StatusMovedPermanently, location: "http://files.example.com/other"},
+		// Without a health check, the root is redirected like any other path
+		{prefix: "/", path: "/", code: http.StatusMovedPermanently, location: "http://files.example.com/"},
+	} {
+		h := newTestReloadingHandler(t, Config{Root: root, Mask: "**", URLPrefix: tt.prefix, CanonicalHost: "files.example.com"})
+		r := httptest.NewRequest(http.MethodGet, tt.path, nil)
+		r.Host = "10.0.0.5:8080"
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+
+		if w.Code != tt.code || w.Header().Get("Location") != tt.location {
+			t.Errorf("GET %s under %s = %d %q, want %d %q", tt.path, tt.prefix, w.Code, w.Header().Get("Location"), tt.code, tt.location)
+		}
+	}
+}
+
+func TestVirtualHosts(t *testing.T) {
+	root := writeFiles(t, map[string]string{"default.txt": "default"})
+	docs := writeFiles(t, map[string]string{"a.txt": "docs", "b.key": "key"})
+	h := newTestReloadingHandler(t, Config{
+		Root:        root,
+		Mask:        "**",
+		VirtualHost: []string{"Docs.example.com=" + docs, "keys.example.com=" + docs + "=**\n!*.txt"},
+	})
+
+	for _, tt := range []struct {
+		host string
+		path string
+		code int
+		body string
+	}{
+		{host: "example.com", path: "/files/default.txt", code: http.StatusOK, body: "default"},
+		{host: "example.com", path: "/files/a.txt", code: http.StatusNotFound},
+		{host: "docs.example.com", path: "/files/a.txt", code: http.StatusOK, body: "docs"},
+		{host: "DOCS.example.com:8080", path: "/files/a.txt", code: http.StatusOK, body: "docs"},
+		{host: "docs.example.com", path: "/files/b.key", code: http.StatusOK, body: "key"},
+		{host: "docs.example.com", path: "/files/default.txt", code: http.StatusNotFound},
+		{host: "keys.example.com", path: "/files/b.key", code: http.StatusOK, body: "key"},
+		{host: "keys.example.com", path: "/files/a.txt", code: http.StatusNotFound},
+	} {
+		r := httptest.NewRequest(http.MethodGet, tt.path, nil)
+		r.Host = tt.host
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+
+		if w.Code != tt.code || (tt.body != "" && w.Body.String() != tt.body) {
+			t.Errorf("GET %s%s = %d %q, want %d %q", tt.host, tt.path, w.Code, w.Body, tt.code, tt.body)
+		}
+	}
+}
+
+func TestVirtualHostsInvalid(t *testing.T) {
+	root := t.TempDir()
+	for _, tt := range []struct {
+		name string
+		cfg  Config
+	}{
+		{name: "malformed", cfg: Config{VirtualHost: []string{"example.com"}}},
+		{name: "no root", cfg: Config{VirtualHost: []string{"example.com="}}},
+		{name: "path", cfg: Config{VirtualHost: []string{"example.com/docs=" + root}}},
+		{name: "given twice", cfg: Config{VirtualHost: []string{"example.com=" + root, "EXAMPLE.com=" + root}}},
+		{name: "canonical host", cfg: Config{VirtualHost: []string{"example.com=" + root}, CanonicalHost: "example.org"}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.cfg.Root, tt.cfg.ShutdownTimeout, tt.cfg.RequestTimeout = root, "5s", "0"
+			h := &reloadingHandler{ctx: context.Background(), cfg: tt.cfg, logger: logger.New("test")}
+			if err := h.Reload(); err == nil {
+				t.Error("Reload() succeeded, want an error")
+			}
+		})
+	}
+}
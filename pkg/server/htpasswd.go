@@ -0,0 +1,159 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/md5"
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// htpasswd maps user names to password hashes, as read from an Apache htpasswd file.
+type htpasswd map[string]string
+
+// loadHtpasswd reads an htpasswd file of user:hash lines. Only bcrypt ($2y$, $2b$, $2a$) and Apache MD5 ($apr1$) hashes
+// are supported, any other hash is an error rather than a user who can never log in.
+func loadHtpasswd(name string) (htpasswd, error) {
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read htpasswd file: %w", err)
+	}
+
+	users := htpasswd{}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		user, hash, ok := strings.Cut(line, ":")
+		if !ok || user == "" {
+			return nil, fmt.Errorf("malformed htpasswd line %d", n)
+		}
+		if !isBcrypt(hash) && !strings.HasPrefix(hash, "$apr1$") {
+			return nil, fmt.Errorf("unsupported hash for htpasswd user %q, only bcrypt and apr1 are supported", user)
+		}
+		users[user] = hash
+	}
+	if len(users) == 0 {
+		return nil, fmt.Errorf("htpasswd file %q has no users", name)
+	}
+
+	return users, nil
+}
+
+func isBcrypt(hash string) bool {
+	return strings.HasPrefix(hash, "$2y$") || strings.HasPrefix(hash, "$2b$") || strings.HasPrefix(hash, "$2a$")
+}
+
+// dummyHash returns the hash compared against for unknown users, so that they take as long to reject as known ones.
+// It's only generated once one is rejected, so that processes that never check passwords don't pay for it.
+var dummyHash = sync.OnceValue(func() []byte {
+	hash, _ := bcrypt.GenerateFromPassword([]byte("maskfs"), bcrypt.DefaultCost)
+	return hash
+})
+
+// verify returns true if the password matches the user's hash.
+func (h htpasswd) verify(user, password string) bool {
+	hash, ok := h[user]
+	if !ok {
+		_ = bcrypt.CompareHashAndPassword(dummyHash(), []byte(password))
+		return false
+	}
+
+	if isBcrypt(hash) {
+		return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
+	}
+
+	salt, _, _ := strings.Cut(strings.TrimPrefix(hash, "$apr1$"), "$")
+	return subtle.ConstantTimeCompare([]byte(apr1(password, salt)), []byte(hash)) == 1
+}
+
+// basicAuth returns middleware that rejects requests without HTTP Basic credentials matching a user of the htpasswd
+// file with a 401. The authenticated user is recorded in the access log, and requests are bound to the mask profile the
+// user is bound to, if any.
+func basicAuth(users htpasswd, profiles map[string]string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			user, password, ok := r.BasicAuth()
+			if !ok || !users.verify(user, password) {
+				w.Header().Set("WWW-Authenticate", `Basic realm="maskfs", charset="UTF-8"`)
+				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+				return
+			}
+
+			recordUser(r, user)
+			next.ServeHTTP(w, withProfile(r, profiles[user]))
+		})
+	}
+}
+
+// apr1 returns the Apache MD5 crypt hash of a password with the given salt, in the $apr1$salt$digest form.
+func apr1(password, salt string) string {
+	const magic = "$apr1$"
+	if len(salt) > 8 {
+		salt = salt[:8]
+	}
+	pw := []byte(password)
+
+	alt := md5.Sum([]byte(password + salt + password))
+
+	d := md5.New()
+	d.Write(pw)
+	d.Write([]byte(magic + salt))
+	for i := len(pw); i > 0; i -= 16 {
+		d.Write(alt[:min(i, 16)])
+	}
+	for i := len(pw); i > 0; i >>= 1 {
+		if i&1 != 0 {
+			d.Write([]byte{0})
+		} else {
+			d.Write(pw[:1])
+		}
+	}
+	final := d.Sum(nil)
+
+	// Deliberately slow the hash down, as specified by the algorithm
+	for i := range 1000 {
+		d := md5.New()
+		if i&1 != 0 {
+			d.Write(pw)
+		} else {
+			d.Write(final)
+		}
+		if i%3 != 0 {
+			d.Write([]byte(salt))
+		}
+		if i%7 != 0 {
+			d.Write(pw)
+		}
+		if i&1 != 0 {
+			d.Write(final)
+		} else {
+			d.Write(pw)
+		}
+		final = d.Sum(nil)
+	}
+
+	const itoa64 = "./0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+	var out []byte
+	encode := func(v uint32, n int) {
+		for ; n > 0; n-- {
+			out = append(out, itoa64[v&0x3f])
+			v >>= 6
+		}
+	}
+	for _, i := range [][3]int{{0, 6, 12}, {1, 7, 13}, {2, 8, 14}, {3, 9, 15}, {4, 10, 5}} {
+		encode(uint32(final[i[0]])<<16|uint32(final[i[1]])<<8|uint32(final[i[2]]), 4)
+	}
+	encode(uint32(final[11]), 2)
+
+	return magic + salt + "$" + string(out)
+}
@@ -0,0 +1,104 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestApr1(t *testing.T) {
+	// Known hashes, the first from Apache's htpasswd documentation
+	for _, tt := range []struct {
+		password, salt, want string
+	}{
+		{password: "myPassword", salt: "r31.....", want: "$apr1$r31.....$HqJZimcKQFAMYayBlzkrA/"},
+		{password: "password", salt: "saltsalt", want: "$apr1$saltsalt$yAAkm4libquA.ZWLHbSBq/"},
+	} {
+		if got := apr1(tt.password, tt.salt); got != tt.want {
+			t.Errorf("apr1(%q, %q) = %q, want %q", tt.password, tt.salt, got, tt.want)
+		}
+	}
+}
+
+func writeHtpasswd(t *testing.T, contents string) string {
+	t.Helper()
+	name := filepath.Join(t.TempDir(), "htpasswd")
+	if err := os.WriteFile(name, []byte(contents), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return name
+}
+
+func TestLoadHtpasswd(t *testing.T) {
+	for _, tt := range []struct {
+		name     string
+		contents string
+		ok       bool
+	}{
+		{name: "apr1", contents: "# comment\n\nalice:$apr1$r31.....$HqJZimcKQFAMYayBlzkrA/\n", ok: true},
+		{name: "bcrypt", contents: "bob:$2y$05$abcdefghijklmnopqrstuu\n", ok: true},
+		{name: "sha1", contents: "carol:{SHA}W6ph5Mm5Pz8GgiULbPgzG37mj9g=\n"},
+		{name: "crypt", contents: "dave:rqXexS6ZhobKA\n"},
+		{name: "malformed", contents: "erin\n"},
+		{name: "no users", contents: "# nobody\n"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := loadHtpasswd(writeHtpasswd(t, tt.contents)); (err == nil) != tt.ok {
+				t.Errorf("loadHtpasswd() = %v, want ok %t", err, tt.ok)
+			}
+		})
+	}
+}
+
+func TestBasicAuth(t *testing.T) {
+	bcryptHash, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	users := htpasswd{
+		"alice": "$apr1$r31.....$HqJZimcKQFAMYayBlzkrA/",
+		"bob":   string(bcryptHash),
+	}
+
+	var profile string
+	h := basicAuth(users, map[string]string{"bob": "public"})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		profile = profileOf(r)
+	}))
+
+	for _, tt := range []struct {
+		name           string
+		user, password string
+		code           int
+		profile        string
+	}{
+		{name: "apr1", user: "alice", password: "myPassword", code: http.StatusOK},
+		{name: "bcrypt with a profile", user: "bob", password: "secret", code: http.StatusOK, profile: "public"},
+		{name: "wrong password", user: "alice", password: "secret", code: http.StatusUnauthorized},
+		{name: "unknown user", user: "mallory", password: "myPassword", code: http.StatusUnauthorized},
+		{name: "no credentials", code: http.StatusUnauthorized},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			profile = ""
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tt.user != "" {
+				r.SetBasicAuth(tt.user, tt.password)
+			}
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+
+			if w.Code != tt.code {
+				t.Fatalf("code = %d, want %d", w.Code, tt.code)
+			}
+			if profile != tt.profile {
+				t.Errorf("profile = %q, want %q", profile, tt.profile)
+			}
+			if tt.code == http.StatusUnauthorized && w.Header().Get("WWW-Authenticate") == "" {
+				t.Error("401 without a WWW-Authenticate challenge")
+			}
+		})
+	}
+}
@@ -0,0 +1,281 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/njhale/maskfs/pkg/logger"
+)
+
+// indexCache wraps the filesystem of a root on the host to remember the listings and lstats of the directories
+// requests list, so that listing a hot directory doesn't read it and lstat each of its children every time. Every cached
+// directory is watched with fsnotify, and what's cached for a directory is dropped as soon as it, or anything in it,
+// changes.
+//
+// Only what the watches can see is cached: the lstats of directories that are watched themselves, since changes below
+// a directory change its modification time without an event in its parent, and the lstats of the other children of
+// watched directories. Stats through symlinks, whose targets can change anywhere, and directories reached through
+// symlinks, which the watches would follow, are never cached.
+type indexCache struct {
+	fs.FS
+	root    string // Absolute path of the root on the host, with its symlinks resolved
+	max     int    // Maximum number of watched directories
+	watcher *fsnotify.Watcher
+	logger  logger.Logger
+
+	ruleFile        string // Name of the per-directory rule files, empty if there are none
+	ruleFileChanged func() // Called whenever a rule file in a watched directory changes
+
+	mu   sync.Mutex
+	dirs map[string]*cachedDir // Watched directories by their paths relative to the root
+}
+
+// cachedDir is what's cached of a watched directory.
+type cachedDir struct {
+	version  int // Incremented whenever the directory changes, so that results read before then aren't cached
+	entries  []fs.DirEntry
+	listed   bool
+	info     fs.FileInfo            // Lstat of the directory itself, nil if not cached
+	children map[string]fs.FileInfo // Lstats of children other than directories, by name
+}
+
+// cacheIndex wraps the server's filesystem in an index cache of up to max directories, if max is positive and the
+// server serves a root on the host, and drops what the cache remembers as the root changes until the context is
+// canceled.
+func (s *Server) cacheIndex(ctx context.Context, max int) error {
+	if max <= 0 || s.root == "" {
+		return nil
+	}
+
+	root, err := filepath.EvalSymlinks(s.root)
+	if err != nil {
+		return fmt.Errorf("failed to resolve root for the index cache: %w", err)
+	}
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return fmt.Errorf("failed to create index cache watcher: %w", err)
+	}
+
+	c := &indexCache{
+		FS:      s.fsys,
+		root:    root,
+		max:     max,
+		watcher: watcher,
+		logger:  s.logger,
+		dirs:    map[string]*cachedDir{},
+
+		ruleFile: s.cfg.NestedMaskFile,
+		ruleFileChanged: func() {
+			if m := s.masks.Load(); m != nil {
+				m.invalidateLayers()
+			}
+		},
+	}
+	s.fsys = c
+
+	go func() {
+		defer watcher.Close()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case event := <-watcher.Events:
+				c.changed(event)
+			case err := <-watcher.Errors:
+				// Events may have been dropped, like when the kernel's queue overflows, so nothing cached can be trusted
+				c.logger.Warnf("Index cache watcher error, dropping the cache: %v", err)
+				c.reset()
+			}
+		}
+	}()
+
+	return nil
+}
+
+// watch starts watching a directory, evicting another one if the cache is full, and returns what's cached of it, or
+// nil if it can't be watched. The caller must hold the lock.
+func (c *indexCache) watch(name string) *cachedDir {
+	host := filepath.Join(c.root, filepath.FromSlash(name))
+	if resolved, err := filepath.EvalSymlinks(host); err != nil || resolved != host {
+		return nil
+	}
+
+	for dir := range c.dirs {
+		if len(c.dirs) < c.max {
+			break
+		}
+		// Evict an arbitrary directory to make room, map iteration order is random
+		c.forget(dir)
+	}
+
+	if err := c.watcher.Add(host); err != nil {
+		c.logger.Debugf("Not caching the index of %q, failed to watch it: %v", name, err)
+		return nil
+	}
+	d := &cachedDir{children: map[string]fs.FileInfo{}}
+	c.dirs[name] = d
+	return d
+}
+
+// forget stops watching a directory and drops what's cached of it. The caller must hold the lock.
+func (c *indexCache) forget(name string) {
+	// The watch is already gone if the directory was deleted
+	_ = c.watcher.Remove(filepath.Join(c.root, filepath.FromSlash(name)))
+	delete(c.dirs, name)
+}
+
+// reset forgets every directory.
+func (c *indexCache) reset() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for dir := range c.dirs {
+		c.forget(dir)
+	}
+}
+
+// changed drops what's cached of the path of an event and of its directory.
+func (c *indexCache) changed(event fsnotify.Event) {
+	rel, err := filepath.Rel(c.root, event.Name)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return
+	}
+	name := filepath.ToSlash(rel)
+	if c.ruleFile != "" && path.Base(name) == c.ruleFile {
+		// Decisions made with the rule file's old rules, or without it, no longer hold
+		c.ruleFileChanged()
+	}
+	// Only these change the names in a directory, and with them the modification time of the directory
+	renamed := event.Has(fsnotify.Create) || event.Has(fsnotify.Remove) || event.Has(fsnotify.Rename)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if d := c.dirs[name]; d != nil {
+		d.version++
+		d.info = nil
+		if event.Has(fsnotify.Remove) || event.Has(fsnotify.Rename) {
+			// The directory and everything below it are gone from their paths, though watches follow renames
+			for dir := range c.dirs {
+				if dir == name || strings.HasPrefix(dir, name+"/") {
+					c.forget(dir)
+				}
+			}
+		}
+	}
+
+	if name == "." {
+		return
+	}
+	if parent := c.dirs[path.Dir(name)]; parent != nil {
+		parent.version++
+		delete(parent.children, path.Base(name))
+		if renamed {
+			parent.entries, parent.listed, parent.info = nil, false, nil
+		}
+	}
+}
+
+func (c *indexCache) ReadDir(name string) ([]fs.DirEntry, error) {
+	c.mu.Lock()
+	d := c.dirs[name]
+	if d != nil && d.listed {
+		entries := slices.Clone(d.entries)
+		c.mu.Unlock()
+		return entries, nil
+	}
+	if d == nil {
+		d = c.watch(name)
+	}
+	var version int
+	if d != nil {
+		version = d.version
+	}
+	c.mu.Unlock()
+
+	entries, err := fs.ReadDir(c.FS, name)
+	if err != nil || d == nil {
+		return entries, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.dirs[name] == d && d.version == version {
+		d.entries, d.listed = entries, true
+	}
+	return slices.Clone(entries), nil
+}
+
+func (c *indexCache) Lstat(name string) (fs.FileInfo, error) {
+	c.mu.Lock()
+	d, parent := c.dirs[name], c.dirs[path.Dir(name)]
+	if name == "." {
+		parent = nil
+	}
+	if d != nil && d.info != nil {
+		info := d.info
+		c.mu.Unlock()
+		return info, nil
+	}
+	if info, ok := parent.child(path.Base(name)); ok {
+		c.mu.Unlock()
+		return info, nil
+	}
+	version, parentVersion := d.currentVersion(), parent.currentVersion()
+	c.mu.Unlock()
+
+	info, err := fs.Lstat(c.FS, name)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	switch {
+	case info.IsDir():
+		if d != nil && c.dirs[name] == d && d.version == version {
+			d.info = info
+		}
+	case parent != nil:
+		if c.dirs[path.Dir(name)] == parent && parent.version == parentVersion {
+			parent.children[path.Base(name)] = info
+		}
+	}
+	return info, nil
+}
+
+func (c *indexCache) Stat(name string) (fs.FileInfo, error) {
+	if info, err := c.Lstat(name); err == nil && info.Mode()&fs.ModeSymlink == 0 {
+		return info, nil
+	}
+	return fs.Stat(c.FS, name)
+}
+
+func (c *indexCache) ReadLink(name string) (string, error) {
+	return fs.ReadLink(c.FS, name)
+}
+
+// child returns the cached lstat of a child of a directory, if any.
+func (d *cachedDir) child(name string) (fs.FileInfo, bool) {
+	if d == nil {
+		return nil, false
+	}
+	info, ok := d.children[name]
+	return info, ok
+}
+
+// currentVersion returns the version of a directory, 0 if it isn't watched.
+func (d *cachedDir) currentVersion() int {
+	if d == nil {
+		return 0
+	}
+	return d.version
+}
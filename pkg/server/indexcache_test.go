@@ -0,0 +1,138 @@
+package server
+
+import (
+	"context"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+// eventually fails the test if the condition doesn't hold within a few seconds.
+func eventually(t *testing.T, what string, condition func() bool) {
+	t.Helper()
+
+	for deadline := time.Now().Add(5 * time.Second); !condition(); time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("%s didn't happen", what)
+		}
+	}
+}
+
+// dirEntryNames returns the names of directory entries.
+func dirEntryNames(entries []fs.DirEntry) []string {
+	var names []string
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	return names
+}
+
+func TestIndexCache(t *testing.T) {
+	root := writeFiles(t, map[string]string{"dir/a.txt": "a", "other/b.txt": "b"})
+	if err := os.Symlink("dir", filepath.Join(root, "link")); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s := newTestServer(t, Config{Root: root, Mask: "**"})
+	if err := s.cacheIndex(ctx, 2); err != nil {
+		t.Fatal(err)
+	}
+	c := s.fsys.(*indexCache)
+	cached := func(name string) bool {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		return c.dirs[name] != nil && c.dirs[name].listed
+	}
+	readDir := func(name string) []string {
+		t.Helper()
+		entries, err := c.ReadDir(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return dirEntryNames(entries)
+	}
+
+	if got := readDir("dir"); !slices.Equal(got, []string{"a.txt"}) {
+		t.Fatalf("ReadDir(dir) = %q, want [a.txt]", got)
+	}
+	if !cached("dir") {
+		t.Fatal("listing of dir wasn't cached")
+	}
+	if info, err := c.Lstat("dir/a.txt"); err != nil || info.Size() != 1 {
+		t.Fatalf("Lstat(dir/a.txt) = %v, %v, want 1 byte", info, err)
+	}
+
+	// Changes drop what's cached, so they're seen by the next read
+	if err := os.WriteFile(filepath.Join(root, "dir", "c.txt"), []byte("c"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	eventually(t, "listing a created file", func() bool { return slices.Equal(readDir("dir"), []string{"a.txt", "c.txt"}) })
+	if err := os.WriteFile(filepath.Join(root, "dir", "a.txt"), []byte("longer"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	eventually(t, "stating a written file", func() bool {
+		info, err := c.Lstat("dir/a.txt")
+		return err == nil && info.Size() == 6
+	})
+
+	// Directories reached through symlinks aren't cached, and no more than the maximum number of directories are
+	if got := readDir("link"); !slices.Equal(got, []string{"a.txt", "c.txt"}) {
+		t.Errorf("ReadDir(link) = %q, want [a.txt c.txt]", got)
+	}
+	if cached("link") {
+		t.Error("listing of a directory reached through a symlink was cached")
+	}
+	readDir(".")
+	readDir("other")
+	c.mu.Lock()
+	watched := len(c.dirs)
+	c.mu.Unlock()
+	if watched > 2 {
+		t.Errorf("%d directories are cached, want at most 2", watched)
+	}
+}
+
+func TestIndexCacheDisabled(t *testing.T) {
+	s := newTestServer(t, Config{Root: writeFiles(t, map[string]string{"a.txt": "a"}), Mask: "**"})
+	if err := s.cacheIndex(context.Background(), 0); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := s.fsys.(*indexCache); ok {
+		t.Error("index was cached with a maximum of 0 directories")
+	}
+}
+
+func TestIndexCacheRuleFiles(t *testing.T) {
+	root := writeFiles(t, map[string]string{"dir/a.txt": "a"})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s := newTestServer(t, Config{Root: root, Mask: "**", NestedMaskFile: ".maskfs"})
+	if err := s.cacheIndex(ctx, 2); err != nil {
+		t.Fatal(err)
+	}
+	h := http.StripPrefix("/files/", s)
+	status := func() int {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/files/dir/a.txt", nil))
+		return w.Code
+	}
+
+	if code := status(); code != http.StatusOK {
+		t.Fatalf("GET dir/a.txt = %d, want %d", code, http.StatusOK)
+	}
+	// Rule files are read again once they change, rather than the mask deciding with the rules it read before
+	if _, err := s.fsys.(*indexCache).ReadDir("dir"); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(root, "dir", ".maskfs"), []byte("!a.txt\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	eventually(t, "masking a file with a new rule file", func() bool { return status() == http.StatusNotFound })
+}
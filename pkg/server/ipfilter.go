@@ -0,0 +1,145 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// ipFilter decides which clients may make requests by their IP addresses, and which proxies to trust to name them.
+type ipFilter struct {
+	allow   []netip.Prefix // Empty to allow every client that isn't denied
+	deny    []netip.Prefix
+	proxies []netip.Prefix // Proxies whose X-Forwarded-For and X-Forwarded-Proto headers are trusted
+}
+
+// newIPFilter parses the CIDRs of an IP filter, returning nil if there's nothing to filter and no proxy to trust.
+func newIPFilter(allow, deny, proxies []string) (*ipFilter, error) {
+	if len(allow) == 0 && len(deny) == 0 && len(proxies) == 0 {
+		return nil, nil
+	}
+
+	f := &ipFilter{}
+	for _, list := range []struct {
+		cidrs    []string
+		prefixes *[]netip.Prefix
+	}{
+		{allow, &f.allow},
+		{deny, &f.deny},
+		{proxies, &f.proxies},
+	} {
+		for _, cidr := range list.cidrs {
+			prefix, err := parseCIDR(cidr)
+			if err != nil {
+				return nil, err
+			}
+			*list.prefixes = append(*list.prefixes, prefix)
+		}
+	}
+	return f, nil
+}
+
+// parseCIDR parses a CIDR, or a single IP address as the CIDR of just that address.
+func parseCIDR(cidr string) (netip.Prefix, error) {
+	cidr = strings.TrimSpace(cidr)
+	if !strings.Contains(cidr, "/") {
+		addr, err := netip.ParseAddr(cidr)
+		if err != nil {
+			return netip.Prefix{}, fmt.Errorf("invalid CIDR %q: %w", cidr, err)
+		}
+		return netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()), nil
+	}
+
+	prefix, err := netip.ParsePrefix(cidr)
+	if err != nil {
+		return netip.Prefix{}, fmt.Errorf("invalid CIDR %q: %w", cidr, err)
+	}
+	return prefix.Masked(), nil
+}
+
+// filterIPs returns middleware that forbids requests from clients that aren't allowed, before anything else handles
+// them.
+func filterIPs(f *ipFilter) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !f.allowed(f.clientIP(r)) {
+				http.Error(w, "Forbidden", http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// allowed returns true if a client may make requests. Denied clients never may, even if they're allowed too, and
+// clients whose addresses aren't known, like those connecting over unix sockets, only may if there's no allow list.
+func (f *ipFilter) allowed(addr netip.Addr) bool {
+	if !addr.IsValid() {
+		return len(f.allow) == 0
+	}
+	if containsAddr(f.deny, addr) {
+		return false
+	}
+	return len(f.allow) == 0 || containsAddr(f.allow, addr)
+}
+
+// clientIP returns the address of the client making a request. For requests from trusted proxies, it's the last
+// address of the X-Forwarded-For header that isn't a trusted proxy's itself, since only the addresses that trusted
+// proxies appended can be trusted, and anything before them could have been sent by the client.
+func (f *ipFilter) clientIP(r *http.Request) netip.Addr {
+	addr := remoteIP(r)
+	if !addr.IsValid() || !containsAddr(f.proxies, addr) {
+		return addr
+	}
+
+	var forwarded []string
+	for _, header := range r.Header.Values("X-Forwarded-For") {
+		forwarded = append(forwarded, strings.Split(header, ",")...)
+	}
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		hop, err := netip.ParseAddr(strings.TrimSpace(forwarded[i]))
+		if err != nil {
+			// Whatever's further along can't be trusted, so the last proxy is the client as far as anyone can tell
+			return addr
+		}
+		if addr = hop.Unmap(); !containsAddr(f.proxies, addr) {
+			return addr
+		}
+	}
+	return addr
+}
+
+// fromProxy returns true if a request was made by a trusted proxy, so that the headers it sets can be trusted. A nil
+// filter trusts no proxies.
+func (f *ipFilter) fromProxy(r *http.Request) bool {
+	if f == nil {
+		return false
+	}
+	addr := remoteIP(r)
+	return addr.IsValid() && containsAddr(f.proxies, addr)
+}
+
+// remoteIP returns the address of the peer making a request, which is invalid if it isn't an IP address.
+func remoteIP(r *http.Request) netip.Addr {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return netip.Addr{}
+	}
+	return addr.Unmap()
+}
+
+// containsAddr returns true if any of the prefixes contains the address.
+func containsAddr(prefixes []netip.Prefix, addr netip.Addr) bool {
+	for _, prefix := range prefixes {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,103 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestIPFilterClientIP(t *testing.T) {
+	f, err := newIPFilter(nil, nil, []string{"10.0.0.0/8"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f == nil {
+		t.Fatal("trusted proxies without allow or deny lists made no filter")
+	}
+
+	for _, tt := range []struct {
+		name      string
+		remote    string
+		forwarded []string
+		want      string
+	}{
+		{name: "direct client", remote: "192.0.2.1:1234", want: "192.0.2.1"},
+		{name: "direct client claiming to forward", remote: "192.0.2.1:1234", forwarded: []string{"198.51.100.1"}, want: "192.0.2.1"},
+		{name: "trusted proxy", remote: "10.0.0.1:1234", forwarded: []string{"198.51.100.1"}, want: "198.51.100.1"},
+		{name: "chain of trusted proxies", remote: "10.0.0.1:1234", forwarded: []string{"203.0.113.9, 198.51.100.1", "10.0.0.2"}, want: "198.51.100.1"},
+		{name: "garbage before the last hop", remote: "10.0.0.1:1234", forwarded: []string{"nonsense, 10.0.0.2"}, want: "10.0.0.2"},
+		{name: "ipv4-mapped address", remote: "[::ffff:10.0.0.1]:1234", forwarded: []string{"198.51.100.1"}, want: "198.51.100.1"},
+		{name: "unix socket", remote: "@", want: "invalid IP"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.RemoteAddr = tt.remote
+			for _, v := range tt.forwarded {
+				r.Header.Add("X-Forwarded-For", v)
+			}
+			if got := f.clientIP(r).String(); got != tt.want {
+				t.Errorf("clientIP() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIPFilterAllowed(t *testing.T) {
+	f, err := newIPFilter([]string{"192.0.2.0/24"}, []string{"192.0.2.66"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := filterIPs(f)(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+
+	for remote, want := range map[string]int{
+		"192.0.2.1:1":    http.StatusOK,
+		"192.0.2.66:1":   http.StatusForbidden,
+		"198.51.100.1:1": http.StatusForbidden,
+		"@":              http.StatusForbidden,
+	} {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.RemoteAddr = remote
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if w.Code != want {
+			t.Errorf("request from %s = %d, want %d", remote, w.Code, want)
+		}
+	}
+
+	if _, err := newIPFilter([]string{"not a cidr"}, nil, nil); err == nil {
+		t.Error("invalid CIDR was parsed")
+	}
+}
+
+func TestScheme(t *testing.T) {
+	proxies, err := newIPFilter(nil, nil, []string{"10.0.0.1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		name    string
+		remote  string
+		proto   string
+		proxies *ipFilter
+		want    string
+	}{
+		{name: "no header", remote: "10.0.0.1:1", proxies: proxies, want: "http"},
+		{name: "trusted proxy", remote: "10.0.0.1:1", proto: "https", proxies: proxies, want: "https"},
+		{name: "trusted proxy appending", remote: "10.0.0.1:1", proto: "HTTPS, http", proxies: proxies, want: "https"},
+		{name: "untrusted client", remote: "192.0.2.1:1", proto: "https", proxies: proxies, want: "http"},
+		{name: "no trusted proxies", remote: "10.0.0.1:1", proto: "https", want: "http"},
+		{name: "unknown scheme", remote: "10.0.0.1:1", proto: "gopher", proxies: proxies, want: "http"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.RemoteAddr = tt.remote
+			if tt.proto != "" {
+				r.Header.Set("X-Forwarded-Proto", tt.proto)
+			}
+			if got := scheme(r, tt.proxies); got != tt.want {
+				t.Errorf("scheme() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
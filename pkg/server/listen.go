@@ -0,0 +1,54 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// listen opens a listener on the given address, either a unix:// URL naming a unix domain socket or a TCP host:port,
+// optionally prefixed with tcp://. Unix domain sockets are given the socket mode, so that access to them can be limited
+// to the users and groups allowed to connect. A socket left behind by a previous run is replaced.
+func listen(addr string, socketMode os.FileMode) (net.Listener, error) {
+	if socket, ok := strings.CutPrefix(addr, "unix://"); ok {
+		if socket == "" {
+			return nil, fmt.Errorf("listen address %q has no socket path", addr)
+		}
+
+		if info, err := os.Lstat(socket); err == nil {
+			if info.Mode().Type() != fs.ModeSocket {
+				return nil, fmt.Errorf("refusing to replace %q, it isn't a socket", socket)
+			}
+			if err := os.Remove(socket); err != nil {
+				return nil, fmt.Errorf("failed to remove stale socket: %w", err)
+			}
+		} else if !errors.Is(err, fs.ErrNotExist) {
+			return nil, fmt.Errorf("failed to stat socket: %w", err)
+		}
+
+		l, err := net.Listen("unix", socket)
+		if err != nil {
+			return nil, err
+		}
+		if err := os.Chmod(socket, socketMode); err != nil {
+			_ = l.Close()
+			return nil, fmt.Errorf("failed to set socket mode: %w", err)
+		}
+		return l, nil
+	}
+
+	return net.Listen("tcp", strings.TrimPrefix(addr, "tcp://"))
+}
+
+// parseSocketMode parses the octal permissions of unix domain sockets, e.g. 0660.
+func parseSocketMode(mode string) (os.FileMode, error) {
+	perm, err := strconv.ParseUint(mode, 8, 32)
+	if err != nil || perm > 0o777 {
+		return 0, fmt.Errorf("invalid socket mode %q, must be octal permissions like 0660", mode)
+	}
+	return os.FileMode(perm), nil
+}
@@ -0,0 +1,69 @@
+package server
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestListen(t *testing.T) {
+	// Socket paths are limited in length, so they can't be below the test's temporary directory
+	dir, err := os.MkdirTemp("", "maskfs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	socket := filepath.Join(dir, "maskfs.sock")
+
+	// A socket left behind by a previous run is replaced
+	stale, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	stale.Close()
+	l, err := listen("unix://"+socket, 0o640)
+	if err != nil {
+		t.Fatalf("listen() over a stale socket = %v", err)
+	}
+	defer l.Close()
+	if info, err := os.Stat(socket); err != nil || info.Mode().Perm() != 0o640 {
+		t.Errorf("socket mode = %v, %v, want %v", info.Mode().Perm(), err, os.FileMode(0o640))
+	}
+
+	// Anything else is left alone
+	file := filepath.Join(dir, "file")
+	if err := os.WriteFile(file, []byte("keep"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := listen("unix://"+file, 0o660); err == nil {
+		t.Error("listen() replaced a regular file")
+	}
+	if contents, err := os.ReadFile(file); err != nil || string(contents) != "keep" {
+		t.Errorf("file = %q, %v after listen(), want it untouched", contents, err)
+	}
+
+	if _, err := listen("unix://", 0o660); err == nil {
+		t.Error("listen() without a socket path succeeded")
+	}
+
+	tcp, err := listen("tcp://127.0.0.1:0", 0o660)
+	if err != nil {
+		t.Fatalf("listen() on tcp = %v", err)
+	}
+	tcp.Close()
+}
+
+func TestParseSocketMode(t *testing.T) {
+	for mode, want := range map[string]os.FileMode{"0660": 0o660, "600": 0o600, "0777": 0o777} {
+		if got, err := parseSocketMode(mode); err != nil || got != want {
+			t.Errorf("parseSocketMode(%q) = %v, %v, want %v", mode, got, err, want)
+		}
+	}
+	for _, mode := range []string{"", "rw", "0888", "1777", "-1"} {
+		if _, err := parseSocketMode(mode); err == nil {
+			t.Errorf("parseSocketMode(%q) succeeded", mode)
+		}
+	}
+}
@@ -0,0 +1,101 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/njhale/maskfs/pkg/index"
+)
+
+// manifest lists every unmasked file below a directory, so that sync clients can diff a whole subtree against their
+// copy in one request.
+type manifest struct {
+	Path  string         `json:"path"` // Path of the directory relative to the root
+	Files []manifestFile `json:"files"`
+}
+
+// manifestFile is a file of a manifest.
+type manifestFile struct {
+	Path    string    `json:"path"` // Slash-separated path of the file relative to the manifest's directory
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mod_time"`
+	SHA256  string    `json:"sha256,omitempty"` // Hex-encoded digest of the file, if requested and the file isn't too large to checksum
+}
+
+// serveManifest writes a JSON manifest of the unmasked files below the directory at ?path=, the root by default, depth
+// first in name order. With ?hash=sha256, files up to the checksum size cap carry their digests too.
+func (s *Server) serveManifest(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	q, err := parseQuery(r.URL.Query(), manifestParams, s.strictQuery)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	hash := q.has("hash")
+	if hash && !s.checksums {
+		http.Error(w, "Checksums are disabled", http.StatusBadRequest)
+		return
+	}
+
+	dir := path.Clean(strings.TrimPrefix(q["path"], "/"))
+	if !fs.ValidPath(dir) {
+		http.NotFound(w, r)
+		return
+	}
+
+	m := s.masksFor(r)
+	directory, err := index.GetEntry(s.fsys, dir)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	if !directory.IsRoot() && m.all.Masked(directory) {
+		s.audit(r, m, directory)
+		http.NotFound(w, r)
+		return
+	}
+	if !directory.IsDir {
+		http.NotFound(w, r)
+		return
+	}
+
+	fsys := newBudgetFS(r.Context(), s.clock, s.fsys, s.maxWalkEntries, s.requestTimeout)
+	result := manifest{Path: directory.FSPath, Files: []manifestFile{}}
+	err = index.Walk(fsys, directory.FSPath, m.all, func(entry *index.Entry, _ int) error {
+		if entry.IsDir {
+			return nil
+		}
+
+		file := manifestFile{
+			Path:    entry.RelPath(directory),
+			Size:    entry.Size,
+			ModTime: entry.ModTime,
+		}
+		if hash && (s.maxChecksumSize <= 0 || entry.Size <= s.maxChecksumSize) {
+			sum, err := s.checksum(fsys, entry)
+			if err != nil {
+				return fmt.Errorf("failed to compute checksum of %q: %w", entry.FSPath, err)
+			}
+			file.SHA256 = sum
+		}
+
+		result.Files = append(result.Files, file)
+		return nil
+	})
+	if err != nil {
+		s.writeError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(result)
+}
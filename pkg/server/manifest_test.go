@@ -0,0 +1,82 @@
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+)
+
+func TestServeManifest(t *testing.T) {
+	root := writeFiles(t, map[string]string{
+		"a.txt":          "hello",
+		"dir/b.txt":      "nested",
+		"dir/secret.key": "secret",
+		"dir/sub/c.txt":  "deeper",
+		"private/d.txt":  "private",
+	})
+	cfg := Config{Root: root, Mask: "**\n!*.key\n!private/", URLPrefix: "/files", Manifests: true, Checksums: true, MaxChecksumSize: 6}
+	h := newTestReloadingHandler(t, cfg)
+
+	get := func(target string) (*manifest, int) {
+		t.Helper()
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, target, nil))
+		if w.Code != http.StatusOK {
+			return nil, w.Code
+		}
+		var m manifest
+		if err := json.NewDecoder(w.Body).Decode(&m); err != nil {
+			t.Fatal(err)
+		}
+		return &m, w.Code
+	}
+	paths := func(m *manifest) []string {
+		var paths []string
+		for _, file := range m.Files {
+			paths = append(paths, file.Path)
+		}
+		return paths
+	}
+
+	m, _ := get("/manifest")
+	if got, want := paths(m), []string{"a.txt", "dir/b.txt", "dir/sub/c.txt"}; !slices.Equal(got, want) {
+		t.Errorf("manifest of the root = %q, want %q", got, want)
+	}
+	if m.Files[0].Size != 5 || m.Files[0].SHA256 != "" {
+		t.Errorf("a.txt = %+v, want 5 bytes without a digest", m.Files[0])
+	}
+
+	// Paths are relative to the requested directory, and only files up to the checksum size cap have digests
+	m, _ = get("/manifest?path=/dir&hash=sha256")
+	if got, want := paths(m), []string{"b.txt", "sub/c.txt"}; !slices.Equal(got, want) {
+		t.Errorf("manifest of dir = %q, want %q", got, want)
+	}
+	sum := sha256.Sum256([]byte("nested"))
+	if m.Files[0].SHA256 != hex.EncodeToString(sum[:]) {
+		t.Errorf("digest of b.txt = %q, want %x", m.Files[0].SHA256, sum)
+	}
+
+	for target, want := range map[string]int{
+		"/manifest?path=private":      http.StatusNotFound,
+		"/manifest?path=a.txt":        http.StatusNotFound,
+		"/manifest?path=missing":      http.StatusNotFound,
+		"/manifest?path=../..":        http.StatusNotFound,
+		"/manifest?hash=md5":          http.StatusBadRequest,
+		"/manifest?path=dir&hash=sha": http.StatusBadRequest,
+	} {
+		if _, code := get(target); code != want {
+			t.Errorf("GET %s = %d, want %d", target, code, want)
+		}
+	}
+
+	// Digests can only be requested when checksums are enabled
+	cfg.Checksums = false
+	h = newTestReloadingHandler(t, cfg)
+	if _, code := get("/manifest?hash=sha256"); code != http.StatusBadRequest {
+		t.Errorf("GET /manifest?hash=sha256 without checksums = %d, want %d", code, http.StatusBadRequest)
+	}
+}
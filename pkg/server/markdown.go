@@ -0,0 +1,83 @@
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"io"
+	"io/fs"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/microcosm-cc/bluemonday"
+	"github.com/njhale/maskfs/pkg/index"
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/extension"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// maxMarkdownSize is the maximum size in bytes of Markdown files rendered as HTML.
+const maxMarkdownSize = 16 << 20
+
+var (
+	// markdown converts GitHub Flavored Markdown to HTML. It doesn't pass raw HTML through, but the result is
+	// sanitized anyway, since links and images can still carry scripts.
+	markdown = goldmark.New(goldmark.WithExtensions(extension.GFM))
+
+	// markdownPolicy sanitizes rendered Markdown, allowing the formatting user generated content may use but nothing
+	// that runs scripts or styles the page.
+	markdownPolicy = bluemonday.UGCPolicy()
+)
+
+// isMarkdown returns true if an entry is a Markdown file, by its extension.
+func isMarkdown(entry *index.Entry) bool {
+	switch strings.ToLower(path.Ext(entry.Name)) {
+	case ".md", ".markdown":
+		return !entry.IsDir
+	}
+	return false
+}
+
+// serveMarkdown renders a Markdown file as a sanitized HTML page styled like the listings.
+func (s *Server) serveMarkdown(w http.ResponseWriter, r *http.Request, m *masks, fsys fs.FS, entry *index.Entry) {
+	if entry.Size > maxMarkdownSize {
+		http.Error(w, fmt.Sprintf("File size %d exceeds the Markdown rendering cap of %d bytes", entry.Size, maxMarkdownSize), http.StatusBadRequest)
+		return
+	}
+
+	// The page only changes along with the file, so the file's version identifies it
+	etag := "W/" + fileETag(m, "md-", entry)
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Last-Modified", entry.ModTime.UTC().Format(http.TimeFormat))
+	if notModified(r, etag, entry.ModTime) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	f, err := fsys.Open(entry.FSPath)
+	if err != nil {
+		s.writeError(w, r, fmt.Errorf("failed to open %q: %w", entry.FSPath, err))
+		return
+	}
+	defer f.Close()
+
+	source, err := io.ReadAll(io.LimitReader(f, maxMarkdownSize))
+	if err != nil {
+		s.writeError(w, r, fmt.Errorf("failed to read %q: %w", entry.FSPath, err))
+		return
+	}
+
+	_, span := startSpan(r.Context(), "maskfs.render", attribute.String("maskfs.format", "markdown"), attribute.String("maskfs.path", entry.FSPath))
+	defer span.End()
+	var rendered bytes.Buffer
+	if err := markdown.Convert(source, &rendered); err != nil {
+		s.writeError(w, r, fmt.Errorf("failed to render %q: %w", entry.FSPath, err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := index.WriteHTMLDocument(w, entry, template.HTML(markdownPolicy.SanitizeBytes(rendered.Bytes()))); err != nil {
+		s.requestLogger(r).Errorf("Failed to write rendered Markdown: %v", err)
+	}
+}
@@ -0,0 +1,72 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestServeMarkdown(t *testing.T) {
+	root := writeFiles(t, map[string]string{
+		"README.md":  "# Title\n\nSome *text* and [a link](javascript:alert(1)).\n\n<script>alert(1)</script>\n",
+		"a.txt":      "# Not Markdown",
+		"secret.md":  "# Secret",
+		"docs/x.MD":  "| a | b |\n|---|---|\n| 1 | 2 |\n",
+		"docs/y.txt": "",
+	})
+
+	for _, tt := range []struct {
+		name     string
+		disabled bool
+		target   string
+		code     int
+		contains []string
+		excludes []string
+	}{
+		{
+			name:     "rendered",
+			target:   "/files/README.md?render=html",
+			code:     http.StatusOK,
+			contains: []string{"<h1", "Title</h1>", "<em>text</em>", `<a href="/files/README.md">`},
+			excludes: []string{"<script", "javascript:"},
+		},
+		{name: "GitHub Flavored Markdown", target: "/files/docs/x.MD?render=html", code: http.StatusOK, contains: []string{"<table>", "<td>1</td>"}},
+		{name: "raw without render", target: "/files/README.md", code: http.StatusOK, contains: []string{"<script>alert(1)</script>"}},
+		{name: "not Markdown", target: "/files/a.txt?render=html", code: http.StatusBadRequest},
+		{name: "masked", target: "/files/secret.md?render=html", code: http.StatusNotFound},
+		{name: "unknown format", target: "/files/README.md?render=pdf", code: http.StatusBadRequest},
+		{name: "disabled", disabled: true, target: "/files/README.md?render=html", code: http.StatusBadRequest},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			h := newHandler(t, Config{Root: root, Mask: "**\n!secret.md", RenderMarkdown: !tt.disabled})
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, tt.target, nil))
+			if w.Code != tt.code {
+				t.Fatalf("GET %s = %d %s, want %d", tt.target, w.Code, w.Body, tt.code)
+			}
+			for _, want := range tt.contains {
+				if !strings.Contains(w.Body.String(), want) {
+					t.Errorf("GET %s = %s, want it to contain %s", tt.target, w.Body, want)
+				}
+			}
+			for _, unwanted := range tt.excludes {
+				if strings.Contains(w.Body.String(), unwanted) {
+					t.Errorf("GET %s = %s, want it not to contain %s", tt.target, w.Body, unwanted)
+				}
+			}
+		})
+	}
+
+	// Rendered pages can be revalidated with the ETag of the file's version
+	h := newHandler(t, Config{Root: root, Mask: "**", RenderMarkdown: true})
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/files/README.md?render=html", nil))
+	r := httptest.NewRequest(http.MethodGet, "/files/README.md?render=html", nil)
+	r.Header.Set("If-None-Match", w.Header().Get("ETag"))
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if w.Code != http.StatusNotModified {
+		t.Errorf("revalidating the rendered page = %d, want %d", w.Code, http.StatusNotModified)
+	}
+}
@@ -0,0 +1,185 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// maxMaskURLSize is the largest set of mask rules fetched from a mask URL.
+const maxMaskURLSize = 4 << 20
+
+// maskURLs are the mask URLs of a configuration, shared by its server, mounts, and virtual hosts, so that each URL is
+// fetched once when the configuration is loaded and polled by one goroutine, however many servers apply its rules.
+type maskURLs struct {
+	ctx context.Context // Bounds fetches and polling
+
+	mu    sync.Mutex
+	rules map[string]*remoteRules
+}
+
+func newMaskURLs(ctx context.Context) *maskURLs {
+	return &maskURLs{
+		ctx:   ctx,
+		rules: map[string]*remoteRules{},
+	}
+}
+
+// get returns the rules of a mask URL, fetching them the first time the URL is asked for, and then polling them every
+// refresh interval until the context is canceled, unless the interval is 0.
+func (m *maskURLs) get(rawURL string, refresh time.Duration) (*remoteRules, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if r, ok := m.rules[rawURL]; ok {
+		return r, nil
+	}
+
+	r, err := newRemoteRules(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := r.fetch(m.ctx); err != nil {
+		return nil, err
+	}
+	m.rules[rawURL] = r
+	r.poll(m.ctx, refresh)
+
+	return r, nil
+}
+
+// remoteRules fetches mask rules from a URL, remembering the last rules fetched along with their ETag, so that polling
+// only downloads rules that have changed.
+type remoteRules struct {
+	url    string
+	client *http.Client
+
+	mu      sync.Mutex
+	etag    string
+	rules   string
+	servers []*Server // Servers whose masks are reloaded when the rules change
+}
+
+func newRemoteRules(rawURL string) (*remoteRules, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid mask url: %w", err)
+	}
+	if u.Scheme != "https" && (u.Scheme != "http" || !isLoopback(u.Hostname())) {
+		// Anyone on the path to the rules could otherwise unmask whatever they like
+		return nil, fmt.Errorf("invalid mask url %q, must be an https url unless it's on a loopback address", rawURL)
+	}
+
+	return &remoteRules{
+		url:    rawURL,
+		client: &http.Client{Timeout: 30 * time.Second},
+	}, nil
+}
+
+// fetch downloads the rules if they changed since the last fetch, returning whether they did.
+func (r *remoteRules) fetch(ctx context.Context) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, r.url, nil)
+	if err != nil {
+		return false, err
+	}
+	if r.etag != "" {
+		req.Header.Set("If-None-Match", r.etag)
+	}
+
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return false, fmt.Errorf("failed to fetch mask rules: %w", err)
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotModified:
+		return false, nil
+	default:
+		return false, fmt.Errorf("failed to fetch mask rules: %s", resp.Status)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxMaskURLSize+1))
+	if err != nil {
+		return false, fmt.Errorf("failed to read mask rules: %w", err)
+	}
+	if len(data) > maxMaskURLSize {
+		return false, fmt.Errorf("mask rules are larger than %d bytes", maxMaskURLSize)
+	}
+
+	changed := string(data) != r.rules
+	r.etag, r.rules = resp.Header.Get("ETag"), string(data)
+
+	return changed, nil
+}
+
+// current returns the rules of the last successful fetch.
+func (r *remoteRules) current() string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.rules
+}
+
+// subscribe reloads the mask of a server whenever polling finds that the rules changed.
+func (r *remoteRules) subscribe(s *Server) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.servers = append(r.servers, s)
+}
+
+// poll refetches the rules every refresh interval, reloading the masks of the subscribed servers when they change,
+// until the context is canceled. Rules that can't be fetched or parsed are logged, and the current masks are kept.
+func (r *remoteRules) poll(ctx context.Context, refresh time.Duration) {
+	if refresh <= 0 {
+		return
+	}
+
+	go func() {
+		ticker := time.NewTicker(refresh)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+
+			changed, err := r.fetch(ctx)
+			r.mu.Lock()
+			servers := r.servers
+			r.mu.Unlock()
+			if len(servers) == 0 {
+				continue
+			}
+
+			if err != nil {
+				if !errors.Is(err, context.Canceled) {
+					servers[0].logger.Errorf("Failed to refresh mask url, keeping the current masks: %v", err)
+				}
+				continue
+			}
+			if !changed {
+				continue
+			}
+			for _, s := range servers {
+				if err := s.ReloadMask(); err != nil {
+					s.logger.Errorf("Failed to reload mask on mask url change, keeping the current mask: %v", err)
+					continue
+				}
+				s.logger.Infof("Reloaded mask on mask url change")
+			}
+		}
+	}()
+}
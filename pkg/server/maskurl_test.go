@@ -0,0 +1,78 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestNewRemoteRules(t *testing.T) {
+	for _, tt := range []struct {
+		url string
+		ok  bool
+	}{
+		{url: "https://rules.example.com/mask", ok: true},
+		{url: "http://127.0.0.1:8080/mask", ok: true},
+		{url: "http://localhost/mask", ok: true},
+		{url: "http://rules.example.com/mask"},
+		{url: "ftp://rules.example.com/mask"},
+		{url: "/etc/maskfs/mask"},
+	} {
+		if _, err := newRemoteRules(tt.url); (err == nil) != tt.ok {
+			t.Errorf("newRemoteRules(%q) = %v, want ok %t", tt.url, err, tt.ok)
+		}
+	}
+}
+
+func TestMaskURLs(t *testing.T) {
+	var (
+		fetches atomic.Int32
+		rules   atomic.Value
+	)
+	rules.Store("a/**")
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches.Add(1)
+		data := rules.Load().(string)
+		etag := `"` + data + `"`
+		if r.Header.Get("If-None-Match") == etag {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", etag)
+		_, _ = w.Write([]byte(data))
+	}))
+	defer srv.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	urls := newMaskURLs(ctx)
+
+	cfg := Config{Root: t.TempDir(), MaskURL: srv.URL, MaskRefresh: "10ms"}
+	var servers []*Server
+	for range 2 {
+		s, err := New(WithConfig(cfg), withMaskURLs(urls))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer s.Close()
+		servers = append(servers, s)
+	}
+	if n := fetches.Load(); n != 1 {
+		t.Errorf("mask url fetched %d times for two servers, want once", n)
+	}
+
+	// Changed rules are picked up by every server sharing them
+	loaded := servers[1].masks.Load()
+	rules.Store("b/**")
+	for deadline := time.Now().Add(5 * time.Second); servers[1].masks.Load() == loaded; time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("changed rules weren't picked up")
+		}
+	}
+	if got := servers[0].remote.current(); got != "b/**" {
+		t.Errorf("rules = %q, want %q", got, "b/**")
+	}
+}
@@ -0,0 +1,57 @@
+package server
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/njhale/maskfs/pkg/index"
+)
+
+// parseMount parses an additional mount given as prefix=root or prefix=root=mask, returning its URL prefix, with a
+// trailing slash, and the configuration of the server backing it. The mount shares every setting of the given
+// configuration except for its root and, when one is given, its mask, which replaces the mask file too.
+// Rules in the mask are new-line delimited, like those of --mask.
+func parseMount(cfg Config, spec string) (string, Config, error) {
+	parts := strings.SplitN(spec, "=", 3)
+	if len(parts) < 2 || parts[0] == "" || parts[1] == "" {
+		return "", Config{}, fmt.Errorf("malformed mount %q, must be prefix=root or prefix=root=mask", spec)
+	}
+
+	prefix := parts[0]
+	if !strings.HasPrefix(prefix, "/") || prefix == "/" {
+		return "", Config{}, fmt.Errorf("mount prefix %q must be a path below /", prefix)
+	}
+	if !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+
+	cfg.Root = parts[1]
+	cfg.GitRepo = ""
+	cfg.Overlay = nil
+	if len(parts) == 3 {
+		cfg.Mask = parts[2]
+		cfg.MaskFile = ""
+		cfg.MaskURL = ""
+	}
+	cfg.Mount = nil
+
+	return prefix, cfg, nil
+}
+
+// parseURLPrefix returns the cleaned URL path to serve files under, without a trailing slash unless it's the root.
+// An empty prefix defaults to index.DefaultLinkPrefix.
+func parseURLPrefix(prefix string) (string, error) {
+	if prefix == "" {
+		return index.DefaultLinkPrefix, nil
+	}
+	if !strings.HasPrefix(prefix, "/") {
+		return "", fmt.Errorf("url prefix %q must start with a slash", prefix)
+	}
+	return path.Clean(prefix), nil
+}
+
+// linked links the entries the server gets under the URL prefix it's mounted under.
+func (s *Server) linked() index.GetEntriesOption {
+	return index.WithLinkPrefix(s.prefix)
+}
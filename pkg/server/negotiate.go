@@ -0,0 +1,47 @@
+package server
+
+import (
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// wantsJSON returns true if the request's Accept header explicitly prefers application/json over text/html.
+// Wildcards are ignored so that HTML remains the default for browsers and clients that accept anything.
+func wantsJSON(r *http.Request) bool {
+	var jsonQ, htmlQ float64
+	for _, accept := range r.Header.Values("Accept") {
+		for _, mediaRange := range strings.Split(accept, ",") {
+			mediaType, params, err := mime.ParseMediaType(mediaRange)
+			if err != nil {
+				continue
+			}
+
+			q := 1.0
+			if value, ok := params["q"]; ok {
+				if q, err = strconv.ParseFloat(value, 64); err != nil {
+					continue
+				}
+			}
+
+			switch mediaType {
+			case "application/json":
+				jsonQ = max(jsonQ, q)
+			case "text/html":
+				htmlQ = max(htmlQ, q)
+			}
+		}
+	}
+
+	return jsonQ > 0 && jsonQ >= htmlQ
+}
+
+// listingAsJSON returns true if a directory listing should be rendered as JSON, as requested by the format query
+// parameter, or otherwise by the Accept header.
+func listingAsJSON(r *http.Request, q query) bool {
+	if q.has("format") {
+		return q["format"] == "json"
+	}
+	return wantsJSON(r)
+}
@@ -0,0 +1,32 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestWantsJSON(t *testing.T) {
+	for _, tt := range []struct {
+		accept []string
+		want   bool
+	}{
+		{accept: nil, want: false},
+		{accept: []string{"*/*"}, want: false},
+		{accept: []string{"application/*"}, want: false},
+		{accept: []string{"application/json"}, want: true},
+		{accept: []string{"text/html,application/json"}, want: true},
+		{accept: []string{"text/html", "application/json;q=0.5"}, want: false},
+		{accept: []string{"text/html;q=0.5, application/json;q=0.9"}, want: true},
+		{accept: []string{"application/json;q=0"}, want: false},
+		{accept: []string{"application/json;q=bogus"}, want: false},
+	} {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		for _, accept := range tt.accept {
+			r.Header.Add("Accept", accept)
+		}
+		if got := wantsJSON(r); got != tt.want {
+			t.Errorf("wantsJSON(Accept: %q) = %t, want %t", tt.accept, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,169 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"github.com/njhale/maskfs/pkg/logger"
+)
+
+// oidcAuth authenticates requests with JWTs issued by an OpenID Connect provider, like the ID tokens of single sign-on
+// logins or the access tokens of clients of an API.
+type oidcAuth struct {
+	issuer    string
+	audiences []string // Tokens must be issued for at least one, the client ID and the audience that were given
+	profiles  []claimProfile
+}
+
+// claimProfile binds the tokens with a claim of a value, or an array claim containing it, to a mask profile.
+type claimProfile struct {
+	claim   string
+	value   string
+	profile string
+}
+
+// newOIDCAuth parses the OIDC configuration, returning nil when no issuer is given. The issuer isn't contacted until
+// the handler is created, see oidcAuth.verifier.
+func newOIDCAuth(cfg Config, profiles map[string]string) (*oidcAuth, error) {
+	if cfg.OIDCIssuer == "" {
+		if cfg.OIDCClientID != "" || cfg.OIDCAudience != "" || len(cfg.OIDCClaimProfile) > 0 {
+			return nil, errors.New("an OIDC client ID, audience, or claim profile was given without an OIDC issuer")
+		}
+		return nil, nil
+	}
+
+	u, err := url.Parse(cfg.OIDCIssuer)
+	if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+		return nil, fmt.Errorf("invalid OIDC issuer %q, must be an https URL", cfg.OIDCIssuer)
+	}
+	if u.Scheme == "http" && !isLoopback(u.Hostname()) {
+		// The issuer's signing keys are fetched from it, so anyone on the path to it could sign tokens of their own
+		return nil, fmt.Errorf("invalid OIDC issuer %q, must be an https URL unless it's on a loopback address", cfg.OIDCIssuer)
+	}
+
+	auth := &oidcAuth{issuer: cfg.OIDCIssuer}
+	for _, audience := range []string{cfg.OIDCClientID, cfg.OIDCAudience} {
+		if audience != "" {
+			auth.audiences = append(auth.audiences, audience)
+		}
+	}
+	if len(auth.audiences) == 0 {
+		return nil, errors.New("an OIDC issuer was given without a client ID or an audience")
+	}
+
+	for _, spec := range cfg.OIDCClaimProfile {
+		// Claim names and profile names can't contain =, but the values they're matched against could
+		claim, rest, _ := strings.Cut(spec, "=")
+		i := strings.LastIndex(rest, "=")
+		if claim == "" || i <= 0 || i == len(rest)-1 {
+			return nil, fmt.Errorf("invalid OIDC claim profile %q, must be claim=value=profile", spec)
+		}
+		rule := claimProfile{claim: claim, value: rest[:i], profile: rest[i+1:]}
+		if _, ok := profiles[rule.profile]; !ok {
+			return nil, fmt.Errorf("OIDC claim %s=%s is bound to undefined mask profile %q", rule.claim, rule.value, rule.profile)
+		}
+		auth.profiles = append(auth.profiles, rule)
+	}
+
+	return auth, nil
+}
+
+// oidcTimeout bounds discovering the issuer's configuration and fetching its signing keys.
+const oidcTimeout = 30 * time.Second
+
+// isLoopback returns true if a host is localhost or a loopback IP address.
+func isLoopback(host string) bool {
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// verifier discovers the issuer's configuration and returns a verifier of the tokens it issues, whose signing keys are
+// fetched from the issuer as they're needed. Neither discovery nor fetching keys can take longer than oidcTimeout.
+func (a *oidcAuth) verifier(ctx context.Context) (*oidc.IDTokenVerifier, error) {
+	ctx = oidc.ClientContext(ctx, &http.Client{Timeout: oidcTimeout})
+	ctx, cancel := context.WithTimeout(ctx, oidcTimeout)
+	defer cancel()
+
+	// The provider fetches keys with the client rather than the context, so they're fetched after it's canceled too
+	provider, err := oidc.NewProvider(ctx, a.issuer)
+	if err != nil {
+		return nil, fmt.Errorf("failed to discover OIDC issuer %q: %w", a.issuer, err)
+	}
+	// The audience is checked by the middleware instead, since either of two can be accepted
+	return provider.Verifier(&oidc.Config{SkipClientIDCheck: true}), nil
+}
+
+// verify verifies a token and that it was issued for one of the audiences, returning it along with its claims.
+func (a *oidcAuth) verify(ctx context.Context, verifier *oidc.IDTokenVerifier, raw string) (*oidc.IDToken, map[string]any, error) {
+	token, err := verifier.Verify(ctx, raw)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !slices.ContainsFunc(a.audiences, func(audience string) bool { return slices.Contains(token.Audience, audience) }) {
+		return nil, nil, fmt.Errorf("expected audience in %q, got %q", a.audiences, token.Audience)
+	}
+
+	var claims map[string]any
+	if err := token.Claims(&claims); err != nil {
+		return nil, nil, err
+	}
+	return token, claims, nil
+}
+
+// profile returns the mask profile of the first claim profile matching the token's claims, or an empty string for the
+// default mask.
+func (a *oidcAuth) profile(claims map[string]any) string {
+	for _, rule := range a.profiles {
+		switch value := claims[rule.claim].(type) {
+		case string:
+			if value == rule.value {
+				return rule.profile
+			}
+		case []any:
+			for _, v := range value {
+				if s, ok := v.(string); ok && s == rule.value {
+					return rule.profile
+				}
+			}
+		}
+	}
+	return ""
+}
+
+// oidcBearerAuth returns middleware that rejects requests that don't carry a token issued for one of the audiences in
+// an "Authorization: Bearer <token>" header with a 401. Requests are bound to the mask profile the token's claims map
+// to.
+func oidcBearerAuth(auth *oidcAuth, verifier *oidc.IDTokenVerifier, log logger.Logger) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			scheme, given, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+			if !ok || !strings.EqualFold(scheme, "Bearer") {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="maskfs"`)
+				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+				return
+			}
+
+			token, claims, err := auth.verify(r.Context(), verifier, strings.TrimSpace(given))
+			if err != nil {
+				logger.ForContext(r.Context(), log).Debugf("Rejected OIDC token: %v", err)
+				w.Header().Set("WWW-Authenticate", `Bearer realm="maskfs", error="invalid_token"`)
+				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+				return
+			}
+
+			recordUser(r, token.Subject)
+			next.ServeHTTP(w, withProfile(r, auth.profile(claims)))
+		})
+	}
+}
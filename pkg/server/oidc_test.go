@@ -0,0 +1,59 @@
+package server
+
+import (
+	"testing"
+)
+
+func TestNewOIDCAuth(t *testing.T) {
+	profiles := map[string]string{"guests": "guests.mask"}
+	for _, tt := range []struct {
+		name    string
+		cfg     Config
+		wantErr bool
+	}{
+		{name: "no issuer", cfg: Config{}},
+		{name: "https issuer", cfg: Config{OIDCIssuer: "https://issuer.example.com", OIDCClientID: "maskfs"}},
+		{name: "http issuer on localhost", cfg: Config{OIDCIssuer: "http://localhost:8080", OIDCAudience: "maskfs"}},
+		{name: "http issuer on a loopback address", cfg: Config{OIDCIssuer: "http://127.0.0.1:8080", OIDCAudience: "maskfs"}},
+		{name: "http issuer on an IPv6 loopback address", cfg: Config{OIDCIssuer: "http://[::1]:8080", OIDCAudience: "maskfs"}},
+		{name: "http issuer", cfg: Config{OIDCIssuer: "http://issuer.example.com", OIDCClientID: "maskfs"}, wantErr: true},
+		{name: "http issuer named like localhost", cfg: Config{OIDCIssuer: "http://localhost.example.com", OIDCClientID: "maskfs"}, wantErr: true},
+		{name: "other scheme", cfg: Config{OIDCIssuer: "ftp://issuer.example.com", OIDCClientID: "maskfs"}, wantErr: true},
+		{name: "no audience", cfg: Config{OIDCIssuer: "https://issuer.example.com"}, wantErr: true},
+		{name: "client ID without issuer", cfg: Config{OIDCClientID: "maskfs"}, wantErr: true},
+		{name: "claim profile", cfg: Config{OIDCIssuer: "https://issuer.example.com", OIDCClientID: "maskfs", OIDCClaimProfile: []string{"groups=guest=guests"}}},
+		{name: "undefined claim profile", cfg: Config{OIDCIssuer: "https://issuer.example.com", OIDCClientID: "maskfs", OIDCClaimProfile: []string{"groups=guest=staff"}}, wantErr: true},
+		{name: "invalid claim profile", cfg: Config{OIDCIssuer: "https://issuer.example.com", OIDCClientID: "maskfs", OIDCClaimProfile: []string{"groups=guests"}}, wantErr: true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := newOIDCAuth(tt.cfg, profiles); (err != nil) != tt.wantErr {
+				t.Errorf("newOIDCAuth() error = %v, want error %t", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestOIDCAuthProfile(t *testing.T) {
+	auth := &oidcAuth{profiles: []claimProfile{
+		{claim: "groups", value: "guest", profile: "guests"},
+		{claim: "email", value: "a@example.com", profile: "a"},
+	}}
+	for _, tt := range []struct {
+		name   string
+		claims map[string]any
+		want   string
+	}{
+		{name: "no claims", claims: map[string]any{}, want: ""},
+		{name: "string claim", claims: map[string]any{"email": "a@example.com"}, want: "a"},
+		{name: "array claim", claims: map[string]any{"groups": []any{"staff", "guest"}}, want: "guests"},
+		{name: "first matching profile", claims: map[string]any{"email": "a@example.com", "groups": []any{"guest"}}, want: "guests"},
+		{name: "other value", claims: map[string]any{"groups": []any{"staff"}}, want: ""},
+		{name: "other type", claims: map[string]any{"groups": 1.0}, want: ""},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := auth.profile(tt.claims); got != tt.want {
+				t.Errorf("profile() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
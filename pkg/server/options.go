@@ -0,0 +1,78 @@
+package server
+
+import (
+	"io/fs"
+
+	"github.com/njhale/maskfs/pkg/clock"
+	"github.com/njhale/maskfs/pkg/index"
+	"github.com/njhale/maskfs/pkg/logger"
+)
+
+// Option configures a server created with New.
+type Option func(*options)
+
+// options are what the options given to New set.
+type options struct {
+	cfg       Config
+	fsys      fs.FS
+	mask      index.Mask
+	fixedMask bool
+	logger    logger.Logger
+	prefix    string
+	clock     clock.Clock
+	maskURLs  *maskURLs
+}
+
+// WithConfig sets the configuration of the server, which is what the flags of the server command set, and which the
+// other options override. Without it, the server has the settings of a zero Config.
+func WithConfig(cfg Config) Option {
+	return func(o *options) {
+		o.cfg = cfg
+	}
+}
+
+// WithFS serves the given filesystem instead of the configured root, like an embed.FS, an fstest.MapFS, or a remote
+// backend. The filesystem isn't on the host, so writes are disabled.
+func WithFS(fsys fs.FS) Option {
+	return func(o *options) {
+		o.fsys = fsys
+	}
+}
+
+// WithMask applies the given mask instead of the masks of the configuration, including its mask profiles. A nil mask
+// masks nothing. Since the mask doesn't come from the configuration, ReloadMask can't reload it.
+func WithMask(m index.Mask) Option {
+	return func(o *options) {
+		o.mask, o.fixedMask = m, true
+	}
+}
+
+// WithLogger sets the logger of the server, which defaults to a logrus logger configured by the logger package.
+// Use logger.Slog to log through a *slog.Logger.
+func WithLogger(l logger.Logger) Option {
+	return func(o *options) {
+		o.logger = l
+	}
+}
+
+// WithPrefix sets the URL path the server is mounted under, which entries link under, overriding the configured URL
+// prefix.
+func WithPrefix(prefix string) Option {
+	return func(o *options) {
+		o.prefix = prefix
+	}
+}
+
+// WithClock sets the clock used by time-dependent features, which defaults to the system's wall clock.
+func WithClock(c clock.Clock) Option {
+	return func(o *options) {
+		o.clock = c
+	}
+}
+
+// withMaskURLs shares the mask URLs fetched for the other servers of a configuration, which are polled for changes.
+func withMaskURLs(urls *maskURLs) Option {
+	return func(o *options) {
+		o.maskURLs = urls
+	}
+}
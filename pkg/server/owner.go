@@ -0,0 +1,47 @@
+package server
+
+import (
+	"fmt"
+	"os/user"
+	"strconv"
+	"strings"
+)
+
+// parseOwner parses an owner given as user, user:group, or :group, where users and groups are names or numeric IDs,
+// returning -1 for the one that isn't given.
+func parseOwner(owner string) (int, int, error) {
+	userName, groupName, _ := strings.Cut(owner, ":")
+	if userName == "" && groupName == "" {
+		return 0, 0, fmt.Errorf("invalid owner %q, must be user, user:group, or :group", owner)
+	}
+
+	uid, gid := -1, -1
+	if userName != "" {
+		id, err := strconv.Atoi(userName)
+		if err != nil {
+			u, lookupErr := user.Lookup(userName)
+			if lookupErr != nil {
+				return 0, 0, fmt.Errorf("failed to look up user %q: %w", userName, lookupErr)
+			}
+			if id, err = strconv.Atoi(u.Uid); err != nil {
+				return 0, 0, fmt.Errorf("user %q doesn't have a numeric ID", userName)
+			}
+		}
+		uid = id
+	}
+	if groupName != "" {
+		id, err := strconv.Atoi(groupName)
+		if err != nil {
+			g, lookupErr := user.LookupGroup(groupName)
+			if lookupErr != nil {
+				return 0, 0, fmt.Errorf("failed to look up group %q: %w", groupName, lookupErr)
+			}
+			if id, err = strconv.Atoi(g.Gid); err != nil {
+				return 0, 0, fmt.Errorf("group %q doesn't have a numeric ID", groupName)
+			}
+		}
+		gid = id
+	}
+
+	return uid, gid, nil
+}
@@ -0,0 +1,46 @@
+package server
+
+import (
+	"os/user"
+	"strconv"
+	"testing"
+)
+
+func TestParseOwner(t *testing.T) {
+	for _, tt := range []struct {
+		owner    string
+		uid, gid int
+	}{
+		{owner: "1000", uid: 1000, gid: -1},
+		{owner: "1000:50", uid: 1000, gid: 50},
+		{owner: ":50", uid: -1, gid: 50},
+		{owner: "1000:", uid: 1000, gid: -1},
+	} {
+		uid, gid, err := parseOwner(tt.owner)
+		if err != nil {
+			t.Errorf("parseOwner(%q) failed: %v", tt.owner, err)
+			continue
+		}
+		if uid != tt.uid || gid != tt.gid {
+			t.Errorf("parseOwner(%q) = %d, %d, want %d, %d", tt.owner, uid, gid, tt.uid, tt.gid)
+		}
+	}
+
+	for _, owner := range []string{"", ":", "no-such-user-maskfs", ":no-such-group-maskfs"} {
+		if _, _, err := parseOwner(owner); err == nil {
+			t.Errorf("parseOwner(%q) succeeded, want an error", owner)
+		}
+	}
+
+	current, err := user.Current()
+	if err != nil {
+		t.Skipf("failed to look up the current user: %v", err)
+	}
+	uid, _, err := parseOwner(current.Username)
+	if err != nil {
+		t.Fatalf("parseOwner(%q) failed: %v", current.Username, err)
+	}
+	if want, _ := strconv.Atoi(current.Uid); uid != want {
+		t.Errorf("parseOwner(%q) = %d, want %d", current.Username, uid, want)
+	}
+}
@@ -0,0 +1,116 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/njhale/maskfs/pkg/mask"
+)
+
+// profileKey is the context key of the mask profile bound to the credentials of a request.
+type profileKey struct{}
+
+// withProfile returns the request bound to the given mask profile, or the request as it is for the default mask.
+func withProfile(r *http.Request, profile string) *http.Request {
+	if profile == "" {
+		return r
+	}
+	return r.WithContext(context.WithValue(r.Context(), profileKey{}, profile))
+}
+
+// profileOf returns the mask profile bound to the credentials of a request, empty for the default mask.
+func profileOf(r *http.Request) string {
+	profile, _ := r.Context().Value(profileKey{}).(string)
+	return profile
+}
+
+// masksFor returns the masks applying to a request, which are those of the mask profile bound to its credentials if
+// there is one. Profiles are checked to exist when the server is created, but a request bound to a profile the masks
+// don't have is served with a mask hiding everything, rather than with the default mask.
+func (s *Server) masksFor(r *http.Request) *masks {
+	m := s.masks.Load()
+	profile := profileOf(r)
+	if profile == "" {
+		return m
+	}
+	if p, ok := m.profiles[profile]; ok {
+		return p
+	}
+	return &masks{all: mask.Not(nil), path: m.path}
+}
+
+// parseMaskProfiles parses mask profiles given as name=mask-file, returning the mask files keyed by profile name.
+func parseMaskProfiles(specs []string) (map[string]string, error) {
+	profiles := map[string]string{}
+	for _, spec := range specs {
+		name, file, ok := strings.Cut(spec, "=")
+		if !ok || name == "" || file == "" {
+			return nil, fmt.Errorf("invalid mask profile %q, must be name=mask-file", spec)
+		}
+		if _, ok := profiles[name]; ok {
+			return nil, fmt.Errorf("mask profile %q is defined more than once", name)
+		}
+		profiles[name] = file
+	}
+	return profiles, nil
+}
+
+// loadTokenProfiles reads a file of bearer tokens bound to mask profiles, with a profile name and a token separated
+// by whitespace on each line, returning the profiles keyed by token. Blank lines and lines starting with # are ignored.
+func loadTokenProfiles(name string, profiles map[string]string) (map[string]string, error) {
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read token profiles file: %w", err)
+	}
+
+	tokens := map[string]string{}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("malformed token profiles line %d, must be a profile name and a token", n)
+		}
+		profile, token := fields[0], fields[1]
+		if _, ok := profiles[profile]; !ok {
+			return nil, fmt.Errorf("token profiles line %d binds a token to undefined mask profile %q", n, profile)
+		}
+		if _, ok := tokens[token]; ok {
+			return nil, fmt.Errorf("token profiles line %d binds a token that's already bound", n)
+		}
+		tokens[token] = profile
+	}
+	if len(tokens) == 0 {
+		return nil, fmt.Errorf("token profiles file %q has no tokens", name)
+	}
+
+	return tokens, nil
+}
+
+// parseUserProfiles parses htpasswd users bound to mask profiles as user=profile, returning the profiles keyed by user.
+func parseUserProfiles(specs []string, profiles map[string]string, users htpasswd) (map[string]string, error) {
+	bound := map[string]string{}
+	for _, spec := range specs {
+		user, profile, ok := strings.Cut(spec, "=")
+		if !ok || user == "" || profile == "" {
+			return nil, fmt.Errorf("invalid user profile %q, must be user=profile", spec)
+		}
+		if _, ok := profiles[profile]; !ok {
+			return nil, fmt.Errorf("user %q is bound to undefined mask profile %q", user, profile)
+		}
+		if _, ok := users[user]; !ok {
+			return nil, fmt.Errorf("user %q bound to mask profile %q isn't in the htpasswd file", user, profile)
+		}
+		bound[user] = profile
+	}
+	return bound, nil
+}
@@ -0,0 +1,114 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestMaskProfiles(t *testing.T) {
+	root := writeFiles(t, map[string]string{
+		"public/a.txt":  "hello",
+		"private/b.txt": "secret",
+	})
+	hash, err := bcrypt.GenerateFromPassword([]byte("password"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := writeFiles(t, map[string]string{
+		"public.mask":    "public/**",
+		"token-profiles": "# profile token\npublic public-token\n",
+		"htpasswd":       "alice:" + string(hash) + "\nbob:" + string(hash) + "\n",
+	})
+
+	// Only one of an auth token and an htpasswd file can be given, so tokens and users are bound by separate servers
+	profiles := []string{"public=" + filepath.Join(config, "public.mask")}
+	tokens := newTestReloadingHandler(t, Config{
+		Root:          root,
+		Mask:          "**",
+		AuthToken:     "admin-token",
+		MaskProfile:   profiles,
+		TokenProfiles: filepath.Join(config, "token-profiles"),
+	})
+	users := newTestReloadingHandler(t, Config{
+		Root:        root,
+		Mask:        "**",
+		Htpasswd:    filepath.Join(config, "htpasswd"),
+		MaskProfile: profiles,
+		UserProfile: []string{"alice=public"},
+	})
+
+	for _, tt := range []struct {
+		name     string
+		token    string
+		user     string
+		password string
+		path     string
+		code     int
+	}{
+		{name: "no token", path: "/files/public/a.txt", code: http.StatusUnauthorized},
+		{name: "unknown token", token: "guess", path: "/files/public/a.txt", code: http.StatusUnauthorized},
+		{name: "auth token sees the mask", token: "admin-token", path: "/files/private/b.txt", code: http.StatusOK},
+		{name: "profile token sees its profile", token: "public-token", path: "/files/public/a.txt", code: http.StatusOK},
+		{name: "profile token doesn't see the mask", token: "public-token", path: "/files/private/b.txt", code: http.StatusNotFound},
+		{name: "wrong password", user: "alice", password: "guess", path: "/files/public/a.txt", code: http.StatusUnauthorized},
+		{name: "unbound user sees the mask", user: "bob", password: "password", path: "/files/private/b.txt", code: http.StatusOK},
+		{name: "profile user sees its profile", user: "alice", password: "password", path: "/files/public/a.txt", code: http.StatusOK},
+		{name: "profile user doesn't see the mask", user: "alice", password: "password", path: "/files/private/b.txt", code: http.StatusNotFound},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			if tt.token != "" {
+				r.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+			if tt.user != "" {
+				r.SetBasicAuth(tt.user, tt.password)
+			}
+			h := tokens
+			if tt.user != "" {
+				h = users
+			}
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+
+			if w.Code != tt.code {
+				t.Errorf("GET %s = %d, want %d", tt.path, w.Code, tt.code)
+			}
+		})
+	}
+}
+
+func TestMaskProfilesInvalid(t *testing.T) {
+	config := writeFiles(t, map[string]string{
+		"public.mask":       "public/**",
+		"undefined-profile": "other token\n",
+		"duplicate-token":   "public token\npublic token\n",
+		"malformed":         "public\n",
+		"admin-token":       "public admin-token\n",
+	})
+	profile := "public=" + filepath.Join(config, "public.mask")
+
+	for _, tt := range []struct {
+		name string
+		cfg  Config
+	}{
+		{name: "malformed profile", cfg: Config{MaskProfile: []string{"public"}}},
+		{name: "profile defined twice", cfg: Config{MaskProfile: []string{profile, profile}}},
+		{name: "token bound to an undefined profile", cfg: Config{MaskProfile: []string{profile}, TokenProfiles: filepath.Join(config, "undefined-profile")}},
+		{name: "token bound twice", cfg: Config{MaskProfile: []string{profile}, TokenProfiles: filepath.Join(config, "duplicate-token")}},
+		{name: "malformed token line", cfg: Config{MaskProfile: []string{profile}, TokenProfiles: filepath.Join(config, "malformed")}},
+		{name: "auth token bound to a profile", cfg: Config{MaskProfile: []string{profile}, TokenProfiles: filepath.Join(config, "admin-token"), AuthToken: "admin-token"}},
+		{name: "user profile without htpasswd", cfg: Config{MaskProfile: []string{profile}, UserProfile: []string{"alice=public"}}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.cfg.Root = t.TempDir()
+			tt.cfg.ShutdownTimeout, tt.cfg.RequestTimeout = "5s", "0"
+			if _, err := New(WithConfig(tt.cfg)); err == nil {
+				t.Error("New() succeeded, want an error")
+			}
+		})
+	}
+}
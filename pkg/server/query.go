@@ -0,0 +1,187 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/njhale/maskfs/pkg/index"
+)
+
+// queryParam describes a query parameter understood by the file server.
+type queryParam struct {
+	// name of the parameter
+	name string
+
+	// validate returns an error if the value is not acceptable for the parameter.
+	// A nil validate accepts any value.
+	validate func(value string) error
+
+	// excludes lists the names of lower-precedence parameters that are dropped when this parameter is present.
+	excludes []string
+}
+
+// queryParams lists the query parameters understood by the file server in order of precedence.
+// When mutually exclusive parameters are given, the one listed first wins and the others are dropped.
+var queryParams = []queryParam{
+	{name: "explain", validate: validateBool, excludes: []string{"archive", "hash", "checksums", "format", "sort", "order", "page", "per_page", "token", "limit", "from", "to", "filter_dirs", "recursive", "maxdepth"}},
+	{name: "archive", validate: validateOneOf("tar.gz", "zip"), excludes: []string{"checksums", "format", "token", "limit", "from", "to", "filter_dirs", "recursive", "maxdepth"}},
+	{name: "hash", validate: validateOneOf("sha256")},
+	{name: "render", validate: validateOneOf("html")},
+	{name: "thumb", validate: validatePositive},
+	{name: "download", validate: validateBool},
+	{name: "checksums", validate: validateBool, excludes: []string{"token", "limit", "format"}},
+	{name: "format", validate: validateOneOf("html", "json")},
+	{name: "sort", validate: validateOneOf(index.SortByName, index.SortBySize, index.SortByModTime), excludes: []string{"token", "limit"}},
+	{name: "order", validate: validateOneOf("asc", "desc"), excludes: []string{"token", "limit"}},
+	{name: "page", validate: validatePositive, excludes: []string{"token", "limit"}},
+	{name: "per_page", validate: validatePositive, excludes: []string{"token", "limit"}},
+	{name: "token", validate: validateToken},
+	{name: "limit", validate: validatePositive},
+	{name: "from", validate: validateTime},
+	{name: "to", validate: validateTime},
+	{name: "filter_dirs", validate: validateBool},
+	{name: "recursive", validate: validateBool},
+	{name: "maxdepth", validate: validatePositive},
+	{name: "expires", validate: validatePositive},
+	{name: "signature"},
+}
+
+// searchParams lists the query parameters understood by the search endpoint in order of precedence.
+var searchParams = []queryParam{
+	{name: "q", validate: validateNonEmpty},
+	{name: "path"},
+	{name: "content", validate: validateBool},
+	{name: "limit", validate: validatePositive},
+}
+
+// manifestParams lists the query parameters understood by the manifest endpoint.
+var manifestParams = []queryParam{
+	{name: "path"},
+	{name: "hash", validate: validateOneOf("sha256")},
+}
+
+func validateNonEmpty(value string) error {
+	if value == "" {
+		return errors.New("must not be empty")
+	}
+	return nil
+}
+
+func validateOneOf(allowed ...string) func(string) error {
+	return func(value string) error {
+		if !slices.Contains(allowed, value) {
+			return fmt.Errorf("must be one of %s", strings.Join(allowed, ", "))
+		}
+		return nil
+	}
+}
+
+func validateBool(value string) error {
+	_, err := strconv.ParseBool(value)
+	return err
+}
+
+func validateTime(value string) error {
+	_, err := time.Parse(time.RFC3339, value)
+	return err
+}
+
+func validateToken(value string) error {
+	_, err := index.DecodeToken(value)
+	return err
+}
+
+func validatePositive(value string) error {
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return err
+	}
+	if n <= 0 {
+		return errors.New("must be positive")
+	}
+	return nil
+}
+
+// query holds the validated query parameters of a request, with at most one value per parameter.
+type query map[string]string
+
+// has returns true if the parameter was given.
+func (q query) has(name string) bool {
+	_, ok := q[name]
+	return ok
+}
+
+// bool returns the value of a boolean parameter, or false if it wasn't given.
+// The value must have already been validated.
+func (q query) bool(name string) bool {
+	b, _ := strconv.ParseBool(q[name])
+	return b
+}
+
+// int returns the value of an integer parameter, or 0 if it wasn't given.
+// The value must have already been validated.
+func (q query) int(name string) int {
+	n, _ := strconv.Atoi(q[name])
+	return n
+}
+
+// time returns the value of an RFC 3339 timestamp parameter, or the zero time if it wasn't given.
+// The value must have already been validated.
+func (q query) time(name string) time.Time {
+	t, _ := time.Parse(time.RFC3339, q[name])
+	return t
+}
+
+// parseQuery validates the given query parameters against the given parameter list, such as queryParams,
+// and resolves conflicts between them.
+//
+// Precedence rules:
+//   - a parameter given more than once takes its first value; in strict mode it's rejected instead
+//   - a parameter excluded by a higher-precedence parameter that was also given is dropped
+//   - an unknown parameter is ignored; in strict mode it's rejected instead
+//
+// Validation errors always reject the query and name the offending parameter.
+func parseQuery(values url.Values, params []queryParam, strict bool) (query, error) {
+	known := make(map[string]bool, len(params))
+	for _, param := range params {
+		known[param.name] = true
+	}
+
+	for name := range values {
+		if strict && !known[name] {
+			return nil, fmt.Errorf("unknown query parameter %q", name)
+		}
+	}
+
+	q := query{}
+	dropped := map[string]bool{}
+	for _, param := range params {
+		given, ok := values[param.name]
+		if !ok || dropped[param.name] {
+			continue
+		}
+
+		if strict && len(given) > 1 {
+			return nil, fmt.Errorf("query parameter %q given more than once", param.name)
+		}
+
+		value := given[0]
+		if param.validate != nil {
+			if err := param.validate(value); err != nil {
+				return nil, fmt.Errorf("invalid value %q for query parameter %q: %w", value, param.name, err)
+			}
+		}
+
+		q[param.name] = value
+		for _, name := range param.excludes {
+			dropped[name] = true
+		}
+	}
+
+	return q, nil
+}
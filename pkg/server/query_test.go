@@ -0,0 +1,85 @@
+package server
+
+import (
+	"errors"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestParseQuery(t *testing.T) {
+	oneOf := func(values ...string) func(string) error {
+		return func(value string) error {
+			for _, v := range values {
+				if value == v {
+					return nil
+				}
+			}
+			return errors.New("unexpected value")
+		}
+	}
+	params := []queryParam{
+		{name: "explain", excludes: []string{"format", "sort"}},
+		{name: "format", validate: oneOf("html", "json")},
+		{name: "sort", validate: oneOf("name", "size"), excludes: []string{"token"}},
+		{name: "token"},
+	}
+
+	for _, tt := range []struct {
+		name   string
+		query  string
+		strict bool
+		want   query
+		err    string
+	}{
+		{name: "empty", query: "", want: query{}},
+		{name: "valid", query: "format=json&sort=size", want: query{"format": "json", "sort": "size"}},
+		{name: "any value", query: "token=anything", want: query{"token": "anything"}},
+		{name: "invalid", query: "format=xml", err: `invalid value "xml" for query parameter "format"`},
+		{name: "unknown ignored", query: "utm_source=mail&format=json", want: query{"format": "json"}},
+		{name: "unknown rejected in strict mode", query: "utm_source=mail", strict: true, err: `unknown query parameter "utm_source"`},
+		{name: "repeated takes the first", query: "format=json&format=html", want: query{"format": "json"}},
+		{name: "repeated rejected in strict mode", query: "format=json&format=html", strict: true, err: `query parameter "format" given more than once`},
+		{name: "higher precedence drops", query: "sort=name&token=abc", want: query{"sort": "name"}},
+		{name: "dropped aren't validated", query: "explain=1&format=xml&sort=color", want: query{"explain": "1"}},
+		{name: "dropped only by given", query: "explain=1&token=abc", want: query{"explain": "1", "token": "abc"}},
+		{name: "conflicts resolve the same in strict mode", query: "sort=name&token=abc", strict: true, want: query{"sort": "name"}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			values, err := url.ParseQuery(tt.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := parseQuery(values, params, tt.strict)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Errorf("parseQuery(%q) = %v, %v, want error %q", tt.query, got, err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseQuery(%q) = %v", tt.query, err)
+			}
+			if !maps.Equal(got, tt.want) {
+				t.Errorf("parseQuery(%q) = %v, want %v", tt.query, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestServeStrictQuery(t *testing.T) {
+	for _, strict := range []bool{false, true} {
+		w := httptest.NewRecorder()
+		newHandler(t, Config{Mask: "**", StrictQuery: strict}).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/files/missing?format=html&color=blue", nil))
+		if strict != (w.Code == http.StatusBadRequest) {
+			t.Errorf("GET with an unknown parameter = %d in strict mode %t", w.Code, strict)
+		}
+		if strict && !strings.Contains(w.Body.String(), `"color"`) {
+			t.Errorf("GET with an unknown parameter = %q, want it named", w.Body)
+		}
+	}
+}
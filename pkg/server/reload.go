@@ -0,0 +1,543 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/njhale/maskfs/pkg/clock"
+	"github.com/njhale/maskfs/pkg/index"
+	"github.com/njhale/maskfs/pkg/logger"
+	"github.com/njhale/maskfs/pkg/mask"
+)
+
+// masks holds the masks applied to requests, which are replaced together when the mask is reloaded.
+type masks struct {
+	all   index.Mask     // Every mask combined, used to decide whether an entry is masked
+	path  pathMask       // The mask built from the path rules alone
+	write *mask.GlobMask // The mask selecting the paths that can be written, nil if writes are disabled
+
+	version string    // Identifies the masks in the validators of responses, see newMaskVersion
+	loaded  time.Time // When the masks were loaded, which listings may have changed at
+
+	profiles map[string]*masks // The masks of each mask profile, keyed by name
+}
+
+// invalidateLayers makes the path masks, including those of the mask profiles, read their per-directory rule files
+// again the next time they're needed.
+func (m *masks) invalidateLayers() {
+	if l, ok := m.path.(interface{ InvalidateLayers() }); ok {
+		l.InvalidateLayers()
+	}
+	for _, p := range m.profiles {
+		p.invalidateLayers()
+	}
+}
+
+// pathMask is a mask built from path rules, which can tell whether a rule names an entry literally and which rule
+// decided whether an entry is masked.
+type pathMask interface {
+	index.Mask
+	Explicit(entry *index.Entry) bool
+	Explain(entry *index.Entry) (masked bool, rule string, index int)
+}
+
+// asPathMask returns the mask as a path mask, which can't tell which rule decided whether an entry is masked unless it
+// is a path mask already.
+func asPathMask(m index.Mask) pathMask {
+	if p, ok := m.(pathMask); ok {
+		return p
+	}
+	return opaquePathMask{m}
+}
+
+// opaquePathMask is a path mask of a mask without rules.
+type opaquePathMask struct {
+	index.Mask
+}
+
+func (opaquePathMask) Explicit(*index.Entry) bool {
+	return false
+}
+
+func (m opaquePathMask) Explain(entry *index.Entry) (masked bool, rule string, index int) {
+	return m.Masked(entry), "", -1
+}
+
+// newPathMask parses path rules of the configured mask type, using them in the configured mode, and checking
+// modification time rules with the clock.
+func newPathMask(cfg Config, rules string, fsys fs.FS, c clock.Clock) (pathMask, error) {
+	exclude := false
+	switch cfg.MaskMode {
+	case "", "include":
+	case "exclude":
+		exclude = true
+	default:
+		return nil, fmt.Errorf("unsupported mask mode %q, must be include or exclude", cfg.MaskMode)
+	}
+
+	switch cfg.MaskType {
+	case "", "glob":
+		var (
+			m   *mask.GlobMask
+			err error
+		)
+		if exclude {
+			m, err = mask.NewExcludeGlobMask(rules)
+		} else {
+			m, err = mask.NewGlobMask(rules)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse path mask: %w", err)
+		}
+
+		m.SetClock(c)
+		if cfg.NestedMaskFile != "" {
+			m.LayerFiles(fsys, cfg.NestedMaskFile)
+		}
+		m.CacheDecisions(cfg.MaskCache)
+		return m, nil
+	case "regex":
+		if cfg.NestedMaskFile != "" {
+			return nil, errors.New("nested mask files are only supported with glob masks")
+		}
+
+		var (
+			m   *mask.RegexMask
+			err error
+		)
+		if exclude {
+			m, err = mask.NewExcludeRegexMask(rules)
+		} else {
+			m, err = mask.NewRegexMask(rules)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse path mask: %w", err)
+		}
+		return m, nil
+	default:
+		return nil, fmt.Errorf("unsupported mask type %q, must be glob or regex", cfg.MaskType)
+	}
+}
+
+// loadMasks reads and parses the mask rules of the given configuration, telling the time by the clock.
+// The rules of the mask URL are the ones last fetched, if there's a mask URL.
+func loadMasks(cfg Config, fsys fs.FS, c clock.Clock, remote *remoteRules) (*masks, error) {
+	rules := cfg.Mask
+	if cfg.MaskFile != "" {
+		// Rules later in the mask take precedence, so put the inline rules after the file's
+		data, err := os.ReadFile(cfg.MaskFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read mask file: %w", err)
+		}
+		rules = string(data) + "\n" + rules
+	}
+	if remote != nil {
+		// Shared rules come first, so that every server can refine them
+		rules = remote.current() + "\n" + rules
+	}
+
+	pathMask, err := newPathMask(cfg, rules, fsys, c)
+	if err != nil {
+		return nil, err
+	}
+
+	junkMask, err := mask.NewJunkMask(cfg.HideJunk)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse junk patterns: %w", err)
+	}
+
+	var typeMasks []index.Mask
+	if cfg.ContentTypes != "" {
+		m, err := mask.NewMIMEMask(cfg.ContentTypes)
+		if err != nil {
+			return nil, err
+		}
+		typeMasks = append(typeMasks, m)
+	}
+	if cfg.HideContentTypes != "" {
+		m, err := mask.NewExcludeMIMEMask(cfg.HideContentTypes)
+		if err != nil {
+			return nil, err
+		}
+		typeMasks = append(typeMasks, m)
+	}
+
+	var ownerMask index.Mask
+	if cfg.OwnedBy != "" {
+		uid, gid, err := parseOwner(cfg.OwnedBy)
+		if err != nil {
+			return nil, err
+		}
+		ownerMask = mask.NewOwnerMask(uid, gid)
+	}
+
+	var permMask index.Mask
+	if cfg.RequirePerm != "" {
+		perm, err := strconv.ParseUint(cfg.RequirePerm, 8, 32)
+		if err != nil || perm > 0o777 {
+			return nil, fmt.Errorf("invalid required permissions %q, must be octal permissions like 0004", cfg.RequirePerm)
+		}
+		permMask = mask.NewModeMask(mask.LacksPerm(fs.FileMode(perm)))
+	}
+
+	var sizeMask index.Mask
+	if cfg.MinFileSize > 0 || cfg.MaxFileSize > 0 {
+		if sizeMask, err = mask.NewSizeMask(cfg.MinFileSize, cfg.MaxFileSize); err != nil {
+			return nil, err
+		}
+	}
+
+	trashDir, err := parseTrashDir(cfg.TrashDir)
+	if err != nil {
+		return nil, err
+	}
+
+	var trashMask index.Mask
+	if trashDir != "" {
+		// Deleted files stay deleted as far as clients are concerned
+		trashMask = hiddenDir(trashDir)
+	}
+
+	// Content types are sniffed last, so that only the files every other mask includes are opened
+	all := mask.All(append([]index.Mask{pathMask, junkMask, ownerMask, permMask, sizeMask, trashMask}, typeMasks...)...)
+
+	// Symlinks are checked after everything else, since resolving them means stat'ing every element of the path
+	symlinkMask, err := newSymlinkMask(cfg.Symlinks, fsys, all)
+	if err != nil {
+		return nil, err
+	}
+
+	m := &masks{
+		all:     mask.All(all, symlinkMask),
+		path:    pathMask,
+		version: newMaskVersion(),
+		loaded:  c.Now(),
+	}
+	if cfg.WriteMask != "" {
+		if m.write, err = mask.NewGlobMask(cfg.WriteMask); err != nil {
+			return nil, fmt.Errorf("failed to parse write mask: %w", err)
+		}
+	}
+
+	profiles, err := parseMaskProfiles(cfg.MaskProfile)
+	if err != nil {
+		return nil, err
+	}
+	for name, file := range profiles {
+		// A profile's rules replace the path rules, while every other mask still applies
+		profileCfg := cfg
+		profileCfg.Mask, profileCfg.MaskFile, profileCfg.MaskURL, profileCfg.MaskProfile = "", file, "", nil
+		p, err := loadMasks(profileCfg, fsys, c, nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load mask profile %q: %w", name, err)
+		}
+
+		if m.profiles == nil {
+			m.profiles = map[string]*masks{}
+		}
+		m.profiles[name] = p
+	}
+
+	return m, nil
+}
+
+// ReloadMask re-reads the mask rules, including the mask file and any per-directory rule files,
+// and atomically replaces the server's mask with them. The rules of the mask URL are only refetched by polling.
+// Requests already being handled finish with the mask they started with.
+// If the rules can't be loaded, the current mask is kept and the error is returned.
+func (s *Server) ReloadMask() error {
+	if s.fixedMask {
+		return errors.New("the mask was given with WithMask and can't be reloaded")
+	}
+
+	m, err := loadMasks(s.cfg, s.fsys, serverClock{s}, s.remote)
+	if err != nil {
+		return err
+	}
+
+	s.masks.Store(m)
+	return nil
+}
+
+// watchMaskFile reloads the mask whenever the mask file changes, if watch is set and there's a mask file,
+// until the context is canceled.
+func (s *Server) watchMaskFile(ctx context.Context, watch bool) error {
+	if !watch || s.cfg.MaskFile == "" {
+		return nil
+	}
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return fmt.Errorf("failed to create mask file watcher: %w", err)
+	}
+
+	// Watch the file's directory rather than the file, since editors often replace a file instead of writing to it
+	if err := watcher.Add(filepath.Dir(s.cfg.MaskFile)); err != nil {
+		_ = watcher.Close()
+		return fmt.Errorf("failed to watch mask file: %w", err)
+	}
+
+	maskFile := filepath.Clean(s.cfg.MaskFile)
+	go func() {
+		defer watcher.Close()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case event := <-watcher.Events:
+				if filepath.Clean(event.Name) != maskFile || !event.Has(fsnotify.Write|fsnotify.Create) {
+					continue
+				}
+				if err := s.ReloadMask(); err != nil {
+					s.logger.Errorf("Failed to reload mask on mask file change, keeping the current mask: %v", err)
+					continue
+				}
+				s.logger.Infof("Reloaded mask on mask file change")
+			case err := <-watcher.Errors:
+				s.logger.Errorf("Mask file watcher error: %v", err)
+			}
+		}
+	}()
+
+	return nil
+}
+
+// generation is everything built from one load of the configuration, which is replaced as a whole on reload.
+type generation struct {
+	server  *Server      // The server of the file prefix, whose settings the listeners are set up with
+	handler http.Handler // Routes requests to the servers of the file prefix and mounts
+	cancel  context.CancelFunc
+	closers closers // The servers of the file prefix, mounts, and virtual hosts, closed once the generation is retired
+
+	mu      sync.Mutex
+	active  int  // Requests being handled
+	retired bool // Set once a newer generation replaced this one, after which it handles no new requests
+}
+
+// acquire counts a request the generation is about to handle, returning false if it's been retired instead.
+func (g *generation) acquire() bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.retired {
+		return false
+	}
+	g.active++
+	return true
+}
+
+// release counts a request the generation finished handling, closing the generation if it was the last one of a
+// retired generation.
+func (g *generation) release() {
+	g.mu.Lock()
+	g.active--
+	done := g.retired && g.active == 0
+	g.mu.Unlock()
+
+	if done {
+		g.close()
+	}
+}
+
+// retire stops the generation's watchers and stops it from handling new requests, which don't depend on the watchers,
+// and closes it once the requests it's handling are done.
+func (g *generation) retire() {
+	if g.cancel != nil {
+		g.cancel()
+	}
+
+	g.mu.Lock()
+	g.retired = true
+	done := g.active == 0
+	g.mu.Unlock()
+
+	if done {
+		g.close()
+	}
+}
+
+// close releases what the generation's servers opened.
+func (g *generation) close() {
+	if err := g.closers.Close(); err != nil {
+		g.server.logger.Errorf("Failed to close a retired configuration: %v", err)
+	}
+}
+
+// reloadingHandler serves every request with the handler of the most recently loaded configuration.
+// Requests already being handled when the configuration is reloaded finish with the handler they started with.
+type reloadingHandler struct {
+	ctx     context.Context
+	cfg     Config
+	logger  logger.Logger
+	current atomic.Pointer[generation]
+	mu      sync.Mutex // Serializes reloads
+	closed  bool       // Set once closed, after which the configuration is no longer reloaded
+	logs    []*logFile // Reopened after every reload
+}
+
+// reopenOnReload reopens the log file after every reload.
+func (h *reloadingHandler) reopenOnReload(l *logFile) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.logs = append(h.logs, l)
+}
+
+// tlsConfig returns the TLS configuration of listeners, which hands every connection the TLS configuration of the
+// current generation, so that reloads pick up renewed certificates and changed client CAs. It's nil if the first
+// generation serves plain HTTP, which reloads can't change.
+func (h *reloadingHandler) tlsConfig() *tls.Config {
+	first := h.current.Load().server.tlsConfig
+	if first == nil {
+		return nil
+	}
+
+	return &tls.Config{
+		MinVersion: first.MinVersion,
+		NextProtos: first.NextProtos,
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			if s := h.current.Load().server; s != nil && s.tlsConfig != nil {
+				return s.tlsConfig, nil
+			}
+			// Closed, so the connection's requests fail anyway
+			return first, nil
+		},
+	}
+}
+
+// newReloadingHandler returns a handler serving the given configuration, which is loaded for the first time. Watchers
+// of the loaded configuration run until the context is canceled.
+func newReloadingHandler(ctx context.Context, cfg Config) (*reloadingHandler, error) {
+	h := &reloadingHandler{
+		ctx:    ctx,
+		cfg:    cfg,
+		logger: logger.New("server"),
+	}
+	if err := h.Reload(); err != nil {
+		return nil, err
+	}
+	return h, nil
+}
+
+func (h *reloadingHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	for {
+		gen := h.current.Load()
+		if !gen.acquire() {
+			// The generation was retired after it was loaded, so a newer one is current by now
+			continue
+		}
+		defer gen.release()
+
+		gen.handler.ServeHTTP(w, r)
+		return
+	}
+}
+
+// Close retires the current configuration, closing it once the requests being handled are done. Requests handled
+// afterwards fail.
+func (h *reloadingHandler) Close() error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.closed = true
+	if old := h.current.Swap(&generation{handler: http.HandlerFunc(unavailable)}); old != nil {
+		old.retire()
+	}
+	return nil
+}
+
+// unavailable responds to requests handled after the server was closed.
+func unavailable(w http.ResponseWriter, _ *http.Request) {
+	http.Error(w, "Service unavailable", http.StatusServiceUnavailable)
+}
+
+// Reload re-reads everything the configuration refers to, such as the root, the mask, auth, and listing template
+// files, and atomically replaces the handler with one built from them.
+// If the configuration can't be loaded, the current handler is kept and the error is returned.
+func (h *reloadingHandler) Reload() error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.closed {
+		return errors.New("the server is closed")
+	}
+
+	ctx, cancel := context.WithCancel(h.ctx)
+	gen, err := newGeneration(ctx, h.cfg, h, newMaskURLs(ctx))
+	if err != nil {
+		cancel()
+		return err
+	}
+	gen.cancel = cancel
+
+	if old := h.current.Swap(gen); old != nil {
+		// Requests already being handled by the old generation finish with it before it's closed
+		old.retire()
+	}
+	for _, l := range h.logs {
+		if err := l.reopen(); err != nil {
+			h.logger.Errorf("Failed to reopen log file %q, still writing to the file that was open: %v", l.name, err)
+		}
+	}
+	return nil
+}
+
+// reloadOnSignal reloads the configuration whenever the process receives SIGHUP, until the context is canceled.
+func (h *reloadingHandler) reloadOnSignal(ctx context.Context) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+
+	go func() {
+		defer signal.Stop(hup)
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-hup:
+				if err := h.Reload(); err != nil {
+					h.logger.Errorf("Failed to reload configuration on SIGHUP, keeping the current configuration: %v", err)
+					continue
+				}
+				h.logger.Infof("Reloaded configuration on SIGHUP")
+			}
+		}
+	}()
+}
+
+// serveReload reloads the configuration on POST requests, responding with 204 once the new configuration is in use,
+// or with the error if it can't be loaded.
+func (h *reloadingHandler) serveReload(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if profileOf(r) != "" {
+		// Clients bound to a mask profile are restricted, so they don't get to administer the server
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	if err := h.Reload(); err != nil {
+		h.logger.Errorf("Failed to reload configuration on request, keeping the current configuration: %v", err)
+		http.Error(w, fmt.Sprintf("Failed to reload configuration: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	h.logger.Infof("Reloaded configuration on request")
+	w.WriteHeader(http.StatusNoContent)
+}
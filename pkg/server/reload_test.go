@@ -0,0 +1,111 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/njhale/maskfs/pkg/logger"
+)
+
+// newTestReloadingHandler returns a handler serving the given configuration the way Run does, defaulting the durations
+// it requires.
+func newTestReloadingHandler(t *testing.T, cfg Config) *reloadingHandler {
+	t.Helper()
+
+	if cfg.ShutdownTimeout == "" {
+		cfg.ShutdownTimeout = "5s"
+	}
+	if cfg.RequestTimeout == "" {
+		cfg.RequestTimeout = "0"
+	}
+	h, err := newReloadingHandler(context.Background(), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return h
+}
+
+func TestReloadingHandler(t *testing.T) {
+	root := writeFiles(t, map[string]string{"a.txt": "a", "b.txt": "b"})
+	config := t.TempDir()
+	maskFile, tokenFile := filepath.Join(config, "mask"), filepath.Join(config, "token")
+	write := func(name, contents string) {
+		t.Helper()
+		if err := os.WriteFile(name, []byte(contents), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(maskFile, "a.txt")
+	write(tokenFile, "old")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	h := &reloadingHandler{
+		ctx: ctx,
+		cfg: Config{
+			Root:            root,
+			MaskFile:        maskFile,
+			AuthTokenFile:   tokenFile,
+			AdminReload:     true,
+			ShutdownTimeout: "5s",
+			RequestTimeout:  "0",
+		},
+		logger: logger.New("test"),
+	}
+	if err := h.Reload(); err != nil {
+		t.Fatal(err)
+	}
+
+	do := func(method, target, token string) int {
+		t.Helper()
+		r := httptest.NewRequest(method, target, nil)
+		if token != "" {
+			r.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w.Code
+	}
+	expect := func(method, target, token string, want int) {
+		t.Helper()
+		if code := do(method, target, token); code != want {
+			t.Errorf("%s %s with token %q = %d, want %d", method, target, token, code, want)
+		}
+	}
+
+	expect(http.MethodGet, "/files/a.txt", "old", http.StatusOK)
+	expect(http.MethodGet, "/files/b.txt", "old", http.StatusNotFound)
+	expect(http.MethodPost, "/admin/reload", "", http.StatusUnauthorized)
+	expect(http.MethodGet, "/admin/reload", "old", http.StatusMethodNotAllowed)
+
+	// Nothing changes until the configuration is reloaded, and then everything it refers to is read again
+	write(maskFile, "b.txt")
+	write(tokenFile, "new")
+	expect(http.MethodGet, "/files/a.txt", "old", http.StatusOK)
+	expect(http.MethodPost, "/admin/reload", "old", http.StatusNoContent)
+	expect(http.MethodGet, "/files/a.txt", "new", http.StatusNotFound)
+	expect(http.MethodGet, "/files/b.txt", "new", http.StatusOK)
+	expect(http.MethodGet, "/files/b.txt", "old", http.StatusUnauthorized)
+
+	// A configuration that can't be loaded keeps the current one
+	if err := os.Remove(maskFile); err != nil {
+		t.Fatal(err)
+	}
+	expect(http.MethodPost, "/admin/reload", "new", http.StatusInternalServerError)
+	expect(http.MethodGet, "/files/b.txt", "new", http.StatusOK)
+}
+
+func TestAdminReloadRequiresAuth(t *testing.T) {
+	h := &reloadingHandler{
+		ctx:    context.Background(),
+		cfg:    Config{Root: t.TempDir(), Mask: "**", AdminReload: true, ShutdownTimeout: "5s", RequestTimeout: "0"},
+		logger: logger.New("test"),
+	}
+	if err := h.Reload(); err == nil {
+		t.Error("Reload() enabled the reload endpoint without authentication")
+	}
+}
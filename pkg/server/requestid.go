@@ -0,0 +1,71 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+
+	"github.com/njhale/maskfs/pkg/logger"
+)
+
+// maxRequestIDLength is the maximum length of the request IDs clients can give, longer ones are replaced.
+const maxRequestIDLength = 128
+
+// requestIDKey is the context key of the ID of a request.
+type requestIDKey struct{}
+
+// requestIDs returns middleware that identifies every request by the ID its X-Request-ID header gives, like the one a
+// proxy in front of the server generated, or else by a new random one. The ID is returned in the response's
+// X-Request-ID header and added to every record logged for the request, so it has to wrap every handler that logs.
+func requestIDs(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if requestIDOf(r.Context()) != "" {
+			// The request is already identified, like requests of virtual hosts by the default host's middleware
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		id := r.Header.Get("X-Request-ID")
+		if !validRequestID(id) {
+			id = newRequestID()
+		}
+		w.Header().Set("X-Request-ID", id)
+
+		ctx := context.WithValue(r.Context(), requestIDKey{}, id)
+		ctx = logger.ContextWithFields(ctx, "request_id", id)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// requestIDOf returns the ID of the request of a context, empty if it has none.
+func requestIDOf(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// validRequestID returns true if a request ID given by a client can be used as is, which it can if it's short and
+// only has characters that can't forge log lines or response headers.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for _, c := range []byte(id) {
+		if c <= ' ' || c >= 0x7f || c == '"' || c == '\\' {
+			return false
+		}
+	}
+	return true
+}
+
+// newRequestID returns a random 128-bit request ID, hex encoded.
+func newRequestID() string {
+	var b [16]byte
+	_, _ = rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
+
+// requestLogger returns the server's logger, adding the request's ID to every record.
+func (s *Server) requestLogger(r *http.Request) logger.Logger {
+	return logger.ForContext(r.Context(), s.logger)
+}
@@ -0,0 +1,57 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRequestIDs(t *testing.T) {
+	for _, tt := range []struct {
+		name   string
+		header string
+		keep   bool
+	}{
+		{name: "no ID", header: ""},
+		{name: "valid ID", header: "abc-123", keep: true},
+		{name: "ID with spaces", header: "abc 123"},
+		{name: "ID with quotes", header: `abc"123`},
+		{name: "ID with non-ASCII characters", header: "abcé"},
+		{name: "long ID", header: strings.Repeat("a", maxRequestIDLength+1)},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			var seen string
+			h := requestIDs(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				seen = requestIDOf(r.Context())
+			}))
+
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tt.header != "" {
+				r.Header.Set("X-Request-ID", tt.header)
+			}
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+
+			if got := w.Header().Get("X-Request-ID"); got != seen || seen == "" {
+				t.Fatalf("X-Request-ID = %q, handler saw %q", got, seen)
+			}
+			if (seen == tt.header) != tt.keep {
+				t.Errorf("request ID = %q, given %q, want it kept %t", seen, tt.header, tt.keep)
+			}
+		})
+	}
+}
+
+func TestRequestIDsNested(t *testing.T) {
+	var inner string
+	h := requestIDs(requestIDs(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		inner = requestIDOf(r.Context())
+	})))
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	if got := w.Header().Get("X-Request-ID"); got != inner {
+		t.Errorf("nested middleware replaced request ID %q with %q", got, inner)
+	}
+}
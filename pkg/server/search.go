@@ -0,0 +1,154 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/njhale/maskfs/pkg/index"
+)
+
+// errSearchLimit stops a search once enough results have been found.
+var errSearchLimit = errors.New("search result limit reached")
+
+// searchResult is a single match, encoded as one line of a search response.
+type searchResult struct {
+	FSPath   string `json:"fs_path"`
+	LinkPath string `json:"link_path"`
+	Line     int    `json:"line,omitempty"` // Line number of a content match, starting at 1, omitted for name matches
+	Text     string `json:"text,omitempty"` // Text of the matching line, omitted for name matches
+}
+
+// serveSearch streams the unmasked entries below a directory whose names contain a query, case-insensitively,
+// as newline-delimited JSON. With ?content=true, the lines of unmasked files containing the query are streamed too.
+//
+// Results are written as they're found. An error after the first result is reported by a final line with an
+// "error" field, since the response status has already been sent.
+func (s *Server) serveSearch(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	q, err := parseQuery(r.URL.Query(), searchParams, s.strictQuery)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !q.has("q") {
+		http.Error(w, `query parameter "q" is required`, http.StatusBadRequest)
+		return
+	}
+
+	dir := path.Clean(strings.TrimPrefix(q["path"], "/"))
+	if !fs.ValidPath(dir) {
+		http.NotFound(w, r)
+		return
+	}
+
+	m := s.masksFor(r)
+	directory, err := index.GetEntry(s.fsys, dir)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	if !directory.IsRoot() && m.all.Masked(directory) {
+		s.audit(r, m, directory)
+		http.NotFound(w, r)
+		return
+	}
+	if !directory.IsDir {
+		http.NotFound(w, r)
+		return
+	}
+
+	var (
+		fsys    = newBudgetFS(r.Context(), s.clock, s.fsys, s.maxWalkEntries, s.requestTimeout)
+		needle  = strings.ToLower(q["q"])
+		limit   = q.int("limit")
+		found   int
+		written bool
+		enc     = json.NewEncoder(w)
+		flusher = http.NewResponseController(w)
+	)
+	w.Header().Set("Content-Type", "application/x-ndjson")
+
+	emit := func(result searchResult) error {
+		written = true
+		if err := enc.Encode(result); err != nil {
+			return err
+		}
+		_ = flusher.Flush()
+
+		if found++; limit > 0 && found >= limit {
+			return errSearchLimit
+		}
+		return nil
+	}
+
+	err = index.Walk(fsys, directory.FSPath, m.all, func(entry *index.Entry, _ int) error {
+		if strings.Contains(strings.ToLower(entry.Name), needle) {
+			if err := emit(searchResult{FSPath: entry.FSPath, LinkPath: entry.LinkPath}); err != nil {
+				return err
+			}
+		}
+
+		if !q.bool("content") || entry.IsDir || (s.maxSearchSize > 0 && entry.Size > s.maxSearchSize) {
+			return nil
+		}
+		return grepFile(fsys, entry, needle, emit)
+	}, s.linked())
+	if err == nil || errors.Is(err, errSearchLimit) {
+		return
+	}
+
+	if !written {
+		s.writeError(w, r, err)
+		return
+	}
+
+	s.requestLogger(r).Errorf("Aborting search of %q: %v", directory.FSPath, err)
+	_ = enc.Encode(struct {
+		Error string `json:"error"`
+	}{
+		Error: err.Error(),
+	})
+}
+
+// grepFile calls emit for every line of a file containing the lower-cased needle, case-insensitively.
+// Files that look binary, because they contain a NUL byte, stop being searched at the first one.
+func grepFile(fsys fs.FS, entry *index.Entry, needle string, emit func(searchResult) error) error {
+	f, err := fsys.Open(entry.FSPath)
+	if err != nil {
+		return fmt.Errorf("failed to open %q: %w", entry.FSPath, err)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 1<<20)
+	for n := 1; scanner.Scan(); n++ {
+		line := scanner.Bytes()
+		if bytes.IndexByte(line, 0) >= 0 {
+			return nil
+		}
+		if !strings.Contains(strings.ToLower(string(line)), needle) {
+			continue
+		}
+
+		if err := emit(searchResult{FSPath: entry.FSPath, LinkPath: entry.LinkPath, Line: n, Text: string(line)}); err != nil {
+			return err
+		}
+	}
+
+	if errors.Is(scanner.Err(), bufio.ErrTooLong) {
+		// Lines this long aren't text worth searching
+		return nil
+	}
+	return scanner.Err()
+}
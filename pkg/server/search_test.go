@@ -0,0 +1,78 @@
+package server
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestServeSearch(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"notes/todo.txt":     "buy milk\nwrite TODO list\n",
+		"notes/done.txt":     "nothing to do\n",
+		"notes/secret.key":   "todo: rotate keys\n",
+		"notes/binary.bin":   "todo\x00todo\n",
+		"other/TODO.md":      "",
+		"private/todo.txt":   "todo\n",
+		"notes/sub/todo.txt": "",
+	})
+	s := newTestServer(t, Config{Root: dir, Mask: "**\n!*.key\n!private/", Search: true})
+
+	search := func(query string) (int, []string) {
+		t.Helper()
+
+		w := httptest.NewRecorder()
+		s.serveSearch(w, httptest.NewRequest(http.MethodGet, "/search?"+query, nil))
+		if w.Code != http.StatusOK {
+			return w.Code, nil
+		}
+		var results []string
+		scanner := bufio.NewScanner(w.Body)
+		for scanner.Scan() {
+			var result searchResult
+			if err := json.Unmarshal(scanner.Bytes(), &result); err != nil {
+				t.Fatalf("malformed result %q: %v", scanner.Text(), err)
+			}
+			if result.Line > 0 {
+				results = append(results, result.FSPath+":"+result.Text)
+			} else {
+				results = append(results, result.FSPath)
+			}
+		}
+		return w.Code, results
+	}
+
+	for _, tt := range []struct {
+		query string
+		code  int
+		want  []string
+	}{
+		{query: "q=todo", code: http.StatusOK, want: []string{"notes/sub/todo.txt", "notes/todo.txt", "other/TODO.md"}},
+		{query: "q=todo&path=notes", code: http.StatusOK, want: []string{"notes/sub/todo.txt", "notes/todo.txt"}},
+		{query: "q=todo&path=/notes&content=true", code: http.StatusOK, want: []string{"notes/sub/todo.txt", "notes/todo.txt", "notes/todo.txt:write TODO list"}},
+		{query: "q=todo&limit=2", code: http.StatusOK, want: []string{"notes/sub/todo.txt", "notes/todo.txt"}},
+		{query: "q=rotate&content=true", code: http.StatusOK, want: nil},
+		{query: "q=todo&path=private", code: http.StatusNotFound},
+		{query: "q=todo&path=missing", code: http.StatusNotFound},
+		{query: "q=todo&path=../notes", code: http.StatusNotFound},
+		{query: "q=todo&path=notes/todo.txt", code: http.StatusNotFound},
+		{query: "path=notes", code: http.StatusBadRequest},
+		{query: "q=", code: http.StatusBadRequest},
+		{query: "q=todo&limit=0", code: http.StatusBadRequest},
+	} {
+		code, results := search(tt.query)
+		if code != tt.code || !slices.Equal(results, tt.want) {
+			t.Errorf("search %s = %d %q, want %d %q", tt.query, code, results, tt.code, tt.want)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	s.serveSearch(w, httptest.NewRequest(http.MethodPost, "/search?q=todo", strings.NewReader("")))
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("POST /search = %d, want %d", w.Code, http.StatusMethodNotAllowed)
+	}
+}
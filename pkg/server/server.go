@@ -2,157 +2,1636 @@ package server
 
 import (
 	"context"
+	"crypto/tls"
+	"errors"
 	"fmt"
+	"html/template"
+	"io"
+	"io/fs"
+	"mime"
+	"net"
 	"net/http"
 	"net/url"
 	"os"
+	"path"
 	"path/filepath"
 	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/coreos/go-oidc/v3/oidc"
+	"github.com/njhale/maskfs/pkg/archivefs"
+	"github.com/njhale/maskfs/pkg/clock"
+	"github.com/njhale/maskfs/pkg/gitfs"
 	"github.com/njhale/maskfs/pkg/index"
 	"github.com/njhale/maskfs/pkg/logger"
 	"github.com/njhale/maskfs/pkg/mask"
+	"github.com/njhale/maskfs/pkg/overlayfs"
+	"github.com/njhale/maskfs/pkg/s3fs"
+	"go.opentelemetry.io/otel/attribute"
+	"golang.org/x/sync/errgroup"
 )
 
 // Config represents the server configuration
 type Config struct {
-	Port string `usage:"Port to listen on" default:"9888"`
-	Mask string `usage:"Path mask to apply to the server" default:"**/maskfs/\n**/*.go"`
+	Port       string   `usage:"Port to listen on, unless listen addresses are given" default:"9888"`
+	Listen     []string `split:"false" usage:"Address to listen on instead of the port, either host:port or unix:///path/to.sock, can be repeated"`
+	SocketMode string   `usage:"Octal permissions of unix domain sockets listened on" default:"0660"`
+
+	EnablePprof bool   `name:"enable-pprof" usage:"Serve net/http/pprof profiles and expvar variables under /debug/ on the pprof address, separately from the files, never expose it publicly"`
+	PprofAddr   string `name:"pprof-addr" usage:"Address to serve the pprof and expvar endpoints on when they're enabled, either host:port or unix:///path/to.sock" default:"localhost:6060"`
+
+	Root        string `usage:"Directory to serve, tar or zip archive to serve the members of, or S3 bucket and key prefix to serve the objects of as s3://bucket/prefix, request paths are resolved relative to it" default:"/"`
+	URLPrefix   string `name:"url-prefix" usage:"URL path to serve files under, / to serve them at the root in place of the health check" default:"/files"`
+	Mask        string `usage:"Path mask to apply to the server, rules like mtime:<30d only expose files modified within the last 30 days" default:"**/maskfs/\n**/*.go"`
+	MaskFile    string `usage:"Path to a file of mask rules, inline --mask rules are applied after them and take precedence"`
+	MaskURL     string `name:"mask-url" usage:"HTTPS URL of mask rules shared by many servers, or an HTTP URL on a loopback address, the mask file's and inline rules are applied after them and take precedence"`
+	MaskRefresh string `usage:"How often to poll the mask URL for changed rules, using its ETag, 0 to only fetch the rules on start and reload" default:"5m"`
+	MaskType    string `usage:"Syntax of mask rules, glob for .gitignore patterns or regex for RE2 regular expressions matched against paths relative to the root" default:"glob"`
+	MaskMode    string `usage:"How mask rules are used, include to select the files to serve or exclude to select the files to hide like .gitignore" default:"include"`
+	HideJunk    string `usage:"New-line delimited name patterns of junk files to hide, empty to show them" default:"*~\n.DS_Store\nThumbs.db\n#*#"`
+
+	Overlay []string `split:"false" usage:"Additional root layered over the root, which can be a directory, an archive, or an s3:// root, shadowing the entries at the same paths in the root and earlier layers while merging directories, disables writes, can be repeated"`
+
+	MaxArchiveSize int64 `usage:"Maximum size in bytes that the compressed contents of an archive root or overlay are decompressed to, 0 for no limit" default:"10737418240"`
+
+	GitRepo string `usage:"Path of a bare or working git repository to serve a revision of instead of the root, request paths are resolved relative to the top of its tree"`
+	GitRef  string `usage:"Git revision to serve from the git repository, like a branch, a tag, or a commit, resolved on start and reload" default:"HEAD"`
+
+	S3Region              string `name:"s3-region" usage:"Region of the bucket of an s3:// root, empty to use the region of the AWS configuration"`
+	S3Endpoint            string `name:"s3-endpoint" usage:"URL of an S3 compatible object store to serve an s3:// root from instead of AWS, like http://localhost:9000 for MinIO"`
+	S3PathStyle           bool   `name:"s3-path-style" usage:"Address the bucket of an s3:// root in the URL path rather than the host name, which most S3 compatible object stores need"`
+	S3AccessKeyID         string `name:"s3-access-key-id" usage:"Access key ID of static credentials for an s3:// root, instead of the default AWS credential chain"`
+	S3SecretAccessKeyFile string `name:"s3-secret-access-key-file" usage:"Path of a file containing the secret access key of the static credentials for an s3:// root"`
+	S3StatCache           string `name:"s3-stat-cache" usage:"How long the stats of objects listed in a directory of an s3:// root are remembered, 0 to ask the bucket for every stat" default:"1m"`
+	S3Timeout             string `name:"s3-timeout" usage:"How long a request to the bucket of an s3:// root can take, or go without sending any of an object being read, 0 for no limit" default:"30s"`
+
+	NestedMaskFile         string `usage:"Name of per-directory files whose rules are layered on the mask for their directory and below, e.g. .maskfs"`
+	FollowExternalSymlinks bool   `usage:"Follow symlinks whose targets are outside of the root instead of treating them as not found"`
+	Symlinks               string `usage:"How symlinks are handled, serve to follow them, deny to hide them and everything reached through them, or resolve-and-mask to only serve them when their targets are inside the root and unmasked too" default:"serve"`
+	MinFileSize            int64  `usage:"Hide files smaller than this size in bytes, 0 for no limit"`
+	MaxFileSize            int64  `usage:"Hide files larger than this size in bytes, 0 for no limit"`
+	ContentTypes           string `usage:"New-line delimited content type patterns, like text/*, of the only files to serve, sniffed from their first 512 bytes"`
+	HideContentTypes       string `usage:"New-line delimited content type patterns, like application/octet-stream for binaries, of files to hide, sniffed from their first 512 bytes"`
+	OwnedBy                string `usage:"Hide entries not owned by this user or group, given as user, user:group, or :group by name or ID"`
+	RequirePerm            string `usage:"Octal permission bits entries must all have to be served, like 0004 to hide entries that aren't world-readable"`
+	HideEmptyDirs          bool   `usage:"Hide directories without any unmasked files below them, unless a mask rule names them explicitly"`
+	MaskCache              int    `usage:"Maximum number of glob mask decisions to cache in memory, 0 to match every path against the rules every time" default:"100000"`
+	IndexCache             int    `usage:"Maximum number of directories of a root on the host whose listings and entries to cache in memory, watching them for changes with fsnotify, 0 to read directories on every request"`
+	DirSizes               bool   `usage:"Report the total size of the unmasked files below each directory in listings, walking directories as they're listed"`
+	DirSizeCache           string `usage:"How long the total sizes of directories are remembered, 0 to walk directories for every listing" default:"1m"`
+	SniffContentTypes      bool   `usage:"Sniff the content types of files whose extensions don't tell them from their first 512 bytes, to show in listings"`
+	HostInfo               bool   `usage:"Report the owners, inodes, and link counts of entries in JSON listings for auditing, which reveal more about the host than the rest of a listing"`
+
+	ShutdownTimeout string `usage:"Maximum time to wait for listeners to shut down gracefully" default:"5s"`
+	StrictQuery     bool   `usage:"Reject requests with unknown or repeated query parameters"`
+	Checksums       bool   `usage:"Serve SHA256SUMS files for directories requested with ?checksums=1 and digests of files requested with ?hash=sha256"`
+	ChecksumCache   int    `usage:"Maximum number of file checksums to cache in memory, 0 to disable the cache" default:"10000"`
+	MaxChecksumSize int64  `usage:"Maximum size in bytes of files to checksum, 0 for no limit" default:"1073741824"`
+	Archives        bool   `usage:"Serve archives of the unmasked files below directories requested with ?archive=tar.gz or ?archive=zip"`
+	Search          bool   `usage:"Serve searches of the names and contents of unmasked files at /search"`
+	Manifests       bool   `usage:"Serve JSON manifests of the unmasked files below directories requested with /manifest?path=, with their digests too if requested with &hash=sha256 and checksums are enabled"`
+	StatAPI         bool   `name:"stat-api" usage:"Serve the entries of unmasked paths POSTed as a JSON array to /api/stat, so that clients can stat many paths in one request"`
+	MaxSearchSize   int64  `usage:"Maximum size in bytes of files whose contents are searched, 0 for no limit" default:"16777216"`
+	MaxWalkEntries  int    `usage:"Maximum number of entries a single listing or checksum request may walk, 0 for no limit"`
+	MaxDepth        int    `usage:"Maximum depth of recursive listings requested with ?recursive=true, 0 for no limit" default:"16"`
+	StatConcurrency int    `usage:"Maximum number of entries to stat at once when listing a directory, which speeds up listing large directories on network filesystems" default:"1"`
+	StreamListings  bool   `usage:"Render listings of directories as their entries are read, in the order the directory lists them, so that huge directories don't have to fit in memory, unless sorting, pagination, or other options need every entry at once"`
+	RequestTimeout  string `usage:"Maximum time a single listing or checksum request may take, 0 for no limit" default:"0"`
+
+	WriteMask     string `usage:"New-line delimited rules selecting the paths that can be written with PUT or POST, in addition to being unmasked, empty to disable writes"`
+	MaxUploadSize int64  `usage:"Maximum size in bytes of uploaded files, 0 for no limit" default:"104857600"`
+	TrashDir      string `usage:"Directory below the root to move files deleted with DELETE into, which is never served, empty to disable deletes"`
+
+	Mount       []string `split:"false" usage:"Additional file server to mount as prefix=root or prefix=root=mask, sharing the other settings, can be repeated"`
+	VirtualHost []string `split:"false" usage:"Serve another root for requests to a host as host=root or host=root=mask, sharing the other settings, can be repeated"`
+
+	WebDAVPrefix string `name:"webdav-prefix" usage:"Also serve the masked files read-only over WebDAV under this URL prefix, e.g. /dav, empty to disable"`
+
+	ListingTemplate string `usage:"Path to a Go html/template file to render HTML listings with instead of the built-in template"`
+	RenderMarkdown  bool   `usage:"Render Markdown files requested with ?render=html as sanitized HTML pages styled like the listings"`
+	Thumbnails      bool   `usage:"Serve thumbnails of JPEG, PNG, GIF, and WebP images requested with ?thumb= and the maximum width and height in pixels, up to 1024"`
+	ThumbnailCache  int64  `usage:"Maximum total size in bytes of thumbnails to cache in memory, 0 to disable the cache" default:"67108864"`
+
+	DownloadExtensions []string `usage:"Extensions of files, like .exe or .svg, to always serve as downloads instead of letting browsers render them, which any file can be with ?download=1"`
+
+	AutoRefreshSeconds int  `usage:"Reload HTML directory listings in the browser every given number of seconds, 0 to disable"`
+	HumanizeListings   bool `usage:"Show sizes like 1.4 MiB and modification times like 3 hours ago in HTML listings, instead of byte counts and RFC 3339 times"`
+
+	CanonicalHost string `usage:"Redirect requests for any other host to this host, with or without a port, except for the health check"`
+
+	Compression        bool   `usage:"Compress responses of compressible content types, like listings, JSON, and text files, with zstd or gzip for clients that accept them"`
+	CompressionMinSize int64  `usage:"Minimum size in bytes of responses to compress" default:"1024"`
+	CompressionExclude string `usage:"New-line delimited content type patterns, like text/csv, of responses never to compress, besides the already compressed formats like images, video, and archives that never are"`
+
+	Header []string `split:"false" usage:"Response header to set as [glob=]Name: value, on requests whose URL paths match the glob, like /files/static/**=Cache-Control: max-age=300, or on every request without one, can be repeated"`
+
+	AccessLog       string `usage:"Write an access log of every request to this file, or to stdout if -, empty to disable, the file is reopened on reload so that it can be rotated"`
+	AccessLogFormat string `usage:"Format of access log lines, common or combined, followed by the request duration in microseconds" default:"common"`
+
+	OTLPEndpoint string `name:"otlp-endpoint" usage:"URL of an OTLP collector to export OpenTelemetry traces of requests to, over HTTP for http:// and https:// URLs like http://localhost:4318, or over gRPC for grpc:// and grpcs:// URLs like grpc://localhost:4317, configured further by the standard OTEL_* environment variables, empty to disable"`
+
+	AuditLog string `usage:"Write a JSON line for every request that resolves to a masked entry, with the client, path, and mask rule that masked it, to this file, or to stdout if -, empty to disable"`
+
+	TLSCert       string `name:"tls-cert" usage:"Path to a PEM encoded TLS certificate, serves HTTPS when set along with --tls-key, reread on reload"`
+	TLSKey        string `name:"tls-key" usage:"Path to the PEM encoded private key of the TLS certificate"`
+	TLSMinVersion string `name:"tls-min-version" usage:"Minimum TLS version to accept, one of 1.0, 1.1, 1.2, or 1.3" default:"1.2"`
+
+	TLSClientCA      string   `name:"tls-client-ca" usage:"Path to a PEM encoded bundle of CA certificates to require client certificates signed by, recording their names as the users in access logs, requires --tls-cert and --tls-key"`
+	TLSClientProfile []string `name:"tls-client-profile" split:"false" usage:"Client certificate name, its subject common name or a DNS, email, or URI subject alternative name, bound to a mask profile as name=profile, can be repeated"`
+
+	AuthToken     string `usage:"Require this bearer token on file requests, empty to allow anonymous access"`
+	AuthTokenFile string `usage:"Path to a file containing the bearer token to require on file requests, instead of --auth-token"`
+	Htpasswd      string `usage:"Path to an htpasswd file of bcrypt or apr1 hashed passwords to require HTTP Basic authentication against on file requests"`
+
+	MaskProfile   []string `split:"false" usage:"Named mask profile as name=mask-file, whose rules replace the mask's path rules for the clients bound to it, can be repeated"`
+	TokenProfiles string   `usage:"Path to a file binding bearer tokens to mask profiles, with a profile name and a token on each line, the tokens are accepted along with the auth token"`
+	UserProfile   []string `split:"false" usage:"htpasswd user bound to a mask profile as user=profile, can be repeated"`
+
+	OIDCIssuer       string   `name:"oidc-issuer" usage:"HTTPS issuer URL, or HTTP on a loopback address, of an OpenID Connect provider whose JWTs to require as bearer tokens on file requests, instead of an auth token or an htpasswd file"`
+	OIDCClientID     string   `name:"oidc-client-id" usage:"Client ID that the OIDC provider issues ID tokens for, which tokens are accepted for along with the OIDC audience"`
+	OIDCAudience     string   `name:"oidc-audience" usage:"Audience that the OIDC provider issues access tokens for, which tokens are accepted for along with the OIDC client ID"`
+	OIDCClaimProfile []string `name:"oidc-claim-profile" split:"false" usage:"OIDC token claim value bound to a mask profile as claim=value=profile, like groups=admins=admin or sub=1234=alice, matching array claims containing the value too, the first matching one applies, can be repeated"`
+
+	SigningKey     string `usage:"Key of the URLs signed with maskfs sign, which grant GET and HEAD requests of files until they expire without authentication, requires an auth token, an htpasswd file, an OIDC issuer, or a TLS client CA"`
+	SigningKeyFile string `usage:"Path to a file containing the key of signed URLs, instead of --signing-key"`
+
+	AllowCIDR      []string `name:"allow-cidr" usage:"CIDR or IP address of clients to allow, denying every other client, can be repeated"`
+	DenyCIDR       []string `name:"deny-cidr" usage:"CIDR or IP address of clients to deny, even if they're allowed, can be repeated"`
+	TrustedProxies []string `usage:"CIDR or IP address of proxies whose X-Forwarded-For headers are trusted to name the clients the allow and deny lists and the audit log see, and whose X-Forwarded-Proto headers are trusted to name the scheme clients used, can be repeated"`
+
+	WatchMaskFile bool `usage:"Reload the mask when the mask file changes, the whole configuration is always reloaded on SIGHUP"`
+	AdminReload   bool `usage:"Reload the whole configuration on authenticated POST /admin/reload requests, requires an auth token, an htpasswd file, an OIDC issuer, or a TLS client CA"`
+	ExplainMasks  bool `usage:"Explain which mask rule unmasked a file on authenticated ?explain=1 requests by clients not bound to a mask profile, masked files are still not found, requires an auth token, an htpasswd file, an OIDC issuer, or a TLS client CA"`
 }
 
 // Server represents a secure HTTP file server with glob-based filtering
 type Server struct {
-	mask   index.Mask
-	logger logger.Logger
+	root            string // Absolute path of the served directory on the host, empty when serving another filesystem
+	prefix          string // URL path the server is mounted under, which entries link under
+	fsys            fs.FS
+	cfg             Config                // The configuration the server was created with, used to reload the mask
+	masks           atomic.Pointer[masks] // Swapped as a whole when the mask is reloaded, see ReloadMask
+	remote          *remoteRules          // Nil without a mask URL
+	maskRefresh     time.Duration
+	fixedMask       bool // Set when the mask was given with WithMask rather than loaded from the configuration
+	hideEmptyDirs   bool
+	logger          logger.Logger
+	shutdownTimeout time.Duration
+	strictQuery     bool
+	checksums       bool
+	maxChecksumSize int64
+	checksumCache   *checksumCache
+	dirSizes        bool
+	dirSizeCache    *dirSizeCache
+	sniffTypes      bool
+	archives        bool
+	search          bool
+	statAPI         bool
+	manifests       bool
+	maxSearchSize   int64
+	maxWalkEntries  int
+	maxDepth        int
+	streamListings  bool
+	statConcurrency int
+	requestTimeout  time.Duration
+	metadata        index.MetadataProvider
+	refreshSeconds  int
+	humanize        bool
+	clock           clock.Clock
+	writeRoot       *os.Root // Nil when writes are disabled
+	maxUploadSize   int64
+	trashDir        string            // Relative to the root, empty when deletes are disabled
+	tlsConfig       *tls.Config       // Nil when serving plain HTTP
+	clientCerts     bool              // Whether clients must present certificates signed by the TLS client CAs
+	clientProfiles  map[string]string // The mask profiles of client certificate names bound to one
+	tokens          map[string]string // Accepted bearer tokens mapped to their mask profiles, nil when bearer authentication is disabled
+	users           htpasswd          // Nil when basic authentication is disabled
+	userProfiles    map[string]string // The mask profiles of htpasswd users bound to one
+	oidc            *oidcAuth         // Nil when OIDC authentication is disabled
+	signingKey      []byte            // Nil unless signed URLs are accepted
+	template        *template.Template
+	renderMarkdown  bool
+	thumbnails      bool
+	downloadExts    map[string]bool // Lower-cased extensions of files always served as downloads
+	compressExclude []string        // Patterns of content types of responses never to compress
+	headerRules     []headerRule
+	ipFilter        *ipFilter // Nil unless clients are filtered by IP address or proxies are trusted
+	auditLog        *auditLog // Nil unless requests of masked entries are audited
+	thumbnailCache  *thumbnailCache
+	explain         bool    // Whether ?explain=1 requests are answered
+	hostInfo        bool    // Whether JSON listings report the owners, inodes, and link counts of entries
+	closers         closers // What the server opened to serve, like archives, released on Close
+}
+
+// closers are closed together, in the reverse of the order they were opened in.
+type closers []io.Closer
+
+func (c closers) Close() error {
+	var errs []error
+	for i := len(c) - 1; i >= 0; i-- {
+		errs = append(errs, c[i].Close())
+	}
+	return errors.Join(errs...)
+}
+
+// Close releases what the server opened to serve its root, like archives, after which it can't serve them anymore.
+// Filesystems given with WithFS are left to the caller to close.
+func (s *Server) Close() error {
+	return s.closers.Close()
+}
+
+// New creates a file server configured by the given options. Without WithFS, it serves the configured root, and
+// without WithMask, it applies the configured masks.
+func New(opts ...Option) (_ *Server, err error) {
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	cfg := o.cfg
+	if o.prefix != "" {
+		cfg.URLPrefix = o.prefix
+	}
+
+	var (
+		root   string
+		fsys   = o.fsys
+		opened closers
+	)
+	defer func() {
+		if err != nil {
+			_ = opened.Close()
+		}
+	}()
+	if fsys == nil {
+		if root, fsys, err = openRoot(cfg, &opened); err != nil {
+			return nil, err
+		}
+	}
+
+	server, err := newServer(cfg, root, fsys)
+	if err != nil {
+		return nil, err
+	}
+	// The write root is opened after the root, so it's closed before it
+	server.closers = append(opened, server.closers...)
+	if o.logger != nil {
+		server.logger = o.logger
+	}
+	if o.clock != nil {
+		server.clock = o.clock
+	}
+
+	if cfg.MaskURL != "" {
+		urls, refresh := o.maskURLs, server.maskRefresh
+		if urls == nil {
+			// Without a configuration loaded around it, the server's mask URL is fetched once and isn't polled
+			urls, refresh = newMaskURLs(context.Background()), 0
+		}
+		if server.remote, err = urls.get(cfg.MaskURL, refresh); err != nil {
+			return nil, err
+		}
+	}
+
+	if !o.fixedMask {
+		masks, err := loadMasks(cfg, fsys, serverClock{server}, server.remote)
+		if err != nil {
+			return nil, err
+		}
+		server.masks.Store(masks)
+		if server.remote != nil {
+			server.remote.subscribe(server)
+		}
+		return server, nil
+	}
+
+	m := o.mask
+	if m == nil {
+		m = mask.All()
+	}
+	fixed := &masks{all: m, path: asPathMask(m), version: newMaskVersion(), loaded: server.clock.Now()}
+	if cfg.WriteMask != "" {
+		if fixed.write, err = mask.NewGlobMask(cfg.WriteMask); err != nil {
+			return nil, fmt.Errorf("failed to parse write mask: %w", err)
+		}
+	}
+	server.masks.Store(fixed)
+	server.fixedMask = true
+
+	return server, nil
+}
+
+// NewWithFS creates a server of the given filesystem instead of a directory on the host, like an embed.FS, an
+// fstest.MapFS, or a remote backend, applying the given mask to it. A nil mask masks nothing.
+// It's short for New(WithFS(fsys), WithMask(m)), so the server has the settings of a zero Config otherwise. Like any
+// server, it links to entries under index.DefaultLinkPrefix, so it's meant to be mounted there, with or without
+// http.StripPrefix.
+func NewWithFS(fsys fs.FS, m index.Mask) (*Server, error) {
+	if fsys == nil {
+		return nil, errors.New("a filesystem is required")
+	}
+	return New(WithFS(fsys), WithMask(m))
+}
+
+// openS3Root opens the objects of a bucket below a key prefix. They aren't on the host, so the returned root is empty.
+func openS3Root(cfg Config, bucket, prefix string) (string, fs.FS, error) {
+	if cfg.FollowExternalSymlinks {
+		return "", nil, errors.New("buckets have no symlinks to follow outside of the root")
+	}
+
+	statCache, err := parseDuration(cfg.S3StatCache)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to parse s3 stat cache duration: %w", err)
+	}
+	timeout, err := parseDuration(cfg.S3Timeout)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to parse s3 timeout: %w", err)
+	}
+
+	var secretAccessKey string
+	if cfg.S3SecretAccessKeyFile != "" {
+		data, err := os.ReadFile(cfg.S3SecretAccessKeyFile)
+		if err != nil {
+			return "", nil, fmt.Errorf("failed to read s3 secret access key file: %w", err)
+		}
+		if secretAccessKey = strings.TrimSpace(string(data)); secretAccessKey == "" {
+			return "", nil, errors.New("s3 secret access key file is empty")
+		}
+	}
+
+	fsys, err := s3fs.New(context.Background(), s3fs.Config{
+		Bucket:          bucket,
+		Prefix:          prefix,
+		Region:          cfg.S3Region,
+		Endpoint:        cfg.S3Endpoint,
+		PathStyle:       cfg.S3PathStyle,
+		AccessKeyID:     cfg.S3AccessKeyID,
+		SecretAccessKey: secretAccessKey,
+		StatCacheTTL:    statCache,
+		Timeout:         timeout,
+	})
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to open s3 root: %w", err)
+	}
+	return "", fsys, nil
+}
+
+// openOverlayRoot opens the root and each overlay layer, and layers them in order. The layers are merged into a tree
+// that isn't on the host, so the returned root is empty.
+func openOverlayRoot(cfg Config, opened *closers) (string, fs.FS, error) {
+	base := cfg
+	base.Overlay = nil
+	_, fsys, err := openRoot(base, opened)
+	if err != nil {
+		return "", nil, err
+	}
+
+	layers := []fs.FS{fsys}
+	for _, layer := range cfg.Overlay {
+		layerCfg := base
+		layerCfg.Root, layerCfg.GitRepo = layer, ""
+		_, fsys, err := openRoot(layerCfg, opened)
+		if err != nil {
+			return "", nil, fmt.Errorf("failed to open overlay %q: %w", layer, err)
+		}
+		layers = append(layers, fsys)
+	}
+
+	return "", overlayfs.New(layers...), nil
 }
 
-// New creates a new FileServer instance
-func New(cfg Config) (*Server, error) {
-	pathMask, err := mask.NewGlobMask(cfg.Mask)
+// openGitRoot opens the tree of the configured git revision, adding the repository to what's opened. It isn't on the
+// host, so the returned root is empty.
+func openGitRoot(cfg Config, opened *closers) (string, fs.FS, error) {
+	if cfg.FollowExternalSymlinks {
+		return "", nil, errors.New("git trees have no symlinks to follow outside of the root")
+	}
+
+	fsys, err := gitfs.Open(cfg.GitRepo, cfg.GitRef)
 	if err != nil {
-		return nil, fmt.Errorf("failed to parse path mask: %w", err)
+		return "", nil, err
+	}
+	*opened = append(*opened, fsys)
+	return "", fsys, nil
+}
+
+// openArchiveRoot opens the members of an archive, adding the archive to what's opened. They aren't on the host, so
+// the returned root is empty.
+func openArchiveRoot(cfg Config, name string, opened *closers) (string, fs.FS, error) {
+	if cfg.FollowExternalSymlinks {
+		return "", nil, errors.New("archives have no symlinks to follow outside of the root")
 	}
 
-	return &Server{
-		mask:   pathMask,
-		logger: logger.New("server"),
-	}, nil
+	fsys, err := archivefs.Open(name, cfg.MaxArchiveSize)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to open archive root: %w", err)
+	}
+	*opened = append(*opened, fsys)
+	return "", fsys, nil
+}
+
+// openRoot resolves the configured root and opens it, confining symlinks to it unless external symlinks are followed.
+// Whatever has to be closed once the root is no longer served is added to opened, even if opening the root fails.
+func openRoot(cfg Config, opened *closers) (string, fs.FS, error) {
+	if len(cfg.Overlay) > 0 {
+		return openOverlayRoot(cfg, opened)
+	}
+	if cfg.GitRepo != "" {
+		return openGitRoot(cfg, opened)
+	}
+	if bucket, prefix, ok := s3fs.ParseURL(cfg.Root); ok {
+		return openS3Root(cfg, bucket, prefix)
+	}
+
+	root := cfg.Root
+	if root == "" {
+		root = "/"
+	}
+	root, err := filepath.Abs(root)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to resolve root: %w", err)
+	}
+
+	if archivefs.IsArchive(root) {
+		if info, err := os.Stat(root); err == nil && info.Mode().IsRegular() {
+			return openArchiveRoot(cfg, root, opened)
+		}
+	}
+
+	if cfg.FollowExternalSymlinks {
+		if cfg.Symlinks != "" && cfg.Symlinks != "serve" {
+			return "", nil, fmt.Errorf("symlinks outside of the root can only be followed with the serve symlink policy, not %s", cfg.Symlinks)
+		}
+		if _, err := os.Stat(root); err != nil {
+			return "", nil, fmt.Errorf("failed to stat root: %w", err)
+		}
+		return root, os.DirFS(root), nil
+	}
+
+	// Confine symlinks to the root, so that following one outside of it fails as if it didn't exist
+	confined, err := os.OpenRoot(root)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to open root: %w", err)
+	}
+	*opened = append(*opened, confined)
+	return root, confined.FS(), nil
+}
+
+// newServer creates a server of the given filesystem, which is the root on the host unless root is empty, without its
+// masks, which are left to New.
+func newServer(cfg Config, root string, fsys fs.FS) (*Server, error) {
+	shutdownTimeout, err := parseDuration(cfg.ShutdownTimeout)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse shutdown timeout: %w", err)
+	}
+
+	requestTimeout, err := parseDuration(cfg.RequestTimeout)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse request timeout: %w", err)
+	}
+
+	maskRefresh, err := parseDuration(cfg.MaskRefresh)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse mask refresh interval: %w", err)
+	}
+
+	compressExclude, err := parseCompressExclude(cfg.CompressionExclude)
+	if err != nil {
+		return nil, err
+	}
+
+	ipFilter, err := newIPFilter(cfg.AllowCIDR, cfg.DenyCIDR, cfg.TrustedProxies)
+	if err != nil {
+		return nil, err
+	}
+
+	var headerRules []headerRule
+	for _, spec := range cfg.Header {
+		rule, err := parseHeaderRule(spec)
+		if err != nil {
+			return nil, err
+		}
+		headerRules = append(headerRules, rule)
+	}
+
+	downloadExts := map[string]bool{}
+	for _, ext := range cfg.DownloadExtensions {
+		if ext = strings.ToLower(strings.TrimSpace(ext)); ext == "" {
+			continue
+		}
+		if !strings.HasPrefix(ext, ".") {
+			ext = "." + ext
+		}
+		downloadExts[ext] = true
+	}
+
+	dirSizeCache, err := parseDuration(cfg.DirSizeCache)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse directory size cache duration: %w", err)
+	}
+
+	var tlsConfig *tls.Config
+	switch {
+	case cfg.TLSCert != "" && cfg.TLSKey != "":
+		// Load the key pair up front so that a bad certificate fails fast instead of when the listener starts
+		cert, err := tls.LoadX509KeyPair(cfg.TLSCert, cfg.TLSKey)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load TLS key pair: %w", err)
+		}
+		minVersion, err := parseTLSVersion(cfg.TLSMinVersion)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig = &tls.Config{
+			MinVersion:   minVersion,
+			Certificates: []tls.Certificate{cert},
+			// Connections are handed the configuration of the current generation, which has to offer HTTP/2 itself
+			NextProtos: []string{"h2", "http/1.1"},
+		}
+	case cfg.TLSCert != "":
+		return nil, errors.New("a TLS certificate was given without a TLS key")
+	case cfg.TLSKey != "":
+		return nil, errors.New("a TLS key was given without a TLS certificate")
+	}
+	if cfg.TLSClientCA != "" {
+		if tlsConfig == nil {
+			return nil, errors.New("a TLS client CA was given without a TLS certificate and key")
+		}
+		if tlsConfig.ClientCAs, err = loadClientCAs(cfg.TLSClientCA); err != nil {
+			return nil, err
+		}
+		tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+
+	authToken := cfg.AuthToken
+	if cfg.AuthTokenFile != "" {
+		if authToken != "" {
+			return nil, errors.New("only one of an auth token and an auth token file can be given")
+		}
+
+		data, err := os.ReadFile(cfg.AuthTokenFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read auth token file: %w", err)
+		}
+		if authToken = strings.TrimSpace(string(data)); authToken == "" {
+			return nil, errors.New("auth token file is empty")
+		}
+	}
+
+	signingKey, err := LoadSigningKey(cfg.SigningKey, cfg.SigningKeyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	urlPrefix, err := parseURLPrefix(cfg.URLPrefix)
+	if err != nil {
+		return nil, err
+	}
+
+	profiles, err := parseMaskProfiles(cfg.MaskProfile)
+	if err != nil {
+		return nil, err
+	}
+
+	var tokens map[string]string
+	if cfg.TokenProfiles != "" {
+		if tokens, err = loadTokenProfiles(cfg.TokenProfiles, profiles); err != nil {
+			return nil, err
+		}
+	}
+	if authToken != "" {
+		if _, ok := tokens[authToken]; ok {
+			return nil, errors.New("the auth token is also bound to a mask profile")
+		}
+		if tokens == nil {
+			tokens = map[string]string{}
+		}
+		tokens[authToken] = ""
+	}
+
+	var (
+		users        htpasswd
+		userProfiles map[string]string
+	)
+	if cfg.Htpasswd != "" {
+		if tokens != nil {
+			return nil, errors.New("only one of an auth token and an htpasswd file can be given")
+		}
+		if users, err = loadHtpasswd(cfg.Htpasswd); err != nil {
+			return nil, err
+		}
+	}
+
+	var clientProfiles map[string]string
+	if len(cfg.TLSClientProfile) > 0 {
+		if cfg.TLSClientCA == "" {
+			return nil, errors.New("TLS clients were bound to mask profiles without a TLS client CA")
+		}
+		if clientProfiles, err = parseClientProfiles(cfg.TLSClientProfile, profiles); err != nil {
+			return nil, err
+		}
+	}
+
+	openID, err := newOIDCAuth(cfg, profiles)
+	if err != nil {
+		return nil, err
+	}
+	if openID != nil && (tokens != nil || users != nil) {
+		return nil, errors.New("only one of an auth token, an htpasswd file, and an OIDC issuer can be given")
+	}
+
+	if len(cfg.UserProfile) > 0 {
+		if users == nil {
+			return nil, errors.New("users were bound to mask profiles without an htpasswd file")
+		}
+		if userProfiles, err = parseUserProfiles(cfg.UserProfile, profiles, users); err != nil {
+			return nil, err
+		}
+	}
+
+	var tmpl *template.Template
+	if cfg.ListingTemplate != "" {
+		data, err := os.ReadFile(cfg.ListingTemplate)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read listing template: %w", err)
+		}
+		if tmpl, err = index.ParseTemplate(string(data)); err != nil {
+			return nil, err
+		}
+	}
+
+	trashDir, err := parseTrashDir(cfg.TrashDir)
+	if err != nil {
+		return nil, err
+	}
+	if trashDir != "" && cfg.WriteMask == "" {
+		return nil, errors.New("a trash directory was given without a write mask")
+	}
+
+	var audit *auditLog
+	if cfg.AuditLog != "" {
+		if audit, err = openAuditLog(cfg.AuditLog); err != nil {
+			return nil, err
+		}
+	}
+
+	var writeRoot *os.Root
+	if cfg.WriteMask != "" {
+		if root == "" {
+			return nil, errors.New("writes are only supported when serving a directory on the host")
+		}
+		// Always confine writes to the root, even when reads follow symlinks out of it
+		if writeRoot, err = os.OpenRoot(root); err != nil {
+			return nil, fmt.Errorf("failed to open root for writing: %w", err)
+		}
+	}
+
+	server := &Server{
+		root:            root,
+		prefix:          urlPrefix,
+		fsys:            fsys,
+		cfg:             cfg,
+		hideEmptyDirs:   cfg.HideEmptyDirs,
+		logger:          logger.New("server"),
+		shutdownTimeout: shutdownTimeout,
+		strictQuery:     cfg.StrictQuery,
+		checksums:       cfg.Checksums,
+		maxChecksumSize: cfg.MaxChecksumSize,
+		checksumCache:   newChecksumCache(cfg.ChecksumCache),
+		dirSizes:        cfg.DirSizes,
+		dirSizeCache:    newDirSizeCache(dirSizeCache),
+		sniffTypes:      cfg.SniffContentTypes,
+		archives:        cfg.Archives,
+		search:          cfg.Search,
+		statAPI:         cfg.StatAPI,
+		manifests:       cfg.Manifests,
+		maxSearchSize:   cfg.MaxSearchSize,
+		writeRoot:       writeRoot,
+		maxUploadSize:   cfg.MaxUploadSize,
+		trashDir:        trashDir,
+		maxWalkEntries:  cfg.MaxWalkEntries,
+		maxDepth:        cfg.MaxDepth,
+		streamListings:  cfg.StreamListings,
+		statConcurrency: cfg.StatConcurrency,
+		requestTimeout:  requestTimeout,
+		refreshSeconds:  cfg.AutoRefreshSeconds,
+		humanize:        cfg.HumanizeListings,
+		clock:           clock.Real,
+		compressExclude: compressExclude,
+		headerRules:     headerRules,
+		ipFilter:        ipFilter,
+		auditLog:        audit,
+		tlsConfig:       tlsConfig,
+		clientCerts:     cfg.TLSClientCA != "",
+		clientProfiles:  clientProfiles,
+		tokens:          tokens,
+		users:           users,
+		userProfiles:    userProfiles,
+		oidc:            openID,
+		signingKey:      signingKey,
+		template:        tmpl,
+		renderMarkdown:  cfg.RenderMarkdown,
+		thumbnails:      cfg.Thumbnails,
+		downloadExts:    downloadExts,
+		thumbnailCache:  newThumbnailCache(cfg.ThumbnailCache),
+		explain:         cfg.ExplainMasks,
+		hostInfo:        cfg.HostInfo,
+		maskRefresh:     maskRefresh,
+	}
+	if writeRoot != nil {
+		server.closers = closers{writeRoot}
+	}
+
+	return server, nil
+}
+
+// parseDuration parses a duration, treating an empty one as zero so that configurations built in code can leave it out.
+func parseDuration(d string) (time.Duration, error) {
+	if d == "" {
+		return 0, nil
+	}
+	return time.ParseDuration(d)
+}
+
+// parseTLSVersion returns the TLS version with the given number, defaulting to TLS 1.2.
+func parseTLSVersion(version string) (uint16, error) {
+	switch version {
+	case "1.0":
+		return tls.VersionTLS10, nil
+	case "1.1":
+		return tls.VersionTLS11, nil
+	case "", "1.2":
+		return tls.VersionTLS12, nil
+	case "1.3":
+		return tls.VersionTLS13, nil
+	default:
+		return 0, fmt.Errorf("unsupported minimum TLS version %q", version)
+	}
+}
+
+// authenticates returns true if requests are required to authenticate.
+func (s *Server) authenticates() bool {
+	return s.tokens != nil || s.users != nil || s.oidc != nil || s.clientCerts
+}
+
+// SetMetadataProvider sets the provider of extra metadata attached to entries in directory listings.
+// A nil provider, the default, attaches no metadata.
+func (s *Server) SetMetadataProvider(provider index.MetadataProvider) {
+	s.metadata = provider
+}
+
+// SetTemplate sets the template used to render HTML directory listings, which is executed with an index.Listing.
+// Templates parsed with index.ParseTemplate have its helper functions available and are checked for errors.
+// A nil template, the default unless a listing template file is configured, uses the built-in template.
+func (s *Server) SetTemplate(tmpl *template.Template) {
+	s.template = tmpl
+}
+
+// FS returns the served directory with the server's current mask applied, so that masked entries don't exist in it.
+// Later reloads of the mask don't affect the returned filesystem.
+func (s *Server) FS() fs.FS {
+	return mask.FS(s.fsys, s.masks.Load().all)
+}
+
+// serverClock tells the time by the server's clock, so that masks built before the clock is set use it.
+type serverClock struct {
+	s *Server
+}
+
+func (c serverClock) Now() time.Time {
+	return c.s.clock.Now()
+}
+
+// SetClock sets the clock used by time-dependent features, which defaults to the system's wall clock.
+func (s *Server) SetClock(c clock.Clock) {
+	s.clock = c
+}
+
+// Handler returns the handler Run serves, which routes requests to the files under the URL prefix, to the mounts, and
+// to every other endpoint the configuration enables, behind authentication when it's configured. It lets applications
+// mount maskfs in their own routers and serve it with their own servers, which is why the listener, TLS, access log,
+// and OTLP settings are ignored, though spans are still started with the global OpenTelemetry tracer provider. Mask
+// file watchers and mask URL polling run until the context is canceled, when the handler closes the roots and
+// everything else it opened once the requests being handled are done, and fails later requests. The configuration is
+// reloaded on POST /admin/reload when enabled, but not on SIGHUP.
+func Handler(ctx context.Context, cfg Config) (http.Handler, error) {
+	h, err := newReloadingHandler(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
+	context.AfterFunc(ctx, func() { _ = h.Close() })
+	return requestIDs(h), nil
 }
 
 // Run starts the file server
 func Run(ctx context.Context, cfg Config) error {
-	server, err := New(cfg)
+	reloader, err := newReloadingHandler(ctx, cfg)
+	if err != nil {
+		return err
+	}
+	// Deferred first so that it runs last, once the listeners are shut down
+	defer reloader.Close()
+	reloader.reloadOnSignal(ctx)
+
+	// Listeners and the access log aren't part of what's reloaded, so they're set up from the first configuration
+	server := reloader.current.Load().server
+	server.logger.Debugf("Server created with root %q and mask: %#v", server.root, server.masks.Load().all)
+
+	var handler http.Handler = reloader
+	if cfg.OTLPEndpoint != "" {
+		shutdown, err := setupTracing(ctx, cfg.OTLPEndpoint)
+		if err != nil {
+			return err
+		}
+		defer func() {
+			// Flush the spans of the last requests, which ctx is already canceled for
+			shutdownCtx, cancel := context.WithTimeout(context.Background(), server.shutdownTimeout)
+			defer cancel()
+			if err := shutdown(shutdownCtx); err != nil {
+				server.logger.Errorf("Failed to flush traces: %v", err)
+			}
+		}()
+
+		handler = traceRequests(handler)
+	}
+	if cfg.AccessLog != "" {
+		var combined bool
+		switch cfg.AccessLogFormat {
+		case "", "common":
+		case "combined":
+			combined = true
+		default:
+			return fmt.Errorf("unsupported access log format %q, must be common or combined", cfg.AccessLogFormat)
+		}
+
+		var out io.Writer = os.Stdout
+		if cfg.AccessLog != "-" {
+			f, err := openLogFile(cfg.AccessLog)
+			if err != nil {
+				return fmt.Errorf("failed to open access log: %w", err)
+			}
+			defer f.Close()
+			reloader.reopenOnReload(f)
+			out = f
+		}
+
+		// Log outermost so that requests rejected by other middleware are logged too
+		handler = accessLog(out, combined, server.clock)(handler)
+	}
+	// Identify requests before anything else, so that every log line of a request, including its access log line, and
+	// even those of rejected requests, can be correlated
+	handler = requestIDs(handler)
+
+	addrs := cfg.Listen
+	if len(addrs) == 0 {
+		addrs = []string{":" + cfg.Port}
+	}
+	socketMode, err := parseSocketMode(cfg.SocketMode)
 	if err != nil {
 		return err
 	}
-	server.logger.Debugf("Server created with mask: %#v", server.mask)
+
+	// Open every listener before serving on any, so that a bad address fails fast
+	var (
+		listeners   []net.Listener
+		httpServers []*http.Server
+	)
+	for _, addr := range addrs {
+		l, err := listen(addr, socketMode)
+		if err != nil {
+			for _, opened := range listeners {
+				_ = opened.Close()
+			}
+			return fmt.Errorf("failed to listen on %q: %w", addr, err)
+		}
+
+		listeners = append(listeners, l)
+		httpServers = append(httpServers, &http.Server{
+			Addr:      addr,
+			Handler:   handler,
+			TLSConfig: reloader.tlsConfig(),
+		})
+	}
+
+	if cfg.EnablePprof {
+		l, err := listen(cfg.PprofAddr, socketMode)
+		if err != nil {
+			for _, opened := range listeners {
+				_ = opened.Close()
+			}
+			return fmt.Errorf("failed to listen on pprof address %q: %w", cfg.PprofAddr, err)
+		}
+
+		server.logger.Infof("Serving pprof and expvar endpoints on %s", cfg.PprofAddr)
+		listeners = append(listeners, l)
+		httpServers = append(httpServers, &http.Server{
+			Addr:    cfg.PprofAddr,
+			Handler: debugHandler(),
+		})
+	}
+
+	return server.serve(ctx, listeners, httpServers)
+}
+
+// newGeneration creates the server of the given configuration, along with the servers of its mounts, and the handler
+// routing requests to them. Mask file watchers and mask URL polling run until the context is canceled.
+func newGeneration(ctx context.Context, cfg Config, reloader *reloadingHandler, urls *maskURLs) (_ *generation, err error) {
+	server, err := New(WithConfig(cfg), withMaskURLs(urls))
+	if err != nil {
+		return nil, err
+	}
+	opened := closers{server}
+	defer func() {
+		if err != nil {
+			_ = opened.Close()
+		}
+	}()
+	if err := server.watchMaskFile(ctx, cfg.WatchMaskFile); err != nil {
+		return nil, err
+	}
+	if err := server.cacheIndex(ctx, cfg.IndexCache); err != nil {
+		return nil, err
+	}
 
 	// Set up the default HTTP muxer
 	mux := http.NewServeMux()
 
-	// Register root handler that always returns 200 OK
-	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
-		server.logger.Debugf("Root handler called: %s", r.URL.Path)
-		if r.URL.Path != "/" {
-			http.NotFound(w, r)
-			return
+	// Register root handler that always returns 200 OK, unless files are served at the root instead
+	if server.prefix != "/" {
+		mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+			server.logger.Debugf("Root handler called: %s", r.URL.Path)
+			if r.URL.Path != "/" {
+				http.NotFound(w, r)
+				return
+			}
+			w.WriteHeader(http.StatusOK)
+		})
+	}
+
+	// Discover the OIDC issuer once per generation, so that reloads pick up changes to its configuration
+	var verifier *oidc.IDTokenVerifier
+	if server.oidc != nil {
+		if verifier, err = server.oidc.verifier(ctx); err != nil {
+			return nil, err
 		}
-		w.WriteHeader(http.StatusOK)
-	})
+	}
 
-	// Register the file server under /files/
-	mux.Handle("/files/", http.StripPrefix("/files/", server))
+	// Everything but the root handler is behind authentication when enabled.
+	// The root handler stays public so that liveness checks keep working.
+	protect := func(h http.Handler) http.Handler {
+		switch {
+		case verifier != nil:
+			h = oidcBearerAuth(server.oidc, verifier, server.logger)(h)
+		case server.tokens != nil:
+			h = bearerAuth(server.tokens)(h)
+		case server.users != nil:
+			h = basicAuth(server.users, server.userProfiles)(h)
+		}
+		if server.clientCerts {
+			// Client certificates are required along with any other credentials, whose profiles take precedence
+			h = clientCertAuth(server.clientProfiles)(h)
+		}
+		return h
+	}
 
-	// Create and start the HTTP server
-	httpServer := &http.Server{
-		Addr:    ":" + cfg.Port,
-		Handler: mux,
+	// Files, unlike the other endpoints, can also be requested with signed URLs instead of authenticating
+	protectFiles := protect
+	if server.signingKey != nil {
+		if !server.authenticates() {
+			return nil, errors.New("signed URLs require an auth token, an htpasswd file, an OIDC issuer, or a TLS client CA")
+		}
+		protectFiles = func(h http.Handler) http.Handler {
+			return signedURLs(server.signingKey, serverClock{server}, protect(h))(h)
+		}
 	}
 
-	errCh := make(chan error, 1)
+	// Register the file server under its prefix
+	filesPrefix := strings.TrimSuffix(server.prefix, "/") + "/"
+	mux.Handle(filesPrefix, protectFiles(http.StripPrefix(filesPrefix, server)))
 
-	// Start the server in a goroutine
-	go func() {
-		server.logger.Debugf("Starting server on port: %s", cfg.Port)
-		errCh <- httpServer.ListenAndServe()
-	}()
+	// Register each additional mount under its own prefix, backed by its own server
+	prefixes := map[string]bool{filesPrefix: true}
+	for _, spec := range cfg.Mount {
+		prefix, mountCfg, err := parseMount(cfg, spec)
+		if err != nil {
+			return nil, err
+		}
+		if prefixes[prefix] {
+			return nil, fmt.Errorf("mount prefix %q is already in use", prefix)
+		}
+		prefixes[prefix] = true
 
-	// Wait for context cancellation or server error
-	select {
-	case <-ctx.Done():
-		server.logger.Debugf("Context canceled, shutting down server")
-		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-		defer cancel()
-		return httpServer.Shutdown(shutdownCtx)
-	case err := <-errCh:
-		if err != http.ErrServerClosed {
-			server.logger.Errorf("Server error: %v", err)
-			return err
+		mounted, err := New(WithConfig(mountCfg), withMaskURLs(urls))
+		if err != nil {
+			return nil, fmt.Errorf("failed to create mount %q: %w", prefix, err)
 		}
-		return nil
+		opened = append(opened, mounted)
+		mounted.prefix = strings.TrimSuffix(prefix, "/")
+		if err := mounted.watchMaskFile(ctx, cfg.WatchMaskFile); err != nil {
+			return nil, err
+		}
+		if err := mounted.cacheIndex(ctx, cfg.IndexCache); err != nil {
+			return nil, err
+		}
+
+		server.logger.Debugf("Mounted root %q at %q", mounted.root, prefix)
+		mux.Handle(prefix, protectFiles(http.StripPrefix(prefix, mounted)))
+	}
+
+	if cfg.ExplainMasks && !server.authenticates() {
+		return nil, errors.New("mask explanations require an auth token, an htpasswd file, an OIDC issuer, or a TLS client CA")
+	}
+
+	if cfg.AdminReload {
+		if !server.authenticates() {
+			return nil, errors.New("the reload endpoint requires an auth token, an htpasswd file, an OIDC issuer, or a TLS client CA")
+		}
+		mux.Handle("/admin/reload", protect(http.HandlerFunc(reloader.serveReload)))
+	}
+
+	if server.search {
+		mux.Handle("/search", protect(http.HandlerFunc(server.serveSearch)))
+	}
+
+	if server.manifests {
+		mux.Handle("/manifest", protect(http.HandlerFunc(server.serveManifest)))
+	}
+
+	if server.statAPI {
+		mux.Handle("/api/stat", protect(http.HandlerFunc(server.serveStat)))
+	}
+
+	if cfg.WebDAVPrefix != "" {
+		if err := validateWebDAVPrefix(cfg.WebDAVPrefix, server.prefix); err != nil {
+			return nil, err
+		}
+
+		prefix := strings.TrimSuffix(cfg.WebDAVPrefix, "/")
+		if prefixes[prefix+"/"] {
+			return nil, fmt.Errorf("webdav prefix %q is already in use by a mount", cfg.WebDAVPrefix)
+		}
+		dav := protect(server.webdavHandler(prefix))
+
+		// Register the prefix with and without a trailing slash, since clients don't redirect PROPFIND requests
+		mux.Handle(prefix, dav)
+		mux.Handle(prefix+"/", dav)
+	}
+
+	var handler http.Handler = mux
+	if cfg.Compression {
+		// Compress each generation's own routes, so that the routes of virtual hosts aren't compressed twice
+		handler = compress(cfg.CompressionMinSize, server.compressExclude)(handler)
+	}
+	if len(server.headerRules) > 0 {
+		handler = setHeaders(server.headerRules)(handler)
+	}
+	if len(cfg.VirtualHost) > 0 {
+		if cfg.CanonicalHost != "" {
+			return nil, errors.New("virtual hosts can't be served along with a canonical host, which every other host is redirected to")
+		}
+
+		hosts := map[string]http.Handler{}
+		for _, spec := range cfg.VirtualHost {
+			host, hostCfg, err := parseVirtualHost(cfg, spec)
+			if err != nil {
+				return nil, err
+			}
+			if hosts[host] != nil {
+				return nil, fmt.Errorf("virtual host %q is given more than once", host)
+			}
+
+			gen, err := newGeneration(ctx, hostCfg, reloader, urls)
+			if err != nil {
+				return nil, fmt.Errorf("failed to create virtual host %q: %w", host, err)
+			}
+			opened = append(opened, gen.closers...)
+			server.logger.Debugf("Serving root %q for host %q", gen.server.root, host)
+			hosts[host] = gen.handler
+		}
+		handler = virtualHosts(hosts, handler)
+	}
+	if cfg.CanonicalHost != "" {
+		next, redirect := handler, canonicalHost(cfg.CanonicalHost, server.ipFilter)(handler)
+		handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if server.prefix != "/" && r.URL.Path == "/" {
+				// Liveness checks reach the server by whatever host they know it by, so the health check isn't redirected
+				next.ServeHTTP(w, r)
+				return
+			}
+			redirect.ServeHTTP(w, r)
+		})
+	}
+	if server.auditLog != nil {
+		// Give requests somewhere to record their users for the audit log, unless the access log already has
+		next := handler
+		handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			r, _ = withUserRecorder(r)
+			next.ServeHTTP(w, r)
+		})
+	}
+	if server.ipFilter != nil {
+		// Outermost, so that nothing is done for clients that aren't allowed
+		handler = filterIPs(server.ipFilter)(handler)
+	}
+	return &generation{server: server, handler: handler, closers: opened}, nil
+}
+
+// serve runs the given HTTP servers, each on the listener at the same index, until the context is canceled or any one
+// of them fails. All servers are then shut down concurrently within the shutdown timeout, and every serve and
+// shutdown error is returned combined.
+func (s *Server) serve(ctx context.Context, listeners []net.Listener, httpServers []*http.Server) error {
+	var (
+		eg, egCtx    = errgroup.WithContext(ctx)
+		serveErrs    = make([]error, len(httpServers))
+		shutdownErrs = make([]error, len(httpServers))
+	)
+	for i, httpServer := range httpServers {
+		eg.Go(func() error {
+			s.logger.Debugf("Starting server on: %s", httpServer.Addr)
+
+			var err error
+			if httpServer.TLSConfig != nil {
+				// The certificates are already loaded into the TLS config
+				err = httpServer.ServeTLS(listeners[i], "", "")
+			} else {
+				err = httpServer.Serve(listeners[i])
+			}
+			if !errors.Is(err, http.ErrServerClosed) {
+				s.logger.Errorf("Server error: %v", err)
+				serveErrs[i] = err
+
+				// Returning the error cancels egCtx, triggering the shutdown of the remaining servers
+				return err
+			}
+			return nil
+		})
+	}
+
+	// Wait for context cancellation or server error
+	<-egCtx.Done()
+	s.logger.Debugf("Shutting down %d server(s)", len(httpServers))
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), s.shutdownTimeout)
+	defer cancel()
+
+	var shutdown sync.WaitGroup
+	for i, httpServer := range httpServers {
+		shutdown.Add(1)
+		go func() {
+			defer shutdown.Done()
+			shutdownErrs[i] = httpServer.Shutdown(shutdownCtx)
+		}()
 	}
+	shutdown.Wait()
+
+	// Every server has stopped serving, so the serve goroutines have returned
+	_ = eg.Wait()
+
+	return errors.Join(append(serveErrs, shutdownErrs...)...)
 }
 
-// ServeHTTP handles file requests
+// ServeHTTP handles file requests. Requests routed through http.StripPrefix have paths relative to the root, while
+// requests with absolute paths are expected under the URL prefix the server links entries under, which is stripped
+// from them, so that the server can be mounted at its prefix as it is too.
 func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	s.logger.Debugf("Handling request %s: %s", r.Method, r.URL.Path)
-	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+	if strings.HasPrefix(r.URL.Path, "/") {
+		http.StripPrefix(strings.TrimSuffix(s.prefix, "/")+"/", http.HandlerFunc(s.serveFiles)).ServeHTTP(w, r)
+		return
+	}
+	s.serveFiles(w, r)
+}
+
+// serveFiles handles file requests whose paths are relative to the root.
+func (s *Server) serveFiles(w http.ResponseWriter, r *http.Request) {
+	log := s.requestLogger(r).Fields("method", r.Method, "path", r.URL.Path)
+	log.Debugf("Handling request %s: %s", r.Method, r.URL.Path)
+	var allowed bool
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		allowed = true
+	case http.MethodPut, http.MethodPost:
+		allowed = s.writeRoot != nil
+	case http.MethodDelete:
+		allowed = s.trashDir != ""
+	}
+	if !allowed {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
+	q, err := parseQuery(r.URL.Query(), queryParams, s.strictQuery)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
 	// Clean and normalize the path
 	fsPath, err := url.PathUnescape(r.URL.Path)
 	if err != nil {
 		http.Error(w, "Bad Request", http.StatusBadRequest)
 		return
 	}
-	if fsPath = filepath.Clean(fsPath); fsPath == "." {
-		http.Error(w, "Bad Request", http.StatusBadRequest)
+	// Request paths are always slash-separated and relative to the root, regardless of the host's path separator.
+	// An empty path, or one that cleans to ".", refers to the root itself.
+	// Paths that would climb above the root are not found rather than escaping it.
+	if fsPath = path.Clean(fsPath); !fs.ValidPath(fsPath) {
+		http.NotFound(w, r)
 		return
 	}
 
-	s.logger.Debugf("Serving path: %q", fsPath)
+	log.Debugf("Serving path: %q", fsPath)
+
+	// Use the same mask for the whole request, even if it's reloaded while the request is being handled
+	m := s.masksFor(r)
+
+	switch r.Method {
+	case http.MethodPut, http.MethodPost:
+		s.serveUpload(w, r, fsPath, m)
+		return
+	case http.MethodDelete:
+		s.serveDelete(w, r, fsPath, m)
+		return
+	}
+
+	explain := q.bool("explain")
+	if explain && !s.explain {
+		http.Error(w, "Forbidden: mask explanations are disabled", http.StatusForbidden)
+		return
+	}
+	if explain && profileOf(r) != "" {
+		// Clients bound to a mask profile are restricted, so they don't get to learn how the mask is made up
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
 
 	// Get entry info
-	dirFS := os.DirFS("/")
-	entry, err := index.GetEntry(dirFS, fsPath)
+	_, span := startSpan(r.Context(), "maskfs.stat", attribute.String("maskfs.path", fsPath))
+	entry, err := index.GetEntry(s.fsys, fsPath, s.linked())
+	span.End()
 	if err != nil {
-		s.logger.Errorf("Error getting entry\n", err)
+		log.Errorf("Error getting entry: %v", err)
 		http.NotFound(w, r)
 		return
 	}
 
-	s.logger.Debugf("Got entry from filesystem: %#v", entry)
+	log.Debugf("Got entry from filesystem: %#v", entry)
 
-	if s.mask.Masked(entry) {
+	_, span = startSpan(r.Context(), "maskfs.mask", attribute.String("maskfs.path", entry.FSPath))
+	masked := !entry.IsRoot() && m.all.Masked(entry)
+	span.SetAttributes(attribute.Bool("maskfs.masked", masked))
+	span.End()
+	if masked {
 		// The client-requested entry is masked, return a 404.
-		s.logger.Debugf("Entry %q is masked, returning 404\n\t%q", entry.FSPath, entry.LinkPath)
+		// The root itself is never masked so that its unmasked children can always be listed.
+		log.Debugf("Entry %q is masked, returning 404\n\t%q", entry.FSPath, entry.LinkPath)
+		s.audit(r, m, entry)
 		http.NotFound(w, r)
 		return
 	}
 
+	if explain {
+		// Only unmasked entries are explained, so that explanations can't be used to probe for masked ones
+		if err := writeExplanation(w, m, entry); err != nil {
+			log.Errorf("Failed to write explanation: %v", err)
+		}
+		return
+	}
+
+	if q.has("hash") {
+		if !s.checksums {
+			http.Error(w, "Checksums are disabled", http.StatusBadRequest)
+			return
+		}
+		if entry.IsDir {
+			http.Error(w, "Hashes are only computed for files, use ?checksums=1 for directories", http.StatusBadRequest)
+			return
+		}
+
+		s.writeChecksum(w, r, newBudgetFS(r.Context(), s.clock, s.fsys, s.maxWalkEntries, s.requestTimeout), entry)
+		return
+	}
+
 	if entry.IsDir {
 		// The client-requested entry is an unmasked directory, render a masked index of its immediate children.
-		masked, err := index.GetEntries(dirFS, entry.FSPath, s.mask)
-		if err != nil {
-			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		budget := newBudgetFS(r.Context(), s.clock, s.fsys, s.maxWalkEntries, s.requestTimeout)
+		if q.has("archive") {
+			if !s.archives {
+				http.Error(w, "Archives are disabled", http.StatusBadRequest)
+				return
+			}
+
+			s.serveArchive(w, r, budget, m, entry, q["archive"])
 			return
 		}
 
-		// Sort by name to ensure the entry order in the rendered HTML is consistent.
-		sort.Slice(masked, func(i, j int) bool {
-			return masked[i].Name < masked[j].Name
-		})
+		s.serveIndex(w, r, q, budget, m, entry)
+		return
+	}
 
-		if err := masked.WriteHTML(w, entry, masked); err != nil {
-			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+	if q.has("render") {
+		if !s.renderMarkdown {
+			http.Error(w, "Markdown rendering is disabled", http.StatusBadRequest)
+			return
+		}
+		if !isMarkdown(entry) {
+			http.Error(w, "Only Markdown files can be rendered", http.StatusBadRequest)
+			return
 		}
 
+		s.serveMarkdown(w, r, m, newBudgetFS(r.Context(), s.clock, s.fsys, s.maxWalkEntries, s.requestTimeout), entry)
+		return
+	}
+
+	if q.has("thumb") {
+		if !s.thumbnails {
+			http.Error(w, "Thumbnails are disabled", http.StatusBadRequest)
+			return
+		}
+
+		s.serveThumbnail(w, r, m, newBudgetFS(r.Context(), s.clock, s.fsys, s.maxWalkEntries, s.requestTimeout), entry, q.int("thumb"))
 		return
 	}
 
 	// The entry is an unmasked file, serve its contents using ServeFileFS.
-	http.ServeFileFS(w, r, dirFS, entry.FSPath)
+	// ServeFileFS sniffs the content types of extensions missing from Go's table, set the ones known here instead.
+	if entry.MIMEType != "" {
+		w.Header().Set("Content-Type", entry.MIMEType)
+	}
+	if q.bool("download") || s.downloadExts[strings.ToLower(path.Ext(entry.Name))] {
+		w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": entry.Name}))
+	}
+	// Files are served along with the masks of the listings linking to them, so revalidate them when the masks change
+	w.Header().Set("ETag", fileETag(m, "", entry))
+	_, span = startSpan(r.Context(), "maskfs.serve", attribute.String("maskfs.path", entry.FSPath), attribute.Int64("maskfs.size", entry.Size))
+	defer span.End()
+	http.ServeFileFS(w, r, s.fsys, entry.FSPath)
+}
+
+// serveIndex renders a masked index of the immediate children of a directory, or of all its descendants if requested.
+func (s *Server) serveIndex(w http.ResponseWriter, r *http.Request, q query, fsys fs.FS, m *masks, directory *index.Entry) {
+	if s.streamable(r, q) {
+		s.streamIndex(w, r, q, fsys, m, directory)
+		return
+	}
+
+	maxDepth := s.maxDepth
+	if q.bool("recursive") && q.has("maxdepth") {
+		if maxDepth > 0 && q.int("maxdepth") > maxDepth {
+			http.Error(w, fmt.Sprintf("query parameter \"maxdepth\" exceeds the maximum of %d", maxDepth), http.StatusBadRequest)
+			return
+		}
+		maxDepth = q.int("maxdepth")
+	}
+
+	_, span := startSpan(r.Context(), "maskfs.readdir", attribute.String("maskfs.path", directory.FSPath), attribute.Bool("maskfs.recursive", q.bool("recursive")))
+	// Only listing is timed, the rest of the request keeps the masks themselves, which caches like the directory sizes'
+	// are keyed on
+	timed, recordMaskTime := timeMasks(span, m)
+	var (
+		masked index.Entries
+		err    error
+	)
+	if q.bool("recursive") {
+		masked, err = descendants(fsys, timed, directory, maxDepth, s.linked())
+	} else {
+		masked, err = index.GetEntries(fsys, directory.FSPath, timed.all, index.WithBrokenEntries(index.SkipBroken), index.WithConcurrency(s.statConcurrency), s.linked())
+	}
+	recordMaskTime()
+	span.SetAttributes(attribute.Int("maskfs.entries", len(masked)))
+	span.End()
+	// List what can be listed, unless the request ran out of budget part way
+	var listingErr *index.ListingError
+	if errors.As(err, &listingErr) && !errors.Is(err, errBudgetExceeded) {
+		s.logSkipped(r, listingErr)
+		err = nil
+	}
+	if err != nil {
+		s.writeError(w, r, err)
+		return
+	}
+
+	if s.hideEmptyDirs {
+		if masked, err = withoutEmptyDirs(fsys, m, masked); err != nil {
+			s.writeError(w, r, err)
+			return
+		}
+	}
+
+	// Sort by path to ensure the entry order in the rendered HTML is consistent.
+	// For the immediate children of the directory this is the same as sorting by name.
+	sort.Slice(masked, func(i, j int) bool {
+		return masked[i].FSPath < masked[j].FSPath
+	})
+
+	if q.has("from") || q.has("to") {
+		from, to := q.time("from"), q.time("to")
+		if !from.IsZero() && !to.IsZero() && from.After(to) {
+			http.Error(w, `query parameter "from" is after "to"`, http.StatusBadRequest)
+			return
+		}
+
+		masked = masked.ModifiedBetween(from, to, q.bool("filter_dirs"))
+	}
+
+	if q.bool("checksums") {
+		if !s.checksums {
+			http.Error(w, "Checksums are disabled", http.StatusBadRequest)
+			return
+		}
+
+		s.writeChecksums(w, r, fsys, directory, masked)
+		return
+	}
+
+	// Cursor pagination with token and limit follows the path order, so it's excluded by any other sort or pagination
+	var next, prevLink, nextLink string
+	switch {
+	case q.has("token") || q.has("limit"):
+		if masked, next, err = masked.Page(q["token"], q.int("limit")); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if next != "" {
+			nextLink = pageLink(r, directory, "token", next)
+		}
+
+	case q.has("sort") || q.has("order") || q.has("page") || q.has("per_page"):
+		key := q["sort"]
+		if key == "" {
+			key = index.SortByName
+		}
+		if err := masked.SortBy(key, q["order"] == "desc"); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if q.has("page") || q.has("per_page") {
+			page, perPage := max(q.int("page"), 1), q.int("per_page")
+			if perPage == 0 {
+				perPage = defaultPerPage
+			}
+
+			var more bool
+			masked, more = masked.Offset(page, perPage)
+			if page > 1 {
+				prevLink = pageLink(r, directory, "page", strconv.Itoa(page-1))
+			}
+			if more {
+				nextLink = pageLink(r, directory, "page", strconv.Itoa(page+1))
+			}
+		}
+	}
+
+	// Advertise the adjacent pages using a standard Link header so clients don't need to parse the body
+	if prevLink != "" {
+		w.Header().Add("Link", fmt.Sprintf("<%s>; rel=\"prev\"", prevLink))
+	}
+	if nextLink != "" {
+		w.Header().Add("Link", fmt.Sprintf("<%s>; rel=\"next\"", nextLink))
+	}
+
+	if err := s.setDirSizes(r.Context(), fsys, m, masked...); err != nil {
+		s.writeError(w, r, err)
+		return
+	}
+	if err := s.sniffContentTypes(r.Context(), masked...); err != nil {
+		s.writeError(w, r, err)
+		return
+	}
+	s.addHostInfo(masked...)
+
+	if s.metadata != nil {
+		masked.Enrich(s.metadata)
+	}
+
+	// The listing's format depends on the Accept header unless it's given explicitly, so caches must key on it
+	asJSON := listingAsJSON(r, q)
+	w.Header().Add("Vary", "Accept")
+
+	// Let clients polling the directory revalidate their copy instead of downloading the listing again
+	format := "html"
+	if asJSON {
+		format = "json"
+	}
+	etag, modTime := listingETag(m, format, directory, masked, prevLink, nextLink), lastModified(m, directory, masked)
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Last-Modified", modTime.UTC().Format(http.TimeFormat))
+	if notModified(r, etag, modTime) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	_, span = startSpan(r.Context(), "maskfs.render", attribute.String("maskfs.format", format), attribute.Int("maskfs.entries", len(masked)))
+	defer span.End()
+	if asJSON {
+		w.Header().Set("Content-Type", "application/json")
+		if err := masked.WriteJSON(w, directory, masked, index.WithNextToken(next), index.WithPageLinks(prevLink, nextLink)); err != nil {
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	opts := []index.WriteOption{index.WithRefresh(s.refreshSeconds), index.WithPageLinks(prevLink, nextLink)}
+	if s.humanize {
+		opts = append(opts, index.WithHumanized(s.clock.Now()))
+	}
+	if s.template != nil {
+		err = masked.WriteHTMLTemplate(w, directory, masked, s.template, opts...)
+	} else {
+		err = masked.WriteHTML(w, directory, masked, opts...)
+	}
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+	}
+}
+
+// defaultPerPage is the number of entries per page when a page is requested without a page size.
+const defaultPerPage = 100
+
+// pageLink returns the link to the listing of the same directory with the same query, except for the given parameter.
+func pageLink(r *http.Request, directory *index.Entry, name, value string) string {
+	values := r.URL.Query()
+	values.Set(name, value)
+	return directory.LinkPath + "?" + values.Encode()
+}
+
+// descendants returns the unmasked entries below a directory, down to the given depth, or without limit if it's 0.
+func descendants(fsys fs.FS, m *masks, directory *index.Entry, maxDepth int, opts ...index.GetEntriesOption) (index.Entries, error) {
+	var entries index.Entries
+	err := index.Walk(fsys, directory.FSPath, m.all, func(entry *index.Entry, depth int) error {
+		entries = append(entries, entry)
+		if entry.IsDir && maxDepth > 0 && depth >= maxDepth {
+			return fs.SkipDir
+		}
+		return nil
+	}, opts...)
+
+	return entries, err
+}
+
+// withoutEmptyDirs returns the entries without the directories that have no unmasked files below them.
+// Directories named explicitly by a mask rule are kept regardless.
+func withoutEmptyDirs(fsys fs.FS, m *masks, entries index.Entries) (index.Entries, error) {
+	var kept index.Entries
+	for _, entry := range entries {
+		if entry.IsDir && !entry.IsSymlink && !m.path.Explicit(entry) {
+			found, err := index.HasUnmasked(fsys, entry.FSPath, m.all)
+			if err != nil {
+				return nil, err
+			}
+			if !found {
+				continue
+			}
+		}
+		kept = append(kept, entry)
+	}
+
+	return kept, nil
+}
+
+// sniffContentTypes sets the content types of the files among the given entries whose extensions don't tell them to
+// the ones sniffed from their contents, if the server sniffs content types. Files that can't be read are left without
+// one.
+func (s *Server) sniffContentTypes(ctx context.Context, entries ...*index.Entry) error {
+	if !s.sniffTypes {
+		return nil
+	}
+
+	for _, entry := range entries {
+		err := entry.SniffMIMEType()
+		if errors.Is(err, errBudgetExceeded) {
+			return err
+		}
+		if err != nil {
+			logger.ForContext(ctx, s.logger).Debugf("Not sniffing the content type of %q: %v", entry.FSPath, err)
+		}
+	}
+	return nil
+}
+
+// addHostInfo adds the host info of the entries, if host info is reported.
+func (s *Server) addHostInfo(entries ...*index.Entry) {
+	if !s.hostInfo {
+		return
+	}
+	for _, entry := range entries {
+		entry.AddHostInfo()
+	}
+}
+
+// writeError writes the response for an error encountered while handling a request.
+func (s *Server) writeError(w http.ResponseWriter, r *http.Request, err error) {
+	log := s.requestLogger(r)
+	if errors.Is(err, errBudgetExceeded) {
+		log.Debugf("Aborting request: %v", err)
+		http.Error(w, "Service Unavailable: "+err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+
+	log.Errorf("Error handling request: %v", err)
+	http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 }
@@ -0,0 +1,828 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"io/fs"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/njhale/maskfs/pkg/clock"
+	"github.com/njhale/maskfs/pkg/index"
+	"github.com/njhale/maskfs/pkg/logger"
+	"github.com/njhale/maskfs/pkg/mask"
+)
+
+// freeAddr returns a loopback address that nothing is listening on.
+func freeAddr(t *testing.T) string {
+	t.Helper()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	return l.Addr().String()
+}
+
+// waitForServer polls an address until it answers an HTTP request, or fails the test after a few seconds.
+func waitForServer(t *testing.T, addr string) {
+	t.Helper()
+
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		resp, err := http.Get("http://" + addr)
+		if err == nil {
+			resp.Body.Close()
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("server on %s didn't start: %v", addr, err)
+		}
+	}
+}
+
+// listenLoopback opens a listener on a free loopback port.
+func listenLoopback(t *testing.T) net.Listener {
+	t.Helper()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return l
+}
+
+func TestServeShutdown(t *testing.T) {
+	s := &Server{logger: logger.New("test"), shutdownTimeout: 5 * time.Second}
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	a, b := listenLoopback(t), listenLoopback(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan error, 1)
+	go func() {
+		done <- s.serve(ctx, []net.Listener{a, b}, []*http.Server{{Handler: ok}, {Handler: ok}})
+	}()
+	waitForServer(t, a.Addr().String())
+	waitForServer(t, b.Addr().String())
+
+	// Canceling the context shuts every server down
+	cancel()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("serve() = %v, want a clean shutdown", err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("serve() didn't return after the context was canceled")
+	}
+	for _, l := range []net.Listener{a, b} {
+		if resp, err := http.Get("http://" + l.Addr().String()); err == nil {
+			resp.Body.Close()
+			t.Errorf("GET on %s succeeded after shutdown", l.Addr())
+		}
+	}
+}
+
+func TestServeListenError(t *testing.T) {
+	s := &Server{logger: logger.New("test"), shutdownTimeout: 5 * time.Second}
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	a, closed := listenLoopback(t), listenLoopback(t)
+	closed.Close()
+
+	// A server that can't serve shuts the others down, and its error is returned
+	done := make(chan error, 1)
+	go func() {
+		done <- s.serve(context.Background(), []net.Listener{a, closed}, []*http.Server{{Handler: ok}, {Handler: ok}})
+	}()
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Error("serve() on a closed listener succeeded")
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("serve() didn't return after a server failed")
+	}
+	if resp, err := http.Get("http://" + a.Addr().String()); err == nil {
+		resp.Body.Close()
+		t.Errorf("GET on %s succeeded after another server failed", a.Addr())
+	}
+}
+
+func TestRunListeners(t *testing.T) {
+	root := writeFiles(t, map[string]string{"a.txt": "hello"})
+	// Socket paths are limited in length, so they can't be below the test's temporary directory
+	sockets, err := os.MkdirTemp("", "maskfs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(sockets)
+	socket := filepath.Join(sockets, "maskfs.sock")
+	addr := freeAddr(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan error, 1)
+	go func() {
+		done <- Run(ctx, Config{
+			Root:            root,
+			Mask:            "**",
+			Listen:          []string{"unix://" + socket, "tcp://" + addr},
+			SocketMode:      "0600",
+			ShutdownTimeout: "5s",
+			RequestTimeout:  "0",
+		})
+	}()
+
+	unixClient := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, "unix", socket)
+		},
+	}}
+	for name, get := range map[string]func() (*http.Response, error){
+		"unix": func() (*http.Response, error) { return unixClient.Get("http://maskfs/files/a.txt") },
+		"tcp":  func() (*http.Response, error) { return http.Get("http://" + addr + "/files/a.txt") },
+	} {
+		var resp *http.Response
+		for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+			if resp, err = get(); err == nil || time.Now().After(deadline) {
+				break
+			}
+		}
+		if err != nil {
+			t.Fatalf("GET over %s: %v", name, err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK || string(body) != "hello" {
+			t.Errorf("GET over %s = %d %q, want %d %q", name, resp.StatusCode, body, http.StatusOK, "hello")
+		}
+	}
+	if info, err := os.Stat(socket); err != nil || info.Mode().Perm() != 0o600 {
+		t.Errorf("socket mode = %v, %v, want %v", info.Mode().Perm(), err, os.FileMode(0o600))
+	}
+
+	// Canceling the context shuts every listener down
+	cancel()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("Run() = %v, want a clean shutdown", err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("Run() didn't return after the context was canceled")
+	}
+	if resp, err := unixClient.Get("http://maskfs/files/a.txt"); err == nil {
+		resp.Body.Close()
+		t.Error("GET over unix succeeded after shutdown")
+	}
+}
+
+func TestRunListenerError(t *testing.T) {
+	l := listenLoopback(t)
+	defer l.Close()
+
+	// A listener that can't be opened fails Run without serving on the others
+	err := Run(context.Background(), Config{
+		Root:            t.TempDir(),
+		Mask:            "**",
+		Listen:          []string{"127.0.0.1:0", l.Addr().String()},
+		SocketMode:      "0660",
+		ShutdownTimeout: "5s",
+		RequestTimeout:  "0",
+	})
+	if err == nil {
+		t.Error("Run() on an address in use succeeded")
+	}
+}
+
+// newTestServer returns a server for the given configuration, defaulting the durations it requires.
+func newTestServer(t *testing.T, cfg Config) *Server {
+	t.Helper()
+
+	if cfg.ShutdownTimeout == "" {
+		cfg.ShutdownTimeout = "5s"
+	}
+	if cfg.RequestTimeout == "" {
+		cfg.RequestTimeout = "0"
+	}
+	s, err := New(WithConfig(cfg))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+// newHandler returns a server for the given configuration under /files/, the way Run serves it.
+func newHandler(t *testing.T, cfg Config) http.Handler {
+	t.Helper()
+
+	return http.StripPrefix("/files/", newTestServer(t, cfg))
+}
+
+// writeFiles writes files with the given contents below a new temporary directory, returning the directory.
+func writeFiles(t *testing.T, files map[string]string) string {
+	t.Helper()
+
+	dir := t.TempDir()
+	for name, contents := range files {
+		name = filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(name), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(name, []byte(contents), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestServePages(t *testing.T) {
+	dir := writeFiles(t, map[string]string{"a": "", "c": "", "e": ""})
+	h := newHandler(t, Config{Mask: "**"})
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/files"+dir+"/?limit=2", nil))
+	next, ok := strings.CutPrefix(w.Header().Get("Link"), "<")
+	if next, _, _ = strings.Cut(next, ">"); !ok || !strings.HasSuffix(w.Header().Get("Link"), `; rel="next"`) {
+		t.Fatalf("first page Link = %q, want a link to the next page", w.Header().Get("Link"))
+	}
+
+	// A file added before the last one seen isn't on the next page, one added after it is
+	for _, name := range []string{"b", "d"} {
+		if err := os.WriteFile(filepath.Join(dir, name), nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, next, nil))
+	body := w.Body.String()
+	if w.Code != http.StatusOK || !strings.Contains(body, ">d</a>") || !strings.Contains(body, ">e</a>") || strings.Contains(body, ">b</a>") || strings.Contains(body, ">a</a>") {
+		t.Errorf("GET %s = %d, want only d and e:\n%s", next, w.Code, body)
+	}
+	if link := w.Header().Get("Link"); link != "" {
+		t.Errorf("last page Link = %q, want none", link)
+	}
+
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/files"+dir+"/?limit=0", nil))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("GET with a zero limit = %d, want %d", w.Code, http.StatusBadRequest)
+	}
+}
+
+// describer is a MetadataProvider describing files by their names.
+type describer struct{}
+
+func (describer) Enrich(entry *index.Entry) map[string]string {
+	if entry.IsDir {
+		return nil
+	}
+	return map[string]string{"description": "all about " + entry.Name}
+}
+
+func TestServeMetadata(t *testing.T) {
+	dir := writeFiles(t, map[string]string{"a.txt": "", "sub/b.txt": ""})
+	s := newTestServer(t, Config{Mask: "**"})
+	h := http.StripPrefix("/files/", s)
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/files"+dir+"/", nil))
+	if strings.Contains(w.Body.String(), "description") {
+		t.Error("listing has metadata without a provider")
+	}
+
+	s.SetMetadataProvider(describer{})
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/files"+dir+"/", nil))
+	for _, want := range []string{"<th>description</th>", "<td>all about a.txt</td>"} {
+		if !strings.Contains(w.Body.String(), want) {
+			t.Errorf("listing doesn't contain %q", want)
+		}
+	}
+}
+
+func TestServeRoot(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"secret.txt":     "secret",
+		"root/a.txt":     "hello",
+		"root/sub/b.txt": "world",
+		"sibling/c.txt":  "sibling",
+		"rootless/d.txt": "prefix",
+	})
+	srv := httptest.NewServer(newHandler(t, Config{Root: filepath.Join(dir, "root"), Mask: "**"}))
+	defer srv.Close()
+	client := srv.Client()
+	client.CheckRedirect = func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }
+
+	for _, p := range []string{
+		"/files/../secret.txt",
+		"/files/../../secret.txt",
+		"/files/sub/../../secret.txt",
+		"/files/..%2fsecret.txt",
+		"/files/%2e%2e/secret.txt",
+		"/files/%2e%2e%2f%2e%2e%2fsecret.txt",
+		"/files/..\\secret.txt",
+		"/files/../sibling/c.txt",
+		"/files/../rootless/d.txt",
+	} {
+		// Send the path as is, without the client cleaning it first
+		r, err := http.NewRequest(http.MethodGet, srv.URL, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r.URL.Opaque = p
+		resp, err := client.Do(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusNotFound {
+			t.Errorf("GET %s = %d %q, want %d", p, resp.StatusCode, body, http.StatusNotFound)
+		}
+		for _, outside := range []string{"secret", "sibling", "prefix"} {
+			if strings.Contains(string(body), outside) {
+				t.Errorf("GET %s = %q, which is outside of the root", p, body)
+			}
+		}
+	}
+
+	// Paths within the root are served relative to it
+	for p, want := range map[string]string{"/files/a.txt": "hello", "/files/sub/b.txt": "world"} {
+		resp, err := client.Get(srv.URL + p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK || string(body) != want {
+			t.Errorf("GET %s = %d %q, want %d %q", p, resp.StatusCode, body, http.StatusOK, want)
+		}
+	}
+}
+
+func TestServeSymlinks(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"outside/secret.txt": "secret",
+		"root/a.txt":         "hello",
+		"root/inner/b.txt":   "world",
+	})
+	root := filepath.Join(dir, "root")
+	for link, target := range map[string]string{
+		"file-out":  filepath.Join(dir, "outside", "secret.txt"),
+		"dir-out":   filepath.Join(dir, "outside"),
+		"hop-out":   filepath.Join(dir, "outside", "secret.txt"),
+		"chain-out": "hop-out",
+		"hop-in":    "a.txt",
+		"chain-in":  "hop-in",
+		"dir-in":    "inner",
+	} {
+		if err := os.Symlink(target, filepath.Join(root, link)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, tt := range []struct {
+		name     string
+		cfg      Config
+		listed   []string
+		unlisted []string
+		found    map[string]string
+		notFound []string
+	}{
+		{
+			name:     "confined",
+			cfg:      Config{Root: root, Mask: "**"},
+			listed:   []string{"a.txt", "inner", "dir-in", "hop-in", "chain-in"},
+			unlisted: []string{"file-out", "dir-out", "hop-out", "chain-out"},
+			found:    map[string]string{"/files/chain-in": "hello", "/files/dir-in/b.txt": "world"},
+			notFound: []string{"/files/file-out", "/files/dir-out/", "/files/dir-out/secret.txt", "/files/hop-out", "/files/chain-out"},
+		},
+		{
+			name:   "following external symlinks",
+			cfg:    Config{Root: root, Mask: "**", FollowExternalSymlinks: true},
+			listed: []string{"a.txt", "inner", "dir-in", "file-out", "dir-out", "chain-out"},
+			found: map[string]string{
+				"/files/chain-in":           "hello",
+				"/files/dir-out/secret.txt": "secret",
+				"/files/chain-out":          "secret",
+			},
+		},
+		{
+			// Symlinks are masked as files, whatever their targets are
+			name:     "directory rules",
+			cfg:      Config{Root: root, Mask: "**/"},
+			listed:   []string{"inner"},
+			unlisted: []string{"a.txt", "dir-in", "dir-out"},
+			notFound: []string{"/files/dir-in/"},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			h := newHandler(t, tt.cfg)
+
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/files/", nil))
+			for _, name := range tt.listed {
+				if !strings.Contains(w.Body.String(), ">"+name+"</a>") {
+					t.Errorf("listing doesn't contain %s", name)
+				}
+			}
+			for _, name := range tt.unlisted {
+				if strings.Contains(w.Body.String(), ">"+name+"</a>") {
+					t.Errorf("listing contains %s", name)
+				}
+			}
+
+			for p, want := range tt.found {
+				w := httptest.NewRecorder()
+				h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, p, nil))
+				if w.Code != http.StatusOK || w.Body.String() != want {
+					t.Errorf("GET %s = %d %q, want %d %q", p, w.Code, w.Body, http.StatusOK, want)
+				}
+			}
+			for _, p := range tt.notFound {
+				w := httptest.NewRecorder()
+				h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, p, nil))
+				if w.Code != http.StatusNotFound {
+					t.Errorf("GET %s = %d, want %d", p, w.Code, http.StatusNotFound)
+				}
+			}
+		})
+	}
+}
+
+func TestServeNestedMaskFile(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"sub/.maskfs": "!*.key\n",
+		"sub/a.txt":   "",
+		"sub/b.key":   "",
+		"c.key":       "",
+	})
+	h := newHandler(t, Config{Root: dir, Mask: "**", NestedMaskFile: ".maskfs"})
+
+	for p, want := range map[string]int{
+		"/files/sub/a.txt":   http.StatusOK,
+		"/files/sub/b.key":   http.StatusNotFound,
+		"/files/sub/.maskfs": http.StatusNotFound,
+		"/files/c.key":       http.StatusOK,
+	} {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, p, nil))
+		if w.Code != want {
+			t.Errorf("GET %s = %d, want %d", p, w.Code, want)
+		}
+	}
+}
+
+func TestServeHideEmptyDirs(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"full/a.txt":            "",
+		"hidden/deep/x.key":     "",
+		"negated/deep/x.key":    "",
+		"negated/deep/keep.key": "",
+	})
+	if err := os.Mkdir(filepath.Join(dir, "explicit"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("..", filepath.Join(dir, "hidden", "deep", "up")); err != nil {
+		t.Fatal(err)
+	}
+	// The keys are masked except for the one re-included after the negation, and so are the symlinks named up
+	rules := "**\n!*.key\n!up\nnegated/deep/keep.key\nexplicit/"
+
+	for _, tt := range []struct {
+		name     string
+		hide     bool
+		listed   []string
+		unlisted []string
+	}{
+		{name: "shown", listed: []string{"full", "hidden", "negated", "explicit"}},
+		{name: "hidden", hide: true, listed: []string{"full", "negated", "explicit"}, unlisted: []string{"hidden"}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			newHandler(t, Config{Root: dir, Mask: rules, HideEmptyDirs: tt.hide}).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/files/", nil))
+			if w.Code != http.StatusOK {
+				t.Fatalf("GET / = %d", w.Code)
+			}
+			for _, name := range tt.listed {
+				if !strings.Contains(w.Body.String(), ">"+name+"</a>") {
+					t.Errorf("listing doesn't contain %s", name)
+				}
+			}
+			for _, name := range tt.unlisted {
+				if strings.Contains(w.Body.String(), ">"+name+"</a>") {
+					t.Errorf("listing contains %s", name)
+				}
+			}
+		})
+	}
+}
+
+func TestServeRegexMask(t *testing.T) {
+	dir := writeFiles(t, map[string]string{"builds/2024/a.txt": "", "builds/v2/b.txt": ""})
+	h := newHandler(t, Config{Root: dir, Mask: `^builds/([0-9]+(/|$)|$)`, MaskType: "regex"})
+
+	for p, want := range map[string]int{
+		"/files/builds/2024/a.txt": http.StatusOK,
+		"/files/builds/v2/b.txt":   http.StatusNotFound,
+	} {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, p, nil))
+		if w.Code != want {
+			t.Errorf("GET %s = %d, want %d", p, w.Code, want)
+		}
+	}
+
+	for _, cfg := range []Config{
+		{Mask: "a(b", MaskType: "regex"},
+		{Mask: ".*", MaskType: "regex", NestedMaskFile: ".maskfs"},
+		{Mask: "**", MaskType: "pcre"},
+	} {
+		cfg.Root, cfg.ShutdownTimeout, cfg.RequestTimeout = dir, "5s", "0"
+		if _, err := New(WithConfig(cfg)); err == nil {
+			t.Errorf("New() with mask %q of type %s succeeded", cfg.Mask, cfg.MaskType)
+		}
+	}
+}
+
+func TestNewWithFS(t *testing.T) {
+	fsys := fstest.MapFS{
+		"a.txt":      {Data: []byte("hello")},
+		"secret.key": {Data: []byte("secret")},
+	}
+	m, err := mask.NewGlobMask("**\n!*.key")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		name   string
+		mask   index.Mask
+		masked bool
+	}{
+		{name: "mask", mask: m, masked: true},
+		{name: "nil mask"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := NewWithFS(fsys, tt.mask)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for p, code := range map[string]int{"a.txt": http.StatusOK, "secret.key": http.StatusNotFound, "missing": http.StatusNotFound} {
+				if p == "secret.key" && !tt.masked {
+					code = http.StatusOK
+				}
+				w := httptest.NewRecorder()
+				http.StripPrefix("/files/", s).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/files/"+p, nil))
+				if w.Code != code {
+					t.Errorf("GET %s = %d, want %d", p, w.Code, code)
+				}
+			}
+
+			if err := s.ReloadMask(); err == nil {
+				t.Error("ReloadMask() reloaded a mask given in code")
+			}
+		})
+	}
+
+	if _, err := NewWithFS(nil, m); err == nil {
+		t.Error("NewWithFS() succeeded without a filesystem")
+	}
+
+	// Filesystems that aren't on the host can't be written
+	if _, err := New(WithFS(fsys), WithConfig(Config{WriteMask: "**"})); err == nil {
+		t.Error("New() enabled writes to a filesystem that isn't on the host")
+	}
+}
+
+func TestNewRoot(t *testing.T) {
+	if _, err := New(WithConfig(Config{Root: filepath.Join(t.TempDir(), "missing"), Mask: "**", ShutdownTimeout: "5s", RequestTimeout: "0"})); err == nil {
+		t.Error("New with a missing root succeeded, want an error")
+	}
+}
+
+func TestServeJSON(t *testing.T) {
+	dir := writeFiles(t, map[string]string{"a.txt": "hello", "sub/b.txt": ""})
+	s := newTestServer(t, Config{Mask: "**"})
+	s.SetMetadataProvider(describer{})
+	r := httptest.NewRequest(http.MethodGet, "/files"+dir+"/", nil)
+	r.Header.Set("Accept", "application/json")
+	w := httptest.NewRecorder()
+	http.StripPrefix("/files/", s).ServeHTTP(w, r)
+
+	if got := w.Header().Get("Content-Type"); got != "application/json" {
+		t.Errorf("Content-Type = %q, want application/json", got)
+	}
+	var listing struct {
+		Entries []struct {
+			Name     string            `json:"name"`
+			Size     int64             `json:"size"`
+			Mode     string            `json:"mode"`
+			IsDir    bool              `json:"is_dir"`
+			Metadata map[string]string `json:"metadata"`
+		} `json:"entries"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&listing); err != nil {
+		t.Fatal(err)
+	}
+	if len(listing.Entries) != 2 {
+		t.Fatalf("listing has %d entries, want 2", len(listing.Entries))
+	}
+	a, sub := listing.Entries[0], listing.Entries[1]
+	if a.Name != "a.txt" || a.Size != 5 || a.IsDir || a.Mode[0] != '-' || a.Metadata["description"] != "all about a.txt" {
+		t.Errorf("a.txt = %+v", a)
+	}
+	if sub.Name != "sub" || !sub.IsDir || sub.Mode[0] != 'd' {
+		t.Errorf("sub = %+v", sub)
+	}
+}
+
+func TestServeHideJunk(t *testing.T) {
+	field, _ := reflect.TypeFor[Config]().FieldByName("HideJunk")
+	dir := writeFiles(t, map[string]string{
+		"a.txt":     "",
+		"a.txt~":    "",
+		".DS_Store": "",
+		"Thumbs.db": "",
+		"#a.txt#":   "",
+		"notes.bak": "",
+	})
+
+	for _, tt := range []struct {
+		name     string
+		hideJunk string
+		shown    []string
+		hidden   []string
+	}{
+		{name: "defaults", hideJunk: field.Tag.Get("default"), shown: []string{"a.txt", "notes.bak"}, hidden: []string{"a.txt~", ".DS_Store", "Thumbs.db", "#a.txt#"}},
+		{name: "overridden", hideJunk: "*.bak\n.DS_Store", shown: []string{"a.txt", "a.txt~", "Thumbs.db", "#a.txt#"}, hidden: []string{"notes.bak", ".DS_Store"}},
+		{name: "disabled", hideJunk: "", shown: []string{"a.txt", "a.txt~", ".DS_Store", "Thumbs.db", "#a.txt#", "notes.bak"}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			h := newHandler(t, Config{Mask: "**", HideJunk: tt.hideJunk})
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/files"+dir+"/", nil))
+			for _, name := range tt.shown {
+				if !strings.Contains(w.Body.String(), ">"+name+"</a>") {
+					t.Errorf("listing doesn't show %q", name)
+				}
+			}
+			for _, name := range tt.hidden {
+				if strings.Contains(w.Body.String(), ">"+name+"</a>") {
+					t.Errorf("listing shows %q", name)
+				}
+				w := httptest.NewRecorder()
+				h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/files"+dir+"/"+url.PathEscape(name), nil))
+				if w.Code != http.StatusNotFound {
+					t.Errorf("GET %s = %d, want %d", name, w.Code, http.StatusNotFound)
+				}
+			}
+		})
+	}
+
+	if _, err := New(WithConfig(Config{Mask: "**", HideJunk: "[a-", ShutdownTimeout: "5s", RequestTimeout: "0"})); err == nil {
+		t.Error("New() accepted a malformed junk pattern")
+	}
+}
+
+func TestServeTimeRange(t *testing.T) {
+	dir := writeFiles(t, map[string]string{"jan01.log": "", "jan10.log": "", "jan20.log": ""})
+	for name, d := range map[string]int{"jan01.log": 1, "jan10.log": 10, "jan20.log": 20} {
+		modTime := time.Date(2024, time.January, d, 12, 0, 0, 0, time.UTC)
+		if err := os.Chtimes(filepath.Join(dir, name), modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	h := newHandler(t, Config{Mask: "**"})
+
+	for _, tt := range []struct {
+		name  string
+		query string
+		code  int
+		want  []string
+	}{
+		{name: "closed", query: "from=2024-01-05T00:00:00Z&to=2024-01-15T00:00:00Z", code: http.StatusOK, want: []string{"jan10.log"}},
+		{name: "open start", query: "to=2024-01-10T12:00:00Z", code: http.StatusOK, want: []string{"jan01.log", "jan10.log"}},
+		{name: "open end", query: "from=2024-01-10T12:00:00Z", code: http.StatusOK, want: []string{"jan10.log", "jan20.log"}},
+		{name: "from after to", query: "from=2024-01-20T00:00:00Z&to=2024-01-10T00:00:00Z", code: http.StatusBadRequest},
+		{name: "invalid bound", query: "from=2024-01-20", code: http.StatusBadRequest},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/files"+dir+"/?"+tt.query, nil))
+			if w.Code != tt.code {
+				t.Fatalf("GET ?%s = %d, want %d", tt.query, w.Code, tt.code)
+			}
+			for _, name := range []string{"jan01.log", "jan10.log", "jan20.log"} {
+				if listed := strings.Contains(w.Body.String(), ">"+name+"</a>"); listed != slices.Contains(tt.want, name) {
+					t.Errorf("GET ?%s lists %s %t, want %v", tt.query, name, listed, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestServeAutoRefresh(t *testing.T) {
+	dir := writeFiles(t, map[string]string{"a.txt": ""})
+	for _, seconds := range []int{0, 30} {
+		t.Run(strconv.Itoa(seconds), func(t *testing.T) {
+			w := httptest.NewRecorder()
+			newHandler(t, Config{Mask: "**", AutoRefreshSeconds: seconds}).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/files"+dir+"/", nil))
+
+			refreshes := strings.Contains(w.Body.String(), `http-equiv="refresh"`)
+			if want := `<meta http-equiv="refresh" content="30">`; seconds > 0 && !strings.Contains(w.Body.String(), want) {
+				t.Errorf("listing with AutoRefreshSeconds %d doesn't contain %q", seconds, want)
+			}
+			if seconds == 0 && refreshes {
+				t.Error("listing refreshes without AutoRefreshSeconds")
+			}
+		})
+	}
+}
+
+func TestServeHumanizeListings(t *testing.T) {
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	dir := writeFiles(t, map[string]string{"a.txt": "hello"})
+	if err := os.Chtimes(filepath.Join(dir, "a.txt"), now, now.Add(-3*time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, humanize := range []bool{false, true} {
+		t.Run(strconv.FormatBool(humanize), func(t *testing.T) {
+			s := newTestServer(t, Config{Root: dir, Mask: "**", HumanizeListings: humanize})
+			s.clock = clock.Fixed(now)
+			w := httptest.NewRecorder()
+			http.StripPrefix("/files/", s).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/files/", nil))
+
+			if got := strings.Contains(w.Body.String(), ">3 hours ago</td>"); got != humanize {
+				t.Errorf("listing with HumanizeListings %t shows the age: %t", humanize, got)
+			}
+			if got := strings.Contains(w.Body.String(), ">5 B</td>"); got != humanize {
+				t.Errorf("listing with HumanizeListings %t shows the human size: %t", humanize, got)
+			}
+		})
+	}
+}
+
+func TestServeListings(t *testing.T) {
+	fsys := fstest.MapFS{
+		"empty":             {Mode: fs.ModeDir | 0o755},
+		"masked/secret.key": {Data: []byte("secret")},
+		"public/a.txt":      {Data: []byte("hello")},
+	}
+	s, err := New(WithFS(fsys), WithConfig(Config{Mask: "**\n!*.key", URLPrefix: "/"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	for _, tt := range []struct {
+		name     string
+		path     string
+		code     int
+		contains []string
+		excludes []string
+	}{
+		{name: "root", path: "/", code: http.StatusOK, contains: []string{"public", "empty"}, excludes: []string{`href="/.."`, "No entries"}},
+		{name: "empty directory", path: "/empty/", code: http.StatusOK, contains: []string{"No entries", `href="/empty/.."`}},
+		{name: "fully masked directory", path: "/masked/", code: http.StatusOK, contains: []string{"No entries"}, excludes: []string{"secret.key"}},
+		{name: "file", path: "/public/a.txt", code: http.StatusOK, contains: []string{"hello"}},
+		{name: "masked file", path: "/masked/secret.key", code: http.StatusNotFound},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			r.Header.Set("Accept", "text/html")
+			w := httptest.NewRecorder()
+			s.ServeHTTP(w, r)
+
+			if w.Code != tt.code {
+				t.Fatalf("GET %s = %d, want %d", tt.path, w.Code, tt.code)
+			}
+			for _, s := range tt.contains {
+				if !strings.Contains(w.Body.String(), s) {
+					t.Errorf("GET %s doesn't contain %q", tt.path, s)
+				}
+			}
+			for _, s := range tt.excludes {
+				if strings.Contains(w.Body.String(), s) {
+					t.Errorf("GET %s contains %q", tt.path, s)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,115 @@
+package server
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/njhale/maskfs/pkg/clock"
+)
+
+// LoadSigningKey returns the key of signed URLs given directly or in a file, or nil if neither is given.
+func LoadSigningKey(key, keyFile string) ([]byte, error) {
+	if keyFile == "" {
+		if key == "" {
+			return nil, nil
+		}
+		return []byte(key), nil
+	}
+	if key != "" {
+		return nil, errors.New("only one of a signing key and a signing key file can be given")
+	}
+
+	data, err := os.ReadFile(keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read signing key file: %w", err)
+	}
+	if key = strings.TrimSpace(string(data)); key == "" {
+		return nil, errors.New("signing key file is empty")
+	}
+	return []byte(key), nil
+}
+
+// SignURL signs a URL of a file with a key, returning a copy of it that grants GET and HEAD requests until it expires
+// even when the server requires authentication. The signature covers the URL's path and its whole query, so neither can
+// be changed without invalidating it, but the mask still applies to the requests it grants, as it does to anonymous
+// ones.
+func SignURL(u *url.URL, key []byte, expires time.Time) (*url.URL, error) {
+	if len(key) == 0 {
+		return nil, errors.New("the signing key is empty")
+	}
+
+	signed := *u
+	query := signed.Query()
+	query.Del("signature")
+	query.Set("expires", strconv.FormatInt(expires.Unix(), 10))
+	query.Set("signature", urlSignature(key, signed.Path, query))
+	signed.RawQuery = query.Encode()
+
+	return &signed, nil
+}
+
+// urlSignature returns the base64 encoded HMAC-SHA256 of a URL path and its query without the signature, with the
+// query's keys sorted so that reordering them doesn't invalidate the signature.
+func urlSignature(key []byte, urlPath string, query url.Values) string {
+	unsigned := url.Values{}
+	for k, v := range query {
+		if k != "signature" {
+			unsigned[k] = v
+		}
+	}
+
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(urlPath))
+	mac.Write([]byte{'?'})
+	mac.Write([]byte(unsigned.Encode()))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// signedURLs returns middleware that lets GET and HEAD requests of URLs signed with the key through to the handler
+// without authentication, as anonymous requests, and sends every other request through the authenticated handler.
+// Requests of signed URLs that have expired or whose signatures don't match are forbidden rather than authenticated,
+// since they're never meant to be.
+func signedURLs(key []byte, clk clock.Clock, authenticated http.Handler) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			query := r.URL.Query()
+			signature := query.Get("signature")
+			if signature == "" {
+				authenticated.ServeHTTP(w, r)
+				return
+			}
+
+			if r.Method != http.MethodGet && r.Method != http.MethodHead {
+				http.Error(w, "Signed URLs only grant GET and HEAD requests", http.StatusForbidden)
+				return
+			}
+
+			expires, err := strconv.ParseInt(query.Get("expires"), 10, 64)
+			if err != nil {
+				http.Error(w, "Signed URL is invalid", http.StatusForbidden)
+				return
+			}
+			if !clk.Now().Before(time.Unix(expires, 0)) {
+				http.Error(w, "Signed URL has expired", http.StatusForbidden)
+				return
+			}
+
+			want := urlSignature(key, r.URL.Path, query)
+			if !hmac.Equal([]byte(signature), []byte(want)) {
+				http.Error(w, "Signed URL is invalid", http.StatusForbidden)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
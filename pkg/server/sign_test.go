@@ -0,0 +1,92 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSignedURLs(t *testing.T) {
+	root := writeFiles(t, map[string]string{
+		"a.txt":      "hello",
+		"secret.key": "secret",
+	})
+	key := []byte("signing-key")
+
+	h := newTestReloadingHandler(t, Config{
+		Root:       root,
+		Mask:       "**\n!*.key",
+		URLPrefix:  "/files",
+		AuthToken:  "token",
+		SigningKey: string(key),
+	})
+
+	sign := func(path string, expires time.Time) string {
+		u, err := SignURL(&url.URL{Path: path}, key, expires)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return u.String()
+	}
+	later, earlier := time.Now().Add(time.Hour), time.Now().Add(-time.Hour)
+	signed := sign("/files/a.txt", later)
+
+	for _, tt := range []struct {
+		name   string
+		method string
+		target string
+		code   int
+	}{
+		{name: "unsigned", target: "/files/a.txt", code: http.StatusUnauthorized},
+		{name: "signed", target: signed, code: http.StatusOK},
+		{name: "signed head", method: http.MethodHead, target: signed, code: http.StatusOK},
+		{name: "signed post", method: http.MethodPost, target: signed, code: http.StatusForbidden},
+		{name: "expired", target: sign("/files/a.txt", earlier), code: http.StatusForbidden},
+		{name: "other key", target: func() string {
+			u, _ := SignURL(&url.URL{Path: "/files/a.txt"}, []byte("other-key"), later)
+			return u.String()
+		}(), code: http.StatusForbidden},
+		{name: "other path", target: strings.Replace(signed, "a.txt", "b.txt", 1), code: http.StatusForbidden},
+		{name: "extended expiry", target: withQuery(t, signed, "expires", strconv.FormatInt(later.Add(time.Hour).Unix(), 10)), code: http.StatusForbidden},
+		{name: "added query", target: withQuery(t, signed, "download", "1"), code: http.StatusForbidden},
+		{name: "masked file", target: sign("/files/secret.key", later), code: http.StatusNotFound},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			method := tt.method
+			if method == "" {
+				method = http.MethodGet
+			}
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, httptest.NewRequest(method, tt.target, nil))
+
+			if w.Code != tt.code {
+				t.Errorf("%s %s = %d, want %d", method, tt.target, w.Code, tt.code)
+			}
+		})
+	}
+}
+
+func TestSignedURLsRequireAuth(t *testing.T) {
+	cfg := Config{Root: t.TempDir(), SigningKey: "key", ShutdownTimeout: "5s", RequestTimeout: "0"}
+	if _, err := newReloadingHandler(context.Background(), cfg); err == nil {
+		t.Error("signed URLs were accepted without authentication")
+	}
+}
+
+// withQuery returns a URL with a query parameter set to another value.
+func withQuery(t *testing.T, rawURL, key, value string) string {
+	t.Helper()
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	query := u.Query()
+	query.Set(key, value)
+	u.RawQuery = query.Encode()
+	return u.String()
+}
@@ -0,0 +1,82 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/njhale/maskfs/pkg/index"
+)
+
+const (
+	// maxStatPaths is the maximum number of paths a single batch stat request may ask for.
+	maxStatPaths = 1000
+	// maxStatBody is the maximum size in bytes of the body of a batch stat request.
+	maxStatBody = 1 << 20
+)
+
+// statResult is the result of stat'ing one of the paths of a batch stat request.
+type statResult struct {
+	Path  string       `json:"path"`
+	Entry *index.Entry `json:"entry,omitempty"`
+	Error string       `json:"error,omitempty"`
+}
+
+// serveStat stats every path of a JSON array POSTed to it, relative to the root, and responds with a JSON array of
+// their entries in the same order. Masked paths are reported as not found just like missing ones, so that batches
+// can't tell them apart.
+func (s *Server) serveStat(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var paths []string
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxStatBody)).Decode(&paths); err != nil {
+		http.Error(w, fmt.Sprintf("Request body must be a JSON array of paths: %v", err), http.StatusBadRequest)
+		return
+	}
+	if len(paths) > maxStatPaths {
+		http.Error(w, fmt.Sprintf("Request asks for %d paths, more than the maximum of %d", len(paths), maxStatPaths), http.StatusBadRequest)
+		return
+	}
+
+	var (
+		m       = s.masksFor(r)
+		fsys    = newBudgetFS(r.Context(), s.clock, s.fsys, s.maxWalkEntries, s.requestTimeout)
+		results = make([]statResult, len(paths))
+	)
+	for i, p := range paths {
+		results[i].Path = p
+
+		fsPath := path.Clean(strings.TrimPrefix(p, "/"))
+		if !fs.ValidPath(fsPath) {
+			results[i].Error = "not found"
+			continue
+		}
+
+		entry, err := index.GetEntry(fsys, fsPath, s.linked())
+		if errors.Is(err, errBudgetExceeded) {
+			s.writeError(w, r, err)
+			return
+		}
+		if err != nil {
+			results[i].Error = "not found"
+			continue
+		}
+		if !entry.IsRoot() && m.all.Masked(entry) {
+			s.audit(r, m, entry)
+			results[i].Error = "not found"
+			continue
+		}
+
+		results[i].Entry = entry
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(results)
+}
@@ -0,0 +1,77 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestServeStat(t *testing.T) {
+	root := writeFiles(t, map[string]string{"a.txt": "hello", "dir/b.txt": "", "secret.key": "secret"})
+	h := newTestReloadingHandler(t, Config{Root: root, Mask: "**\n!*.key", URLPrefix: "/files", StatAPI: true})
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/api/stat", strings.NewReader(`["a.txt", "/dir", "secret.key", "missing", "../etc/passwd"]`)))
+	if w.Code != http.StatusOK {
+		t.Fatalf("POST /api/stat = %d %s, want %d", w.Code, w.Body, http.StatusOK)
+	}
+	var results []statResult
+	if err := json.NewDecoder(w.Body).Decode(&results); err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 5 {
+		t.Fatalf("POST /api/stat returned %d results, want 5", len(results))
+	}
+	// Results are in the order of the paths, and masked paths look just like missing ones
+	for i, want := range []struct {
+		path, name string
+		isDir      bool
+	}{
+		{path: "a.txt", name: "a.txt"},
+		{path: "/dir", name: "dir", isDir: true},
+		{path: "secret.key"},
+		{path: "missing"},
+		{path: "../etc/passwd"},
+	} {
+		result := results[i]
+		switch {
+		case result.Path != want.path:
+			t.Errorf("result %d is of %q, want %q", i, result.Path, want.path)
+		case want.name == "" && (result.Entry != nil || result.Error != "not found"):
+			t.Errorf("result of %q = %+v, want not found", want.path, result)
+		case want.name != "" && (result.Entry == nil || result.Entry.Name != want.name || result.Entry.IsDir != want.isDir):
+			t.Errorf("result of %q = %+v, want an entry named %q", want.path, result, want.name)
+		}
+	}
+
+	paths, _ := json.Marshal(make([]string, maxStatPaths+1))
+	for _, tt := range []struct {
+		name   string
+		method string
+		body   string
+		code   int
+	}{
+		{name: "GET", method: http.MethodGet, code: http.StatusMethodNotAllowed},
+		{name: "not an array", method: http.MethodPost, body: `{"path": "a.txt"}`, code: http.StatusBadRequest},
+		{name: "too many paths", method: http.MethodPost, body: string(paths), code: http.StatusBadRequest},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, httptest.NewRequest(tt.method, "/api/stat", strings.NewReader(tt.body)))
+			if w.Code != tt.code {
+				t.Errorf("%s /api/stat = %d, want %d", tt.method, w.Code, tt.code)
+			}
+		})
+	}
+}
+
+func TestServeStatDisabled(t *testing.T) {
+	h := newTestReloadingHandler(t, Config{Root: writeFiles(t, map[string]string{"a.txt": ""}), Mask: "**", URLPrefix: "/files"})
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/api/stat", strings.NewReader(`["a.txt"]`)))
+	if w.Code == http.StatusOK {
+		t.Errorf("POST /api/stat without StatAPI = %d, want an error", w.Code)
+	}
+}
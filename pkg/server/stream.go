@@ -0,0 +1,99 @@
+package server
+
+import (
+	"errors"
+	"io/fs"
+	"net/http"
+
+	"github.com/njhale/maskfs/pkg/index"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// streamable returns true if listings are streamed and the requested listing can be rendered as its entries are read,
+// which every option needing all of the entries at once, like sorting, pagination, or metadata columns, rules out.
+func (s *Server) streamable(r *http.Request, q query) bool {
+	if !s.streamListings || s.hideEmptyDirs || s.metadata != nil {
+		return false
+	}
+	if s.template != nil && !listingAsJSON(r, q) {
+		// Custom templates are given every entry of a listing at once
+		return false
+	}
+	for _, name := range []string{"recursive", "from", "to", "checksums", "token", "limit", "sort", "order", "page", "per_page"} {
+		if q.has(name) {
+			return false
+		}
+	}
+	return true
+}
+
+// streamIndex renders a masked index of the immediate children of a directory as they're read, in the order the
+// directory lists them. Since the listing is written before it's complete, it has no ETag or Last-Modified header,
+// and an error part way through can only be logged, leaving the listing incomplete.
+func (s *Server) streamIndex(w http.ResponseWriter, r *http.Request, q query, fsys fs.FS, m *masks, directory *index.Entry) {
+	// Reading and rendering are interleaved, so they share a span, but the time spent masking is still told apart
+	_, span := startSpan(r.Context(), "maskfs.stream", attribute.String("maskfs.path", directory.FSPath))
+	defer span.End()
+	// Only listing is timed, the rest of the request keeps the masks themselves, which caches like the directory sizes'
+	// are keyed on
+	timed, recordMaskTime := timeMasks(span, m)
+	defer recordMaskTime()
+
+	skipped := &index.ListingError{Dir: directory.FSPath}
+	entries := func(yield func(*index.Entry, error) bool) {
+		for entry, err := range index.IterEntries(fsys, directory.FSPath, timed.all, index.WithBrokenEntries(index.SkipBroken), index.WithConcurrency(s.statConcurrency), s.linked()) {
+			// Skip what can't be listed, unless the request ran out of budget part way
+			var listingErr *index.ListingError
+			if errors.As(err, &listingErr) && !errors.Is(err, errBudgetExceeded) {
+				skipped.Errs = append(skipped.Errs, listingErr.Errs...)
+				continue
+			}
+			if err == nil {
+				if err = s.setDirSizes(r.Context(), fsys, m, entry); err == nil {
+					err = s.sniffContentTypes(r.Context(), entry)
+				}
+				s.addHostInfo(entry)
+			}
+			if !yield(entry, err) {
+				return
+			}
+		}
+	}
+
+	w.Header().Add("Vary", "Accept")
+	var err error
+	if listingAsJSON(r, q) {
+		w.Header().Set("Content-Type", "application/json")
+		err = index.WriteJSONStream(w, directory, entries)
+	} else {
+		opts := []index.WriteOption{index.WithRefresh(s.refreshSeconds)}
+		if s.humanize {
+			opts = append(opts, index.WithHumanized(s.clock.Now()))
+		}
+		err = index.WriteHTMLStream(w, directory, entries, opts...)
+	}
+
+	if len(skipped.Errs) > 0 {
+		s.logSkipped(r, skipped)
+	}
+	switch {
+	case errors.Is(err, errBudgetExceeded):
+		s.requestLogger(r).Debugf("Aborting streamed listing: %v", err)
+	case err != nil:
+		s.requestLogger(r).Errorf("Failed to stream listing: %v", err)
+	}
+}
+
+// logSkipped logs the entries a listing skipped because they couldn't be read.
+func (s *Server) logSkipped(r *http.Request, listingErr *index.ListingError) {
+	log := s.requestLogger(r)
+	logf := log.Debugf
+	for _, err := range listingErr.Errs {
+		if !errors.Is(err, index.ErrBrokenSymlink) {
+			// Broken symlinks are routine, but other entries should be readable
+			logf = log.Warnf
+			break
+		}
+	}
+	logf("Skipping unreadable entries: %v", listingErr)
+}
@@ -0,0 +1,58 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+)
+
+func TestServeStreamListings(t *testing.T) {
+	root := writeFiles(t, map[string]string{"a.txt": "a", "b.txt": "b", "secret.key": "secret", "sub/c.txt": "c"})
+	h := newHandler(t, Config{Root: root, Mask: "**\n!*.key", StreamListings: true})
+
+	for _, tt := range []struct {
+		target   string
+		streamed bool
+	}{
+		{target: "/files/?format=json", streamed: true},
+		{target: "/files/?format=json&sort=name&order=desc"},
+		{target: "/files/?format=json&limit=10"},
+	} {
+		t.Run(tt.target, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, tt.target, nil))
+			if w.Code != http.StatusOK {
+				t.Fatalf("GET %s = %d, want %d", tt.target, w.Code, http.StatusOK)
+			}
+			// Streamed listings are written before they're complete, so they can't be validated
+			if streamed := w.Header().Get("ETag") == ""; streamed != tt.streamed {
+				t.Errorf("GET %s streamed = %t, want %t", tt.target, streamed, tt.streamed)
+			}
+
+			var listing struct {
+				Entries []struct {
+					Name string `json:"name"`
+				} `json:"entries"`
+			}
+			if err := json.NewDecoder(w.Body).Decode(&listing); err != nil {
+				t.Fatal(err)
+			}
+			var names []string
+			for _, entry := range listing.Entries {
+				names = append(names, entry.Name)
+			}
+			slices.Sort(names)
+			if want := []string{"a.txt", "b.txt", "sub"}; !slices.Equal(names, want) {
+				t.Errorf("GET %s listed %q, want %q", tt.target, names, want)
+			}
+		})
+	}
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/files/sub/", nil))
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "text/html; charset=utf-8" {
+		t.Errorf("GET /files/sub/ = %d with Content-Type %q, want an HTML listing", w.Code, w.Header().Get("Content-Type"))
+	}
+}
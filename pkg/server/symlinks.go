@@ -0,0 +1,116 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"strings"
+
+	"github.com/njhale/maskfs/pkg/index"
+)
+
+// maxSymlinkHops is the most symlinks followed when resolving a path, like the kernel's limit.
+const maxSymlinkHops = 40
+
+// errOutsideRoot is returned when a symlink leads outside of the served directory.
+var errOutsideRoot = errors.New("symlink leads outside of the root")
+
+// symlinkMask applies a symlink policy other than serve to entries reached through symlinks, whether the entries are
+// symlinks themselves or below symlinked directories.
+type symlinkMask struct {
+	fsys    fs.FS
+	resolve bool       // Resolve symlinks and mask their targets with rest, instead of masking them outright
+	rest    index.Mask // The masks targets of resolved symlinks have to pass
+}
+
+// newSymlinkMask returns the mask applying the symlink policy, or nil for the serve policy, which serves symlinks
+// like any other entry.
+func newSymlinkMask(policy string, fsys fs.FS, rest index.Mask) (index.Mask, error) {
+	switch policy {
+	case "", "serve":
+		return nil, nil
+	case "deny":
+		return &symlinkMask{fsys: fsys}, nil
+	case "resolve-and-mask":
+		return &symlinkMask{fsys: fsys, resolve: true, rest: rest}, nil
+	default:
+		return nil, fmt.Errorf("unsupported symlink policy %q, must be deny, serve, or resolve-and-mask", policy)
+	}
+}
+
+func (m *symlinkMask) Masked(entry *index.Entry) bool {
+	if entry.IsRoot() {
+		return false
+	}
+
+	resolved, linked, err := resolveSymlinks(m.fsys, entry.FSPath)
+	switch {
+	case err != nil:
+		// Entries that can't be resolved can't be shown to lead somewhere unmasked
+		return true
+	case !linked:
+		return false
+	case !m.resolve:
+		return true
+	}
+
+	target, err := index.GetEntry(m.fsys, resolved)
+	if err != nil {
+		return true
+	}
+	return !target.IsRoot() && m.rest != nil && m.rest.Masked(target)
+}
+
+// resolveSymlinks returns the path an entry's path leads to with every symlink along it resolved, and whether there
+// were any symlinks to resolve. Symlinks leading outside of the filesystem, including those with absolute targets,
+// are an error.
+func resolveSymlinks(fsys fs.FS, fsPath string) (string, bool, error) {
+	var (
+		resolved = "."
+		parts    = strings.Split(fsPath, "/")
+		linked   bool
+		hops     int
+	)
+	for len(parts) > 0 {
+		part := parts[0]
+		parts = parts[1:]
+
+		switch part {
+		case "", ".":
+			continue
+		case "..":
+			// The resolved path has no symlinks left in it, so going up is a matter of dropping its last element
+			if resolved == "." {
+				return "", linked, errOutsideRoot
+			}
+			resolved = path.Dir(resolved)
+			continue
+		}
+
+		next := path.Join(resolved, part)
+		info, err := fs.Lstat(fsys, next)
+		if err != nil {
+			return "", linked, err
+		}
+		if info.Mode()&fs.ModeSymlink == 0 {
+			resolved = next
+			continue
+		}
+
+		linked = true
+		if hops++; hops > maxSymlinkHops {
+			return "", linked, fmt.Errorf("too many levels of symlinks resolving %q", fsPath)
+		}
+		target, err := fs.ReadLink(fsys, next)
+		if err != nil {
+			return "", linked, err
+		}
+		if path.IsAbs(target) {
+			return "", linked, errOutsideRoot
+		}
+		parts = append(strings.Split(target, "/"), parts...)
+	}
+
+	return resolved, linked, nil
+}
@@ -0,0 +1,133 @@
+package server
+
+import (
+	"errors"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+)
+
+func TestResolveSymlinks(t *testing.T) {
+	link := func(target string) *fstest.MapFile {
+		return &fstest.MapFile{Data: []byte(target), Mode: fs.ModeSymlink}
+	}
+	fsys := fstest.MapFS{
+		"a.txt":         {},
+		"dir/b.txt":     {},
+		"dir/up":        link("../a.txt"),
+		"to-dir":        link("dir"),
+		"to-a":          link("a.txt"),
+		"chain":         link("to-a"),
+		"absolute":      link("/etc/passwd"),
+		"escape":        link("../outside"),
+		"loop":          link("loop"),
+		"dangling":      link("missing"),
+		"dir/nested/up": link("../../to-dir"),
+	}
+
+	for _, tt := range []struct {
+		path     string
+		resolved string
+		linked   bool
+		err      error
+	}{
+		{path: "a.txt", resolved: "a.txt"},
+		{path: "dir/b.txt", resolved: "dir/b.txt"},
+		{path: "to-dir/b.txt", resolved: "dir/b.txt", linked: true},
+		{path: "chain", resolved: "a.txt", linked: true},
+		{path: "dir/up", resolved: "a.txt", linked: true},
+		{path: "dir/nested/up/b.txt", resolved: "dir/b.txt", linked: true},
+		{path: "absolute", err: errOutsideRoot},
+		{path: "escape", err: errOutsideRoot},
+		{path: "dangling", err: fs.ErrNotExist},
+	} {
+		resolved, linked, err := resolveSymlinks(fsys, tt.path)
+		if tt.err != nil {
+			if !errors.Is(err, tt.err) {
+				t.Errorf("resolveSymlinks(%q) error = %v, want %v", tt.path, err, tt.err)
+			}
+			continue
+		}
+		if err != nil || resolved != tt.resolved || linked != tt.linked {
+			t.Errorf("resolveSymlinks(%q) = %q, %t, %v, want %q, %t", tt.path, resolved, linked, err, tt.resolved, tt.linked)
+		}
+	}
+
+	if _, _, err := resolveSymlinks(fsys, "loop"); err == nil {
+		t.Error("resolveSymlinks(loop) succeeded")
+	}
+}
+
+func TestServeSymlinkPolicy(t *testing.T) {
+	root := writeFiles(t, map[string]string{
+		"a.txt":          "a",
+		"secret/b.txt":   "b",
+		"public/c.txt":   "c",
+		"public/d.key":   "d",
+		"private/f.txt":  "f",
+		"private/g.key":  "g",
+		"public/h/i.txt": "i",
+	})
+	for link, target := range map[string]string{
+		"public/to-a":      "../a.txt",
+		"public/to-secret": "../secret/b.txt",
+		"public/to-key":    "d.key",
+		"public/to-dir":    "../private",
+	} {
+		if err := os.Symlink(target, filepath.Join(root, filepath.FromSlash(link))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	mask := "**\n!*.key\n!secret/"
+
+	for _, tt := range []struct {
+		policy   string
+		found    []string
+		notFound []string
+	}{
+		{
+			policy:   "serve",
+			found:    []string{"a.txt", "public/to-a", "public/to-secret", "public/to-dir/f.txt"},
+			notFound: []string{"secret/b.txt", "public/d.key", "public/to-dir/g.key"},
+		},
+		{
+			policy:   "deny",
+			found:    []string{"a.txt", "public/c.txt", "public/h/i.txt"},
+			notFound: []string{"public/to-a", "public/to-secret", "public/to-dir/", "public/to-dir/f.txt"},
+		},
+		{
+			// Symlinks are masked by their targets on top of their own paths
+			policy:   "resolve-and-mask",
+			found:    []string{"a.txt", "public/to-a", "public/to-dir/", "public/to-dir/f.txt"},
+			notFound: []string{"public/to-secret", "public/to-key", "public/to-dir/g.key"},
+		},
+	} {
+		t.Run(tt.policy, func(t *testing.T) {
+			h := newHandler(t, Config{Root: root, Mask: mask, Symlinks: tt.policy})
+			get := func(p string) int {
+				w := httptest.NewRecorder()
+				h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/files/"+p, nil))
+				return w.Code
+			}
+
+			for _, p := range tt.found {
+				if code := get(p); code != http.StatusOK {
+					t.Errorf("GET %s = %d, want %d", p, code, http.StatusOK)
+				}
+			}
+			for _, p := range tt.notFound {
+				if code := get(p); code != http.StatusNotFound {
+					t.Errorf("GET %s = %d, want %d", p, code, http.StatusNotFound)
+				}
+			}
+		})
+	}
+
+	if _, err := New(WithConfig(Config{Root: root, Symlinks: "follow", ShutdownTimeout: "5s", RequestTimeout: "0"})); err == nil {
+		t.Error("New() accepted an unsupported symlink policy")
+	}
+}
@@ -0,0 +1,183 @@
+package server
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/gif" // Registers GIF decoding with image.Decode
+	"image/jpeg"
+	"image/png"
+	"io"
+	"io/fs"
+	"net/http"
+	"sync"
+
+	"github.com/njhale/maskfs/pkg/index"
+	"go.opentelemetry.io/otel/attribute"
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/webp" // Registers WebP decoding with image.Decode
+)
+
+const (
+	// maxThumbnailSize is the maximum width and height in pixels of thumbnails.
+	maxThumbnailSize = 1024
+	// maxThumbnailPixels is the maximum number of pixels of images to thumbnail, so that small files decoding to huge
+	// images can't exhaust the server's memory.
+	maxThumbnailPixels = 64 << 20
+)
+
+// thumbnailKey identifies a thumbnail of a version of a file by the file's path, modification time, and size, so that a
+// cached thumbnail is never returned for a file that has since been modified.
+type thumbnailKey struct {
+	path    string
+	modTime int64
+	size    int64
+	px      int
+}
+
+type thumbnail struct {
+	data        []byte
+	contentType string
+}
+
+// thumbnailCache caches encoded thumbnails, so that galleries of the same images don't decode them over and over.
+type thumbnailCache struct {
+	mu     sync.Mutex
+	thumbs map[thumbnailKey]thumbnail
+	size   int64 // Total size in bytes of the cached thumbnails
+	max    int64 // Maximum total size in bytes of cached thumbnails, 0 to disable caching
+}
+
+func newThumbnailCache(max int64) *thumbnailCache {
+	return &thumbnailCache{
+		thumbs: map[thumbnailKey]thumbnail{},
+		max:    max,
+	}
+}
+
+func (c *thumbnailCache) get(key thumbnailKey) (thumbnail, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	thumb, ok := c.thumbs[key]
+	return thumb, ok
+}
+
+func (c *thumbnailCache) put(key thumbnailKey, thumb thumbnail) {
+	size := int64(len(thumb.data))
+	if size > c.max {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, ok := c.thumbs[key]; ok {
+		return
+	}
+	for k, cached := range c.thumbs {
+		if c.size+size <= c.max {
+			break
+		}
+		// Evict arbitrary thumbnails to make room, map iteration order is random
+		delete(c.thumbs, k)
+		c.size -= int64(len(cached.data))
+	}
+	c.thumbs[key] = thumb
+	c.size += size
+}
+
+// serveThumbnail serves a JPEG or PNG thumbnail of an image file scaled down to fit within px by px pixels, keeping its
+// aspect ratio. Images already small enough are re-encoded at their own size.
+func (s *Server) serveThumbnail(w http.ResponseWriter, r *http.Request, m *masks, fsys fs.FS, entry *index.Entry, px int) {
+	if px > maxThumbnailSize {
+		http.Error(w, fmt.Sprintf("Thumbnail size %d exceeds the maximum of %d pixels", px, maxThumbnailSize), http.StatusBadRequest)
+		return
+	}
+
+	// The thumbnail only changes along with the file, so the file's version identifies it
+	etag := "W/" + fileETag(m, fmt.Sprintf("thumb%d-", px), entry)
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Last-Modified", entry.ModTime.UTC().Format(http.TimeFormat))
+	if notModified(r, etag, entry.ModTime) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	key := thumbnailKey{
+		path:    entry.FSPath,
+		modTime: entry.ModTime.UnixNano(),
+		size:    entry.Size,
+		px:      px,
+	}
+	thumb, ok := s.thumbnailCache.get(key)
+	if !ok {
+		_, span := startSpan(r.Context(), "maskfs.thumbnail", attribute.String("maskfs.path", entry.FSPath), attribute.Int("maskfs.thumb", px))
+		defer span.End()
+		var err error
+		if thumb, err = makeThumbnail(fsys, entry, px); err != nil {
+			if errors.Is(err, errNotImage) && !errors.Is(err, errBudgetExceeded) {
+				http.Error(w, "Only JPEG, PNG, GIF, and WebP images can be thumbnailed", http.StatusBadRequest)
+				return
+			}
+			s.writeError(w, r, fmt.Errorf("failed to make thumbnail of %q: %w", entry.FSPath, err))
+			return
+		}
+		s.thumbnailCache.put(key, thumb)
+	}
+
+	w.Header().Set("Content-Type", thumb.contentType)
+	_, _ = w.Write(thumb.data)
+}
+
+// errNotImage is returned by makeThumbnail for files that aren't images it can decode.
+var errNotImage = errors.New("not a supported image")
+
+// makeThumbnail decodes an image file and encodes it scaled down to fit within px by px pixels, as a JPEG if it's
+// opaque and as a PNG otherwise.
+func makeThumbnail(fsys fs.FS, entry *index.Entry, px int) (thumbnail, error) {
+	f, err := fsys.Open(entry.FSPath)
+	if err != nil {
+		return thumbnail{}, err
+	}
+	defer f.Close()
+
+	// Check the dimensions before decoding the whole image, then decode it from the start again
+	var head bytes.Buffer
+	config, _, err := image.DecodeConfig(io.TeeReader(f, &head))
+	if err != nil {
+		return thumbnail{}, fmt.Errorf("%w: %w", errNotImage, err)
+	}
+	if int64(config.Width)*int64(config.Height) > maxThumbnailPixels {
+		return thumbnail{}, fmt.Errorf("%w: %dx%d pixels is too large", errNotImage, config.Width, config.Height)
+	}
+	src, _, err := image.Decode(io.MultiReader(&head, f))
+	if err != nil {
+		return thumbnail{}, fmt.Errorf("%w: %w", errNotImage, err)
+	}
+
+	bounds := src.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	if width > px || height > px {
+		if width >= height {
+			width, height = px, max(1, height*px/width)
+		} else {
+			width, height = max(1, width*px/height), px
+		}
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.BiLinear.Scale(dst, dst.Bounds(), src, bounds, draw.Src, nil)
+
+	var buf bytes.Buffer
+	if dst.Opaque() {
+		if err := jpeg.Encode(&buf, dst, &jpeg.Options{Quality: 85}); err != nil {
+			return thumbnail{}, err
+		}
+		return thumbnail{data: buf.Bytes(), contentType: "image/jpeg"}, nil
+	}
+	if err := png.Encode(&buf, dst); err != nil {
+		return thumbnail{}, err
+	}
+	return thumbnail{data: buf.Bytes(), contentType: "image/png"}, nil
+}
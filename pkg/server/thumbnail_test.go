@@ -0,0 +1,113 @@
+package server
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// writePNG writes a PNG image of the given size, which is transparent unless it's opaque.
+func writePNG(t *testing.T, name string, width, height int, opaque bool) {
+	t.Helper()
+
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	fill := color.NRGBA{R: 255, A: 128}
+	if opaque {
+		fill.A = 255
+	}
+	for y := range height {
+		for x := range width {
+			img.Set(x, y, fill)
+		}
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(name, buf.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestServeThumbnail(t *testing.T) {
+	root := writeFiles(t, map[string]string{"a.txt": "not an image"})
+	writePNG(t, filepath.Join(root, "wide.png"), 200, 100, true)
+	writePNG(t, filepath.Join(root, "small.png"), 50, 40, false)
+	writePNG(t, filepath.Join(root, "secret.png"), 10, 10, true)
+
+	for _, tt := range []struct {
+		name        string
+		disabled    bool
+		target      string
+		code        int
+		contentType string
+		width       int
+		height      int
+	}{
+		// Opaque images are thumbnailed as JPEGs, keeping their aspect ratio
+		{name: "scaled", target: "/files/wide.png?thumb=64", code: http.StatusOK, contentType: "image/jpeg", width: 64, height: 32},
+		// Images with transparency stay PNGs, and small ones aren't scaled up
+		{name: "small", target: "/files/small.png?thumb=64", code: http.StatusOK, contentType: "image/png", width: 50, height: 40},
+		{name: "not an image", target: "/files/a.txt?thumb=64", code: http.StatusBadRequest},
+		{name: "too large", target: "/files/wide.png?thumb=2048", code: http.StatusBadRequest},
+		{name: "not positive", target: "/files/wide.png?thumb=0", code: http.StatusBadRequest},
+		{name: "masked", target: "/files/secret.png?thumb=64", code: http.StatusNotFound},
+		{name: "disabled", disabled: true, target: "/files/wide.png?thumb=64", code: http.StatusBadRequest},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			h := newHandler(t, Config{Root: root, Mask: "**\n!secret.png", Thumbnails: !tt.disabled, ThumbnailCache: 1 << 20})
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, tt.target, nil))
+			if w.Code != tt.code {
+				t.Fatalf("GET %s = %d %s, want %d", tt.target, w.Code, w.Body, tt.code)
+			}
+			if tt.code != http.StatusOK {
+				return
+			}
+
+			if contentType := w.Header().Get("Content-Type"); contentType != tt.contentType {
+				t.Errorf("Content-Type = %q, want %q", contentType, tt.contentType)
+			}
+			config, _, err := image.DecodeConfig(w.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if config.Width != tt.width || config.Height != tt.height {
+				t.Errorf("thumbnail is %dx%d, want %dx%d", config.Width, config.Height, tt.width, tt.height)
+			}
+		})
+	}
+}
+
+func TestThumbnailCache(t *testing.T) {
+	c := newThumbnailCache(10)
+	a, b := thumbnailKey{path: "a.png", px: 64}, thumbnailKey{path: "b.png", px: 64}
+
+	c.put(a, thumbnail{data: make([]byte, 6)})
+	if _, ok := c.get(a); !ok {
+		t.Fatal("thumbnail wasn't cached")
+	}
+	// A thumbnail of another version of the file is another thumbnail
+	if _, ok := c.get(thumbnailKey{path: "a.png", px: 64, size: 1}); ok {
+		t.Error("thumbnail of another version of the file was returned")
+	}
+
+	// Thumbnails are evicted to keep the cache within its maximum size, and ones larger than it aren't cached
+	c.put(b, thumbnail{data: make([]byte, 6)})
+	if _, ok := c.get(a); ok {
+		t.Error("thumbnail wasn't evicted")
+	}
+	if _, ok := c.get(b); !ok || c.size != 6 {
+		t.Errorf("cache has %d bytes of thumbnails, want the 6 of the last one", c.size)
+	}
+	c.put(thumbnailKey{path: "c.png"}, thumbnail{data: make([]byte, 11)})
+	if _, ok := c.get(thumbnailKey{path: "c.png"}); ok {
+		t.Error("thumbnail larger than the cache was cached")
+	}
+}
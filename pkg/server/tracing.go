@@ -0,0 +1,126 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sync/atomic"
+	"time"
+
+	"github.com/njhale/maskfs/pkg/index"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracer starts the spans of the stages of handling requests. Until tracing is set up, it's a no-op.
+var tracer = otel.Tracer("github.com/njhale/maskfs/pkg/server")
+
+// setupTracing sets up exporting traces to an OTLP collector at the given endpoint, over HTTP for http:// and https://
+// URLs and over gRPC for grpc:// and grpcs:// ones. Everything else, like headers, sampling, and resource attributes,
+// is configured by the standard OTEL_* environment variables. The returned function flushes and stops exporting.
+func setupTracing(ctx context.Context, endpoint string) (func(context.Context) error, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil || u.Host == "" {
+		return nil, fmt.Errorf("invalid OTLP endpoint %q, must be an http, https, grpc, or grpcs URL", endpoint)
+	}
+
+	var client otlptrace.Client
+	switch u.Scheme {
+	case "http", "https":
+		opts := []otlptracehttp.Option{otlptracehttp.WithEndpointURL(endpoint)}
+		if u.Path == "" || u.Path == "/" {
+			// Like the standard environment variables, a bare endpoint means the collector's default traces path
+			opts = append(opts, otlptracehttp.WithURLPath("/v1/traces"))
+		}
+		client = otlptracehttp.NewClient(opts...)
+	case "grpc", "grpcs":
+		opts := []otlptracegrpc.Option{otlptracegrpc.WithEndpoint(u.Host)}
+		if u.Scheme == "grpc" {
+			opts = append(opts, otlptracegrpc.WithInsecure())
+		}
+		client = otlptracegrpc.NewClient(opts...)
+	default:
+		return nil, fmt.Errorf("invalid OTLP endpoint %q, must be an http, https, grpc, or grpcs URL", endpoint)
+	}
+
+	// Spans are exported in batches in the background, so an unreachable collector doesn't fail startup
+	exporter, err := otlptrace.New(ctx, client)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create OTLP trace exporter: %w", err)
+	}
+
+	// The environment's resource attributes, like OTEL_SERVICE_NAME, take precedence over the default service name
+	res, err := resource.New(ctx,
+		resource.WithAttributes(semconv.ServiceName("maskfs")),
+		resource.WithFromEnv(),
+		resource.WithTelemetrySDK(),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create trace resource: %w", err)
+	}
+
+	provider := sdktrace.NewTracerProvider(sdktrace.WithBatcher(exporter), sdktrace.WithResource(res))
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+
+	return provider.Shutdown, nil
+}
+
+// traceRequests returns middleware that starts a server span for every request, continuing the trace of the client's
+// traceparent header if it sent one.
+func traceRequests(next http.Handler) http.Handler {
+	identified := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if id := requestIDOf(r.Context()); id != "" {
+			trace.SpanFromContext(r.Context()).SetAttributes(attribute.String("maskfs.request_id", id))
+		}
+		next.ServeHTTP(w, r)
+	})
+	return otelhttp.NewHandler(identified, "maskfs", otelhttp.WithSpanNameFormatter(func(_ string, r *http.Request) string {
+		// Paths would make span names unbounded, they're recorded as attributes instead
+		return r.Method
+	}))
+}
+
+// startSpan starts a span of a stage of handling a request as a child of the request's span.
+func startSpan(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return tracer.Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// timedMask sums the time its mask spends deciding whether entries are masked, so that the spans of listings can tell
+// the time spent on masks apart from the time spent on the filesystem, without a span for every entry.
+type timedMask struct {
+	index.Mask
+	spent atomic.Int64 // Nanoseconds, entries may be masked concurrently
+}
+
+func (m *timedMask) Masked(entry *index.Entry) bool {
+	start := time.Now()
+	defer func() { m.spent.Add(int64(time.Since(start))) }()
+	return m.Mask.Masked(entry)
+}
+
+// timeMasks returns a copy of the masks with their combined mask timed when the span is recording, along with a
+// function that records the time spent masking on the span once the copy is done with. The copy is a different
+// *masks, so it mustn't be used where masks are identified by their pointer, like in the directory size cache.
+func timeMasks(span trace.Span, m *masks) (*masks, func()) {
+	if !span.IsRecording() {
+		return m, func() {}
+	}
+
+	timed := &timedMask{Mask: m.all}
+	withTimed := *m
+	withTimed.all = timed
+	return &withTimed, func() {
+		span.SetAttributes(attribute.Int64("maskfs.mask.duration_us", time.Duration(timed.spent.Load()).Microseconds()))
+	}
+}
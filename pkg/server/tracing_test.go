@@ -0,0 +1,98 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+// spanAttr returns the value of an attribute of a span, and whether it has it.
+func spanAttr(span sdktrace.ReadOnlySpan, key attribute.Key) (attribute.Value, bool) {
+	for _, attr := range span.Attributes() {
+		if attr.Key == key {
+			return attr.Value, true
+		}
+	}
+	return attribute.Value{}, false
+}
+
+func TestTraceRequests(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+
+	root := writeFiles(t, map[string]string{"a.txt": "hello", "dir/b.txt": "", "secret.key": ""})
+	h := traceRequests(newHandler(t, Config{Root: root, Mask: "**\n!*.key"}))
+
+	const traceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+	for _, tt := range []struct {
+		target string
+		spans  []string
+		masked bool
+	}{
+		{target: "/files/a.txt", spans: []string{"maskfs.stat", "maskfs.mask", "maskfs.serve", "GET"}},
+		{target: "/files/secret.key", spans: []string{"maskfs.stat", "maskfs.mask", "GET"}, masked: true},
+		{target: "/files/dir/", spans: []string{"maskfs.stat", "maskfs.mask", "maskfs.readdir", "maskfs.render", "GET"}},
+	} {
+		t.Run(tt.target, func(t *testing.T) {
+			recorder.Reset()
+			r := httptest.NewRequest(http.MethodGet, tt.target, nil)
+			// Requests continue the traces of their clients
+			r.Header.Set("Traceparent", "00-"+traceID+"-00f067aa0ba902b7-01")
+			h.ServeHTTP(httptest.NewRecorder(), r)
+
+			var names []string
+			for _, span := range recorder.Ended() {
+				names = append(names, span.Name())
+				if got := span.SpanContext().TraceID().String(); got != traceID {
+					t.Errorf("span %s is of trace %s, want %s", span.Name(), got, traceID)
+				}
+
+				switch span.Name() {
+				case "maskfs.mask":
+					if masked, ok := spanAttr(span, "maskfs.masked"); !ok || masked.AsBool() != tt.masked {
+						t.Errorf("maskfs.masked = %v, want %t", masked.AsBool(), tt.masked)
+					}
+				case "maskfs.readdir":
+					if entries, ok := spanAttr(span, "maskfs.entries"); !ok || entries.AsInt64() != 1 {
+						t.Errorf("maskfs.entries = %d, want 1", entries.AsInt64())
+					}
+					if _, ok := spanAttr(span, "maskfs.mask.duration_us"); !ok {
+						t.Error("listing span doesn't record the time spent masking")
+					}
+				}
+			}
+			if !slices.Equal(names, tt.spans) {
+				t.Errorf("spans = %q, want %q", names, tt.spans)
+			}
+		})
+	}
+}
+
+func TestTimeMasks(t *testing.T) {
+	m := &masks{}
+	_, span := noop.NewTracerProvider().Tracer("test").Start(context.Background(), "test")
+	// Masks aren't timed for spans that aren't recorded
+	timed, record := timeMasks(span, m)
+	record()
+	if timed != m {
+		t.Error("masks were timed for a span that isn't recording")
+	}
+}
+
+func TestSetupTracingInvalidEndpoint(t *testing.T) {
+	for _, endpoint := range []string{"localhost:4318", "ftp://localhost:4318", "http://", "://"} {
+		if _, err := setupTracing(context.Background(), endpoint); err == nil {
+			t.Errorf("setupTracing(%q) succeeded, want an error", endpoint)
+		}
+	}
+}
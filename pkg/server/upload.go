@@ -0,0 +1,233 @@
+package server
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/njhale/maskfs/pkg/index"
+)
+
+// serveUpload creates or replaces the file at the given path with the request body.
+//
+// The file must be allowed by the write mask and, as it would be once written, unmasked by the read mask, so that
+// uploads can't probe for or replace masked files. Per-directory rule files are never written, so that uploads can't
+// change the mask. Its parent directory must already exist and be unmasked.
+// The body is written to a temporary file next to the target and renamed over it once complete, so readers never
+// see a partially written file and a failed upload leaves the existing file untouched.
+func (s *Server) serveUpload(w http.ResponseWriter, r *http.Request, fsPath string, m *masks) {
+	if fsPath == "." {
+		http.Error(w, "Cannot write to the root directory", http.StatusMethodNotAllowed)
+		return
+	}
+	if s.ruleFile(fsPath) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	dir := path.Dir(fsPath)
+	parent, err := index.GetEntry(s.fsys, dir)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	if !parent.IsRoot() && m.all.Masked(parent) {
+		s.audit(r, m, parent)
+		http.NotFound(w, r)
+		return
+	}
+	if !parent.IsDir {
+		http.NotFound(w, r)
+		return
+	}
+
+	existing, err := index.GetEntry(s.fsys, fsPath)
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		existing = nil
+	case err != nil:
+		// Broken symlinks and the like, which can't be safely replaced
+		http.Error(w, "Conflict", http.StatusConflict)
+		return
+	}
+
+	target := existing
+	if target == nil {
+		// Mask the file as it will be once written
+		target = &index.Entry{
+			Name:    path.Base(fsPath),
+			Mode:    0o644,
+			ModTime: s.clock.Now(),
+			FSPath:  fsPath,
+		}
+		if uid, gid := os.Getuid(), os.Getgid(); uid >= 0 && gid >= 0 {
+			target.Owner = &index.Owner{UID: uint32(uid), GID: uint32(gid)}
+		}
+	}
+	if m.all.Masked(target) {
+		if existing != nil {
+			s.audit(r, m, target)
+		}
+		http.NotFound(w, r)
+		return
+	}
+	if m.write == nil || m.write.Masked(target) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+	if existing != nil && (existing.IsDir || existing.IsSymlink) {
+		http.Error(w, "Conflict: not a regular file", http.StatusConflict)
+		return
+	}
+
+	body := r.Body
+	if s.maxUploadSize > 0 {
+		body = http.MaxBytesReader(w, r.Body, s.maxUploadSize)
+	}
+
+	if err := s.writeFile(fsPath, body); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			http.Error(w, fmt.Sprintf("Request body exceeds the maximum upload size of %d bytes", tooLarge.Limit), http.StatusRequestEntityTooLarge)
+			return
+		}
+
+		s.writeError(w, r, fmt.Errorf("failed to write %q: %w", fsPath, err))
+		return
+	}
+
+	s.dirSizeCache.invalidate(fsPath)
+	s.requestLogger(r).Infof("Wrote %q", fsPath)
+	if existing != nil {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if created, err := index.GetEntry(s.fsys, fsPath, s.linked()); err == nil {
+		w.Header().Set("Location", created.LinkPath)
+	}
+	w.WriteHeader(http.StatusCreated)
+}
+
+// writeFile atomically replaces the named file with the contents of r, by writing them to a temporary file in the
+// same directory and renaming it over the original once complete.
+func (s *Server) writeFile(name string, r io.Reader) error {
+	tmp := path.Join(path.Dir(name), "."+path.Base(name)+".upload-"+rand.Text())
+	f, err := s.writeRoot.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(f, r)
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = s.writeRoot.Rename(tmp, name)
+	}
+	if err != nil {
+		_ = s.writeRoot.Remove(tmp)
+		return err
+	}
+
+	return nil
+}
+
+// serveDelete moves the file at the given path into a timestamped directory below the trash directory, keeping its
+// path, so that deletions can be recovered. Like uploads, the file must be unmasked, allowed by the write mask, and not
+// a per-directory rule file.
+func (s *Server) serveDelete(w http.ResponseWriter, r *http.Request, fsPath string, m *masks) {
+	if s.ruleFile(fsPath) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	entry, err := index.GetEntry(s.fsys, fsPath)
+	if errors.Is(err, index.ErrBrokenSymlink) {
+		// Broken symlinks can't be masked by their target, but can still be removed
+		info, lstatErr := fs.Lstat(s.fsys, fsPath)
+		if lstatErr != nil {
+			http.NotFound(w, r)
+			return
+		}
+		entry, err = &index.Entry{Name: info.Name(), Mode: info.Mode(), ModTime: info.ModTime(), IsSymlink: true, FSPath: fsPath}, nil
+	}
+	if err != nil || entry.IsRoot() {
+		http.NotFound(w, r)
+		return
+	}
+	if m.all.Masked(entry) {
+		s.audit(r, m, entry)
+		http.NotFound(w, r)
+		return
+	}
+	if m.write == nil || m.write.Masked(entry) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+	if entry.IsDir && !entry.IsSymlink {
+		http.Error(w, "Conflict: cannot delete a directory", http.StatusConflict)
+		return
+	}
+
+	trashed := path.Join(s.trashDir, s.clock.Now().UTC().Format("20060102T150405.000000000Z"), fsPath)
+	if err := s.writeRoot.MkdirAll(path.Dir(trashed), 0o755); err != nil {
+		s.writeError(w, r, fmt.Errorf("failed to create trash directory: %w", err))
+		return
+	}
+	if err := s.writeRoot.Rename(fsPath, trashed); err != nil {
+		s.writeError(w, r, fmt.Errorf("failed to move %q to the trash: %w", fsPath, err))
+		return
+	}
+
+	s.dirSizeCache.invalidate(fsPath)
+	s.requestLogger(r).Infof("Moved %q to %q", fsPath, trashed)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ruleFile returns whether the given path is that of a per-directory rule file.
+func (s *Server) ruleFile(fsPath string) bool {
+	return s.cfg.NestedMaskFile != "" && path.Base(fsPath) == s.cfg.NestedMaskFile
+}
+
+// parseTrashDir returns the slash-separated path of the trash directory relative to the root.
+// The trash directory must be below the root, so that files can be moved into it without copying them.
+func parseTrashDir(dir string) (string, error) {
+	if dir == "" {
+		return "", nil
+	}
+
+	cleaned := path.Clean(filepath.ToSlash(dir))
+	if !fs.ValidPath(cleaned) || cleaned == "." {
+		return "", fmt.Errorf("trash directory %q must be a relative path below the root", dir)
+	}
+	return cleaned, nil
+}
+
+// hiddenDir masks a directory and everything below it, regardless of any other mask.
+type hiddenDir string
+
+func (h hiddenDir) Masked(entry *index.Entry) bool {
+	return entry.FSPath == string(h) || strings.HasPrefix(entry.FSPath, string(h)+"/")
+}
+
+func (h hiddenDir) PruneHint(dir *index.Entry) index.Prune {
+	switch {
+	case h.Masked(dir):
+		return index.PruneMasked
+	case dir.IsRoot() || strings.HasPrefix(string(h), dir.FSPath+"/"):
+		// The hidden directory is below this one
+		return index.PruneNone
+	default:
+		return index.PruneUnmasked
+	}
+}
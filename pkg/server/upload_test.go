@@ -0,0 +1,87 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestServeUpload(t *testing.T) {
+	for _, tt := range []struct {
+		name     string
+		method   string
+		path     string
+		body     string
+		code     int
+		location string
+		want     map[string]string // Contents of files after the request, empty for files that mustn't exist
+	}{
+		{name: "create", method: http.MethodPut, path: "uploads/new.txt", body: "new", code: http.StatusCreated, location: "/files/uploads%2Fnew.txt", want: map[string]string{"uploads/new.txt": "new"}},
+		{name: "create with post", method: http.MethodPost, path: "uploads/new.txt", body: "new", code: http.StatusCreated, location: "/files/uploads%2Fnew.txt", want: map[string]string{"uploads/new.txt": "new"}},
+		{name: "overwrite", method: http.MethodPut, path: "uploads/existing.txt", body: "new", code: http.StatusNoContent, want: map[string]string{"uploads/existing.txt": "new"}},
+		{name: "denied by the write mask", method: http.MethodPut, path: "readonly/r.txt", body: "new", code: http.StatusForbidden, want: map[string]string{"readonly/r.txt": "old"}},
+		{name: "new file denied by the write mask", method: http.MethodPut, path: "readonly/new.txt", body: "new", code: http.StatusForbidden, want: map[string]string{"readonly/new.txt": ""}},
+		{name: "masked target", method: http.MethodPut, path: "uploads/secret.key", body: "new", code: http.StatusNotFound, want: map[string]string{"uploads/secret.key": "old"}},
+		{name: "new masked target", method: http.MethodPut, path: "uploads/new.key", body: "new", code: http.StatusNotFound, want: map[string]string{"uploads/new.key": ""}},
+		{name: "rule file", method: http.MethodPut, path: "uploads/.maskfs", body: "**", code: http.StatusForbidden, want: map[string]string{"uploads/.maskfs": "!*.key\n"}},
+		{name: "new rule file", method: http.MethodPut, path: "uploads/sub/.maskfs", body: "**", code: http.StatusForbidden, want: map[string]string{"uploads/sub/.maskfs": ""}},
+		{name: "missing parent", method: http.MethodPut, path: "missing/new.txt", body: "new", code: http.StatusNotFound, want: map[string]string{"missing/new.txt": ""}},
+		{name: "masked parent", method: http.MethodPut, path: "private/new.txt", body: "new", code: http.StatusNotFound, want: map[string]string{"private/new.txt": ""}},
+		{name: "directory", method: http.MethodPut, path: "uploads/sub", body: "new", code: http.StatusConflict},
+		{name: "too large", method: http.MethodPut, path: "uploads/existing.txt", body: "much too large", code: http.StatusRequestEntityTooLarge, want: map[string]string{"uploads/existing.txt": "old"}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := writeFiles(t, map[string]string{
+				"uploads/existing.txt": "old",
+				"uploads/secret.key":   "old",
+				"uploads/.maskfs":      "!*.key\n",
+				"uploads/sub/a.txt":    "",
+				"readonly/r.txt":       "old",
+				"private/x.txt":        "",
+			})
+			h := newHandler(t, Config{
+				Root:           dir,
+				Mask:           "**\n!private/",
+				NestedMaskFile: ".maskfs",
+				WriteMask:      "uploads/**\nmissing/**\nprivate/**",
+				MaxUploadSize:  8,
+			})
+
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, httptest.NewRequest(tt.method, "/files/"+tt.path, strings.NewReader(tt.body)))
+			if w.Code != tt.code {
+				t.Errorf("%s %s = %d %s, want %d", tt.method, tt.path, w.Code, w.Body, tt.code)
+			}
+			if location := w.Header().Get("Location"); location != tt.location {
+				t.Errorf("Location = %q, want %q", location, tt.location)
+			}
+			for name, want := range tt.want {
+				contents, err := os.ReadFile(filepath.Join(dir, filepath.FromSlash(name)))
+				switch {
+				case want == "" && !os.IsNotExist(err):
+					t.Errorf("%s exists, want it not to", name)
+				case want != "" && string(contents) != want:
+					t.Errorf("%s = %q, %v, want %q", name, contents, err, want)
+				}
+			}
+
+			// Failed uploads don't leave their temporary files behind
+			leftovers, err := filepath.Glob(filepath.Join(dir, "uploads", ".*.upload-*"))
+			if err != nil || len(leftovers) > 0 {
+				t.Errorf("temporary files left behind: %v, %v", leftovers, err)
+			}
+		})
+	}
+
+	t.Run("writes disabled", func(t *testing.T) {
+		dir := writeFiles(t, map[string]string{"a.txt": "old"})
+		w := httptest.NewRecorder()
+		newHandler(t, Config{Root: dir, Mask: "**"}).ServeHTTP(w, httptest.NewRequest(http.MethodPut, "/files/a.txt", strings.NewReader("new")))
+		if w.Code != http.StatusMethodNotAllowed {
+			t.Errorf("PUT = %d, want %d", w.Code, http.StatusMethodNotAllowed)
+		}
+	})
+}
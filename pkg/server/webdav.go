@@ -0,0 +1,107 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/njhale/maskfs/pkg/mask"
+	"golang.org/x/net/webdav"
+)
+
+// webdavHandler returns a handler serving the masked files read-only over WebDAV under the given URL prefix.
+// Only the methods needed to browse and download are allowed, and masked entries are left out of PROPFIND responses
+// just like they're left out of directory listings.
+func (s *Server) webdavHandler(prefix string) http.Handler {
+	// The handler requires a lock system even though locking methods are never allowed through
+	locks := webdav.NewMemLS()
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodOptions, http.MethodGet, http.MethodHead, "PROPFIND":
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		h := &webdav.Handler{
+			Prefix:     prefix,
+			FileSystem: davFS{fsys: mask.FS(s.fsys, s.masksFor(r).all)},
+			LockSystem: locks,
+			Logger: func(r *http.Request, err error) {
+				if err != nil {
+					s.requestLogger(r).Debugf("WebDAV %s %s: %v", r.Method, r.URL.Path, err)
+				}
+			},
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// validateWebDAVPrefix returns an error if the prefix can't be used to serve WebDAV alongside a file server
+// under the given prefix.
+func validateWebDAVPrefix(prefix, filesPrefix string) error {
+	switch {
+	case !strings.HasPrefix(prefix, "/"):
+		return fmt.Errorf("webdav prefix %q must start with a slash", prefix)
+	case prefix == "/", prefix == filesPrefix, filesPrefix != "/" && strings.HasPrefix(prefix, filesPrefix+"/"):
+		return fmt.Errorf("webdav prefix %q conflicts with the file server", prefix)
+	}
+	return nil
+}
+
+// davFS is a read-only webdav.FileSystem backed by an fs.FS.
+type davFS struct {
+	fsys fs.FS
+}
+
+func (d davFS) Mkdir(context.Context, string, os.FileMode) error {
+	return os.ErrPermission
+}
+
+func (d davFS) OpenFile(_ context.Context, name string, flag int, _ os.FileMode) (webdav.File, error) {
+	if flag&(os.O_WRONLY|os.O_RDWR|os.O_CREATE|os.O_TRUNC|os.O_APPEND) != 0 {
+		return nil, os.ErrPermission
+	}
+
+	// Open through http.FS, which adapts the file to http.File by adding Readdir and Seek
+	f, err := http.FS(d.fsys).Open("/" + davPath(name))
+	if err != nil {
+		return nil, err
+	}
+
+	return readOnlyFile{File: f}, nil
+}
+
+func (d davFS) RemoveAll(context.Context, string) error {
+	return os.ErrPermission
+}
+
+func (d davFS) Rename(context.Context, string, string) error {
+	return os.ErrPermission
+}
+
+func (d davFS) Stat(_ context.Context, name string) (os.FileInfo, error) {
+	return fs.Stat(d.fsys, davPath(name))
+}
+
+// davPath converts a slash-rooted WebDAV path, which may have a trailing slash, to a valid fs.FS path.
+func davPath(name string) string {
+	if name = strings.TrimPrefix(path.Clean("/"+name), "/"); name == "" {
+		return "."
+	}
+	return name
+}
+
+// readOnlyFile is a webdav.File that can't be written to.
+type readOnlyFile struct {
+	http.File
+}
+
+func (readOnlyFile) Write([]byte) (int, error) {
+	return 0, os.ErrPermission
+}
@@ -0,0 +1,84 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestWebDAV(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"a.txt":     "hello",
+		"b.key":     "secret",
+		"sub/c.txt": "world",
+	})
+	h := newTestServer(t, Config{Root: dir, Mask: "**\n!*.key"}).webdavHandler("/dav")
+
+	serve := func(method, target string, headers map[string]string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, target, nil)
+		for k, v := range headers {
+			r.Header.Set(k, v)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w
+	}
+
+	w := serve("PROPFIND", "/dav/", map[string]string{"Depth": "1"})
+	if w.Code != http.StatusMultiStatus {
+		t.Fatalf("PROPFIND = %d, want %d", w.Code, http.StatusMultiStatus)
+	}
+	for _, href := range []string{"/dav/a.txt", "/dav/sub/"} {
+		if !strings.Contains(w.Body.String(), "<D:href>"+href+"</D:href>") {
+			t.Errorf("PROPFIND doesn't contain %s:\n%s", href, w.Body)
+		}
+	}
+	if strings.Contains(w.Body.String(), "b.key") {
+		t.Errorf("PROPFIND contains a masked entry:\n%s", w.Body)
+	}
+
+	for _, tt := range []struct {
+		method string
+		target string
+		code   int
+		body   string
+	}{
+		{method: http.MethodGet, target: "/dav/a.txt", code: http.StatusOK, body: "hello"},
+		{method: http.MethodGet, target: "/dav/sub/c.txt", code: http.StatusOK, body: "world"},
+		{method: http.MethodGet, target: "/dav/b.key", code: http.StatusNotFound},
+		{method: "PROPFIND", target: "/dav/b.key", code: http.StatusNotFound},
+		{method: http.MethodPut, target: "/dav/new.txt", code: http.StatusMethodNotAllowed},
+		{method: http.MethodDelete, target: "/dav/a.txt", code: http.StatusMethodNotAllowed},
+		{method: "MKCOL", target: "/dav/new", code: http.StatusMethodNotAllowed},
+		{method: "LOCK", target: "/dav/a.txt", code: http.StatusMethodNotAllowed},
+	} {
+		w := serve(tt.method, tt.target, map[string]string{"Depth": "0"})
+		if w.Code != tt.code || (tt.body != "" && w.Body.String() != tt.body) {
+			t.Errorf("%s %s = %d %q, want %d %q", tt.method, tt.target, w.Code, w.Body, tt.code, tt.body)
+		}
+	}
+}
+
+func TestValidateWebDAVPrefix(t *testing.T) {
+	for _, tt := range []struct {
+		prefix      string
+		filesPrefix string
+		valid       bool
+	}{
+		{prefix: "/dav", filesPrefix: "/files", valid: true},
+		{prefix: "/dav/", filesPrefix: "/files", valid: true},
+		{prefix: "/filesystem", filesPrefix: "/files", valid: true},
+		{prefix: "/files", filesPrefix: "/shared", valid: true},
+		{prefix: "dav", filesPrefix: "/files"},
+		{prefix: "/", filesPrefix: "/files"},
+		{prefix: "/files", filesPrefix: "/files"},
+		{prefix: "/files/dav", filesPrefix: "/files"},
+		{prefix: "/shared/dav", filesPrefix: "/shared"},
+		{prefix: "/dav", filesPrefix: "/", valid: true},
+	} {
+		if err := validateWebDAVPrefix(tt.prefix, tt.filesPrefix); (err == nil) != tt.valid {
+			t.Errorf("validateWebDAVPrefix(%q, %q) = %v, want valid %t", tt.prefix, tt.filesPrefix, err, tt.valid)
+		}
+	}
+}
@@ -0,0 +1,290 @@
+// Package sftpd serves an fs.FS read-only over SFTP, authenticating clients by their public keys.
+//
+// Combined with mask.FS, masked entries simply don't exist for clients, so sftp, scp, and other SFTP clients
+// can pull the unmasked files of a directory.
+package sftpd
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/njhale/maskfs/pkg/logger"
+	"golang.org/x/crypto/ssh"
+)
+
+// Config configures a server.
+type Config struct {
+	// HostKeyFile is the path of the server's private host key. An ed25519 key is generated there if it doesn't exist.
+	HostKeyFile string
+	// AuthorizedKeysFile is the path of a file of the public keys allowed to connect, in the authorized_keys format.
+	AuthorizedKeysFile string
+	// MaxConnections is the maximum number of clients connected at once, further connections are closed right away, 0
+	// for no limit.
+	MaxConnections int
+	// IdleTimeout is how long a client can go without sending anything before it's disconnected, and how long the SSH
+	// handshake can take, 0 for no limit.
+	IdleTimeout time.Duration
+}
+
+// maxSessions is the maximum number of session channels a connection can have open at once.
+const maxSessions = 10
+
+// Server serves a filesystem over SFTP.
+type Server struct {
+	fsys        fs.FS
+	ssh         *ssh.ServerConfig
+	logger      logger.Logger
+	maxConns    int
+	idleTimeout time.Duration
+}
+
+// New creates a server for the filesystem, loading or generating its host key and loading the authorized keys.
+func New(fsys fs.FS, cfg Config) (*Server, error) {
+	log := logger.New("sftp")
+
+	if cfg.AuthorizedKeysFile == "" {
+		return nil, errors.New("an authorized keys file is required")
+	}
+	authorized, err := loadAuthorizedKeys(cfg.AuthorizedKeysFile)
+	if err != nil {
+		return nil, err
+	}
+
+	hostKey, err := loadHostKey(cfg.HostKeyFile, log)
+	if err != nil {
+		return nil, err
+	}
+
+	sshConfig := &ssh.ServerConfig{
+		PublicKeyCallback: func(meta ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			if !authorized[string(key.Marshal())] {
+				return nil, fmt.Errorf("unknown public key for %q", meta.User())
+			}
+			return &ssh.Permissions{
+				Extensions: map[string]string{"pubkey-fp": ssh.FingerprintSHA256(key)},
+			}, nil
+		},
+	}
+	sshConfig.AddHostKey(hostKey)
+
+	return &Server{
+		fsys:        fsys,
+		ssh:         sshConfig,
+		logger:      log,
+		maxConns:    cfg.MaxConnections,
+		idleTimeout: cfg.IdleTimeout,
+	}, nil
+}
+
+// loadAuthorizedKeys reads the public keys of an authorized_keys file, keyed by their wire encoding.
+func loadAuthorizedKeys(name string) (map[string]bool, error) {
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read authorized keys file: %w", err)
+	}
+
+	keys := map[string]bool{}
+	for rest := bytes.TrimSpace(data); len(rest) > 0; {
+		var key ssh.PublicKey
+		key, _, _, rest, err = ssh.ParseAuthorizedKey(rest)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse authorized keys file: %w", err)
+		}
+		keys[string(key.Marshal())] = true
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("authorized keys file %q has no keys", name)
+	}
+
+	return keys, nil
+}
+
+// loadHostKey reads a private host key, generating an ed25519 key and writing it to the file if it doesn't exist,
+// so that clients see the same host key every time the server starts.
+func loadHostKey(name string, log logger.Logger) (ssh.Signer, error) {
+	if name == "" {
+		return nil, errors.New("a host key file is required")
+	}
+
+	data, err := os.ReadFile(name)
+	if errors.Is(err, fs.ErrNotExist) {
+		_, key, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate host key: %w", err)
+		}
+		block, err := ssh.MarshalPrivateKey(key, "maskfs host key")
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode host key: %w", err)
+		}
+
+		data = pem.EncodeToMemory(block)
+		if err := os.WriteFile(name, data, 0o600); err != nil {
+			return nil, fmt.Errorf("failed to write host key: %w", err)
+		}
+		log.Infof("Generated host key %q", name)
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to read host key: %w", err)
+	}
+
+	signer, err := ssh.ParsePrivateKey(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse host key: %w", err)
+	}
+	log.Infof("Host key fingerprint: %s", ssh.FingerprintSHA256(signer.PublicKey()))
+
+	return signer, nil
+}
+
+// Serve accepts connections on the listener until the context is canceled or accepting fails, when the listener and
+// every open connection are closed.
+func (s *Server) Serve(ctx context.Context, l net.Listener) error {
+	var (
+		mu     sync.Mutex
+		conns  = map[net.Conn]struct{}{}
+		closed bool
+		wg     sync.WaitGroup
+	)
+
+	closeConns := func() {
+		mu.Lock()
+		defer mu.Unlock()
+		closed = true
+		for conn := range conns {
+			_ = conn.Close()
+		}
+	}
+	stop := context.AfterFunc(ctx, func() {
+		_ = l.Close()
+		closeConns()
+	})
+	defer stop()
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			// Close the connections left open, so that waiting for them doesn't wait for their clients to disconnect
+			closeConns()
+			wg.Wait()
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+
+		mu.Lock()
+		if closed || (s.maxConns > 0 && len(conns) >= s.maxConns) {
+			mu.Unlock()
+			s.logger.Debugf("Refused connection from %s, too many clients are connected", conn.RemoteAddr())
+			_ = conn.Close()
+			continue
+		}
+		conns[conn] = struct{}{}
+		mu.Unlock()
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() {
+				mu.Lock()
+				delete(conns, conn)
+				mu.Unlock()
+			}()
+
+			s.serveConn(conn)
+		}()
+	}
+}
+
+// serveConn performs the SSH handshake on a connection and serves the SFTP subsystem on its session channels.
+// Shells, commands, and every other kind of channel are refused.
+func (s *Server) serveConn(conn net.Conn) {
+	defer conn.Close()
+
+	if s.idleTimeout > 0 {
+		conn = &idleConn{Conn: conn, timeout: s.idleTimeout}
+	}
+
+	sshConn, channels, requests, err := ssh.NewServerConn(conn, s.ssh)
+	if err != nil {
+		s.logger.Debugf("SSH handshake with %s failed: %v", conn.RemoteAddr(), err)
+		return
+	}
+	defer sshConn.Close()
+
+	log := s.logger.Fields("remote", conn.RemoteAddr().String(), "user", sshConn.User(), "key", sshConn.Permissions.Extensions["pubkey-fp"])
+	log.Infof("Client connected")
+	defer log.Infof("Client disconnected")
+
+	go ssh.DiscardRequests(requests)
+
+	sessions := make(chan struct{}, maxSessions)
+	for newChannel := range channels {
+		if newChannel.ChannelType() != "session" {
+			_ = newChannel.Reject(ssh.UnknownChannelType, "only session channels are supported")
+			continue
+		}
+
+		select {
+		case sessions <- struct{}{}:
+		default:
+			_ = newChannel.Reject(ssh.ResourceShortage, "too many sessions")
+			continue
+		}
+
+		channel, requests, err := newChannel.Accept()
+		if err != nil {
+			<-sessions
+			log.Errorf("Failed to accept channel: %v", err)
+			continue
+		}
+
+		go func() {
+			defer func() { <-sessions }()
+			defer channel.Close()
+
+			for req := range requests {
+				// The subsystem request's payload is the subsystem's name as an SSH string
+				if req.Type != "subsystem" || string(req.Payload) != "\x00\x00\x00\x04sftp" {
+					_ = req.Reply(false, nil)
+					continue
+				}
+				_ = req.Reply(true, nil)
+
+				err := newSession(s.fsys, channel).serve()
+				if err != nil {
+					log.Errorf("SFTP session failed: %v", err)
+				}
+
+				status := uint32(0)
+				if err != nil {
+					status = 1
+				}
+				_, _ = channel.SendRequest("exit-status", false, ssh.Marshal(struct{ Status uint32 }{status}))
+				return
+			}
+		}()
+	}
+}
+
+// idleConn is a connection that times out once a read has been waiting for the client for the timeout.
+type idleConn struct {
+	net.Conn
+	timeout time.Duration
+}
+
+func (c *idleConn) Read(p []byte) (int, error) {
+	if err := c.Conn.SetReadDeadline(time.Now().Add(c.timeout)); err != nil {
+		return 0, err
+	}
+	return c.Conn.Read(p)
+}
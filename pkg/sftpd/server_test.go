@@ -0,0 +1,163 @@
+package sftpd
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// newTestServer starts a server for the test filesystem, returning its address and the config of an authorized
+// client. The server is stopped when the returned function is called, which returns what Serve returned.
+func newTestServer(t *testing.T, cfg Config) (string, *ssh.ClientConfig, func() error) {
+	t.Helper()
+
+	dir := t.TempDir()
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.AuthorizedKeysFile = filepath.Join(dir, "authorized_keys")
+	if err := os.WriteFile(cfg.AuthorizedKeysFile, ssh.MarshalAuthorizedKey(signer.PublicKey()), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	cfg.HostKeyFile = filepath.Join(dir, "host_key")
+
+	server, err := New(testFS, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(cfg.HostKeyFile); err != nil {
+		t.Errorf("host key wasn't generated: %v", err)
+	}
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- server.Serve(ctx, l) }()
+
+	stop := sync.OnceValue(func() error {
+		cancel()
+		select {
+		case err := <-done:
+			return err
+		case <-time.After(5 * time.Second):
+			return errors.New("server didn't stop")
+		}
+	})
+	t.Cleanup(func() { _ = stop() })
+
+	return l.Addr().String(), &ssh.ClientConfig{
+		User:            "test",
+		Auth:            []ssh.AuthMethod{ssh.PublicKeys(signer)},
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+	}, stop
+}
+
+func TestServe(t *testing.T) {
+	addr, clientConfig, stop := newTestServer(t, Config{})
+
+	conn, err := ssh.Dial("tcp", addr, clientConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	session, err := conn.NewSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := session.Shell(); err == nil {
+		t.Error("shell was started")
+	}
+
+	session, err = conn.NewSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdin, err := session.StdinPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout, err := session.StdoutPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := session.RequestSubsystem("sftp"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := stdin.Write([]byte{0, 0, 0, 5, fxpInit, 0, 0, 0, 3}); err != nil {
+		t.Fatal(err)
+	}
+	version := make([]byte, 9)
+	if _, err := io.ReadFull(stdout, version); err != nil || version[4] != fxpVersion {
+		t.Fatalf("got %v, %v for init, want a version packet", version, err)
+	}
+
+	// Connected clients don't keep the server from stopping
+	if err := stop(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestServeUnauthorized(t *testing.T) {
+	addr, clientConfig, _ := newTestServer(t, Config{})
+
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientConfig.Auth = []ssh.AuthMethod{ssh.PublicKeys(signer)}
+
+	if conn, err := ssh.Dial("tcp", addr, clientConfig); err == nil {
+		conn.Close()
+		t.Error("client with an unknown key connected")
+	}
+}
+
+func TestServeLimits(t *testing.T) {
+	addr, clientConfig, _ := newTestServer(t, Config{MaxConnections: 1, IdleTimeout: 100 * time.Millisecond})
+
+	// Clients that never handshake are disconnected once idle
+	idle, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer idle.Close()
+	_ = idle.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := io.Copy(io.Discard, idle); err != nil {
+		t.Errorf("idle connection wasn't closed: %v", err)
+	}
+
+	conn, err := ssh.Dial("tcp", addr, clientConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	clientConfig.Timeout = 5 * time.Second
+	if other, err := ssh.Dial("tcp", addr, clientConfig); err == nil {
+		other.Close()
+		t.Error("connected more clients than the maximum")
+	}
+}
@@ -0,0 +1,482 @@
+package sftpd
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"strconv"
+)
+
+// SFTP version 3 packet types, see draft-ietf-secsh-filexfer-02.
+const (
+	fxpInit     = 1
+	fxpVersion  = 2
+	fxpOpen     = 3
+	fxpClose    = 4
+	fxpRead     = 5
+	fxpWrite    = 6
+	fxpLstat    = 7
+	fxpFstat    = 8
+	fxpSetstat  = 9
+	fxpFsetstat = 10
+	fxpOpendir  = 11
+	fxpReaddir  = 12
+	fxpRemove   = 13
+	fxpMkdir    = 14
+	fxpRmdir    = 15
+	fxpRealpath = 16
+	fxpStat     = 17
+	fxpRename   = 18
+	fxpReadlink = 19
+	fxpSymlink  = 20
+	fxpStatus   = 101
+	fxpHandle   = 102
+	fxpData     = 103
+	fxpName     = 104
+	fxpAttrs    = 105
+)
+
+// SFTP status codes.
+const (
+	fxOK               = 0
+	fxEOF              = 1
+	fxNoSuchFile       = 2
+	fxPermissionDenied = 3
+	fxFailure          = 4
+	fxBadMessage       = 5
+	fxOpUnsupported    = 8
+)
+
+// Flags of file attributes and of the OPEN request.
+const (
+	attrSize        = 0x1
+	attrPermissions = 0x4
+	attrACModTime   = 0x8
+
+	openWrite = 0x2 | 0x4 | 0x8 | 0x10 | 0x20 // WRITE, APPEND, CREAT, TRUNC, and EXCL
+)
+
+const (
+	maxPacketSize = 1 << 18 // Larger than any packet clients send, including writes of 32KiB
+	maxReadSize   = 1 << 16 // Reads of more than this are shortened, which clients handle like any short read
+	readDirBatch  = 100
+	maxHandles    = 1024 // More open handles than any client needs, which are refused rather than leaking memory
+)
+
+// errBadMessage is returned for malformed packets, which end the session.
+var errBadMessage = errors.New("malformed SFTP packet")
+
+// session serves the SFTP protocol over a single channel. Requests are handled one at a time, in order.
+type session struct {
+	fsys    fs.FS
+	rw      io.ReadWriter
+	handles map[string]any // Open *fileHandle and *dirHandle values by handle
+	next    uint64
+}
+
+// fileHandle is a file opened for reading.
+type fileHandle struct {
+	file fs.File
+}
+
+// dirHandle is a directory opened for listing, along with the entries not yet read.
+type dirHandle struct {
+	name    string
+	entries []fs.DirEntry
+}
+
+func newSession(fsys fs.FS, rw io.ReadWriter) *session {
+	return &session{
+		fsys:    fsys,
+		rw:      rw,
+		handles: map[string]any{},
+	}
+}
+
+// serve handles requests until the client closes the channel, closing every handle left open.
+func (s *session) serve() error {
+	defer func() {
+		for _, h := range s.handles {
+			if f, ok := h.(*fileHandle); ok {
+				_ = f.file.Close()
+			}
+		}
+	}()
+
+	for {
+		packet, err := s.readPacket()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		if err := s.handle(packet); err != nil {
+			return err
+		}
+	}
+}
+
+func (s *session) readPacket() (*decoder, error) {
+	var length uint32
+	if err := binary.Read(s.rw, binary.BigEndian, &length); err != nil {
+		return nil, err
+	}
+	if length == 0 || length > maxPacketSize {
+		return nil, fmt.Errorf("invalid SFTP packet length %d", length)
+	}
+
+	data := make([]byte, length)
+	if _, err := io.ReadFull(s.rw, data); err != nil {
+		return nil, err
+	}
+	return &decoder{data: data}, nil
+}
+
+func (s *session) writePacket(e *encoder) error {
+	data := binary.BigEndian.AppendUint32(nil, uint32(len(e.data)))
+	_, err := s.rw.Write(append(data, e.data...))
+	return err
+}
+
+// handle serves a single request, returning an error only when the session can't continue.
+func (s *session) handle(p *decoder) error {
+	typ := p.byte()
+	if typ == fxpInit {
+		// The client's version follows, and the server answers with the only version it speaks
+		return s.writePacket(newPacket(fxpVersion).uint32(3))
+	}
+
+	id := p.uint32()
+	if p.err != nil {
+		return errBadMessage
+	}
+
+	switch typ {
+	case fxpOpen:
+		name, flags := p.string(), p.uint32()
+		if p.err != nil {
+			return s.status(id, fxBadMessage, "")
+		}
+		if flags&openWrite != 0 {
+			return s.status(id, fxPermissionDenied, "read-only filesystem")
+		}
+		return s.open(id, fsPath(name))
+	case fxpOpendir:
+		name := p.string()
+		if p.err != nil {
+			return s.status(id, fxBadMessage, "")
+		}
+		return s.opendir(id, fsPath(name))
+	case fxpClose:
+		handle := p.string()
+		h, ok := s.handles[handle]
+		if !ok {
+			return s.status(id, fxFailure, "invalid handle")
+		}
+		delete(s.handles, handle)
+		if f, ok := h.(*fileHandle); ok {
+			if err := f.file.Close(); err != nil {
+				return s.error(id, err)
+			}
+		}
+		return s.status(id, fxOK, "")
+	case fxpRead:
+		handle, offset, length := p.string(), p.uint64(), p.uint32()
+		f, ok := s.handles[handle].(*fileHandle)
+		if p.err != nil || !ok {
+			return s.status(id, fxFailure, "invalid handle")
+		}
+		return s.read(id, f, int64(offset), min(length, maxReadSize))
+	case fxpReaddir:
+		d, ok := s.handles[p.string()].(*dirHandle)
+		if p.err != nil || !ok {
+			return s.status(id, fxFailure, "invalid handle")
+		}
+		return s.readdir(id, d)
+	case fxpStat, fxpLstat:
+		name := fsPath(p.string())
+		stat := fs.Stat
+		if typ == fxpLstat {
+			stat = fs.Lstat
+		}
+		info, err := stat(s.fsys, name)
+		if err != nil {
+			return s.error(id, err)
+		}
+		return s.writePacket(newPacket(fxpAttrs).uint32(id).attrs(info))
+	case fxpFstat:
+		f, ok := s.handles[p.string()].(*fileHandle)
+		if p.err != nil || !ok {
+			return s.status(id, fxFailure, "invalid handle")
+		}
+		info, err := f.file.Stat()
+		if err != nil {
+			return s.error(id, err)
+		}
+		return s.writePacket(newPacket(fxpAttrs).uint32(id).attrs(info))
+	case fxpRealpath:
+		name := "/" + fsPath(p.string())
+		if name == "/." {
+			name = "/"
+		}
+		return s.writePacket(newPacket(fxpName).uint32(id).uint32(1).string(name).string(name).uint32(0))
+	case fxpReadlink:
+		target, err := fs.ReadLink(s.fsys, fsPath(p.string()))
+		if err != nil {
+			return s.error(id, err)
+		}
+		return s.writePacket(newPacket(fxpName).uint32(id).uint32(1).string(target).string(target).uint32(0))
+	case fxpWrite, fxpSetstat, fxpFsetstat, fxpRemove, fxpMkdir, fxpRmdir, fxpRename, fxpSymlink:
+		return s.status(id, fxPermissionDenied, "read-only filesystem")
+	default:
+		return s.status(id, fxOpUnsupported, "unsupported operation")
+	}
+}
+
+func (s *session) open(id uint32, name string) error {
+	if len(s.handles) >= maxHandles {
+		return s.status(id, fxFailure, "too many open handles")
+	}
+
+	f, err := s.fsys.Open(name)
+	if err != nil {
+		return s.error(id, err)
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		_ = f.Close()
+		return s.error(id, err)
+	}
+	if info.IsDir() {
+		_ = f.Close()
+		return s.status(id, fxFailure, "is a directory")
+	}
+
+	return s.writePacket(newPacket(fxpHandle).uint32(id).string(s.addHandle(&fileHandle{file: f})))
+}
+
+func (s *session) opendir(id uint32, name string) error {
+	if len(s.handles) >= maxHandles {
+		return s.status(id, fxFailure, "too many open handles")
+	}
+
+	entries, err := fs.ReadDir(s.fsys, name)
+	if err != nil {
+		return s.error(id, err)
+	}
+	return s.writePacket(newPacket(fxpHandle).uint32(id).string(s.addHandle(&dirHandle{name: name, entries: entries})))
+}
+
+func (s *session) addHandle(h any) string {
+	s.next++
+	handle := strconv.FormatUint(s.next, 10)
+	s.handles[handle] = h
+	return handle
+}
+
+// read reads from a file at an offset, seeking first for files that can't read at an offset.
+func (s *session) read(id uint32, f *fileHandle, offset int64, length uint32) error {
+	buf := make([]byte, length)
+
+	var (
+		n   int
+		err error
+	)
+	switch r := f.file.(type) {
+	case io.ReaderAt:
+		n, err = r.ReadAt(buf, offset)
+	case io.ReadSeeker:
+		if _, err = r.Seek(offset, io.SeekStart); err == nil {
+			n, err = io.ReadFull(r, buf)
+		}
+	default:
+		return s.status(id, fxOpUnsupported, "file can't be read at an offset")
+	}
+
+	if n == 0 && (errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF)) {
+		return s.status(id, fxEOF, "")
+	}
+	if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return s.error(id, err)
+	}
+
+	return s.writePacket(newPacket(fxpData).uint32(id).bytes(buf[:n]))
+}
+
+// readdir returns the next batch of a directory's entries, skipping entries that disappear before they're stat'd.
+func (s *session) readdir(id uint32, d *dirHandle) error {
+	if len(d.entries) == 0 {
+		return s.status(id, fxEOF, "")
+	}
+
+	batch := d.entries[:min(len(d.entries), readDirBatch)]
+	d.entries = d.entries[len(batch):]
+
+	var (
+		count int
+		names = newPacket(0)
+	)
+	for _, entry := range batch {
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		names.string(entry.Name()).string(longName(info)).attrs(info)
+		count++
+	}
+
+	return s.writePacket(newPacket(fxpName).uint32(id).uint32(uint32(count)).raw(names.data[1:]))
+}
+
+// error responds with the status best describing an error.
+func (s *session) error(id uint32, err error) error {
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		return s.status(id, fxNoSuchFile, "no such file")
+	case errors.Is(err, fs.ErrPermission):
+		return s.status(id, fxPermissionDenied, "permission denied")
+	default:
+		return s.status(id, fxFailure, err.Error())
+	}
+}
+
+func (s *session) status(id, code uint32, msg string) error {
+	return s.writePacket(newPacket(fxpStatus).uint32(id).uint32(code).string(msg).string(""))
+}
+
+// fsPath returns the path in the filesystem of a client path, which is absolute or relative to the root, the
+// client's working directory. Paths can't climb above the root.
+func fsPath(name string) string {
+	name = path.Clean("/" + name)
+	if name == "/" {
+		return "."
+	}
+	return name[1:]
+}
+
+// longName formats an entry like ls -l, which clients show as is in long listings.
+func longName(info fs.FileInfo) string {
+	mode := []byte(info.Mode().String())
+	if mode[0] == 'L' {
+		// Go marks symlinks with an L, ls with an l
+		mode[0] = 'l'
+	}
+	return fmt.Sprintf("%s 1 maskfs maskfs %8d %s %s", mode, info.Size(), info.ModTime().Format("Jan _2 15:04"), info.Name())
+}
+
+// posixMode returns the POSIX file mode of a file, with its type bits.
+func posixMode(mode fs.FileMode) uint32 {
+	perm := uint32(mode.Perm())
+	switch mode.Type() {
+	case fs.ModeDir:
+		return 0o040000 | perm
+	case fs.ModeSymlink:
+		return 0o120000 | perm
+	case fs.ModeNamedPipe:
+		return 0o010000 | perm
+	case fs.ModeSocket:
+		return 0o140000 | perm
+	case fs.ModeDevice | fs.ModeCharDevice:
+		return 0o020000 | perm
+	case fs.ModeDevice:
+		return 0o060000 | perm
+	default:
+		return 0o100000 | perm
+	}
+}
+
+// encoder builds a packet.
+type encoder struct {
+	data []byte
+}
+
+func newPacket(typ byte) *encoder {
+	return &encoder{data: []byte{typ}}
+}
+
+func (e *encoder) uint32(v uint32) *encoder {
+	e.data = binary.BigEndian.AppendUint32(e.data, v)
+	return e
+}
+
+func (e *encoder) uint64(v uint64) *encoder {
+	e.data = binary.BigEndian.AppendUint64(e.data, v)
+	return e
+}
+
+func (e *encoder) string(v string) *encoder {
+	return e.bytes([]byte(v))
+}
+
+func (e *encoder) bytes(v []byte) *encoder {
+	e.uint32(uint32(len(v)))
+	e.data = append(e.data, v...)
+	return e
+}
+
+func (e *encoder) raw(v []byte) *encoder {
+	e.data = append(e.data, v...)
+	return e
+}
+
+// attrs encodes the size, permissions, and times of a file.
+func (e *encoder) attrs(info fs.FileInfo) *encoder {
+	modTime := uint32(info.ModTime().Unix())
+	return e.uint32(attrSize | attrPermissions | attrACModTime).
+		uint64(uint64(info.Size())).
+		uint32(posixMode(info.Mode())).
+		uint32(modTime).
+		uint32(modTime)
+}
+
+// decoder reads the fields of a packet. Reading past the end sets err and yields zero values.
+type decoder struct {
+	data []byte
+	err  error
+}
+
+func (d *decoder) take(n int) []byte {
+	if d.err != nil || len(d.data) < n {
+		d.err = errBadMessage
+		return nil
+	}
+	v := d.data[:n]
+	d.data = d.data[n:]
+	return v
+}
+
+func (d *decoder) byte() byte {
+	if v := d.take(1); v != nil {
+		return v[0]
+	}
+	return 0
+}
+
+func (d *decoder) uint32() uint32 {
+	if v := d.take(4); v != nil {
+		return binary.BigEndian.Uint32(v)
+	}
+	return 0
+}
+
+func (d *decoder) uint64() uint64 {
+	if v := d.take(8); v != nil {
+		return binary.BigEndian.Uint64(v)
+	}
+	return 0
+}
+
+func (d *decoder) string() string {
+	n := d.uint32()
+	if d.err == nil && uint64(n) > uint64(len(d.data)) {
+		d.err = errBadMessage
+		return ""
+	}
+	return string(d.take(int(n)))
+}
@@ -0,0 +1,209 @@
+package sftpd
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"strconv"
+	"testing"
+	"testing/fstest"
+)
+
+// client speaks SFTP to a session over a pipe.
+type client struct {
+	t    *testing.T
+	conn net.Conn
+	done chan error
+	id   uint32
+}
+
+func newClient(t *testing.T, fsys fstest.MapFS) *client {
+	t.Helper()
+
+	conn, server := net.Pipe()
+	c := &client{t: t, conn: conn, done: make(chan error, 1)}
+	go func() {
+		c.done <- newSession(fsys, server).serve()
+		_ = server.Close()
+	}()
+	t.Cleanup(func() { _ = conn.Close() })
+
+	c.send(newPacket(fxpInit).uint32(3))
+	if typ, _ := c.recv(); typ != fxpVersion {
+		t.Fatalf("got packet type %d for init, want %d", typ, fxpVersion)
+	}
+	return c
+}
+
+func (c *client) send(e *encoder) {
+	c.t.Helper()
+	data := binary.BigEndian.AppendUint32(nil, uint32(len(e.data)))
+	if _, err := c.conn.Write(append(data, e.data...)); err != nil {
+		c.t.Fatal(err)
+	}
+}
+
+// request sends a request of a type, with a new id followed by the fields of the encoder.
+func (c *client) request(typ byte, fields *encoder) {
+	c.t.Helper()
+	c.id++
+	c.send(newPacket(typ).uint32(c.id).raw(fields.data[1:]))
+}
+
+func (c *client) recv() (byte, *decoder) {
+	c.t.Helper()
+	var length uint32
+	if err := binary.Read(c.conn, binary.BigEndian, &length); err != nil {
+		c.t.Fatal(err)
+	}
+	data := make([]byte, length)
+	if _, err := io.ReadFull(c.conn, data); err != nil {
+		c.t.Fatal(err)
+	}
+	d := &decoder{data: data}
+	typ := d.byte()
+	if typ != fxpVersion {
+		if id := d.uint32(); id != c.id {
+			c.t.Fatalf("got response to request %d, want %d", id, c.id)
+		}
+	}
+	return typ, d
+}
+
+// status receives a response, which must be a status, and returns its code.
+func (c *client) status() uint32 {
+	c.t.Helper()
+	typ, d := c.recv()
+	if typ != fxpStatus {
+		c.t.Fatalf("got packet type %d, want a status", typ)
+	}
+	return d.uint32()
+}
+
+func (c *client) open(name string) (string, uint32) {
+	c.t.Helper()
+	c.request(fxpOpen, newPacket(0).string(name).uint32(0x1).uint32(0))
+	typ, d := c.recv()
+	if typ == fxpStatus {
+		return "", d.uint32()
+	}
+	return d.string(), fxOK
+}
+
+var testFS = fstest.MapFS{
+	"a.txt":     {Data: []byte("hello")},
+	"dir/b.txt": {Data: []byte("world")},
+}
+
+func TestSessionRead(t *testing.T) {
+	c := newClient(t, testFS)
+
+	handle, code := c.open("/dir/../a.txt")
+	if code != fxOK {
+		t.Fatalf("open returned status %d", code)
+	}
+
+	c.request(fxpRead, newPacket(0).string(handle).uint64(1).uint32(100))
+	typ, d := c.recv()
+	if data := d.string(); typ != fxpData || data != "ello" {
+		t.Errorf("read returned packet type %d with %q, want %q", typ, data, "ello")
+	}
+
+	c.request(fxpRead, newPacket(0).string(handle).uint64(5).uint32(100))
+	if code := c.status(); code != fxEOF {
+		t.Errorf("read at the end returned status %d, want %d", code, fxEOF)
+	}
+
+	c.request(fxpClose, newPacket(0).string(handle))
+	if code := c.status(); code != fxOK {
+		t.Errorf("close returned status %d", code)
+	}
+	c.request(fxpRead, newPacket(0).string(handle).uint64(0).uint32(100))
+	if code := c.status(); code != fxFailure {
+		t.Errorf("read of a closed handle returned status %d, want %d", code, fxFailure)
+	}
+}
+
+func TestSessionReadDir(t *testing.T) {
+	c := newClient(t, testFS)
+
+	c.request(fxpOpendir, newPacket(0).string("/"))
+	typ, d := c.recv()
+	if typ != fxpHandle {
+		t.Fatalf("opendir returned packet type %d", typ)
+	}
+	handle := d.string()
+
+	c.request(fxpReaddir, newPacket(0).string(handle))
+	typ, d = c.recv()
+	if typ != fxpName {
+		t.Fatalf("readdir returned packet type %d", typ)
+	}
+	if count := d.uint32(); count != 2 {
+		t.Errorf("readdir returned %d entries, want 2", count)
+	}
+	if name := d.string(); name != "a.txt" {
+		t.Errorf("readdir returned %q first, want %q", name, "a.txt")
+	}
+
+	c.request(fxpReaddir, newPacket(0).string(handle))
+	if code := c.status(); code != fxEOF {
+		t.Errorf("readdir past the end returned status %d, want %d", code, fxEOF)
+	}
+}
+
+func TestSessionErrors(t *testing.T) {
+	c := newClient(t, testFS)
+
+	if _, code := c.open("missing.txt"); code != fxNoSuchFile {
+		t.Errorf("open of a missing file returned status %d, want %d", code, fxNoSuchFile)
+	}
+	if _, code := c.open("dir"); code != fxFailure {
+		t.Errorf("open of a directory returned status %d, want %d", code, fxFailure)
+	}
+
+	c.request(fxpOpen, newPacket(0).string("a.txt").uint32(0x2).uint32(0))
+	if code := c.status(); code != fxPermissionDenied {
+		t.Errorf("open for writing returned status %d, want %d", code, fxPermissionDenied)
+	}
+	c.request(fxpRemove, newPacket(0).string("a.txt"))
+	if code := c.status(); code != fxPermissionDenied {
+		t.Errorf("remove returned status %d, want %d", code, fxPermissionDenied)
+	}
+
+	// A string claiming to be longer than the packet is malformed rather than read past the end
+	c.request(fxpOpen, newPacket(0).uint32(0xffffffff).raw([]byte("a.txt")))
+	if code := c.status(); code != fxBadMessage {
+		t.Errorf("open with an overlong name returned status %d, want %d", code, fxBadMessage)
+	}
+}
+
+func TestSessionMaxHandles(t *testing.T) {
+	c := newClient(t, testFS)
+
+	for i := range maxHandles {
+		if _, code := c.open("a.txt"); code != fxOK {
+			t.Fatalf("open %d returned status %d", i, code)
+		}
+	}
+	if _, code := c.open("a.txt"); code != fxFailure {
+		t.Errorf("open beyond the maximum handles returned status %d, want %d", code, fxFailure)
+	}
+
+	c.request(fxpClose, newPacket(0).string(strconv.Itoa(1)))
+	if code := c.status(); code != fxOK {
+		t.Fatalf("close returned status %d", code)
+	}
+	if _, code := c.open("a.txt"); code != fxOK {
+		t.Errorf("open after closing a handle returned status %d", code)
+	}
+}
+
+func TestSessionEnd(t *testing.T) {
+	c := newClient(t, testFS)
+
+	_ = c.conn.Close()
+	if err := <-c.done; err != nil {
+		t.Errorf("session ended with %v when the client closed it, want nil", err)
+	}
+}